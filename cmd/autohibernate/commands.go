@@ -0,0 +1,351 @@
+//go:build windows
+
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/control"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// command is a subcommand of the executable
+type command struct {
+	name    string
+	args    string // Arguments shown in the usage
+	summary string
+	run     func(fs *flag.FlagSet, args []string)
+}
+
+// commands lists the subcommands in the order shown in the usage
+var commands = []command{
+	{"status", "[--json] [--imds] [--config <file>]", "Show sessions, idle timers, warning and inhibitors of the running service", cmdStatus},
+	{"explain", "[--json]", "Explain why the VM will or will not be acted on, and when", cmdExplain},
+	{"pause", "--for <duration> [--reason <text>] [--json]", "Pause idle actions, e.g. --for 2h", cmdPause},
+	{"resume", "[--json]", "End a pause set with pause", cmdResume},
+	{"hibernate-now", "[--reason <text>] [--json]", "Run pre-hibernate hooks and hibernate now", cmdHibernateNow},
+	{"history", "[--since <7d|12h|date>] [--json]", "Show warnings, hibernations, resumes, pauses and updates", cmdHistory},
+	{"check", "[--json]", "Run an idle check now", cmdCheck},
+	{"log-level", "<debug|info|warn|error> [--json]", "Change the log level until the service restarts", cmdLogLevel},
+	{"savings", "[--format json|csv] [--config <file>]", "Show the estimated cost savings report", cmdSavings},
+	{"check-update", "[--config <file>]", "Check for available updates", cmdCheckUpdate},
+	{"protect-secret", "<file>", "Read a secret from stdin and write it DPAPI-protected to the file", cmdProtectSecret},
+	{"install", "[--config <file>]", "Install the service", cmdInstall},
+	{"uninstall", "", "Uninstall the service", cmdUninstall},
+	{"debug", "[--config <file>]", "Run in the console instead of as a service", cmdDebug},
+	{"version", "", "Show version information", cmdVersion},
+}
+
+// findCommand returns the subcommand with the given name, or nil
+func findCommand(name string) *command {
+	for i := range commands {
+		if commands[i].name == name {
+			return &commands[i]
+		}
+	}
+	return nil
+}
+
+// runCommand runs a subcommand with its arguments
+func runCommand(name string, args []string) {
+	if name == "help" {
+		usage()
+		return
+	}
+	c := findCommand(name)
+	if c == nil {
+		fmt.Fprintf(os.Stderr, "Unknown command %q\n\n", name)
+		usage()
+		os.Exit(2)
+	}
+
+	fs := flag.NewFlagSet(c.name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s %s %s\n\n%s\n", executableName(), c.name, c.args, c.summary)
+		fs.PrintDefaults()
+	}
+	c.run(fs, args)
+}
+
+// usage prints the subcommands and the flags kept for compatibility
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage: %s <command> [arguments]\n\nCommands:\n", executableName())
+	for _, c := range commands {
+		fmt.Fprintf(out, "  %-15s %s\n", c.name, c.summary)
+	}
+	fmt.Fprintf(out, "\nRun '%s <command> -h' for the arguments of a command. Without a command, the service runs.\n", executableName())
+	fmt.Fprintf(out, "\nFlags (aliases of the commands above):\n")
+	flag.PrintDefaults()
+}
+
+// executableName returns the file name of the running executable
+func executableName() string {
+	return filepath.Base(os.Args[0])
+}
+
+// parseArgs parses flags placed anywhere among the arguments and returns the positional arguments
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		fs.Parse(args)
+		if fs.NArg() == 0 {
+			return positional
+		}
+		positional = append(positional, fs.Arg(0))
+		args = fs.Args()[1:]
+	}
+}
+
+// noArgs parses flags and exits with the usage if positional arguments are given
+func noArgs(fs *flag.FlagSet, args []string) {
+	if positional := parseArgs(fs, args); len(positional) > 0 {
+		fmt.Fprintf(fs.Output(), "Unexpected argument %q\n", positional[0])
+		fs.Usage()
+		os.Exit(2)
+	}
+}
+
+// jsonFlag defines the --json flag on a command
+func jsonFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("json", false, "Write the response as JSON")
+}
+
+// configFlag defines the --config flag on a command
+func configFlag(fs *flag.FlagSet, opts *options) {
+	fs.StringVar(&opts.configPath, "config", "", "Path to configuration file (default: config.json in executable directory)")
+}
+
+// send sends a control request to the service. With asJSON, the response is written as
+// JSON, including a failure; otherwise a failure ends the process with the error.
+func send(req control.Request, asJSON bool) *control.Response {
+	resp, err := control.Send(req)
+	if asJSON {
+		if resp == nil {
+			resp = &control.Response{Error: err.Error()}
+		}
+		writeJSON(resp)
+		if err != nil {
+			os.Exit(1)
+		}
+		return resp
+	}
+	if err != nil {
+		log.Fatalf("%s failed: %v", req.Command, err)
+	}
+	return resp
+}
+
+// sendAndPrint sends a control request and prints the message of the response
+func sendAndPrint(req control.Request, asJSON bool) {
+	if resp := send(req, asJSON); !asJSON {
+		fmt.Println(resp.Message)
+	}
+}
+
+// writeJSON writes v to stdout as indented JSON
+func writeJSON(v any) {
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		log.Fatalf("Failed to write JSON: %v", err)
+	}
+}
+
+// cmdStatus shows the state of the running service, or the IMDS view with --imds
+func cmdStatus(fs *flag.FlagSet, args []string) {
+	opts := &options{}
+	asJSON := jsonFlag(fs)
+	imds := fs.Bool("imds", false, "Show the VM and remote control value read from IMDS instead")
+	configFlag(fs, opts)
+	noArgs(fs, args)
+
+	if *imds {
+		runIMDSStatus(opts)
+		return
+	}
+	resp := send(control.Request{Command: control.CommandStatus}, *asJSON)
+	if *asJSON {
+		return
+	}
+	if err := control.WriteStatus(os.Stdout, resp.Status, time.Now()); err != nil {
+		log.Fatalf("Failed to write status: %v", err)
+	}
+}
+
+// explanation is the JSON output of explain
+type explanation struct {
+	Explanation []string        `json:"explanation"`
+	Status      *control.Status `json:"status"`
+}
+
+// cmdExplain explains why the VM will or will not be acted on
+func cmdExplain(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	noArgs(fs, args)
+
+	resp, err := control.Send(control.Request{Command: control.CommandStatus})
+	if err != nil {
+		if *asJSON {
+			writeJSON(control.Response{Error: err.Error()})
+			os.Exit(1)
+		}
+		log.Fatalf("explain failed: %v", err)
+	}
+
+	lines := control.Explain(resp.Status, time.Now())
+	if *asJSON {
+		writeJSON(explanation{Explanation: lines, Status: resp.Status})
+		return
+	}
+	for _, line := range lines {
+		fmt.Println(line)
+	}
+}
+
+// cmdPause pauses idle actions for a duration
+func cmdPause(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	duration := fs.Duration("for", 0, "How long to pause idle actions, e.g. 30m or 2h")
+	reason := fs.String("reason", "", "Why idle actions are paused, shown in the log")
+	noArgs(fs, args)
+
+	if *duration < time.Second {
+		fmt.Fprintln(fs.Output(), "--for must be at least 1s")
+		fs.Usage()
+		os.Exit(2)
+	}
+	sendAndPrint(control.Request{Command: control.CommandPause, Seconds: int(duration.Seconds()), Reason: *reason}, *asJSON)
+}
+
+// cmdResume ends a pause set with pause
+func cmdResume(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	noArgs(fs, args)
+	sendAndPrint(control.Request{Command: control.CommandResume}, *asJSON)
+}
+
+// cmdHibernateNow hibernates the VM now
+func cmdHibernateNow(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	reason := fs.String("reason", "", "Why the VM is hibernated, shown in the log and webhooks")
+	noArgs(fs, args)
+	sendAndPrint(control.Request{Command: control.CommandHibernate, Reason: *reason}, *asJSON)
+}
+
+// cmdHistory shows the history journal. It is read from disk, so it works while the service is stopped.
+func cmdHistory(fs *flag.FlagSet, args []string) {
+	asJSON := fs.Bool("json", false, "Write the records as JSON")
+	since := fs.String("since", "", "Show records from this far back (e.g. 7d, 12h) or since a date (2006-01-02)")
+	noArgs(fs, args)
+
+	var from time.Time
+	if *since != "" {
+		var err error
+		if from, err = journal.ParseSince(*since, time.Now()); err != nil {
+			fmt.Fprintln(fs.Output(), err)
+			fs.Usage()
+			os.Exit(2)
+		}
+	}
+
+	j := journal.New(journal.DefaultPath(), journal.DefaultMaxSize, journal.DefaultKeep)
+	records, err := j.Records(from)
+	if err != nil {
+		log.Fatalf("Failed to read history: %v", err)
+	}
+	if *asJSON {
+		if records == nil {
+			records = []journal.Record{}
+		}
+		writeJSON(records)
+		return
+	}
+	if len(records) == 0 {
+		fmt.Printf("No history recorded in %s\n", j.Path())
+		return
+	}
+	if err := journal.WriteText(os.Stdout, records); err != nil {
+		log.Fatalf("Failed to write history: %v", err)
+	}
+}
+
+// cmdCheck runs an idle check now
+func cmdCheck(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	noArgs(fs, args)
+	sendAndPrint(control.Request{Command: control.CommandCheck}, *asJSON)
+}
+
+// cmdLogLevel changes the log level of the running service
+func cmdLogLevel(fs *flag.FlagSet, args []string) {
+	asJSON := jsonFlag(fs)
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	sendAndPrint(control.Request{Command: control.CommandSetLogLevel, Level: positional[0]}, *asJSON)
+}
+
+// cmdSavings shows the savings report
+func cmdSavings(fs *flag.FlagSet, args []string) {
+	opts := &options{}
+	configFlag(fs, opts)
+	fs.StringVar(&opts.format, "format", "json", "Output format: json or csv")
+	noArgs(fs, args)
+	runSavings(opts)
+}
+
+// cmdCheckUpdate checks for available updates
+func cmdCheckUpdate(fs *flag.FlagSet, args []string) {
+	opts := &options{}
+	configFlag(fs, opts)
+	noArgs(fs, args)
+	runCheckUpdate(opts)
+}
+
+// cmdProtectSecret writes a DPAPI-protected secret to a file
+func cmdProtectSecret(fs *flag.FlagSet, args []string) {
+	positional := parseArgs(fs, args)
+	if len(positional) != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+	runProtectSecret(positional[0])
+}
+
+// cmdInstall installs the service
+func cmdInstall(fs *flag.FlagSet, args []string) {
+	opts := &options{}
+	configFlag(fs, opts)
+	noArgs(fs, args)
+	runInstall(opts)
+}
+
+// cmdUninstall uninstalls the service
+func cmdUninstall(fs *flag.FlagSet, args []string) {
+	noArgs(fs, args)
+	runUninstall()
+}
+
+// cmdDebug runs the service in the console
+func cmdDebug(fs *flag.FlagSet, args []string) {
+	opts := &options{debugMode: true}
+	configFlag(fs, opts)
+	noArgs(fs, args)
+	runServiceOrDebug(opts)
+}
+
+// cmdVersion shows version information
+func cmdVersion(fs *flag.FlagSet, args []string) {
+	noArgs(fs, args)
+	fmt.Println(version.Short())
+}
@@ -3,11 +3,13 @@
 package main
 
 import (
+	"bufio"
 	"context"
 	"flag"
 	"fmt"
 	"log"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
@@ -15,10 +17,12 @@ import (
 	"github.com/smitstech/AzureAutoHibernate/internal/config"
 	"github.com/smitstech/AzureAutoHibernate/internal/installer"
 	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/savings"
 	"github.com/smitstech/AzureAutoHibernate/internal/service"
 	"github.com/smitstech/AzureAutoHibernate/internal/updater"
 	"github.com/smitstech/AzureAutoHibernate/internal/version"
 	"golang.org/x/sys/windows/svc"
+	"golang.org/x/term"
 )
 
 // options holds command-line flags
@@ -29,17 +33,26 @@ type options struct {
 	uninstall   bool
 	showVersion bool
 	checkUpdate bool
+	showStatus  bool
+	savings     bool
+	format      string
+	protectFile string
 }
 
 // parseFlags parses command-line flags and returns options
 func parseFlags() *options {
 	opts := &options{}
 	flag.StringVar(&opts.configPath, "config", "", "Path to configuration file (default: config.json in executable directory)")
-	flag.BoolVar(&opts.debugMode, "debug", false, "Run in debug mode (console) instead of as a service")
-	flag.BoolVar(&opts.install, "install", false, "Install the service")
-	flag.BoolVar(&opts.uninstall, "uninstall", false, "Uninstall the service")
-	flag.BoolVar(&opts.showVersion, "version", false, "Show version information")
-	flag.BoolVar(&opts.checkUpdate, "check-update", false, "Check for available updates")
+	flag.BoolVar(&opts.debugMode, "debug", false, "Run in debug mode (console) instead of as a service (debug)")
+	flag.BoolVar(&opts.install, "install", false, "Install the service (install)")
+	flag.BoolVar(&opts.uninstall, "uninstall", false, "Uninstall the service (uninstall)")
+	flag.BoolVar(&opts.showVersion, "version", false, "Show version information (version)")
+	flag.BoolVar(&opts.checkUpdate, "check-update", false, "Check for available updates (check-update)")
+	flag.BoolVar(&opts.showStatus, "status", false, "Show the VM and remote control status read from IMDS (status --imds)")
+	flag.BoolVar(&opts.savings, "savings", false, "Show the estimated cost savings report (savings)")
+	flag.StringVar(&opts.format, "format", "json", "Output format for -savings: json or csv")
+	flag.StringVar(&opts.protectFile, "protect-secret", "", "Read a secret from stdin and write it DPAPI-protected to the given file (protect-secret)")
+	flag.Usage = usage
 	flag.Parse()
 	return opts
 }
@@ -48,6 +61,12 @@ func main() {
 	// Setup basic console logging
 	log.SetFlags(log.LstdFlags | log.Lshortfile)
 
+	// Subcommands come first; flags are kept as aliases for existing scripts
+	if len(os.Args) > 1 && !strings.HasPrefix(os.Args[1], "-") {
+		runCommand(os.Args[1], os.Args[2:])
+		return
+	}
+
 	opts := parseFlags()
 
 	// Dispatch to appropriate mode
@@ -56,9 +75,15 @@ func main() {
 		fmt.Println(version.Short())
 		os.Exit(0)
 	case opts.checkUpdate:
-		runCheckUpdate()
+		runCheckUpdate(opts)
+	case opts.showStatus:
+		runIMDSStatus(opts)
+	case opts.savings:
+		runSavings(opts)
+	case opts.protectFile != "":
+		runProtectSecret(opts.protectFile)
 	case opts.install:
-		runInstall()
+		runInstall(opts)
 	case opts.uninstall:
 		runUninstall()
 	default:
@@ -67,7 +92,14 @@ func main() {
 }
 
 // runCheckUpdate checks for available updates and displays the result
-func runCheckUpdate() {
+func runCheckUpdate(opts *options) {
+	// The proxy settings live in config.json; without it, use the proxy from the environment
+	if cfg, err := config.Load(opts.configPath); err != nil {
+		log.Printf("Warning: %v - using default HTTP settings", err)
+	} else {
+		configureHTTP(cfg)
+	}
+
 	log.Println("Checking for updates...")
 	log.Printf("Current version: %s", version.Version)
 
@@ -87,9 +119,133 @@ func runCheckUpdate() {
 	}
 }
 
+// runIMDSStatus displays the VM metadata and the remote control value read from IMDS
+func runIMDSStatus(opts *options) {
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	configureHTTP(cfg)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	vmMetadata, err := azure.GetVMMetadata(ctx, cfg.IMDSEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to get VM metadata from IMDS: %v", err)
+	}
+	fmt.Printf("VM:             %s (resource group %s)\n", vmMetadata.VMName, vmMetadata.ResourceGroup)
+	fmt.Printf("Version:        %s\n", version.Version)
+
+	if cfg.DisableRemoteControl {
+		fmt.Println("Remote control: disabled (disableRemoteControl)")
+		return
+	}
+
+	control, err := azure.GetControl(ctx, cfg.IMDSEndpoint)
+	if err != nil {
+		log.Fatalf("Failed to read remote control value: %v", err)
+	}
+	fmt.Printf("Mode:           %s\n", control.EffectiveMode(time.Now()))
+	fmt.Printf("Remote control: %s\n", control)
+	for _, warning := range control.Warnings {
+		fmt.Printf("  Warning: ignoring invalid value: %s\n", warning)
+	}
+}
+
+// runSavings writes the savings report computed from the ledger and price table
+func runSavings(opts *options) {
+	if opts.format != "json" && opts.format != "csv" {
+		log.Fatalf("-format must be json or csv (got: %s)", opts.format)
+	}
+
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+
+	records, err := savings.NewLedger(savings.DataPath(savings.LedgerFileName)).Records()
+	if err != nil {
+		log.Fatalf("Failed to read savings ledger: %v", err)
+	}
+
+	pricePath := cfg.PriceTable
+	if pricePath == "" {
+		if pricePath, err = savings.DefaultPath(savings.PriceTableFileName); err != nil {
+			log.Fatalf("Failed to locate price table: %v", err)
+		}
+	}
+	prices, err := savings.LoadPriceTable(pricePath)
+	if err != nil {
+		// Report hours without prices rather than failing
+		log.Printf("Warning: %v - savings will not be estimated", err)
+	}
+
+	to := time.Now()
+	from := to
+	if len(records) > 0 {
+		from = records[0].Time
+	}
+	report := savings.Compute(records, prices, from, to, time.Local)
+
+	if opts.format == "csv" {
+		err = report.WriteCSV(os.Stdout)
+	} else {
+		err = report.WriteJSON(os.Stdout)
+	}
+	if err != nil {
+		log.Fatalf("Failed to write savings report: %v", err)
+	}
+}
+
+// runProtectSecret reads a secret from stdin and writes it DPAPI-protected to path
+func runProtectSecret(path string) {
+	secret, err := readSecret()
+	if err != nil {
+		log.Fatalf("Failed to read secret: %v", err)
+	}
+
+	protected, err := azure.ProtectSecret([]byte(secret))
+	if err != nil {
+		log.Fatalf("Failed to protect secret: %v", err)
+	}
+
+	if err := azure.WriteSecretFile(path, protected); err != nil {
+		log.Fatalf("Failed to write protected secret: %v", err)
+	}
+	log.Printf("Protected secret written to %s", path)
+}
+
+// readSecret prompts for a secret without echoing it, or reads one line when stdin is redirected
+func readSecret() (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", err
+		}
+		return strings.TrimSpace(line), nil
+	}
+
+	fmt.Fprint(os.Stderr, "Enter secret: ")
+	secret, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(secret)), nil
+}
+
 // runInstall handles service installation
-func runInstall() {
-	if err := installer.Install(); err != nil {
+func runInstall(opts *options) {
+	// Load configuration so the capability test uses the configured identity
+	cfg, err := config.Load(opts.configPath)
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	configureHTTP(cfg)
+
+	if err := installer.Install(cfg); err != nil {
 		log.Fatalf("Failed to install service: %v", err)
 	}
 	log.Println("Service installed successfully")
@@ -103,6 +259,13 @@ func runUninstall() {
 	log.Println("Service uninstalled successfully")
 }
 
+// configureHTTP applies the outbound HTTP settings from the configuration
+func configureHTTP(cfg *config.Config) {
+	if err := azure.ConfigureHTTP(cfg); err != nil {
+		log.Fatalf("Failed to configure outbound HTTP: %v", err)
+	}
+}
+
 // runServiceOrDebug runs the main service logic in either service or debug mode
 func runServiceOrDebug(opts *options) {
 	// Load configuration
@@ -142,12 +305,18 @@ func runServiceOrDebug(opts *options) {
 	appLogger.Info(logger.EventServiceStart, appinfo.Name+" service starting...")
 	appLogger.Debugf(logger.EventConfigLoaded, "Log level set to: %s", logLevel.String())
 
+	// Route all outbound HTTP through the configured proxy and root CAs
+	if err := azure.ConfigureHTTP(cfg); err != nil {
+		appLogger.Errorf(logger.EventConfigError, "Failed to configure outbound HTTP: %v", err)
+		log.Fatalf("Failed to configure outbound HTTP: %v", err)
+	}
+
 	// Fetch VM metadata from Azure IMDS
 	appLogger.Info(logger.EventConfigLoaded, "Fetching VM metadata from Azure IMDS...")
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	vmMetadata, err := azure.GetVMMetadata(ctx)
+	vmMetadata, err := azure.GetVMMetadata(ctx, cfg.IMDSEndpoint)
 	if err != nil {
 		appLogger.Errorf(logger.EventConfigError, "Failed to get VM metadata from IMDS: %v", err)
 		log.Fatalf("Failed to get VM metadata from IMDS: %v\nThe service must run on an Azure VM with access to the Instance Metadata Service.", err)
@@ -147,6 +147,14 @@ func applyUpdate(exePath, updateDir string) error {
 			continue
 		}
 
+		// Keep an existing price table, which users edit with their own prices
+		if entry.Name() == "prices.json" {
+			if _, err := os.Stat(filepath.Join(exeDir, entry.Name())); err == nil {
+				log.Println("Skipping prices.json (keeping the existing price table)")
+				continue
+			}
+		}
+
 		srcPath := filepath.Join(updateDir, entry.Name())
 		dstPath := filepath.Join(exeDir, entry.Name())
 
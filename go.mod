@@ -4,7 +4,10 @@ go 1.25.12
 
 require (
 	github.com/creativeprojects/go-selfupdate v1.6.0
+	github.com/google/go-github/v86 v86.0.0
 	golang.org/x/sys v0.47.0
+	golang.org/x/term v0.44.0
+	software.sslmate.com/src/go-pkcs12 v0.7.3
 )
 
 require (
@@ -13,7 +16,6 @@ require (
 	github.com/Masterminds/semver/v3 v3.5.0 // indirect
 	github.com/davidmz/go-pageant v1.0.2 // indirect
 	github.com/go-fed/httpsig v1.1.0 // indirect
-	github.com/google/go-github/v86 v86.0.0 // indirect
 	github.com/google/go-querystring v1.2.0 // indirect
 	github.com/hashicorp/go-cleanhttp v0.5.2 // indirect
 	github.com/hashicorp/go-retryablehttp v0.7.8 // indirect
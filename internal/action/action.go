@@ -0,0 +1,190 @@
+package action
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// Action is an operation performed when an idle condition is met
+type Action interface {
+	// Name returns the configured action name (e.g. "hibernate")
+	Name() string
+	// Execute performs the action
+	Execute(ctx context.Context) error
+}
+
+// VMClient is the subset of the Azure client used by Azure-side actions
+type VMClient interface {
+	HibernateVM(ctx context.Context) error
+	DeallocateVM(ctx context.Context) error
+}
+
+// CommandRunner runs an external command to completion
+type CommandRunner func(ctx context.Context, name string, args ...string) error
+
+// Deps holds the dependencies used to build actions
+type Deps struct {
+	Client     VMClient      // Azure client for hibernate and deallocate
+	Runner     CommandRunner // Command runner for in-guest actions (default: RunCommand)
+	Script     string        // Script run by the run-script action
+	ScriptArgs []string      // Arguments passed to the script
+}
+
+// New creates the action with the given name
+func New(name string, deps Deps) (Action, error) {
+	runner := deps.Runner
+	if runner == nil {
+		runner = RunCommand
+	}
+
+	switch name {
+	case config.ActionHibernate:
+		if deps.Client == nil {
+			return nil, fmt.Errorf("action %s requires an Azure client", name)
+		}
+		return &azureAction{name: name, run: deps.Client.HibernateVM}, nil
+	case config.ActionDeallocate:
+		if deps.Client == nil {
+			return nil, fmt.Errorf("action %s requires an Azure client", name)
+		}
+		return &azureAction{name: name, run: deps.Client.DeallocateVM}, nil
+	case config.ActionOSShutdown:
+		return &commandAction{name: name, runner: runner, command: "shutdown.exe", args: []string{"/s", "/t", "0"}}, nil
+	case config.ActionLocalHibernate:
+		return &commandAction{name: name, runner: runner, command: "shutdown.exe", args: []string{"/h"}}, nil
+	case config.ActionRunScript:
+		if deps.Script == "" {
+			return nil, fmt.Errorf("action %s requires a script", name)
+		}
+		return &commandAction{name: name, runner: runner, command: deps.Script, args: deps.ScriptArgs}, nil
+	case config.ActionNone:
+		return noneAction{}, nil
+	default:
+		return nil, fmt.Errorf("unknown action: %s", name)
+	}
+}
+
+// IsNone reports whether the action does nothing
+func IsNone(a Action) bool {
+	return a == nil || a.Name() == config.ActionNone
+}
+
+// Verb returns the phrase used for an action in messages, e.g. "VM-01 will <verb>"
+func Verb(name string) string {
+	switch name {
+	case "", config.ActionHibernate:
+		return "hibernate"
+	case config.ActionDeallocate:
+		return "be deallocated"
+	case config.ActionOSShutdown:
+		return "shut down"
+	case config.ActionLocalHibernate:
+		return "hibernate locally"
+	case config.ActionRunScript:
+		return "run the action script"
+	default:
+		return "run " + name
+	}
+}
+
+// Result describes the outcome of running an action with its fallback
+type Result struct {
+	Completed     Action // Action that completed successfully (nil if none did)
+	PrimaryError  error  // Error returned by the primary action
+	FallbackUsed  bool   // True if the fallback action was attempted
+	FallbackError error  // Error returned by the fallback action
+}
+
+// Err returns the error of the last action attempted, or nil if an action completed
+func (r Result) Err() error {
+	if r.Completed != nil {
+		return nil
+	}
+	if r.FallbackUsed {
+		return r.FallbackError
+	}
+	return r.PrimaryError
+}
+
+// RunWithFallback executes the primary action and, if it fails, the fallback action.
+// The fallback is skipped when it does nothing or is the same action as the primary.
+func RunWithFallback(ctx context.Context, primary, fallback Action) Result {
+	var result Result
+
+	result.PrimaryError = primary.Execute(ctx)
+	if result.PrimaryError == nil {
+		result.Completed = primary
+		return result
+	}
+
+	if IsNone(fallback) || fallback.Name() == primary.Name() {
+		return result
+	}
+
+	result.FallbackUsed = true
+	if err := fallback.Execute(ctx); err != nil {
+		result.FallbackError = err
+		return result
+	}
+
+	result.Completed = fallback
+	return result
+}
+
+// RunCommand runs a command and includes its combined output in any error
+func RunCommand(ctx context.Context, name string, args ...string) error {
+	output, err := exec.CommandContext(ctx, name, args...).CombinedOutput()
+	if err != nil {
+		out := strings.TrimSpace(string(output))
+		if out != "" {
+			return fmt.Errorf("%s failed: %w: %s", name, err, out)
+		}
+		return fmt.Errorf("%s failed: %w", name, err)
+	}
+	return nil
+}
+
+// azureAction performs an operation on the VM through the Azure API
+type azureAction struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func (a *azureAction) Name() string {
+	return a.name
+}
+
+func (a *azureAction) Execute(ctx context.Context) error {
+	return a.run(ctx)
+}
+
+// commandAction runs a command inside the guest OS
+type commandAction struct {
+	name    string
+	runner  CommandRunner
+	command string
+	args    []string
+}
+
+func (a *commandAction) Name() string {
+	return a.name
+}
+
+func (a *commandAction) Execute(ctx context.Context) error {
+	return a.runner(ctx, a.command, a.args...)
+}
+
+// noneAction takes no action
+type noneAction struct{}
+
+func (noneAction) Name() string {
+	return config.ActionNone
+}
+
+func (noneAction) Execute(ctx context.Context) error {
+	return nil
+}
@@ -0,0 +1,228 @@
+package action
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// fakeVMClient records Azure calls and returns configured errors
+type fakeVMClient struct {
+	hibernateCalls  int
+	deallocateCalls int
+	hibernateErr    error
+	deallocateErr   error
+}
+
+func (f *fakeVMClient) HibernateVM(ctx context.Context) error {
+	f.hibernateCalls++
+	return f.hibernateErr
+}
+
+func (f *fakeVMClient) DeallocateVM(ctx context.Context) error {
+	f.deallocateCalls++
+	return f.deallocateErr
+}
+
+// fakeRunner records commands instead of executing them
+type fakeRunner struct {
+	commands [][]string
+	err      error
+}
+
+func (f *fakeRunner) run(ctx context.Context, name string, args ...string) error {
+	f.commands = append(f.commands, append([]string{name}, args...))
+	return f.err
+}
+
+// fakeAction is a minimal Action used to test fallback dispatch
+type fakeAction struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *fakeAction) Name() string {
+	return f.name
+}
+
+func (f *fakeAction) Execute(ctx context.Context) error {
+	f.calls++
+	return f.err
+}
+
+// TestNew tests that each action name dispatches to the expected operation
+func TestNew(t *testing.T) {
+	tests := []struct {
+		name           string
+		action         string
+		wantHibernate  int
+		wantDeallocate int
+		wantCommand    []string
+		wantNone       bool
+	}{
+		{name: "hibernate", action: config.ActionHibernate, wantHibernate: 1},
+		{name: "deallocate", action: config.ActionDeallocate, wantDeallocate: 1},
+		{name: "os-shutdown", action: config.ActionOSShutdown, wantCommand: []string{"shutdown.exe", "/s", "/t", "0"}},
+		{name: "local-hibernate", action: config.ActionLocalHibernate, wantCommand: []string{"shutdown.exe", "/h"}},
+		{name: "run-script", action: config.ActionRunScript, wantCommand: []string{`C:\scripts\idle.cmd`, "--idle"}},
+		{name: "none", action: config.ActionNone, wantNone: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeVMClient{}
+			runner := &fakeRunner{}
+
+			a, err := New(tt.action, Deps{
+				Client:     client,
+				Runner:     runner.run,
+				Script:     `C:\scripts\idle.cmd`,
+				ScriptArgs: []string{"--idle"},
+			})
+			if err != nil {
+				t.Fatalf("New(%q) returned error: %v", tt.action, err)
+			}
+			if a.Name() != tt.action {
+				t.Errorf("Name() = %q, want %q", a.Name(), tt.action)
+			}
+
+			if err := a.Execute(context.Background()); err != nil {
+				t.Fatalf("Execute() returned error: %v", err)
+			}
+
+			if client.hibernateCalls != tt.wantHibernate {
+				t.Errorf("HibernateVM calls = %d, want %d", client.hibernateCalls, tt.wantHibernate)
+			}
+			if client.deallocateCalls != tt.wantDeallocate {
+				t.Errorf("DeallocateVM calls = %d, want %d", client.deallocateCalls, tt.wantDeallocate)
+			}
+			if tt.wantCommand != nil {
+				if len(runner.commands) != 1 || !reflect.DeepEqual(runner.commands[0], tt.wantCommand) {
+					t.Errorf("commands = %v, want [%v]", runner.commands, tt.wantCommand)
+				}
+			} else if len(runner.commands) != 0 {
+				t.Errorf("unexpected commands: %v", runner.commands)
+			}
+			if tt.wantNone && !IsNone(a) {
+				t.Errorf("IsNone() = false, want true")
+			}
+		})
+	}
+}
+
+// TestNewErrors tests invalid action construction
+func TestNewErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		action string
+		deps   Deps
+	}{
+		{name: "unknown action", action: "reboot", deps: Deps{Client: &fakeVMClient{}}},
+		{name: "hibernate without client", action: config.ActionHibernate},
+		{name: "deallocate without client", action: config.ActionDeallocate},
+		{name: "run-script without script", action: config.ActionRunScript},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.action, tt.deps); err == nil {
+				t.Errorf("New(%q) expected error, got none", tt.action)
+			}
+		})
+	}
+}
+
+// TestRunWithFallback tests primary/fallback dispatch
+func TestRunWithFallback(t *testing.T) {
+	errPrimary := errors.New("hibernation not enabled")
+	errFallback := errors.New("deallocate failed")
+
+	tests := []struct {
+		name              string
+		primary           *fakeAction
+		fallback          *fakeAction
+		wantCompleted     string
+		wantFallbackUsed  bool
+		wantFallbackCalls int
+		wantErr           error
+	}{
+		{
+			name:          "primary succeeds",
+			primary:       &fakeAction{name: config.ActionHibernate},
+			fallback:      &fakeAction{name: config.ActionDeallocate},
+			wantCompleted: config.ActionHibernate,
+		},
+		{
+			name:              "primary fails, fallback succeeds",
+			primary:           &fakeAction{name: config.ActionHibernate, err: errPrimary},
+			fallback:          &fakeAction{name: config.ActionDeallocate},
+			wantCompleted:     config.ActionDeallocate,
+			wantFallbackUsed:  true,
+			wantFallbackCalls: 1,
+		},
+		{
+			name:              "both fail",
+			primary:           &fakeAction{name: config.ActionHibernate, err: errPrimary},
+			fallback:          &fakeAction{name: config.ActionDeallocate, err: errFallback},
+			wantFallbackUsed:  true,
+			wantFallbackCalls: 1,
+			wantErr:           errFallback,
+		},
+		{
+			name:     "fallback is none",
+			primary:  &fakeAction{name: config.ActionHibernate, err: errPrimary},
+			fallback: &fakeAction{name: config.ActionNone},
+			wantErr:  errPrimary,
+		},
+		{
+			name:     "fallback same as primary",
+			primary:  &fakeAction{name: config.ActionHibernate, err: errPrimary},
+			fallback: &fakeAction{name: config.ActionHibernate},
+			wantErr:  errPrimary,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := RunWithFallback(context.Background(), tt.primary, tt.fallback)
+
+			if tt.wantCompleted == "" {
+				if result.Completed != nil {
+					t.Errorf("Completed = %q, want nil", result.Completed.Name())
+				}
+			} else if result.Completed == nil || result.Completed.Name() != tt.wantCompleted {
+				t.Errorf("Completed = %v, want %q", result.Completed, tt.wantCompleted)
+			}
+			if result.FallbackUsed != tt.wantFallbackUsed {
+				t.Errorf("FallbackUsed = %v, want %v", result.FallbackUsed, tt.wantFallbackUsed)
+			}
+			if tt.fallback.calls != tt.wantFallbackCalls {
+				t.Errorf("fallback calls = %d, want %d", tt.fallback.calls, tt.wantFallbackCalls)
+			}
+			if !errors.Is(result.Err(), tt.wantErr) {
+				t.Errorf("Err() = %v, want %v", result.Err(), tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerb(t *testing.T) {
+	tests := map[string]string{
+		"":                          "hibernate",
+		config.ActionHibernate:      "hibernate",
+		config.ActionDeallocate:     "be deallocated",
+		config.ActionOSShutdown:     "shut down",
+		config.ActionLocalHibernate: "hibernate locally",
+		config.ActionRunScript:      "run the action script",
+		"custom":                    "run custom",
+	}
+	for name, want := range tests {
+		if got := Verb(name); got != want {
+			t.Errorf("Verb(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package action
+
+import "time"
+
+// AttemptState is the state of the attempts at an idle action
+type AttemptState int
+
+const (
+	AttemptIdle      AttemptState = iota // No attempt has failed
+	AttemptRetrying                      // An attempt failed and a retry is scheduled
+	AttemptEscalated                     // The attempts are used up or the failure cannot be retried
+)
+
+func (s AttemptState) String() string {
+	switch s {
+	case AttemptRetrying:
+		return "retrying"
+	case AttemptEscalated:
+		return "escalated"
+	default:
+		return "idle"
+	}
+}
+
+// RetryPolicy bounds the attempts at an idle action before escalating
+type RetryPolicy struct {
+	MaxAttempts  int           // Attempts including the first; 1 escalates on the first failure
+	InitialDelay time.Duration // Delay before the first retry, doubled after each failure
+	MaxDelay     time.Duration // Longest delay between attempts
+}
+
+// Attempts tracks the attempts at an idle action until one succeeds or they escalate.
+// A failure that can be retried moves it to AttemptRetrying with a retry time; the last
+// allowed failure, or one that cannot be retried, moves it to AttemptEscalated. Reset
+// returns it to AttemptIdle. The zero value escalates on the first failure. It is not
+// safe for concurrent use.
+type Attempts struct {
+	policy   RetryPolicy
+	state    AttemptState
+	failures int
+	retryAt  time.Time
+}
+
+// NewAttempts returns an attempt tracker with the given policy
+func NewAttempts(policy RetryPolicy) Attempts {
+	if policy.MaxAttempts < 1 {
+		policy.MaxAttempts = 1
+	}
+	return Attempts{policy: policy}
+}
+
+// State returns the current state
+func (a *Attempts) State() AttemptState {
+	return a.state
+}
+
+// Failures returns the number of failed attempts since the last reset
+func (a *Attempts) Failures() int {
+	return a.failures
+}
+
+// MaxAttempts returns the number of attempts allowed before escalating
+func (a *Attempts) MaxAttempts() int {
+	return max(a.policy.MaxAttempts, 1)
+}
+
+// RetryAt returns when the next attempt is due while retrying
+func (a *Attempts) RetryAt() time.Time {
+	return a.retryAt
+}
+
+// Due reports whether an attempt may run now: none has failed, or the retry delay has passed
+func (a *Attempts) Due(now time.Time) bool {
+	return a.state != AttemptRetrying || !now.Before(a.retryAt)
+}
+
+// Failed records a failed attempt and returns the new state. A retryable failure schedules a
+// retry after the backoff delay, or after retryAfter if Azure asked for a longer one.
+func (a *Attempts) Failed(now time.Time, retryable bool, retryAfter time.Duration) AttemptState {
+	a.failures++
+	if !retryable || a.failures >= a.policy.MaxAttempts {
+		a.state = AttemptEscalated
+		a.retryAt = time.Time{}
+		return a.state
+	}
+
+	delay := a.delay(a.failures)
+	if retryAfter > delay {
+		delay = retryAfter
+	}
+	a.state = AttemptRetrying
+	a.retryAt = now.Add(delay)
+	return a.state
+}
+
+// Reset forgets the attempts once one succeeded, the escalation ran or the idle condition ended
+func (a *Attempts) Reset() {
+	a.state = AttemptIdle
+	a.failures = 0
+	a.retryAt = time.Time{}
+}
+
+// delay returns the backoff after the nth failure: InitialDelay doubled n-1 times, capped at MaxDelay
+func (a *Attempts) delay(n int) time.Duration {
+	delay := a.policy.InitialDelay
+	for i := 1; i < n && delay < a.policy.MaxDelay; i++ {
+		delay *= 2
+	}
+	if a.policy.MaxDelay > 0 && delay > a.policy.MaxDelay {
+		delay = a.policy.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,91 @@
+package action
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAttempts(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	policy := RetryPolicy{MaxAttempts: 4, InitialDelay: 30 * time.Second, MaxDelay: time.Minute}
+
+	type step struct {
+		at          time.Duration // Time of the failure after start
+		retryable   bool
+		retryAfter  time.Duration
+		wantState   AttemptState
+		wantRetryAt time.Duration // After start; ignored unless retrying
+	}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{
+			name: "backoff doubles up to the maximum, then escalates",
+			steps: []step{
+				{at: 0, retryable: true, wantState: AttemptRetrying, wantRetryAt: 30 * time.Second},
+				{at: 30 * time.Second, retryable: true, wantState: AttemptRetrying, wantRetryAt: 90 * time.Second},
+				{at: 90 * time.Second, retryable: true, wantState: AttemptRetrying, wantRetryAt: 150 * time.Second},
+				{at: 150 * time.Second, retryable: true, wantState: AttemptEscalated},
+			},
+		},
+		{
+			name: "failure that cannot be retried escalates at once",
+			steps: []step{
+				{at: 0, retryable: false, wantState: AttemptEscalated},
+			},
+		},
+		{
+			name: "longer Retry-After wins",
+			steps: []step{
+				{at: 0, retryable: true, retryAfter: 2 * time.Minute, wantState: AttemptRetrying, wantRetryAt: 2 * time.Minute},
+				{at: 2 * time.Minute, retryable: true, retryAfter: time.Second, wantState: AttemptRetrying, wantRetryAt: 3 * time.Minute},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a := NewAttempts(policy)
+			if a.State() != AttemptIdle || !a.Due(start) {
+				t.Fatalf("new Attempts: State() = %v, Due() = %v", a.State(), a.Due(start))
+			}
+			for i, s := range tt.steps {
+				now := start.Add(s.at)
+				if !a.Due(now) {
+					t.Fatalf("step %d: Due() = false before the attempt", i)
+				}
+				if got := a.Failed(now, s.retryable, s.retryAfter); got != s.wantState {
+					t.Fatalf("step %d: Failed() = %v, want %v", i, got, s.wantState)
+				}
+				if a.Failures() != i+1 {
+					t.Errorf("step %d: Failures() = %d, want %d", i, a.Failures(), i+1)
+				}
+				if s.wantState != AttemptRetrying {
+					continue
+				}
+				if want := start.Add(s.wantRetryAt); !a.RetryAt().Equal(want) {
+					t.Errorf("step %d: RetryAt() = %v, want %v", i, a.RetryAt(), want)
+				}
+				if a.Due(a.RetryAt().Add(-time.Second)) {
+					t.Errorf("step %d: Due() = true before the retry time", i)
+				}
+			}
+
+			a.Reset()
+			if a.State() != AttemptIdle || a.Failures() != 0 || !a.Due(start) {
+				t.Errorf("after Reset: State() = %v, Failures() = %d", a.State(), a.Failures())
+			}
+		})
+	}
+}
+
+func TestAttemptsZeroValue(t *testing.T) {
+	var a Attempts
+	if got := a.Failed(time.Now(), true, 0); got != AttemptEscalated {
+		t.Errorf("Failed() = %v, want %v", got, AttemptEscalated)
+	}
+	if a.MaxAttempts() != 1 {
+		t.Errorf("MaxAttempts() = %d, want 1", a.MaxAttempts())
+	}
+}
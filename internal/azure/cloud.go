@@ -0,0 +1,122 @@
+package azure
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// Cloud describes the endpoints of an Azure cloud (public, sovereign or Azure Stack Hub)
+type Cloud struct {
+	Name                    string // Cloud name (e.g. AzurePublicCloud)
+	ResourceManagerEndpoint string // Azure Resource Manager endpoint, without trailing slash
+	TokenAudience           string // Resource (audience) requested for ARM access tokens
+	AuthorityHost           string // Entra ID authority used by service principal credentials
+}
+
+// customCloudName names a cloud defined only by configured endpoints
+const customCloudName = "AzureCustomCloud"
+
+// Built-in Azure clouds
+var (
+	AzurePublicCloud = Cloud{
+		Name:                    config.CloudAzurePublic,
+		ResourceManagerEndpoint: "https://management.azure.com",
+		TokenAudience:           "https://management.azure.com/",
+		AuthorityHost:           "https://login.microsoftonline.com",
+	}
+	AzureUSGovernmentCloud = Cloud{
+		Name:                    config.CloudAzureUSGovernment,
+		ResourceManagerEndpoint: "https://management.usgovcloudapi.net",
+		TokenAudience:           "https://management.usgovcloudapi.net/",
+		AuthorityHost:           "https://login.microsoftonline.us",
+	}
+	AzureChinaCloud = Cloud{
+		Name:                    config.CloudAzureChina,
+		ResourceManagerEndpoint: "https://management.chinacloudapi.cn",
+		TokenAudience:           "https://management.chinacloudapi.cn/",
+		AuthorityHost:           "https://login.chinacloudapi.cn",
+	}
+)
+
+// knownClouds maps IMDS azEnvironment values (lower-cased) to built-in clouds
+var knownClouds = map[string]Cloud{
+	strings.ToLower(config.CloudAzurePublic):       AzurePublicCloud,
+	strings.ToLower(config.CloudAzureUSGovernment): AzureUSGovernmentCloud,
+	strings.ToLower(config.CloudAzureChina):        AzureChinaCloud,
+}
+
+// ResolveCloud determines the cloud to use from the configuration and the
+// azEnvironment reported by IMDS. An explicitly configured name takes
+// precedence over azEnvironment, and configured endpoints override those of
+// the selected cloud. An unknown environment is only accepted when the
+// configuration supplies a Resource Manager endpoint (e.g. Azure Stack Hub).
+func ResolveCloud(cfg config.CloudConfig, azEnvironment string) (Cloud, error) {
+	name := cfg.Name
+	if name == "" {
+		name = azEnvironment
+	}
+	if name == "" {
+		if cfg.ResourceManagerEndpoint == "" {
+			name = config.CloudAzurePublic
+		} else {
+			name = customCloudName
+		}
+	}
+
+	cloud, known := knownClouds[strings.ToLower(name)]
+	if !known {
+		if cfg.ResourceManagerEndpoint == "" {
+			return Cloud{}, fmt.Errorf("unknown Azure environment %q: set cloud.resourceManagerEndpoint in config.json", name)
+		}
+		// Custom cloud - default to the public Entra ID authority unless overridden
+		cloud = Cloud{Name: name, AuthorityHost: AzurePublicCloud.AuthorityHost}
+	}
+
+	if cfg.ResourceManagerEndpoint != "" {
+		cloud.ResourceManagerEndpoint = strings.TrimSuffix(cfg.ResourceManagerEndpoint, "/")
+		cloud.TokenAudience = cloud.ResourceManagerEndpoint + "/"
+	}
+	if cfg.TokenAudience != "" {
+		cloud.TokenAudience = cfg.TokenAudience
+	}
+	if cfg.AuthorityHost != "" {
+		cloud.AuthorityHost = strings.TrimSuffix(cfg.AuthorityHost, "/")
+	}
+
+	return cloud, nil
+}
+
+// String returns a human-readable description of the cloud
+func (c Cloud) String() string {
+	return fmt.Sprintf("%s (%s)", c.Name, c.ResourceManagerEndpoint)
+}
+
+// vmURL builds the ARM URL of a virtual machine, with an optional action path
+func (c Cloud) vmURL(subscriptionId, resourceGroup, vmName, action string) string {
+	// https://management.azure.com/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}[/{action}]?api-version=2024-07-01
+	url := fmt.Sprintf(
+		"%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s",
+		c.ResourceManagerEndpoint,
+		subscriptionId,
+		resourceGroup,
+		vmName,
+	)
+	if action != "" {
+		url += "/" + action
+	}
+	return url + "?api-version=" + computeApiVersion
+}
+
+// tagsURL builds the ARM Tags API URL for a virtual machine
+func (c Cloud) tagsURL(subscriptionId, resourceGroup, vmName string) string {
+	return fmt.Sprintf(
+		"%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s/providers/Microsoft.Resources/tags/default?api-version=%s",
+		c.ResourceManagerEndpoint,
+		subscriptionId,
+		resourceGroup,
+		vmName,
+		tagsApiVersion,
+	)
+}
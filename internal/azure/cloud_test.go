@@ -0,0 +1,114 @@
+package azure
+
+import (
+	"testing"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// TestResolveCloud tests cloud selection from IMDS azEnvironment and configuration overrides
+func TestResolveCloud(t *testing.T) {
+	tests := []struct {
+		name          string
+		cfg           config.CloudConfig
+		azEnvironment string
+		expectError   bool
+		want          Cloud
+	}{
+		{name: "empty defaults to public", want: AzurePublicCloud},
+		{name: "public from IMDS", azEnvironment: "AzurePublicCloud", want: AzurePublicCloud},
+		{name: "government from IMDS", azEnvironment: "AzureUSGovernmentCloud", want: AzureUSGovernmentCloud},
+		{name: "china from IMDS (case-insensitive)", azEnvironment: "azurechinacloud", want: AzureChinaCloud},
+		{
+			name:          "configured name overrides IMDS",
+			cfg:           config.CloudConfig{Name: config.CloudAzureUSGovernment},
+			azEnvironment: "AzurePublicCloud",
+			want:          AzureUSGovernmentCloud,
+		},
+		{name: "unknown IMDS environment", azEnvironment: "AzureStackCloud", expectError: true},
+		{
+			name:          "Azure Stack Hub with configured endpoints",
+			azEnvironment: "AzureStackCloud",
+			cfg: config.CloudConfig{
+				ResourceManagerEndpoint: "https://management.local.azurestack.external/",
+				TokenAudience:           "https://management.adfs.azurestack.local/0000",
+				AuthorityHost:           "https://adfs.local.azurestack.external/",
+			},
+			want: Cloud{
+				Name:                    "AzureStackCloud",
+				ResourceManagerEndpoint: "https://management.local.azurestack.external",
+				TokenAudience:           "https://management.adfs.azurestack.local/0000",
+				AuthorityHost:           "https://adfs.local.azurestack.external",
+			},
+		},
+		{
+			name: "custom endpoint without environment",
+			cfg:  config.CloudConfig{ResourceManagerEndpoint: "https://arm.example.com"},
+			want: Cloud{
+				Name:                    customCloudName,
+				ResourceManagerEndpoint: "https://arm.example.com",
+				TokenAudience:           "https://arm.example.com/",
+				AuthorityHost:           AzurePublicCloud.AuthorityHost,
+			},
+		},
+		{
+			name:          "authority override on a built-in cloud",
+			cfg:           config.CloudConfig{AuthorityHost: "https://login.example.com"},
+			azEnvironment: "AzureChinaCloud",
+			want: Cloud{
+				Name:                    config.CloudAzureChina,
+				ResourceManagerEndpoint: AzureChinaCloud.ResourceManagerEndpoint,
+				TokenAudience:           AzureChinaCloud.TokenAudience,
+				AuthorityHost:           "https://login.example.com",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cloud, err := ResolveCloud(tt.cfg, tt.azEnvironment)
+			if tt.expectError {
+				if err == nil {
+					t.Errorf("Expected error but got cloud %v", cloud)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if cloud != tt.want {
+				t.Errorf("ResolveCloud() = %+v, want %+v", cloud, tt.want)
+			}
+		})
+	}
+}
+
+// TestCloudVMURL tests that VM URLs are built from the cloud's Resource Manager endpoint
+func TestCloudVMURL(t *testing.T) {
+	tests := []struct {
+		name   string
+		cloud  Cloud
+		action string
+		want   string
+	}{
+		{
+			name:  "public VM properties",
+			cloud: AzurePublicCloud,
+			want:  "https://management.azure.com/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm?api-version=" + computeApiVersion,
+		},
+		{
+			name:   "government deallocate",
+			cloud:  AzureUSGovernmentCloud,
+			action: "deallocate",
+			want:   "https://management.usgovcloudapi.net/subscriptions/sub/resourceGroups/rg/providers/Microsoft.Compute/virtualMachines/vm/deallocate?api-version=" + computeApiVersion,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.cloud.vmURL("sub", "rg", "vm", tt.action); got != tt.want {
+				t.Errorf("vmURL() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
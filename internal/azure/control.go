@@ -0,0 +1,163 @@
+package azure
+
+import (
+	"bufio"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Remote control keys, read from VM tags or from IMDS user data (one key=value per line)
+const (
+	TagMode       = "autohibernate:mode"       // enabled, paused or dryrun
+	TagPauseUntil = "autohibernate:pauseUntil" // RFC3339 time until which auto-hibernation is paused
+)
+
+// Remote control modes
+const (
+	ModeEnabled = "enabled" // Idle actions run normally (default)
+	ModePaused  = "paused"  // Idle checks are paused
+	ModeDryRun  = "dryrun"  // Idle checks run but actions are only logged
+)
+
+// Remote control sources
+const (
+	ControlSourceTags     = "tags"
+	ControlSourceUserData = "userData"
+)
+
+// validModes lists all supported remote control modes
+var validModes = map[string]bool{
+	ModeEnabled: true,
+	ModePaused:  true,
+	ModeDryRun:  true,
+}
+
+// Control is the remote control value set on the VM through tags or IMDS user data.
+// It lets operators pause auto-hibernation across a fleet without logging into each VM.
+type Control struct {
+	Mode       string    // enabled, paused or dryrun (enabled if not set)
+	PauseUntil time.Time // Auto-hibernation is paused until this time (zero if not set)
+	Source     string    // tags, userData, or empty if no control value is set
+	Warnings   []string  // Invalid values that were ignored
+}
+
+// EffectiveMode returns the mode in force at the given time, applying PauseUntil.
+// An expired pauseUntil ends a paused mode.
+func (c Control) EffectiveMode(now time.Time) string {
+	mode := c.Mode
+	if mode == "" {
+		mode = ModeEnabled
+	}
+	if c.PauseUntil.IsZero() {
+		return mode
+	}
+	if now.Before(c.PauseUntil) {
+		return ModePaused
+	}
+	if mode == ModePaused {
+		return ModeEnabled
+	}
+	return mode
+}
+
+// String returns a human-readable description of the control value
+func (c Control) String() string {
+	if c.Source == "" {
+		return "enabled (no remote control value set)"
+	}
+	mode := c.Mode
+	if mode == "" {
+		mode = ModeEnabled
+	}
+	s := fmt.Sprintf("%s (from %s", mode, c.Source)
+	if !c.PauseUntil.IsZero() {
+		s += ", pauseUntil " + c.PauseUntil.Format(time.RFC3339)
+	}
+	return s + ")"
+}
+
+// GetControl reads the remote control value from the VM tags and user data reported by IMDS.
+// Tags take precedence: user data is only used when no control tag is set.
+// endpoint overrides the IMDS base URL; leave empty to use DefaultIMDSEndpoint.
+func GetControl(ctx context.Context, endpoint string) (Control, error) {
+	compute, err := getComputeMetadata(ctx, endpoint)
+	if err != nil {
+		return Control{}, err
+	}
+
+	tags := make(map[string]string, len(compute.TagsList))
+	for _, tag := range compute.TagsList {
+		tags[tag.Name] = tag.Value
+	}
+
+	return parseControl(tags, compute.UserData), nil
+}
+
+// parseControl builds the control value from VM tags and base64-encoded user data
+func parseControl(tags map[string]string, userData string) Control {
+	values := controlValues(tags)
+	source := ControlSourceTags
+	if len(values) == 0 {
+		values = userDataControlValues(userData)
+		source = ControlSourceUserData
+	}
+	if len(values) == 0 {
+		return Control{}
+	}
+
+	control := Control{Source: source}
+	if mode, ok := values[TagMode]; ok {
+		mode = strings.ToLower(mode)
+		if validModes[mode] {
+			control.Mode = mode
+		} else {
+			control.Warnings = append(control.Warnings, fmt.Sprintf("%s must be one of: enabled, paused, dryrun (got: %s)", TagMode, values[TagMode]))
+		}
+	}
+	if until, ok := values[TagPauseUntil]; ok && until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			control.Warnings = append(control.Warnings, fmt.Sprintf("%s must be an RFC3339 time (got: %s)", TagPauseUntil, until))
+		} else {
+			control.PauseUntil = t
+		}
+	}
+	return control
+}
+
+// controlValues returns the control keys set in a key/value map, matching keys case-insensitively
+func controlValues(pairs map[string]string) map[string]string {
+	values := make(map[string]string)
+	for key, value := range pairs {
+		for _, name := range []string{TagMode, TagPauseUntil} {
+			if strings.EqualFold(strings.TrimSpace(key), name) {
+				values[name] = strings.TrimSpace(value)
+			}
+		}
+	}
+	return values
+}
+
+// userDataControlValues returns the control keys set in user data as key=value lines.
+// Other lines (e.g. scripts or other settings) are ignored.
+func userDataControlValues(userData string) map[string]string {
+	if userData == "" {
+		return nil
+	}
+	data, err := base64.StdEncoding.DecodeString(userData)
+	if err != nil {
+		return nil
+	}
+
+	pairs := make(map[string]string)
+	scanner := bufio.NewScanner(strings.NewReader(string(data)))
+	for scanner.Scan() {
+		if key, value, ok := strings.Cut(scanner.Text(), "="); ok {
+			pairs[key] = value
+		}
+	}
+	return controlValues(pairs)
+}
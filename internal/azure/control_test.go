@@ -0,0 +1,115 @@
+package azure
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+// TestParseControl tests reading the remote control value from tags and user data
+func TestParseControl(t *testing.T) {
+	userData := func(s string) string { return base64.StdEncoding.EncodeToString([]byte(s)) }
+	until := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name         string
+		tags         map[string]string
+		userData     string
+		wantMode     string
+		wantUntil    time.Time
+		wantSource   string
+		wantWarnings int
+	}{
+		{
+			name: "nothing set",
+		},
+		{
+			name:       "paused by tag",
+			tags:       map[string]string{"autohibernate:mode": "Paused", "owner": "team"},
+			wantMode:   ModePaused,
+			wantSource: ControlSourceTags,
+		},
+		{
+			name:       "pause until by tag",
+			tags:       map[string]string{"AutoHibernate:PauseUntil": "2025-06-01T18:00:00Z"},
+			wantUntil:  until,
+			wantSource: ControlSourceTags,
+		},
+		{
+			name:       "dry run from user data",
+			userData:   userData("#!/bin/sh\necho hello\nautohibernate:mode=dryrun\nautohibernate:pauseUntil=2025-06-01T18:00:00Z\n"),
+			wantMode:   ModeDryRun,
+			wantUntil:  until,
+			wantSource: ControlSourceUserData,
+		},
+		{
+			name:       "tags take precedence over user data",
+			tags:       map[string]string{"autohibernate:mode": "enabled"},
+			userData:   userData("autohibernate:mode=paused"),
+			wantMode:   ModeEnabled,
+			wantSource: ControlSourceTags,
+		},
+		{
+			name:     "user data without control keys",
+			userData: userData("some other setting=1"),
+		},
+		{
+			name:     "user data not base64",
+			userData: "autohibernate:mode=paused",
+		},
+		{
+			name:         "invalid values are ignored",
+			tags:         map[string]string{"autohibernate:mode": "off", "autohibernate:pauseUntil": "tomorrow"},
+			wantSource:   ControlSourceTags,
+			wantWarnings: 2,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := parseControl(tt.tags, tt.userData)
+			if c.Mode != tt.wantMode {
+				t.Errorf("Mode = %q, want %q", c.Mode, tt.wantMode)
+			}
+			if !c.PauseUntil.Equal(tt.wantUntil) {
+				t.Errorf("PauseUntil = %v, want %v", c.PauseUntil, tt.wantUntil)
+			}
+			if c.Source != tt.wantSource {
+				t.Errorf("Source = %q, want %q", c.Source, tt.wantSource)
+			}
+			if len(c.Warnings) != tt.wantWarnings {
+				t.Errorf("Warnings = %v, want %d", c.Warnings, tt.wantWarnings)
+			}
+		})
+	}
+}
+
+// TestControlEffectiveMode tests applying pauseUntil to the mode
+func TestControlEffectiveMode(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+	later := now.Add(time.Hour)
+	earlier := now.Add(-time.Hour)
+
+	tests := []struct {
+		name    string
+		control Control
+		want    string
+	}{
+		{name: "default", control: Control{}, want: ModeEnabled},
+		{name: "paused", control: Control{Mode: ModePaused}, want: ModePaused},
+		{name: "dry run", control: Control{Mode: ModeDryRun}, want: ModeDryRun},
+		{name: "pause until in the future", control: Control{PauseUntil: later}, want: ModePaused},
+		{name: "pause until expired", control: Control{PauseUntil: earlier}, want: ModeEnabled},
+		{name: "paused until expired", control: Control{Mode: ModePaused, PauseUntil: earlier}, want: ModeEnabled},
+		{name: "dry run after pause", control: Control{Mode: ModeDryRun, PauseUntil: earlier}, want: ModeDryRun},
+		{name: "dry run paused", control: Control{Mode: ModeDryRun, PauseUntil: later}, want: ModePaused},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.control.EffectiveMode(now); got != tt.want {
+				t.Errorf("EffectiveMode() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package azure
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+const (
+	// tokenRefreshMargin is how long before expiry a cached token is refreshed
+	tokenRefreshMargin = 5 * time.Minute
+
+	// clientAssertionType is the OAuth assertion type for JWT client assertions
+	clientAssertionType = "urn:ietf:params:oauth:client-assertion-type:jwt-bearer"
+)
+
+// Credential provides access tokens for Azure Resource Manager requests
+type Credential interface {
+	// GetToken returns an access token for the given resource (token audience)
+	GetToken(ctx context.Context, resource string) (string, error)
+	// String describes the credential for logs and diagnostics
+	String() string
+}
+
+// NewCredential builds the credential selected in the configuration for the given cloud.
+// Secrets are never read from config.json; they come from a DPAPI-protected file or an environment variable.
+func NewCredential(cfg *config.Config, cloud Cloud) (Credential, error) {
+	cc := cfg.Credential
+
+	switch cc.Type {
+	case "", config.CredentialManagedIdentity:
+		return &ManagedIdentityCredential{Endpoint: cfg.IMDSEndpoint, Identity: ManagedIdentity{
+			ClientID:   cfg.ManagedIdentity.ClientID,
+			ObjectID:   cfg.ManagedIdentity.ObjectID,
+			ResourceID: cfg.ManagedIdentity.ResourceID,
+		}}, nil
+
+	case config.CredentialClientSecret:
+		secret, err := ReadSecret(cc.SecretFile, cc.SecretEnv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read client secret: %w", err)
+		}
+		if secret == "" {
+			return nil, fmt.Errorf("client secret is empty")
+		}
+		return &ClientSecretCredential{AuthorityHost: cloud.AuthorityHost, TenantID: cc.TenantID, ClientID: cc.ClientID, Secret: secret}, nil
+
+	case config.CredentialClientCertificate:
+		password, err := ReadSecret(cc.SecretFile, cc.SecretEnv)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read certificate password: %w", err)
+		}
+		cert, key, err := loadCertificate(cc.CertificateFile, password)
+		if err != nil {
+			return nil, err
+		}
+		return &ClientCertificateCredential{AuthorityHost: cloud.AuthorityHost, TenantID: cc.TenantID, ClientID: cc.ClientID, Certificate: cert, Key: key}, nil
+
+	case config.CredentialWorkloadIdentity:
+		return &WorkloadIdentityCredential{AuthorityHost: cloud.AuthorityHost, TenantID: cc.TenantID, ClientID: cc.ClientID, TokenFile: cc.TokenFile}, nil
+
+	default:
+		return nil, fmt.Errorf("unknown credential type: %s", cc.Type)
+	}
+}
+
+// ManagedIdentityCredential gets tokens from the VM's managed identity through IMDS
+type ManagedIdentityCredential struct {
+	Endpoint string // IMDS base URL (default: DefaultIMDSEndpoint)
+	Identity ManagedIdentity
+}
+
+func (c *ManagedIdentityCredential) GetToken(ctx context.Context, resource string) (string, error) {
+	return getIMDSToken(ctx, c.Endpoint, c.Identity, resource)
+}
+
+func (c *ManagedIdentityCredential) String() string {
+	return fmt.Sprintf("managed identity, %s", c.Identity)
+}
+
+// ClientSecretCredential authenticates a service principal with a client secret
+type ClientSecretCredential struct {
+	AuthorityHost string // Entra ID authority (default: https://login.microsoftonline.com)
+	TenantID      string
+	ClientID      string
+	Secret        string
+	cache         tokenCache
+}
+
+func (c *ClientSecretCredential) GetToken(ctx context.Context, resource string) (string, error) {
+	return c.cache.get(resource, func() (string, time.Duration, error) {
+		return requestAADToken(ctx, c.AuthorityHost, c.TenantID, url.Values{
+			"grant_type":    {"client_credentials"},
+			"client_id":     {c.ClientID},
+			"client_secret": {c.Secret},
+			"scope":         {scopeFor(resource)},
+		})
+	})
+}
+
+func (c *ClientSecretCredential) String() string {
+	return fmt.Sprintf("service principal (client secret), tenant=%s, client_id=%s", c.TenantID, c.ClientID)
+}
+
+// ClientCertificateCredential authenticates a service principal with a certificate
+type ClientCertificateCredential struct {
+	AuthorityHost string // Entra ID authority (default: https://login.microsoftonline.com)
+	TenantID      string
+	ClientID      string
+	Certificate   *x509.Certificate
+	Key           *rsa.PrivateKey
+	cache         tokenCache
+}
+
+func (c *ClientCertificateCredential) GetToken(ctx context.Context, resource string) (string, error) {
+	return c.cache.get(resource, func() (string, time.Duration, error) {
+		assertion, err := c.clientAssertion(aadTokenURL(c.AuthorityHost, c.TenantID))
+		if err != nil {
+			return "", 0, err
+		}
+		return requestAADToken(ctx, c.AuthorityHost, c.TenantID, url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {c.ClientID},
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {assertion},
+			"scope":                 {scopeFor(resource)},
+		})
+	})
+}
+
+func (c *ClientCertificateCredential) String() string {
+	return fmt.Sprintf("service principal (certificate %s), tenant=%s, client_id=%s",
+		certificateThumbprint(c.Certificate), c.TenantID, c.ClientID)
+}
+
+// clientAssertion builds a signed JWT client assertion for the token endpoint
+func (c *ClientCertificateCredential) clientAssertion(audience string) (string, error) {
+	thumbprint := sha1.Sum(c.Certificate.Raw)
+	header, err := json.Marshal(map[string]string{
+		"alg": "RS256",
+		"typ": "JWT",
+		"x5t": base64.RawURLEncoding.EncodeToString(thumbprint[:]),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", fmt.Errorf("failed to generate assertion ID: %w", err)
+	}
+	now := time.Now()
+	claims, err := json.Marshal(map[string]interface{}{
+		"aud": audience,
+		"iss": c.ClientID,
+		"sub": c.ClientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now.Unix(),
+		"exp": now.Add(10 * time.Minute).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signingInput))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, c.Key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign client assertion: %w", err)
+	}
+
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// WorkloadIdentityCredential exchanges a federated token file for an access token
+type WorkloadIdentityCredential struct {
+	AuthorityHost string // Entra ID authority (default: https://login.microsoftonline.com)
+	TenantID      string
+	ClientID      string
+	TokenFile     string
+	cache         tokenCache
+}
+
+func (c *WorkloadIdentityCredential) GetToken(ctx context.Context, resource string) (string, error) {
+	return c.cache.get(resource, func() (string, time.Duration, error) {
+		// Re-read the file for each token request since the federated token is rotated externally
+		assertion, err := os.ReadFile(c.TokenFile)
+		if err != nil {
+			return "", 0, fmt.Errorf("failed to read federated token file: %w", err)
+		}
+		return requestAADToken(ctx, c.AuthorityHost, c.TenantID, url.Values{
+			"grant_type":            {"client_credentials"},
+			"client_id":             {c.ClientID},
+			"client_assertion_type": {clientAssertionType},
+			"client_assertion":      {strings.TrimSpace(string(assertion))},
+			"scope":                 {scopeFor(resource)},
+		})
+	})
+}
+
+func (c *WorkloadIdentityCredential) String() string {
+	return fmt.Sprintf("workload identity (token file %s), tenant=%s, client_id=%s", c.TokenFile, c.TenantID, c.ClientID)
+}
+
+// tokenCache caches a single access token until shortly before it expires
+type tokenCache struct {
+	mu        sync.Mutex
+	resource  string
+	token     string
+	expiresAt time.Time
+}
+
+// get returns the cached token for the resource or fetches a new one
+func (tc *tokenCache) get(resource string, fetch func() (string, time.Duration, error)) (string, error) {
+	tc.mu.Lock()
+	defer tc.mu.Unlock()
+
+	if tc.token != "" && tc.resource == resource && time.Now().Before(tc.expiresAt.Add(-tokenRefreshMargin)) {
+		return tc.token, nil
+	}
+
+	token, expiresIn, err := fetch()
+	if err != nil {
+		return "", err
+	}
+
+	tc.resource = resource
+	tc.token = token
+	tc.expiresAt = time.Now().Add(expiresIn)
+	return token, nil
+}
+
+// aadTokenResponse represents the Entra ID v2.0 token endpoint response
+type aadTokenResponse struct {
+	AccessToken      string      `json:"access_token"`
+	ExpiresIn        json.Number `json:"expires_in"`
+	Error            string      `json:"error"`
+	ErrorDescription string      `json:"error_description"`
+}
+
+// aadTokenURL returns the v2.0 token endpoint for a tenant
+func aadTokenURL(authorityHost, tenantID string) string {
+	if authorityHost == "" {
+		authorityHost = AzurePublicCloud.AuthorityHost
+	}
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimSuffix(authorityHost, "/"), url.PathEscape(tenantID))
+}
+
+// scopeFor converts a resource (audience) into a v2.0 ".default" scope
+func scopeFor(resource string) string {
+	return strings.TrimSuffix(resource, "/") + "/.default"
+}
+
+// requestAADToken posts a client credentials request to the tenant's token endpoint
+func requestAADToken(ctx context.Context, authorityHost, tenantID string, form url.Values) (token string, lifetime time.Duration, err error) {
+	tokenURL := aadTokenURL(authorityHost, tenantID)
+	ctx, span := startRequest(ctx, "entra token", apiEntra, tracing.String("tenant.id", tenantID))
+	defer func() { span.End(err) }()
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to create token request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := httpclient.Default()
+	resp, err := client.Do(req)
+	observe(apiEntra, resp, err)
+	traceResponse(span, resp)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to get token from %s: %w", tokenURL, err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", 0, fmt.Errorf("failed to read token response: %w", err)
+	}
+
+	var tokenResp aadTokenResponse
+	if err := json.Unmarshal(body, &tokenResp); err != nil {
+		return "", 0, fmt.Errorf("token endpoint returned status %d: %s", resp.StatusCode, string(body))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", 0, fmt.Errorf("token endpoint returned status %d: %s: %s", resp.StatusCode, tokenResp.Error, tokenResp.ErrorDescription)
+	}
+	if tokenResp.AccessToken == "" {
+		return "", 0, fmt.Errorf("access token is empty in response")
+	}
+
+	expiresIn, err := tokenResp.ExpiresIn.Int64()
+	if err != nil || expiresIn <= 0 {
+		// Unknown lifetime - don't cache beyond the refresh margin
+		expiresIn = int64(tokenRefreshMargin.Seconds())
+	}
+
+	return tokenResp.AccessToken, time.Duration(expiresIn) * time.Second, nil
+}
+
+// ReadSecret reads a secret from a DPAPI-protected file or an environment variable.
+// Secrets are never stored in config.json; each setting that needs one references it
+// through such a file and environment variable pair.
+// Returns an empty string if neither source is configured.
+func ReadSecret(secretFile, secretEnv string) (string, error) {
+	if secretFile != "" {
+		data, err := os.ReadFile(secretFile)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret file: %w", err)
+		}
+		secret, err := UnprotectSecret(data)
+		if err != nil {
+			return "", fmt.Errorf("failed to decrypt secret file %s: %w", secretFile, err)
+		}
+		return strings.TrimSpace(string(secret)), nil
+	}
+
+	if secretEnv != "" {
+		secret, ok := os.LookupEnv(secretEnv)
+		if !ok {
+			return "", fmt.Errorf("environment variable %s is not set", secretEnv)
+		}
+		return strings.TrimSpace(secret), nil
+	}
+
+	return "", nil
+}
+
+// loadCertificate loads an RSA certificate and private key from a PFX or PEM file.
+// PFX files may use modern (AES/PBES2) encryption and carry a CA chain, which is ignored.
+func loadCertificate(path, password string) (*x509.Certificate, *rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read certificate file: %w", err)
+	}
+
+	var cert *x509.Certificate
+	var key crypto.PrivateKey
+
+	lower := strings.ToLower(path)
+	if strings.HasSuffix(lower, ".pfx") || strings.HasSuffix(lower, ".p12") {
+		key, cert, _, err = pkcs12.DecodeChain(data, password)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to decode PFX certificate: %w", err)
+		}
+	} else {
+		cert, key, err = parsePEMCertificate(data)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, nil, fmt.Errorf("certificate private key must be RSA (got %T)", key)
+	}
+
+	return cert, rsaKey, nil
+}
+
+// parsePEMCertificate extracts the first certificate and private key from PEM data
+func parsePEMCertificate(data []byte) (*x509.Certificate, crypto.PrivateKey, error) {
+	var cert *x509.Certificate
+	var key crypto.PrivateKey
+
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+
+		switch block.Type {
+		case "CERTIFICATE":
+			if cert == nil {
+				c, err := x509.ParseCertificate(block.Bytes)
+				if err != nil {
+					return nil, nil, fmt.Errorf("failed to parse certificate: %w", err)
+				}
+				cert = c
+			}
+		case "PRIVATE KEY":
+			k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+			key = k
+		case "RSA PRIVATE KEY":
+			k, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, nil, fmt.Errorf("failed to parse private key: %w", err)
+			}
+			key = k
+		}
+	}
+
+	if cert == nil {
+		return nil, nil, fmt.Errorf("no certificate found in PEM file")
+	}
+	if key == nil {
+		return nil, nil, fmt.Errorf("no private key found in PEM file")
+	}
+
+	return cert, key, nil
+}
+
+// certificateThumbprint returns the SHA-1 thumbprint of a certificate as shown in the Azure portal
+func certificateThumbprint(cert *x509.Certificate) string {
+	sum := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(sum[:]))
+}
@@ -0,0 +1,307 @@
+package azure
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// tokenServer is a stand-in for the Entra ID token endpoint that records requests
+type tokenServer struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []url.Values
+	paths    []string
+}
+
+func newTokenServer(t *testing.T) *tokenServer {
+	ts := &tokenServer{}
+	ts.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseForm(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ts.mu.Lock()
+		ts.requests = append(ts.requests, r.PostForm)
+		ts.paths = append(ts.paths, r.URL.Path)
+		ts.mu.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"token_type":   "Bearer",
+			"expires_in":   3599,
+			"access_token": "token-for-" + r.PostForm.Get("client_id"),
+		})
+	}))
+	t.Cleanup(ts.Close)
+	return ts
+}
+
+// TestClientSecretCredential tests the client secret flow and token caching
+func TestClientSecretCredential(t *testing.T) {
+	ts := newTokenServer(t)
+	cred := &ClientSecretCredential{AuthorityHost: ts.URL, TenantID: "tenant", ClientID: "app", Secret: "s3cret"}
+
+	for i := 0; i < 2; i++ {
+		token, err := cred.GetToken(context.Background(), "https://management.azure.com/")
+		if err != nil {
+			t.Fatalf("GetToken() error: %v", err)
+		}
+		if token != "token-for-app" {
+			t.Errorf("GetToken() = %q, want %q", token, "token-for-app")
+		}
+	}
+
+	if len(ts.requests) != 1 {
+		t.Fatalf("token requests = %d, want 1 (second call should be cached)", len(ts.requests))
+	}
+	form := ts.requests[0]
+	if ts.paths[0] != "/tenant/oauth2/v2.0/token" {
+		t.Errorf("path = %q, want %q", ts.paths[0], "/tenant/oauth2/v2.0/token")
+	}
+	if form.Get("grant_type") != "client_credentials" {
+		t.Errorf("grant_type = %q, want client_credentials", form.Get("grant_type"))
+	}
+	if form.Get("client_secret") != "s3cret" {
+		t.Errorf("client_secret = %q, want s3cret", form.Get("client_secret"))
+	}
+	if form.Get("scope") != "https://management.azure.com/.default" {
+		t.Errorf("scope = %q, want %q", form.Get("scope"), "https://management.azure.com/.default")
+	}
+}
+
+// TestClientCertificateCredential tests PEM loading and the signed client assertion
+func TestClientCertificateCredential(t *testing.T) {
+	ts := newTokenServer(t)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey: %v", err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "autohibernate-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate: %v", err)
+	}
+
+	pemData := append(
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})...,
+	)
+	certPath := filepath.Join(t.TempDir(), "sp.pem")
+	if err := os.WriteFile(certPath, pemData, 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cert, loadedKey, err := loadCertificate(certPath, "")
+	if err != nil {
+		t.Fatalf("loadCertificate() error: %v", err)
+	}
+
+	cred := &ClientCertificateCredential{AuthorityHost: ts.URL, TenantID: "tenant", ClientID: "app", Certificate: cert, Key: loadedKey}
+	if _, err := cred.GetToken(context.Background(), "https://management.azure.com/"); err != nil {
+		t.Fatalf("GetToken() error: %v", err)
+	}
+
+	form := ts.requests[0]
+	if form.Get("client_assertion_type") != clientAssertionType {
+		t.Errorf("client_assertion_type = %q, want %q", form.Get("client_assertion_type"), clientAssertionType)
+	}
+	if form.Get("client_secret") != "" {
+		t.Error("certificate flow must not send a client secret")
+	}
+
+	// Verify the assertion header, claims and signature
+	parts := strings.Split(form.Get("client_assertion"), ".")
+	if len(parts) != 3 {
+		t.Fatalf("client_assertion has %d parts, want 3", len(parts))
+	}
+	var header map[string]string
+	decodeSegment(t, parts[0], &header)
+	thumbprint := sha1.Sum(der)
+	if header["x5t"] != base64.RawURLEncoding.EncodeToString(thumbprint[:]) {
+		t.Errorf("x5t = %q does not match certificate thumbprint", header["x5t"])
+	}
+	var claims map[string]interface{}
+	decodeSegment(t, parts[1], &claims)
+	if claims["iss"] != "app" || claims["sub"] != "app" {
+		t.Errorf("iss/sub = %v/%v, want app", claims["iss"], claims["sub"])
+	}
+	if claims["aud"] != ts.URL+"/tenant/oauth2/v2.0/token" {
+		t.Errorf("aud = %v, want token endpoint", claims["aud"])
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		t.Fatalf("decode signature: %v", err)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], signature); err != nil {
+		t.Errorf("assertion signature invalid: %v", err)
+	}
+}
+
+// TestLoadCertificatePFX tests a PFX exported by OpenSSL 3 (AES-256-CBC, PBES2, SHA-256 MAC)
+// with the issuing CA in the chain
+func TestLoadCertificatePFX(t *testing.T) {
+	cert, key, err := loadCertificate(filepath.Join("testdata", "modern.pfx"), "autohibernate")
+	if err != nil {
+		t.Fatalf("loadCertificate() error: %v", err)
+	}
+	if cert.Subject.CommonName != "autohibernate-test" {
+		t.Errorf("certificate CN = %q, want the leaf certificate", cert.Subject.CommonName)
+	}
+	if !key.PublicKey.Equal(cert.PublicKey) {
+		t.Error("private key does not match the certificate")
+	}
+
+	if _, _, err := loadCertificate(filepath.Join("testdata", "modern.pfx"), "wrong"); err == nil {
+		t.Error("loadCertificate() with a wrong password succeeded")
+	}
+}
+
+// TestWorkloadIdentityCredential tests that the federated token file is used as the assertion
+func TestWorkloadIdentityCredential(t *testing.T) {
+	ts := newTokenServer(t)
+	tokenFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(tokenFile, []byte("federated-jwt\n"), 0600); err != nil {
+		t.Fatalf("WriteFile: %v", err)
+	}
+
+	cred := &WorkloadIdentityCredential{AuthorityHost: ts.URL, TenantID: "tenant", ClientID: "app", TokenFile: tokenFile}
+	if _, err := cred.GetToken(context.Background(), "https://management.azure.com/"); err != nil {
+		t.Fatalf("GetToken() error: %v", err)
+	}
+
+	if got := ts.requests[0].Get("client_assertion"); got != "federated-jwt" {
+		t.Errorf("client_assertion = %q, want %q", got, "federated-jwt")
+	}
+}
+
+// TestNewCredential tests credential selection from configuration
+func TestNewCredential(t *testing.T) {
+	t.Setenv("AUTOHIBERNATE_TEST_SECRET", "from-env")
+
+	tests := []struct {
+		name        string
+		cfg         config.Config
+		expectError bool
+		check       func(*testing.T, Credential)
+	}{
+		{
+			name: "default is system-assigned managed identity",
+			cfg:  config.Config{},
+			check: func(t *testing.T, c Credential) {
+				mi, ok := c.(*ManagedIdentityCredential)
+				if !ok || !mi.Identity.IsSystemAssigned() {
+					t.Errorf("credential = %v, want system-assigned managed identity", c)
+				}
+			},
+		},
+		{
+			name: "user-assigned managed identity",
+			cfg: config.Config{
+				Credential:      config.CredentialConfig{Type: config.CredentialManagedIdentity},
+				ManagedIdentity: config.ManagedIdentityConfig{ClientID: "uami"},
+			},
+			check: func(t *testing.T, c Credential) {
+				mi, ok := c.(*ManagedIdentityCredential)
+				if !ok || mi.Identity.ClientID != "uami" {
+					t.Errorf("credential = %v, want user-assigned managed identity", c)
+				}
+			},
+		},
+		{
+			name: "client secret from environment",
+			cfg: config.Config{Credential: config.CredentialConfig{
+				Type: config.CredentialClientSecret, TenantID: "t", ClientID: "c", SecretEnv: "AUTOHIBERNATE_TEST_SECRET",
+			}},
+			check: func(t *testing.T, c Credential) {
+				cs, ok := c.(*ClientSecretCredential)
+				if !ok || cs.Secret != "from-env" {
+					t.Errorf("credential = %v, want client secret from environment", c)
+				}
+				if strings.Contains(c.String(), "from-env") {
+					t.Errorf("String() must not include the secret: %s", c)
+				}
+			},
+		},
+		{
+			name: "client secret with missing environment variable",
+			cfg: config.Config{Credential: config.CredentialConfig{
+				Type: config.CredentialClientSecret, TenantID: "t", ClientID: "c", SecretEnv: "AUTOHIBERNATE_TEST_MISSING",
+			}},
+			expectError: true,
+		},
+		{
+			name: "certificate file missing",
+			cfg: config.Config{Credential: config.CredentialConfig{
+				Type: config.CredentialClientCertificate, TenantID: "t", ClientID: "c", CertificateFile: filepath.Join(t.TempDir(), "missing.pfx"),
+			}},
+			expectError: true,
+		},
+		{
+			name: "workload identity",
+			cfg: config.Config{Credential: config.CredentialConfig{
+				Type: config.CredentialWorkloadIdentity, TenantID: "t", ClientID: "c", TokenFile: "token",
+			}},
+			check: func(t *testing.T, c Credential) {
+				if _, ok := c.(*WorkloadIdentityCredential); !ok {
+					t.Errorf("credential = %v, want workload identity", c)
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cred, err := NewCredential(&tt.cfg, AzurePublicCloud)
+			if tt.expectError {
+				if err == nil {
+					t.Error("Expected error but got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			tt.check(t, cred)
+		})
+	}
+}
+
+// decodeSegment decodes a base64url JWT segment into v
+func decodeSegment(t *testing.T, segment string, v interface{}) {
+	t.Helper()
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		t.Fatalf("decode segment: %v", err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		t.Fatalf("unmarshal segment: %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+//go:build !windows
+
+package azure
+
+import "fmt"
+
+// ProtectSecret is only supported on Windows
+func ProtectSecret(secret []byte) ([]byte, error) {
+	return nil, fmt.Errorf("DPAPI is only available on Windows")
+}
+
+// UnprotectSecret is only supported on Windows
+func UnprotectSecret(data []byte) ([]byte, error) {
+	return nil, fmt.Errorf("DPAPI is only available on Windows")
+}
+
+// WriteSecretFile is only supported on Windows
+func WriteSecretFile(path string, data []byte) error {
+	return fmt.Errorf("secret files are only supported on Windows")
+}
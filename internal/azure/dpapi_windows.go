@@ -0,0 +1,92 @@
+//go:build windows
+
+package azure
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// secretFileSDDL grants full access to SYSTEM and the Administrators group only
+	secretFileSDDL = "D:P(A;;FA;;;SY)(A;;FA;;;BA)"
+)
+
+// secretEntropy is mixed into every protected secret. It is not a secret itself: it only keeps
+// these blobs apart from other machine-scope DPAPI blobs, while the file ACL is what restricts access
+var secretEntropy = []byte("AzureAutoHibernate client secret v1")
+
+// entropyBlob returns secretEntropy as a DPAPI blob
+func entropyBlob() *windows.DataBlob {
+	return &windows.DataBlob{Size: uint32(len(secretEntropy)), Data: &secretEntropy[0]}
+}
+
+// ProtectSecret encrypts a secret with DPAPI using the local machine scope,
+// so the service (running as SYSTEM) can decrypt a file created by an administrator
+func ProtectSecret(secret []byte) ([]byte, error) {
+	if len(secret) == 0 {
+		return nil, fmt.Errorf("secret is empty")
+	}
+
+	in := windows.DataBlob{Size: uint32(len(secret)), Data: &secret[0]}
+	var out windows.DataBlob
+	err := windows.CryptProtectData(&in, nil, entropyBlob(), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN|windows.CRYPTPROTECT_LOCAL_MACHINE, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptProtectData failed: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+// UnprotectSecret decrypts data produced by ProtectSecret
+func UnprotectSecret(data []byte) ([]byte, error) {
+	if len(data) == 0 {
+		return nil, fmt.Errorf("protected data is empty")
+	}
+
+	in := windows.DataBlob{Size: uint32(len(data)), Data: &data[0]}
+	var out windows.DataBlob
+	err := windows.CryptUnprotectData(&in, nil, entropyBlob(), 0, nil, windows.CRYPTPROTECT_UI_FORBIDDEN, &out)
+	if err != nil {
+		return nil, fmt.Errorf("CryptUnprotectData failed: %w", err)
+	}
+	defer windows.LocalFree(windows.Handle(unsafe.Pointer(out.Data)))
+
+	return append([]byte(nil), unsafe.Slice(out.Data, out.Size)...), nil
+}
+
+// WriteSecretFile writes protected data to path, replacing any existing file. The new file
+// is readable by SYSTEM and Administrators only.
+func WriteSecretFile(path string, data []byte) error {
+	sd, err := windows.SecurityDescriptorFromString(secretFileSDDL)
+	if err != nil {
+		return fmt.Errorf("failed to create secret file security descriptor: %w", err)
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+
+	// Security attributes only apply to a new file, so never reuse an existing one's ACL
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to replace %s: %w", path, err)
+	}
+
+	name, err := windows.UTF16PtrFromString(path)
+	if err != nil {
+		return err
+	}
+	handle, err := windows.CreateFile(name, windows.GENERIC_WRITE, 0, sa, windows.CREATE_NEW, windows.FILE_ATTRIBUTE_NORMAL, 0)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	f := os.NewFile(uintptr(handle), path)
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,431 @@
+package azure_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/azure/fake"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+)
+
+// fakeConfig returns a validated configuration pointing IMDS and ARM at the fake server
+func fakeConfig(t *testing.T, srv *fake.Server) *config.Config {
+	t.Helper()
+	cfg := &config.Config{
+		NoUsersIdleMinutes: 15,
+		IMDSEndpoint:       srv.URL,
+		Cloud:              config.CloudConfig{ResourceManagerEndpoint: srv.URL, AuthorityHost: srv.URL},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error: %v", err)
+	}
+	return cfg
+}
+
+// newFakeClient builds an AzureClient for the fake VM the way the service does
+func newFakeClient(t *testing.T, ctx context.Context, cfg *config.Config) *azure.AzureClient {
+	t.Helper()
+	metadata, err := azure.GetVMMetadata(ctx, cfg.IMDSEndpoint)
+	if err != nil {
+		t.Fatalf("GetVMMetadata() error: %v", err)
+	}
+	cloud, err := azure.ResolveCloud(cfg.Cloud, metadata.AzEnvironment)
+	if err != nil {
+		t.Fatalf("ResolveCloud() error: %v", err)
+	}
+	credential, err := azure.NewCredential(cfg, cloud)
+	if err != nil {
+		t.Fatalf("NewCredential() error: %v", err)
+	}
+	return azure.NewAzureClient(metadata.SubscriptionId, metadata.ResourceGroup, metadata.VMName, cloud, credential)
+}
+
+// TestHibernationCapabilityEndToEnd runs the installer's capability test against the fake
+func TestHibernationCapabilityEndToEnd(t *testing.T) {
+	t.Setenv("AUTOHIBERNATE_E2E_SECRET", "s3cret")
+
+	tests := []struct {
+		name            string
+		setup           func(*fake.Server)
+		credential      config.CredentialConfig
+		wantIMDS        bool
+		wantToken       bool
+		wantHibernation bool
+		wantAPIError    azure.ErrorKind
+	}{
+		{
+			name:            "managed identity, hibernation enabled",
+			wantIMDS:        true,
+			wantToken:       true,
+			wantHibernation: true,
+		},
+		{
+			name: "service principal, hibernation enabled",
+			credential: config.CredentialConfig{
+				Type: config.CredentialClientSecret, TenantID: "tenant", ClientID: "app", SecretEnv: "AUTOHIBERNATE_E2E_SECRET",
+			},
+			wantIMDS:        true,
+			wantToken:       true,
+			wantHibernation: true,
+		},
+		{
+			name: "hibernation disabled",
+			setup: func(srv *fake.Server) {
+				vm := fake.DefaultVM
+				vm.HibernationEnabled = false
+				srv.SetVM(vm)
+			},
+			wantIMDS:  true,
+			wantToken: true,
+		},
+		{
+			name: "IMDS unavailable",
+			setup: func(srv *fake.Server) {
+				srv.Fail(fake.OpMetadata, fake.Failure{Status: http.StatusServiceUnavailable, Code: "ServiceUnavailable"})
+			},
+		},
+		{
+			name: "managed identity not enabled",
+			setup: func(srv *fake.Server) {
+				srv.Fail(fake.OpIMDSToken, fake.Failure{Status: http.StatusBadRequest, Code: "invalid_request", Message: "Identity not found"})
+			},
+			wantIMDS: true,
+		},
+		{
+			name: "missing role assignment",
+			setup: func(srv *fake.Server) {
+				srv.Fail(fake.OpGetVM, fake.Failure{Status: http.StatusForbidden, Code: "AuthorizationFailed", Message: "does not have authorization"})
+			},
+			wantIMDS:     true,
+			wantToken:    true,
+			wantAPIError: azure.ErrorKindAuthorization,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.NewServer()
+			defer srv.Close()
+			if tt.setup != nil {
+				tt.setup(srv)
+			}
+
+			cfg := fakeConfig(t, srv)
+			cfg.Credential = tt.credential
+			if err := cfg.Validate(); err != nil {
+				t.Fatalf("Validate() error: %v", err)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			result := azure.TestHibernationCapability(ctx, cfg)
+
+			if result.IMDSAvailable != tt.wantIMDS {
+				t.Fatalf("IMDSAvailable = %v, want %v (error: %v)", result.IMDSAvailable, tt.wantIMDS, result.IMDSError)
+			}
+			if result.ConfigError != nil {
+				t.Fatalf("ConfigError = %v", result.ConfigError)
+			}
+			if result.TokenSuccess != tt.wantToken {
+				t.Fatalf("TokenSuccess = %v, want %v (error: %v)", result.TokenSuccess, tt.wantToken, result.TokenError)
+			}
+			if tt.wantAPIError == azure.ErrorKindUnknown {
+				if result.HibernationAPIError != nil {
+					t.Errorf("HibernationAPIError = %v, want none", result.HibernationAPIError)
+				}
+			} else {
+				var armErr *azure.ARMError
+				if !errors.As(result.HibernationAPIError, &armErr) || armErr.Kind != tt.wantAPIError {
+					t.Errorf("HibernationAPIError = %v, want ARM error of kind %v", result.HibernationAPIError, tt.wantAPIError)
+				}
+			}
+			if result.HibernationEnabled != tt.wantHibernation {
+				t.Errorf("HibernationEnabled = %v, want %v", result.HibernationEnabled, tt.wantHibernation)
+			}
+		})
+	}
+}
+
+// TestHibernateEndToEnd runs the hibernate path (actions, AzureClient) against the fake
+func TestHibernateEndToEnd(t *testing.T) {
+	tests := []struct {
+		name              string
+		setup             func(*fake.Server)
+		wantDeallocations []fake.Deallocation
+		wantCompleted     string
+		wantPrimaryKind   azure.ErrorKind
+		wantErr           string
+	}{
+		{
+			name:              "hibernate returns once accepted",
+			wantDeallocations: []fake.Deallocation{{Hibernate: true}},
+			wantCompleted:     config.ActionHibernate,
+		},
+		{
+			name: "hibernation disabled falls back to deallocate",
+			setup: func(srv *fake.Server) {
+				vm := fake.DefaultVM
+				vm.HibernationEnabled = false
+				srv.SetVM(vm)
+			},
+			wantDeallocations: []fake.Deallocation{{Hibernate: false}},
+			wantCompleted:     config.ActionDeallocate,
+			wantPrimaryKind:   azure.ErrorKindHibernationNotEnabled,
+		},
+		{
+			name: "transient throttling recovers through fallback",
+			setup: func(srv *fake.Server) {
+				srv.Fail(fake.OpDeallocate, fake.Failure{Status: http.StatusTooManyRequests, Code: "TooManyRequests", Count: 1})
+			},
+			wantDeallocations: []fake.Deallocation{{Hibernate: false}},
+			wantCompleted:     config.ActionDeallocate,
+			wantPrimaryKind:   azure.ErrorKindThrottled,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.NewServer()
+			defer srv.Close()
+			if tt.setup != nil {
+				tt.setup(srv)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			client := newFakeClient(t, ctx, fakeConfig(t, srv))
+
+			primary, err := action.New(config.ActionHibernate, action.Deps{Client: client})
+			if err != nil {
+				t.Fatalf("action.New(hibernate) error: %v", err)
+			}
+			fallback, err := action.New(config.ActionDeallocate, action.Deps{Client: client})
+			if err != nil {
+				t.Fatalf("action.New(deallocate) error: %v", err)
+			}
+
+			result := action.RunWithFallback(ctx, primary, fallback)
+
+			if tt.wantErr != "" {
+				if err := result.Err(); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Err() = %v, want error containing %q", err, tt.wantErr)
+				}
+			} else if result.Completed == nil || result.Completed.Name() != tt.wantCompleted {
+				t.Errorf("Completed = %v, want %q (error: %v)", result.Completed, tt.wantCompleted, result.Err())
+			}
+
+			if tt.wantPrimaryKind != azure.ErrorKindUnknown {
+				var armErr *azure.ARMError
+				if !errors.As(result.PrimaryError, &armErr) || armErr.Kind != tt.wantPrimaryKind {
+					t.Errorf("PrimaryError = %v, want ARM error of kind %v", result.PrimaryError, tt.wantPrimaryKind)
+				}
+			}
+
+			got := srv.Deallocations()
+			if len(got) != len(tt.wantDeallocations) {
+				t.Fatalf("Deallocations() = %v, want %v", got, tt.wantDeallocations)
+			}
+			for i := range got {
+				if got[i] != tt.wantDeallocations[i] {
+					t.Errorf("Deallocations()[%d] = %v, want %v", i, got[i], tt.wantDeallocations[i])
+				}
+			}
+		})
+	}
+}
+
+// TestHibernationTagsEndToEnd tests that hibernation tags are stamped before deallocate and that tagging fails open
+func TestHibernationTagsEndToEnd(t *testing.T) {
+	tests := []struct {
+		name          string
+		enable        bool
+		tagFailure    *fake.Failure
+		wantTags      bool
+		wantTagErrors int
+	}{
+		{name: "tagging disabled", enable: false},
+		{name: "tagging enabled", enable: true, wantTags: true},
+		{
+			name:          "missing tag permission fails open",
+			enable:        true,
+			tagFailure:    &fake.Failure{Status: http.StatusForbidden, Code: "AuthorizationFailed", Message: "does not have authorization to perform action 'Microsoft.Resources/tags/write'"},
+			wantTagErrors: 1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := fake.NewServer()
+			defer srv.Close()
+			if tt.tagFailure != nil {
+				srv.Fail(fake.OpTags, *tt.tagFailure)
+			}
+
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			client := newFakeClient(t, ctx, fakeConfig(t, srv))
+
+			var tagErrors []error
+			if tt.enable {
+				client.EnableTagging(func(err error) { tagErrors = append(tagErrors, err) })
+			}
+
+			ctx = azure.WithHibernationInfo(ctx, azure.HibernationInfo{Reason: "No users logged in for 15 minutes", Condition: "noUsers"})
+			if err := client.HibernateVM(ctx); err != nil {
+				t.Fatalf("HibernateVM() error: %v", err)
+			}
+
+			if len(srv.Deallocations()) != 1 {
+				t.Errorf("Deallocations() = %v, want one hibernate request", srv.Deallocations())
+			}
+			if len(tagErrors) != tt.wantTagErrors {
+				t.Errorf("tag errors = %v, want %d", tagErrors, tt.wantTagErrors)
+			}
+
+			tags := srv.Tags()
+			if !tt.wantTags {
+				if len(tags) != 0 {
+					t.Errorf("Tags() = %v, want none", tags)
+				}
+				return
+			}
+			if tags[azure.TagLastReason] != "No users logged in for 15 minutes" {
+				t.Errorf("%s = %q", azure.TagLastReason, tags[azure.TagLastReason])
+			}
+			if tags[azure.TagLastCondition] != "noUsers" {
+				t.Errorf("%s = %q, want noUsers", azure.TagLastCondition, tags[azure.TagLastCondition])
+			}
+			if tags[azure.TagLastAction] != config.ActionHibernate {
+				t.Errorf("%s = %q, want %q", azure.TagLastAction, tags[azure.TagLastAction], config.ActionHibernate)
+			}
+			if _, err := time.Parse(time.RFC3339, tags[azure.TagLastRequestedAt]); err != nil {
+				t.Errorf("%s = %q is not RFC3339: %v", azure.TagLastRequestedAt, tags[azure.TagLastRequestedAt], err)
+			}
+			if tags[azure.TagAgentVersion] == "" {
+				t.Errorf("%s is empty", azure.TagAgentVersion)
+			}
+		})
+	}
+}
+
+// TestScheduledEventsEndToEnd polls and acknowledges scheduled events against the fake IMDS
+func TestScheduledEventsEndToEnd(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	poller := azure.NewScheduledEventPoller(srv.URL, fake.DefaultVM.Name)
+
+	changes, err := poller.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll() error: %v", err)
+	}
+	if changes.HasChanges() || len(changes.Pending) != 0 {
+		t.Fatalf("Poll() with no events = %+v, want no changes", changes)
+	}
+
+	srv.SetScheduledEvents(
+		fake.ScheduledEvent{EventId: "evict", EventType: azure.ScheduledEventPreempt, NotBefore: "Mon, 19 Sep 2016 18:29:47 GMT"},
+		fake.ScheduledEvent{EventId: "elsewhere", EventType: azure.ScheduledEventReboot, Resources: []string{"another-vm"}},
+	)
+	changes, err = poller.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll() error: %v", err)
+	}
+	if len(changes.New) != 1 || changes.New[0].EventId != "evict" || !changes.New[0].IsSpotEviction() {
+		t.Fatalf("New = %+v, want the Preempt event only", changes.New)
+	}
+
+	if err := poller.Acknowledge(ctx, changes.New...); err != nil {
+		t.Fatalf("Acknowledge() error: %v", err)
+	}
+	if got := srv.Acknowledged(); len(got) != 1 || got[0] != "evict" {
+		t.Errorf("Acknowledged() = %v, want [evict]", got)
+	}
+
+	changes, err = poller.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll() error: %v", err)
+	}
+	if len(changes.Started) != 1 || changes.Started[0].EventId != "evict" {
+		t.Errorf("Started = %+v, want the acknowledged event", changes.Started)
+	}
+
+	srv.SetScheduledEvents()
+	changes, err = poller.Poll(ctx)
+	if err != nil {
+		t.Fatalf("Poll() error: %v", err)
+	}
+	if len(changes.Completed) != 1 || len(changes.Pending) != 0 {
+		t.Errorf("Completed = %+v, Pending = %+v, want one completed event and none pending", changes.Completed, changes.Pending)
+	}
+
+	// Acknowledging nothing makes no request
+	if err := azure.AcknowledgeScheduledEvents(ctx, srv.URL); err != nil {
+		t.Errorf("AcknowledgeScheduledEvents() with no events error: %v", err)
+	}
+	if got := srv.Requests(fake.OpAcknowledgeEvents); got != 1 {
+		t.Errorf("acknowledge requests = %d, want 1", got)
+	}
+
+	// IMDS failures are reported
+	srv.Fail(fake.OpScheduledEvents, fake.Failure{Status: http.StatusInternalServerError, Code: "InternalError", Message: "boom", Count: 1})
+	if _, err := poller.Poll(ctx); err == nil {
+		t.Error("Poll() error = nil, want IMDS failure")
+	}
+}
+
+// TestControlEndToEnd reads the remote control value from the fake IMDS
+func TestControlEndToEnd(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+	ctx := context.Background()
+
+	control, err := azure.GetControl(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("GetControl() error: %v", err)
+	}
+	if control.Source != "" || control.EffectiveMode(time.Now()) != azure.ModeEnabled {
+		t.Errorf("GetControl() with nothing set = %+v, want enabled", control)
+	}
+
+	vm := fake.DefaultVM
+	vm.UserData = "autohibernate:mode=dryrun\n"
+	srv.SetVM(vm)
+	control, err = azure.GetControl(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("GetControl() error: %v", err)
+	}
+	if control.Mode != azure.ModeDryRun || control.Source != azure.ControlSourceUserData {
+		t.Errorf("GetControl() from user data = %+v, want dryrun from userData", control)
+	}
+
+	srv.SetTags(map[string]string{azure.TagMode: azure.ModePaused})
+	control, err = azure.GetControl(ctx, srv.URL)
+	if err != nil {
+		t.Fatalf("GetControl() error: %v", err)
+	}
+	if control.Mode != azure.ModePaused || control.Source != azure.ControlSourceTags {
+		t.Errorf("GetControl() from tags = %+v, want paused from tags", control)
+	}
+}
+
+// TestVMMetadataEndToEnd tests reading the VM size and region from the fake IMDS
+func TestVMMetadataEndToEnd(t *testing.T) {
+	srv := fake.NewServer()
+	defer srv.Close()
+
+	metadata, err := azure.GetVMMetadata(context.Background(), srv.URL)
+	if err != nil {
+		t.Fatalf("GetVMMetadata() error: %v", err)
+	}
+	if metadata.VMName != fake.DefaultVM.Name || metadata.VMSize != fake.DefaultVM.Size || metadata.Location != fake.DefaultVM.Location {
+		t.Errorf("GetVMMetadata() = %+v, want the fake VM's name, size and location", metadata)
+	}
+}
@@ -0,0 +1,223 @@
+package azure
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrorKind classifies Azure Resource Manager failures by how they are remediated
+type ErrorKind int
+
+const (
+	ErrorKindUnknown               ErrorKind = iota // Unclassified ARM error
+	ErrorKindAuthentication                         // Access token rejected (401)
+	ErrorKindAuthorization                          // Identity lacks a role assignment (AuthorizationFailed)
+	ErrorKindScopeLocked                            // A ReadOnly resource lock blocks the operation (ScopeLocked)
+	ErrorKindHibernationNotEnabled                  // Hibernation is not enabled or supported on the VM
+	ErrorKindOperationNotAllowed                    // Operation not allowed in the VM's current state (OperationNotAllowed)
+	ErrorKindThrottled                              // ARM request throttling (429)
+	ErrorKindNotFound                               // VM or resource group not found (404)
+)
+
+// String returns the name of the error kind
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorKindAuthentication:
+		return "AuthenticationFailed"
+	case ErrorKindAuthorization:
+		return "AuthorizationFailed"
+	case ErrorKindScopeLocked:
+		return "ScopeLocked"
+	case ErrorKindHibernationNotEnabled:
+		return "HibernationNotEnabled"
+	case ErrorKindOperationNotAllowed:
+		return "OperationNotAllowed"
+	case ErrorKindThrottled:
+		return "Throttled"
+	case ErrorKindNotFound:
+		return "NotFound"
+	default:
+		return "Unknown"
+	}
+}
+
+// ARMErrorDetail is a nested error in an ARM error envelope
+type ARMErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// ARMError is a failed Azure Resource Manager request, decoded from the ARM error envelope
+type ARMError struct {
+	Operation  string           // Request that failed (e.g. "hibernation")
+	StatusCode int              // HTTP status code (0 for a failed async operation)
+	Code       string           // ARM error code (e.g. AuthorizationFailed)
+	Message    string           // ARM error message
+	Details    []ARMErrorDetail // Nested error details
+	RetryAfter time.Duration    // Delay requested by a Retry-After header, if any
+	Kind       ErrorKind        // Classification of the error
+}
+
+// armErrorEnvelope is the ARM error response body: {"error": {"code", "message", "details"}}
+type armErrorEnvelope struct {
+	Error *struct {
+		Code    string           `json:"code"`
+		Message string           `json:"message"`
+		Details []ARMErrorDetail `json:"details"`
+	} `json:"error"`
+}
+
+// newARMError decodes a failed ARM response into an ARMError
+func newARMError(operation string, resp *http.Response, body []byte) *ARMError {
+	e := &ARMError{Operation: operation, StatusCode: resp.StatusCode}
+
+	var envelope armErrorEnvelope
+	if err := json.Unmarshal(body, &envelope); err == nil && envelope.Error != nil {
+		e.Code = envelope.Error.Code
+		e.Message = envelope.Error.Message
+		e.Details = envelope.Error.Details
+	} else {
+		e.Message = strings.TrimSpace(string(body))
+	}
+
+	if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+		e.RetryAfter = time.Duration(seconds) * time.Second
+	}
+
+	e.Kind = classifyARMError(e)
+	return e
+}
+
+// classifyARMError determines the error kind from the error codes, message and status
+func classifyARMError(e *ARMError) ErrorKind {
+	codes := []string{e.Code}
+	for _, d := range e.Details {
+		codes = append(codes, d.Code)
+	}
+
+	for _, code := range codes {
+		switch code {
+		case "InvalidAuthenticationToken", "InvalidAuthenticationTokenTenant", "InvalidAuthenticationTokenAudience", "ExpiredAuthenticationToken":
+			return ErrorKindAuthentication
+		case "AuthorizationFailed", "LinkedAuthorizationFailed":
+			return ErrorKindAuthorization
+		case "ScopeLocked":
+			return ErrorKindScopeLocked
+		case "TooManyRequests", "SubscriptionRequestsThrottled", "ResourceRequestsThrottled":
+			return ErrorKindThrottled
+		case "ResourceNotFound", "ResourceGroupNotFound", "NotFound":
+			return ErrorKindNotFound
+		case "OperationNotAllowed":
+			if mentionsHibernation(e) {
+				return ErrorKindHibernationNotEnabled
+			}
+			return ErrorKindOperationNotAllowed
+		}
+	}
+
+	switch e.StatusCode {
+	case http.StatusUnauthorized:
+		return ErrorKindAuthentication
+	case http.StatusForbidden:
+		return ErrorKindAuthorization
+	case http.StatusTooManyRequests:
+		return ErrorKindThrottled
+	case http.StatusNotFound:
+		return ErrorKindNotFound
+	}
+
+	if mentionsHibernation(e) && (e.StatusCode == http.StatusConflict || e.StatusCode == http.StatusBadRequest) {
+		return ErrorKindHibernationNotEnabled
+	}
+	return ErrorKindUnknown
+}
+
+// mentionsHibernation reports whether the error refers to hibernation (e.g. "Hibernation is not enabled")
+func mentionsHibernation(e *ARMError) bool {
+	text := strings.ToLower(e.Code + " " + e.Message)
+	for _, d := range e.Details {
+		text += " " + strings.ToLower(d.Code+" "+d.Message)
+	}
+	return strings.Contains(text, "hibernat")
+}
+
+func (e *ARMError) Error() string {
+	var sb strings.Builder
+	if e.StatusCode != 0 {
+		fmt.Fprintf(&sb, "%s request failed with status %d", e.Operation, e.StatusCode)
+	} else {
+		fmt.Fprintf(&sb, "%s operation failed", e.Operation)
+	}
+	if e.Code != "" {
+		fmt.Fprintf(&sb, ": %s", e.Code)
+	}
+	if e.Message != "" {
+		fmt.Fprintf(&sb, ": %s", e.Message)
+	}
+	return sb.String()
+}
+
+// IsRetryable reports whether a failed request may succeed if it is repeated: throttling,
+// server errors, conflicts with an operation in progress, failed async operations and errors
+// that did not come from ARM (e.g. the network). Errors that need a change to the VM, its
+// permissions or the configuration are not retryable.
+func IsRetryable(err error) bool {
+	if err == nil || errors.Is(err, context.Canceled) {
+		return false
+	}
+	var armErr *ARMError
+	if !errors.As(err, &armErr) {
+		return true
+	}
+	switch armErr.Kind {
+	case ErrorKindThrottled:
+		return true
+	case ErrorKindUnknown:
+		return armErr.StatusCode == 0 || armErr.StatusCode == http.StatusConflict || armErr.StatusCode >= http.StatusInternalServerError
+	default:
+		return false
+	}
+}
+
+// RetryAfter returns the delay Azure asked for before retrying a failed request, or 0
+func RetryAfter(err error) time.Duration {
+	var armErr *ARMError
+	if errors.As(err, &armErr) {
+		return armErr.RetryAfter
+	}
+	return 0
+}
+
+// Remediation returns an actionable message describing how to fix the error
+func (e *ARMError) Remediation() string {
+	switch e.Kind {
+	case ErrorKindAuthentication:
+		return "The access token was rejected. Check that the credential's tenant and the cloud (cloud.name) match the VM's subscription."
+	case ErrorKindAuthorization:
+		if e.Operation == tagsOperation {
+			return "Assign the 'Tag Contributor' role (Microsoft.Resources/tags/write) to the identity on this VM, or set stampVMTags to false."
+		}
+		return "Assign the 'Virtual Machine Contributor' role (or a custom role with Microsoft.Compute/virtualMachines/read and deallocate/action) to the identity on this VM or its resource group. Role assignments can take a few minutes to propagate."
+	case ErrorKindScopeLocked:
+		return "Remove the ReadOnly lock on the VM, its resource group or subscription (a CanNotDelete lock does not block deallocation)."
+	case ErrorKindHibernationNotEnabled:
+		return "Enable hibernation on the VM (deallocate it, then enable Hibernation in the VM configuration), or set fallbackAction to deallocate."
+	case ErrorKindOperationNotAllowed:
+		return "Azure does not allow this operation in the VM's current state or configuration; check the VM's status and any Azure Policy assignments."
+	case ErrorKindThrottled:
+		if e.RetryAfter > 0 {
+			return fmt.Sprintf("Azure Resource Manager is throttling requests; retry after %v.", e.RetryAfter)
+		}
+		return "Azure Resource Manager is throttling requests; retry later."
+	case ErrorKindNotFound:
+		return "The VM was not found. Check that the cloud endpoint and the IMDS metadata (subscription, resource group, VM name) refer to this VM."
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,166 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestNewARMError tests decoding and classification of ARM error responses
+func TestNewARMError(t *testing.T) {
+	tests := []struct {
+		name            string
+		status          int
+		retryAfter      string
+		body            string
+		wantCode        string
+		wantKind        ErrorKind
+		wantRetryAfter  time.Duration
+		wantRemediation string
+	}{
+		{
+			name:            "authorization failed",
+			status:          http.StatusForbidden,
+			body:            `{"error":{"code":"AuthorizationFailed","message":"The client does not have authorization to perform action 'Microsoft.Compute/virtualMachines/deallocate/action'"}}`,
+			wantCode:        "AuthorizationFailed",
+			wantKind:        ErrorKindAuthorization,
+			wantRemediation: "Virtual Machine Contributor",
+		},
+		{
+			name:            "expired token",
+			status:          http.StatusUnauthorized,
+			body:            `{"error":{"code":"ExpiredAuthenticationToken","message":"The access token expiry UTC time is earlier than current UTC time."}}`,
+			wantCode:        "ExpiredAuthenticationToken",
+			wantKind:        ErrorKindAuthentication,
+			wantRemediation: "access token was rejected",
+		},
+		{
+			name:            "read-only lock",
+			status:          http.StatusConflict,
+			body:            `{"error":{"code":"ScopeLocked","message":"The scope cannot perform write operation because following scope(s) are locked"}}`,
+			wantCode:        "ScopeLocked",
+			wantKind:        ErrorKindScopeLocked,
+			wantRemediation: "ReadOnly lock",
+		},
+		{
+			name:            "hibernation not enabled",
+			status:          http.StatusConflict,
+			body:            `{"error":{"code":"OperationNotAllowed","message":"Hibernation is not enabled on the virtual machine."}}`,
+			wantCode:        "OperationNotAllowed",
+			wantKind:        ErrorKindHibernationNotEnabled,
+			wantRemediation: "fallbackAction",
+		},
+		{
+			name:     "hibernation error in details",
+			status:   http.StatusBadRequest,
+			body:     `{"error":{"code":"BadRequest","message":"The request is invalid.","details":[{"code":"OperationNotAllowed","message":"Hibernate is not supported for this VM size."}]}}`,
+			wantCode: "BadRequest",
+			wantKind: ErrorKindHibernationNotEnabled,
+		},
+		{
+			name:            "operation not allowed",
+			status:          http.StatusConflict,
+			body:            `{"error":{"code":"OperationNotAllowed","message":"Operation 'deallocate' is not allowed since the VM is marked for deletion."}}`,
+			wantCode:        "OperationNotAllowed",
+			wantKind:        ErrorKindOperationNotAllowed,
+			wantRemediation: "current state",
+		},
+		{
+			name:            "throttled with Retry-After",
+			status:          http.StatusTooManyRequests,
+			retryAfter:      "17",
+			body:            `{"error":{"code":"SubscriptionRequestsThrottled","message":"Number of requests exceeded the limit."}}`,
+			wantCode:        "SubscriptionRequestsThrottled",
+			wantKind:        ErrorKindThrottled,
+			wantRetryAfter:  17 * time.Second,
+			wantRemediation: "retry after 17s",
+		},
+		{
+			name:     "not found by status",
+			status:   http.StatusNotFound,
+			body:     `{"error":{"code":"ResourceGroupNotFound","message":"Resource group 'rg' could not be found."}}`,
+			wantCode: "ResourceGroupNotFound",
+			wantKind: ErrorKindNotFound,
+		},
+		{
+			name:     "non-JSON body",
+			status:   http.StatusBadGateway,
+			body:     "bad gateway\n",
+			wantKind: ErrorKindUnknown,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: tt.status, Header: http.Header{}}
+			if tt.retryAfter != "" {
+				resp.Header.Set("Retry-After", tt.retryAfter)
+			}
+
+			err := newARMError("hibernation", resp, []byte(tt.body))
+
+			if err.Code != tt.wantCode {
+				t.Errorf("Code = %q, want %q", err.Code, tt.wantCode)
+			}
+			if err.Kind != tt.wantKind {
+				t.Errorf("Kind = %v, want %v", err.Kind, tt.wantKind)
+			}
+			if err.RetryAfter != tt.wantRetryAfter {
+				t.Errorf("RetryAfter = %v, want %v", err.RetryAfter, tt.wantRetryAfter)
+			}
+			if !strings.Contains(err.Remediation(), tt.wantRemediation) {
+				t.Errorf("Remediation() = %q, want it to contain %q", err.Remediation(), tt.wantRemediation)
+			}
+			if !strings.HasPrefix(err.Error(), "hibernation request failed with status") {
+				t.Errorf("Error() = %q, want operation and status prefix", err.Error())
+			}
+		})
+	}
+}
+
+// TestARMErrorTagsRemediation tests that tag permission errors suggest the tag role
+func TestARMErrorTagsRemediation(t *testing.T) {
+	err := &ARMError{Operation: tagsOperation, StatusCode: http.StatusForbidden, Code: "AuthorizationFailed", Kind: ErrorKindAuthorization}
+	if !strings.Contains(err.Remediation(), "Tag Contributor") {
+		t.Errorf("Remediation() = %q, want it to mention Tag Contributor", err.Remediation())
+	}
+}
+
+// TestIsRetryable tests which action errors are worth retrying
+func TestIsRetryable(t *testing.T) {
+	throttled := &ARMError{StatusCode: http.StatusTooManyRequests, Kind: ErrorKindThrottled, RetryAfter: 20 * time.Second}
+	tests := []struct {
+		name           string
+		err            error
+		want           bool
+		wantRetryAfter time.Duration
+	}{
+		{name: "nil", err: nil, want: false},
+		{name: "throttled", err: throttled, want: true, wantRetryAfter: 20 * time.Second},
+		{name: "wrapped throttled", err: fmt.Errorf("hibernate: %w", throttled), want: true, wantRetryAfter: 20 * time.Second},
+		{name: "server error", err: &ARMError{StatusCode: http.StatusServiceUnavailable}, want: true},
+		{name: "conflict", err: &ARMError{StatusCode: http.StatusConflict}, want: true},
+		{name: "async operation failed", err: &ARMError{StatusCode: 0}, want: true},
+		{name: "network", err: errors.New("dial tcp: connection refused"), want: true},
+		{name: "canceled", err: fmt.Errorf("request: %w", context.Canceled), want: false},
+		{name: "bad request", err: &ARMError{StatusCode: http.StatusBadRequest}, want: false},
+		{name: "authorization", err: &ARMError{StatusCode: http.StatusForbidden, Kind: ErrorKindAuthorization}, want: false},
+		{name: "hibernation not enabled", err: &ARMError{StatusCode: http.StatusConflict, Kind: ErrorKindHibernationNotEnabled}, want: false},
+		{name: "scope locked", err: &ARMError{StatusCode: http.StatusConflict, Kind: ErrorKindScopeLocked}, want: false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable() = %v, want %v", got, tt.want)
+			}
+			if got := RetryAfter(tt.err); got != tt.wantRetryAfter {
+				t.Errorf("RetryAfter() = %v, want %v", got, tt.wantRetryAfter)
+			}
+		})
+	}
+}
@@ -0,0 +1,472 @@
+// Package fake provides an in-process fake of the Azure endpoints used by the
+// service: IMDS (metadata, managed identity tokens and scheduled events), the Entra ID token
+// endpoint and the Azure Resource Manager VM and Tags APIs. It lets the azure package,
+// actions and the installer's capability test run end to end off-Azure.
+//
+// Point the code under test at the fake by setting both imdsEndpoint and
+// cloud.resourceManagerEndpoint (or the AUTOHIBERNATE_IMDS_ENDPOINT and
+// AUTOHIBERNATE_ARM_ENDPOINT environment variables) to Server.URL.
+package fake
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Operation identifies a kind of request served by the fake
+type Operation string
+
+const (
+	OpMetadata   Operation = "metadata"   // GET IMDS compute metadata
+	OpIMDSToken  Operation = "imdsToken"  // GET IMDS managed identity token
+	OpAADToken   Operation = "aadToken"   // POST Entra ID token endpoint
+	OpGetVM      Operation = "getVM"      // GET VM properties
+	OpDeallocate Operation = "deallocate" // POST VM deallocate
+	OpTags       Operation = "tags"       // PATCH VM tags (Tags API merge)
+
+	OpScheduledEvents   Operation = "scheduledEvents"   // GET IMDS scheduled events
+	OpAcknowledgeEvents Operation = "acknowledgeEvents" // POST IMDS scheduled events StartRequests
+)
+
+// VM describes the virtual machine served by the fake
+type VM struct {
+	SubscriptionID     string
+	ResourceGroup      string
+	Name               string
+	AzEnvironment      string
+	Size               string
+	Location           string
+	HibernationEnabled bool
+	UserData           string // Plain-text user data, served base64-encoded by IMDS
+}
+
+// DefaultVM is the VM served by a new Server
+var DefaultVM = VM{
+	SubscriptionID:     "00000000-0000-0000-0000-000000000000",
+	ResourceGroup:      "rg-autohibernate",
+	Name:               "vm-autohibernate",
+	AzEnvironment:      "AzurePublicCloud",
+	Size:               "Standard_D4s_v5",
+	Location:           "westeurope",
+	HibernationEnabled: true,
+}
+
+// Token is the access token issued by the fake and required on ARM requests
+const Token = "fake-access-token"
+
+// Failure is an injected error response
+type Failure struct {
+	Status  int    // HTTP status code
+	Code    string // ARM error code (e.g. AuthorizationFailed)
+	Message string // ARM error message
+	Count   int    // Number of requests to fail (0 fails until cleared)
+}
+
+// Deallocation records a deallocate request received by the fake
+type Deallocation struct {
+	Hibernate bool // True if the request asked for hibernation
+}
+
+// ScheduledEvent is a scheduled event served by the fake IMDS
+type ScheduledEvent struct {
+	EventId     string
+	EventType   string   // Freeze, Reboot, Redeploy, Preempt or Terminate
+	EventStatus string   // Scheduled (default) or Started
+	NotBefore   string   // RFC 1123 time, empty once started
+	Resources   []string // Affected VM names (default: the fake VM)
+}
+
+// Server is a fake Azure endpoint backed by httptest.Server
+type Server struct {
+	*httptest.Server
+
+	mu              sync.Mutex
+	vm              VM
+	failures        map[Operation]*Failure
+	requests        map[Operation]int
+	deallocations   []Deallocation
+	tags            map[string]string
+	incarnation     int
+	scheduledEvents []ScheduledEvent
+	acknowledged    []string
+}
+
+// NewServer starts a fake serving DefaultVM. Callers must Close it.
+func NewServer() *Server {
+	s := &Server{
+		vm:       DefaultVM,
+		failures: make(map[Operation]*Failure),
+		requests: make(map[Operation]int),
+		tags:     make(map[string]string),
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// SetVM replaces the VM served by the fake
+func (s *Server) SetVM(vm VM) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.vm = vm
+}
+
+// Fail injects an error response for the given operation
+func (s *Server) Fail(op Operation, f Failure) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures[op] = &f
+}
+
+// ClearFailures removes all injected failures
+func (s *Server) ClearFailures() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.failures = make(map[Operation]*Failure)
+}
+
+// Requests returns how many requests of the given operation were received
+func (s *Server) Requests(op Operation) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[op]
+}
+
+// Deallocations returns the deallocate requests received so far
+func (s *Server) Deallocations() []Deallocation {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]Deallocation(nil), s.deallocations...)
+}
+
+// SetScheduledEvents replaces the scheduled events reported by IMDS
+func (s *Server) SetScheduledEvents(events ...ScheduledEvent) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scheduledEvents = append([]ScheduledEvent(nil), events...)
+	s.incarnation++
+}
+
+// Acknowledged returns the IDs of the scheduled events approved so far
+func (s *Server) Acknowledged() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.acknowledged...)
+}
+
+// SetTags replaces the tags set on the VM (reported by IMDS and the Tags API)
+func (s *Server) SetTags(tags map[string]string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.tags = make(map[string]string, len(tags))
+	for name, value := range tags {
+		s.tags[name] = value
+	}
+}
+
+// Tags returns the tags currently set on the VM
+func (s *Server) Tags() map[string]string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	tags := make(map[string]string, len(s.tags))
+	for name, value := range s.tags {
+		tags[name] = value
+	}
+	return tags
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	path := r.URL.Path
+
+	switch {
+	case r.Method == http.MethodGet && path == "/metadata/instance/compute":
+		s.handle(w, r, OpMetadata, s.serveMetadata)
+	case r.Method == http.MethodGet && path == "/metadata/identity/oauth2/token":
+		s.handle(w, r, OpIMDSToken, s.serveIMDSToken)
+	case r.Method == http.MethodGet && path == "/metadata/scheduledevents":
+		s.handle(w, r, OpScheduledEvents, s.serveScheduledEvents)
+	case r.Method == http.MethodPost && path == "/metadata/scheduledevents":
+		s.handle(w, r, OpAcknowledgeEvents, s.serveAcknowledgeEvents)
+	case r.Method == http.MethodPost && strings.HasSuffix(path, "/oauth2/v2.0/token"):
+		s.handle(w, r, OpAADToken, s.serveAADToken)
+	case strings.HasPrefix(path, "/subscriptions/"):
+		s.serveVM(w, r)
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no fake route for %s %s", r.Method, path))
+	}
+}
+
+// handle counts the request, applies any injected failure and otherwise calls next
+func (s *Server) handle(w http.ResponseWriter, r *http.Request, op Operation, next http.HandlerFunc) {
+	s.mu.Lock()
+	s.requests[op]++
+	failure := s.failures[op]
+	if failure != nil && failure.Count > 0 {
+		failure.Count--
+		if failure.Count == 0 {
+			delete(s.failures, op)
+		}
+	}
+	s.mu.Unlock()
+
+	if failure != nil {
+		writeError(w, failure.Status, failure.Code, failure.Message)
+		return
+	}
+	next(w, r)
+}
+
+func (s *Server) serveMetadata(w http.ResponseWriter, r *http.Request) {
+	if !requireMetadataHeader(w, r) {
+		return
+	}
+	s.mu.Lock()
+	vm := s.vm
+	tagsList := make([]map[string]string, 0, len(s.tags))
+	for name, value := range s.tags {
+		tagsList = append(tagsList, map[string]string{"name": name, "value": value})
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"subscriptionId":    vm.SubscriptionID,
+		"resourceGroupName": vm.ResourceGroup,
+		"name":              vm.Name,
+		"azEnvironment":     vm.AzEnvironment,
+		"vmSize":            vm.Size,
+		"location":          vm.Location,
+		"tagsList":          tagsList,
+		"userData":          base64.StdEncoding.EncodeToString([]byte(vm.UserData)),
+	})
+}
+
+func (s *Server) serveIMDSToken(w http.ResponseWriter, r *http.Request) {
+	if !requireMetadataHeader(w, r) {
+		return
+	}
+	resource := r.URL.Query().Get("resource")
+	if resource == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "resource is required"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]string{
+		"access_token": Token,
+		"expires_in":   "3599",
+		"resource":     resource,
+		"token_type":   "Bearer",
+	})
+}
+
+func (s *Server) serveScheduledEvents(w http.ResponseWriter, r *http.Request) {
+	if !requireMetadataHeader(w, r) {
+		return
+	}
+	s.mu.Lock()
+	events := make([]map[string]interface{}, 0, len(s.scheduledEvents))
+	for _, e := range s.scheduledEvents {
+		status := e.EventStatus
+		if status == "" {
+			status = "Scheduled"
+		}
+		resources := e.Resources
+		if resources == nil {
+			resources = []string{s.vm.Name}
+		}
+		events = append(events, map[string]interface{}{
+			"EventId":           e.EventId,
+			"EventType":         e.EventType,
+			"ResourceType":      "VirtualMachine",
+			"Resources":         resources,
+			"EventStatus":       status,
+			"NotBefore":         e.NotBefore,
+			"Description":       fmt.Sprintf("Fake %s event", e.EventType),
+			"EventSource":       "Platform",
+			"DurationInSeconds": -1,
+		})
+	}
+	incarnation := s.incarnation
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"DocumentIncarnation": incarnation,
+		"Events":              events,
+	})
+}
+
+// serveAcknowledgeEvents approves scheduled events; approved events move to Started like they do on Azure
+func (s *Server) serveAcknowledgeEvents(w http.ResponseWriter, r *http.Request) {
+	if !requireMetadataHeader(w, r) {
+		return
+	}
+	var body struct {
+		StartRequests []struct {
+			EventId string `json:"EventId"`
+		} `json:"StartRequests"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil || len(body.StartRequests) == 0 {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Bad request. StartRequests is required"})
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, req := range body.StartRequests {
+		for i := range s.scheduledEvents {
+			if s.scheduledEvents[i].EventId == req.EventId {
+				s.scheduledEvents[i].EventStatus = "Started"
+				s.scheduledEvents[i].NotBefore = ""
+			}
+		}
+		s.acknowledged = append(s.acknowledged, req.EventId)
+	}
+	s.incarnation++
+	w.WriteHeader(http.StatusOK)
+}
+
+func (s *Server) serveAADToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.PostForm.Get("client_id") == "" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_request", "error_description": "client_id is required"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": Token,
+		"expires_in":   3599,
+		"token_type":   "Bearer",
+	})
+}
+
+// serveVM routes requests under /subscriptions/{sub}/resourceGroups/{rg}/providers/Microsoft.Compute/virtualMachines/{vm}
+// and the VM's tags at .../providers/Microsoft.Resources/tags/default
+func (s *Server) serveVM(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+
+	var op Operation
+	switch {
+	case r.Method == http.MethodGet && len(parts) == 8:
+		op = OpGetVM
+	case r.Method == http.MethodPost && len(parts) == 9 && parts[8] == "deallocate":
+		op = OpDeallocate
+	case r.Method == http.MethodPatch && len(parts) == 12 && strings.EqualFold(strings.Join(parts[8:], "/"), "providers/Microsoft.Resources/tags/default"):
+		op = OpTags
+	default:
+		writeError(w, http.StatusNotFound, "NotFound", fmt.Sprintf("no fake route for %s %s", r.Method, r.URL.Path))
+		return
+	}
+
+	s.handle(w, r, op, func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+Token {
+			writeError(w, http.StatusUnauthorized, "InvalidAuthenticationToken", "The access token is invalid.")
+			return
+		}
+		if r.URL.Query().Get("api-version") == "" {
+			writeError(w, http.StatusBadRequest, "MissingApiVersionParameter", "The api-version query parameter (?api-version=) is required for all requests.")
+			return
+		}
+
+		s.mu.Lock()
+		vm := s.vm
+		s.mu.Unlock()
+
+		if !strings.EqualFold(parts[1], vm.SubscriptionID) || !strings.EqualFold(parts[3], vm.ResourceGroup) ||
+			!strings.EqualFold(parts[5], "Microsoft.Compute") || !strings.EqualFold(parts[7], vm.Name) {
+			writeError(w, http.StatusNotFound, "ResourceNotFound", fmt.Sprintf("The Resource '%s' was not found.", r.URL.Path))
+			return
+		}
+
+		switch op {
+		case OpGetVM:
+			s.serveGetVM(w, vm)
+		case OpDeallocate:
+			s.serveDeallocate(w, r, vm)
+		case OpTags:
+			s.serveTags(w, r)
+		}
+	})
+}
+
+func (s *Server) serveGetVM(w http.ResponseWriter, vm VM) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name": vm.Name,
+		"properties": map[string]interface{}{
+			"additionalCapabilities": map[string]bool{"hibernationEnabled": vm.HibernationEnabled},
+		},
+	})
+}
+
+func (s *Server) serveDeallocate(w http.ResponseWriter, r *http.Request, vm VM) {
+	hibernate := r.URL.Query().Get("hibernate") == "true"
+	if hibernate && !vm.HibernationEnabled {
+		writeError(w, http.StatusConflict, "OperationNotAllowed", "Hibernation is not enabled on the virtual machine.")
+		return
+	}
+
+	s.mu.Lock()
+	s.deallocations = append(s.deallocations, Deallocation{Hibernate: hibernate})
+	s.mu.Unlock()
+
+	// The client returns once Azure accepts the request, so the fake has no async operation to poll
+	w.WriteHeader(http.StatusAccepted)
+}
+
+func (s *Server) serveTags(w http.ResponseWriter, r *http.Request) {
+	var body struct {
+		Operation  string `json:"operation"`
+		Properties struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"properties"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", err.Error())
+		return
+	}
+
+	s.mu.Lock()
+	switch body.Operation {
+	case "Merge":
+	case "Replace":
+		s.tags = make(map[string]string)
+	default:
+		s.mu.Unlock()
+		writeError(w, http.StatusBadRequest, "InvalidRequestContent", fmt.Sprintf("unsupported tags operation %q", body.Operation))
+		return
+	}
+	for name, value := range body.Properties.Tags {
+		s.tags[name] = value
+	}
+	tags := make(map[string]string, len(s.tags))
+	for name, value := range s.tags {
+		tags[name] = value
+	}
+	s.mu.Unlock()
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"name":       "default",
+		"properties": map[string]interface{}{"tags": tags},
+	})
+}
+
+// requireMetadataHeader rejects IMDS requests without the Metadata: true header, like IMDS does
+func requireMetadataHeader(w http.ResponseWriter, r *http.Request) bool {
+	if r.Header.Get("Metadata") != "true" {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": "Bad request. Required metadata header not specified"})
+		return false
+	}
+	return true
+}
+
+// writeError writes an ARM error envelope
+func writeError(w http.ResponseWriter, status int, code, message string) {
+	writeJSON(w, status, map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -7,12 +7,18 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
 )
 
 type AzureClient struct {
 	subscriptionId string
 	resourceGroup  string
 	vmName         string
+	cloud          Cloud
+	credential     Credential
+	tagging        bool        // Stamp hibernation tags before hibernate/deallocate
+	onTagError     func(error) // Called when stamping tags fails (tagging fails open)
 }
 
 // vmResponse represents the Azure VM API response structure
@@ -28,37 +34,64 @@ type additionalCapabilities struct {
 	HibernationEnabled *bool `json:"hibernationEnabled,omitempty"`
 }
 
-func NewAzureClient(subscriptionId, resourceGroup, vmName string) *AzureClient {
+func NewAzureClient(subscriptionId, resourceGroup, vmName string, cloud Cloud, credential Credential) *AzureClient {
 	return &AzureClient{
 		subscriptionId: subscriptionId,
 		resourceGroup:  resourceGroup,
 		vmName:         vmName,
+		cloud:          cloud,
+		credential:     credential,
 	}
 }
 
+// Cloud returns the Azure cloud the client sends requests to
+func (c *AzureClient) Cloud() Cloud {
+	return c.cloud
+}
+
+// Credential returns the credential used for Azure requests
+func (c *AzureClient) Credential() Credential {
+	return c.credential
+}
+
 // HibernateVM sends a hibernation request to Azure for the VM
 func (c *AzureClient) HibernateVM(ctx context.Context) error {
+	return c.deallocate(ctx, true)
+}
+
+// DeallocateVM sends a plain deallocation request (without hibernation) to Azure for the VM
+func (c *AzureClient) DeallocateVM(ctx context.Context) error {
+	return c.deallocate(ctx, false)
+}
+
+// deallocate sends a deallocate request to Azure, optionally asking for hibernation
+func (c *AzureClient) deallocate(ctx context.Context, hibernate bool) (err error) {
+	operation, action := "deallocation", "deallocate"
+	if hibernate {
+		operation, action = "hibernation", "hibernate"
+	}
+	ctx, span := startRequest(ctx, "arm "+action, apiARM)
+	defer func() { span.End(err) }()
+
 	// Get the access token
-	token, err := GetManagedIdentityToken(ctx)
+	token, err := c.credential.GetToken(ctx, c.cloud.TokenAudience)
 	if err != nil {
-		return fmt.Errorf("failed to get managed identity token: %w", err)
+		return fmt.Errorf("failed to get access token: %w", err)
 	}
 
-	// Build the hibernation API URL
-	// https://management.azure.com/subscriptions/{subscriptionId}/resourceGroups/{resourceGroupName}/providers/Microsoft.Compute/virtualMachines/{vmName}/deallocate?api-version=2024-07-01&hibernate=true
-	url := fmt.Sprintf(
-		"%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s/deallocate?api-version=%s&hibernate=true",
-		azureManagementEndpoint,
-		c.subscriptionId,
-		c.resourceGroup,
-		c.vmName,
-		computeApiVersion,
-	)
+	// Stamp hibernation tags first; the VM cannot be tagged once it is going down
+	c.stampHibernationTags(ctx, token, action)
+
+	// Build the deallocate API URL
+	url := c.cloud.vmURL(c.subscriptionId, c.resourceGroup, c.vmName, "deallocate")
+	if hibernate {
+		url += "&hibernate=true"
+	}
 
 	// Create the POST request
 	req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewReader([]byte{}))
 	if err != nil {
-		return fmt.Errorf("failed to create hibernation request: %w", err)
+		return fmt.Errorf("failed to create %s request: %w", operation, err)
 	}
 
 	// Set headers
@@ -66,10 +99,12 @@ func (c *AzureClient) HibernateVM(ctx context.Context) error {
 	req.Header.Set("Content-Type", "application/json")
 
 	// Execute the request
-	client := &http.Client{}
+	client := httpclient.Default()
 	resp, err := client.Do(req)
+	observe(apiARM, resp, err)
+	traceResponse(span, resp)
 	if err != nil {
-		return fmt.Errorf("failed to send hibernation request to %s: %w", url, err)
+		return fmt.Errorf("failed to send %s request to %s: %w", operation, url, err)
 	}
 	defer resp.Body.Close()
 
@@ -79,29 +114,25 @@ func (c *AzureClient) HibernateVM(ctx context.Context) error {
 	// Check response status
 	// 200 OK or 202 Accepted are both valid responses
 	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
-		return fmt.Errorf("hibernation request failed with status %d: %s", resp.StatusCode, string(body))
+		return newARMError(operation, resp, body)
 	}
 
 	return nil
 }
 
 // CheckHibernationEnabled checks if hibernation is enabled on the VM via Azure API
-func (c *AzureClient) CheckHibernationEnabled(ctx context.Context) (bool, error) {
+func (c *AzureClient) CheckHibernationEnabled(ctx context.Context) (enabled bool, err error) {
+	ctx, span := startRequest(ctx, "arm vm properties", apiARM)
+	defer func() { span.End(err) }()
+
 	// Get the access token
-	token, err := GetManagedIdentityToken(ctx)
+	token, err := c.credential.GetToken(ctx, c.cloud.TokenAudience)
 	if err != nil {
-		return false, fmt.Errorf("failed to get managed identity token: %w", err)
+		return false, fmt.Errorf("failed to get access token: %w", err)
 	}
 
 	// Build the VM properties API URL
-	url := fmt.Sprintf(
-		"%s/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s?api-version=%s",
-		azureManagementEndpoint,
-		c.subscriptionId,
-		c.resourceGroup,
-		c.vmName,
-		computeApiVersion,
-	)
+	url := c.cloud.vmURL(c.subscriptionId, c.resourceGroup, c.vmName, "")
 
 	// Create the GET request
 	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
@@ -113,8 +144,10 @@ func (c *AzureClient) CheckHibernationEnabled(ctx context.Context) (bool, error)
 	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
 
 	// Execute the request
-	client := &http.Client{}
+	client := httpclient.Default()
 	resp, err := client.Do(req)
+	observe(apiARM, resp, err)
+	traceResponse(span, resp)
 	if err != nil {
 		return false, fmt.Errorf("failed to get VM properties from %s: %w", url, err)
 	}
@@ -128,7 +161,7 @@ func (c *AzureClient) CheckHibernationEnabled(ctx context.Context) (bool, error)
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
-		return false, fmt.Errorf("VM properties request failed with status %d: %s", resp.StatusCode, string(body))
+		return false, newARMError("VM properties", resp, body)
 	}
 
 	// Parse the JSON response properly
@@ -0,0 +1,35 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/metrics"
+)
+
+// APIs reported in request error metrics
+const (
+	apiIMDS  = "imds"
+	apiARM   = "arm"
+	apiEntra = "entra"
+)
+
+// requestErrors counts failed Azure requests by API and HTTP status code
+var requestErrors = metrics.NewCounterVec("autohibernate_azure_request_errors_total",
+	"Failed Azure requests by API (imds, arm, entra) and HTTP status code (network when no response was received).",
+	"api", "code")
+
+// observe counts the result of client.Do if the request failed. Canceled requests are not
+// failures of the API.
+func observe(api string, resp *http.Response, err error) {
+	switch {
+	case err != nil:
+		if !errors.Is(err, context.Canceled) {
+			requestErrors.With(api, "network").Inc()
+		}
+	case resp.StatusCode >= http.StatusBadRequest:
+		requestErrors.With(api, strconv.Itoa(resp.StatusCode)).Inc()
+	}
+}
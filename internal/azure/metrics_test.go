@@ -0,0 +1,41 @@
+package azure
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestObserve(t *testing.T) {
+	tests := []struct {
+		name     string
+		resp     *http.Response
+		err      error
+		wantCode string // Label counted ("" for none)
+	}{
+		{"success", &http.Response{StatusCode: http.StatusOK}, nil, ""},
+		{"accepted", &http.Response{StatusCode: http.StatusAccepted}, nil, ""},
+		{"forbidden", &http.Response{StatusCode: http.StatusForbidden}, nil, "403"},
+		{"throttled", &http.Response{StatusCode: http.StatusTooManyRequests}, nil, "429"},
+		{"network", nil, errors.New("connection refused"), "network"},
+		{"canceled", nil, fmt.Errorf("get: %w", context.Canceled), ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			api := "test-" + tt.name
+			observe(api, tt.resp, tt.err)
+			for _, code := range []string{"403", "429", "network"} {
+				want := 0.0
+				if code == tt.wantCode {
+					want = 1
+				}
+				if got := requestErrors.With(api, code).Value(); got != want {
+					t.Errorf("requestErrors{code=%q} = %v, want %v", code, got, want)
+				}
+			}
+		})
+	}
+}
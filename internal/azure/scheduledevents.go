@@ -0,0 +1,294 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+)
+
+const (
+	// IMDS Scheduled Events path and API version
+	imdsScheduledEventsPath       = "/metadata/scheduledevents"
+	imdsScheduledEventsApiVersion = "2020-07-01"
+)
+
+// Scheduled event types reported by IMDS
+const (
+	ScheduledEventFreeze    = "Freeze"    // VM is paused for a few seconds (memory-preserving maintenance)
+	ScheduledEventReboot    = "Reboot"    // VM is rebooted
+	ScheduledEventRedeploy  = "Redeploy"  // VM is moved to another host (temporary disk is lost)
+	ScheduledEventPreempt   = "Preempt"   // Spot VM is being evicted
+	ScheduledEventTerminate = "Terminate" // VM is being deleted (scale set scale-in)
+)
+
+// Scheduled event statuses reported by IMDS
+const (
+	ScheduledEventStatusScheduled = "Scheduled" // Waiting for NotBefore (or approval)
+	ScheduledEventStatusStarted   = "Started"   // The event has started
+)
+
+// ScheduledEvent is a platform event pending for the VM, as reported by IMDS Scheduled Events
+type ScheduledEvent struct {
+	EventId           string   `json:"EventId"`
+	EventType         string   `json:"EventType"`         // Freeze, Reboot, Redeploy, Preempt or Terminate
+	ResourceType      string   `json:"ResourceType"`      // Always VirtualMachine
+	Resources         []string `json:"Resources"`         // Names of the affected VMs
+	EventStatus       string   `json:"EventStatus"`       // Scheduled or Started
+	NotBefore         string   `json:"NotBefore"`         // Earliest start time (RFC 1123), empty once started
+	Description       string   `json:"Description"`       // Human-readable description of the event
+	EventSource       string   `json:"EventSource"`       // Platform or User
+	DurationInSeconds int      `json:"DurationInSeconds"` // Expected impact duration, -1 if unknown
+}
+
+// ScheduledEvents is the IMDS Scheduled Events document
+type ScheduledEvents struct {
+	DocumentIncarnation int              `json:"DocumentIncarnation"`
+	Events              []ScheduledEvent `json:"Events"`
+}
+
+// NotBeforeTime returns the earliest time the event starts, if IMDS reported one
+func (e ScheduledEvent) NotBeforeTime() (time.Time, bool) {
+	if e.NotBefore == "" {
+		return time.Time{}, false
+	}
+	t, err := http.ParseTime(e.NotBefore)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// IsSpotEviction reports whether the event is a Spot VM eviction
+func (e ScheduledEvent) IsSpotEviction() bool {
+	return e.EventType == ScheduledEventPreempt
+}
+
+// InterruptsVM reports whether the event stops the guest OS (everything except Freeze)
+func (e ScheduledEvent) InterruptsVM() bool {
+	return e.EventType != ScheduledEventFreeze
+}
+
+// affects reports whether the event applies to the named VM (events without resources apply to all)
+func (e ScheduledEvent) affects(vmName string) bool {
+	if vmName == "" || len(e.Resources) == 0 {
+		return true
+	}
+	for _, r := range e.Resources {
+		if strings.EqualFold(r, vmName) {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns a human-readable description of the event
+func (e ScheduledEvent) String() string {
+	s := fmt.Sprintf("%s %s (%s", e.EventType, e.EventId, e.EventStatus)
+	if e.NotBefore != "" {
+		s += ", not before " + e.NotBefore
+	}
+	return s + ")"
+}
+
+// GetScheduledEvents retrieves the pending scheduled events from IMDS.
+// endpoint overrides the IMDS base URL; leave empty to use DefaultIMDSEndpoint.
+// The first request enables Scheduled Events for the VM, which can take up to two minutes.
+func GetScheduledEvents(ctx context.Context, endpoint string) (*ScheduledEvents, error) {
+	params := url.Values{}
+	params.Add("api-version", imdsScheduledEventsApiVersion)
+
+	eventsEndpoint := imdsURL(endpoint, imdsScheduledEventsPath)
+	reqUrl := fmt.Sprintf("%s?%s", eventsEndpoint, params.Encode())
+
+	// Create the request
+	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set the required Metadata header
+	req.Header.Set("Metadata", "true")
+
+	// Execute the request. The first request can take longer than the shared client
+	// timeout, so only the caller's context bounds it.
+	client := *httpclient.Default()
+	client.Timeout = 0
+	resp, err := client.Do(req)
+	observe(apiIMDS, resp, err)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get scheduled events from IMDS (endpoint: %s): %w", eventsEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	// Read the response body
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read response body: %w", err)
+	}
+
+	// Check response status
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("IMDS returned status %d for scheduled events: %s", resp.StatusCode, string(body))
+	}
+
+	// Parse the JSON response
+	var events ScheduledEvents
+	if err := json.Unmarshal(body, &events); err != nil {
+		return nil, fmt.Errorf("failed to parse scheduled events response: %w", err)
+	}
+
+	return &events, nil
+}
+
+// scheduledEventsApproval is the body of a Scheduled Events acknowledgement
+type scheduledEventsApproval struct {
+	StartRequests []scheduledEventStartRequest `json:"StartRequests"`
+}
+
+type scheduledEventStartRequest struct {
+	EventId string `json:"EventId"`
+}
+
+// AcknowledgeScheduledEvents approves the given events (StartRequests), allowing the
+// platform to start them before NotBefore.
+// endpoint overrides the IMDS base URL; leave empty to use DefaultIMDSEndpoint.
+func AcknowledgeScheduledEvents(ctx context.Context, endpoint string, eventIds ...string) error {
+	if len(eventIds) == 0 {
+		return nil
+	}
+
+	var approval scheduledEventsApproval
+	for _, id := range eventIds {
+		approval.StartRequests = append(approval.StartRequests, scheduledEventStartRequest{EventId: id})
+	}
+
+	data, err := json.Marshal(approval)
+	if err != nil {
+		return fmt.Errorf("failed to encode scheduled events approval: %w", err)
+	}
+
+	params := url.Values{}
+	params.Add("api-version", imdsScheduledEventsApiVersion)
+
+	eventsEndpoint := imdsURL(endpoint, imdsScheduledEventsPath)
+	reqUrl := fmt.Sprintf("%s?%s", eventsEndpoint, params.Encode())
+
+	// Create the request
+	req, err := http.NewRequestWithContext(ctx, "POST", reqUrl, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Metadata", "true")
+	req.Header.Set("Content-Type", "application/json")
+
+	// Execute the request
+	client := httpclient.Default()
+	resp, err := client.Do(req)
+	observe(apiIMDS, resp, err)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge scheduled events (endpoint: %s): %w", eventsEndpoint, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("IMDS returned status %d acknowledging scheduled events: %s", resp.StatusCode, string(body))
+	}
+
+	return nil
+}
+
+// ScheduledEventChanges describes what changed between two scheduled event polls
+type ScheduledEventChanges struct {
+	New       []ScheduledEvent // Events reported for the first time
+	Started   []ScheduledEvent // Known events that moved to Started
+	Completed []ScheduledEvent // Known events no longer reported (finished or canceled)
+	Pending   []ScheduledEvent // All events currently pending for the VM
+}
+
+// HasChanges reports whether any event was added, started or completed
+func (c *ScheduledEventChanges) HasChanges() bool {
+	return len(c.New) > 0 || len(c.Started) > 0 || len(c.Completed) > 0
+}
+
+// ScheduledEventPoller polls IMDS Scheduled Events and reports the changes for one VM
+type ScheduledEventPoller struct {
+	endpoint string
+	vmName   string
+	known    map[string]ScheduledEvent
+	order    []string // Event IDs in the order they were first reported
+}
+
+// NewScheduledEventPoller creates a poller for events affecting the named VM.
+// endpoint overrides the IMDS base URL; leave empty to use DefaultIMDSEndpoint.
+func NewScheduledEventPoller(endpoint, vmName string) *ScheduledEventPoller {
+	return &ScheduledEventPoller{
+		endpoint: endpoint,
+		vmName:   vmName,
+		known:    make(map[string]ScheduledEvent),
+	}
+}
+
+// Poll fetches the scheduled events and returns the changes since the previous poll
+func (p *ScheduledEventPoller) Poll(ctx context.Context) (*ScheduledEventChanges, error) {
+	doc, err := GetScheduledEvents(ctx, p.endpoint)
+	if err != nil {
+		return nil, err
+	}
+	return p.update(doc.Events), nil
+}
+
+// update diffs the reported events against the known events
+func (p *ScheduledEventPoller) update(events []ScheduledEvent) *ScheduledEventChanges {
+	changes := &ScheduledEventChanges{}
+	current := make(map[string]ScheduledEvent)
+
+	for _, e := range events {
+		if !e.affects(p.vmName) {
+			continue
+		}
+		current[e.EventId] = e
+		changes.Pending = append(changes.Pending, e)
+
+		prev, seen := p.known[e.EventId]
+		switch {
+		case !seen:
+			changes.New = append(changes.New, e)
+			p.order = append(p.order, e.EventId)
+		case prev.EventStatus != ScheduledEventStatusStarted && e.EventStatus == ScheduledEventStatusStarted:
+			changes.Started = append(changes.Started, e)
+		}
+	}
+
+	order := p.order[:0]
+	for _, id := range p.order {
+		if _, ok := current[id]; ok {
+			order = append(order, id)
+		} else {
+			changes.Completed = append(changes.Completed, p.known[id])
+		}
+	}
+	p.order = order
+	p.known = current
+
+	return changes
+}
+
+// Acknowledge approves the given events so they start without waiting for NotBefore
+func (p *ScheduledEventPoller) Acknowledge(ctx context.Context, events ...ScheduledEvent) error {
+	ids := make([]string, 0, len(events))
+	for _, e := range events {
+		ids = append(ids, e.EventId)
+	}
+	return AcknowledgeScheduledEvents(ctx, p.endpoint, ids...)
+}
@@ -0,0 +1,124 @@
+package azure
+
+import (
+	"testing"
+	"time"
+)
+
+// eventIDs returns the IDs of the events, in order
+func eventIDs(events []ScheduledEvent) []string {
+	ids := []string{}
+	for _, e := range events {
+		ids = append(ids, e.EventId)
+	}
+	return ids
+}
+
+func equalIDs(got, want []string) bool {
+	if len(got) != len(want) {
+		return false
+	}
+	for i := range got {
+		if got[i] != want[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// TestScheduledEventPollerUpdate tests diffing successive scheduled event documents
+func TestScheduledEventPollerUpdate(t *testing.T) {
+	reboot := ScheduledEvent{EventId: "reboot", EventType: ScheduledEventReboot, EventStatus: ScheduledEventStatusScheduled, Resources: []string{"vm1"}}
+	freeze := ScheduledEvent{EventId: "freeze", EventType: ScheduledEventFreeze, EventStatus: ScheduledEventStatusScheduled, Resources: []string{"VM1", "vm2"}}
+	other := ScheduledEvent{EventId: "other", EventType: ScheduledEventRedeploy, EventStatus: ScheduledEventStatusScheduled, Resources: []string{"vm2"}}
+	rebootStarted := reboot
+	rebootStarted.EventStatus = ScheduledEventStatusStarted
+
+	tests := []struct {
+		name          string
+		events        []ScheduledEvent
+		wantNew       []string
+		wantStarted   []string
+		wantCompleted []string
+		wantPending   []string
+	}{
+		{
+			name:        "no events",
+			wantNew:     []string{},
+			wantStarted: []string{}, wantCompleted: []string{}, wantPending: []string{},
+		},
+		{
+			name:        "new events for this VM only",
+			events:      []ScheduledEvent{reboot, other, freeze},
+			wantNew:     []string{"reboot", "freeze"},
+			wantStarted: []string{}, wantCompleted: []string{},
+			wantPending: []string{"reboot", "freeze"},
+		},
+		{
+			name:        "unchanged",
+			events:      []ScheduledEvent{reboot, other, freeze},
+			wantNew:     []string{},
+			wantStarted: []string{}, wantCompleted: []string{},
+			wantPending: []string{"reboot", "freeze"},
+		},
+		{
+			name:        "started and completed",
+			events:      []ScheduledEvent{rebootStarted},
+			wantNew:     []string{},
+			wantStarted: []string{"reboot"}, wantCompleted: []string{"freeze"},
+			wantPending: []string{"reboot"},
+		},
+		{
+			name:        "all completed",
+			wantNew:     []string{},
+			wantStarted: []string{}, wantCompleted: []string{"reboot"},
+			wantPending: []string{},
+		},
+	}
+
+	// The cases run in sequence against the same poller
+	p := NewScheduledEventPoller("", "vm1")
+	for _, tt := range tests {
+		changes := p.update(tt.events)
+		if got := eventIDs(changes.New); !equalIDs(got, tt.wantNew) {
+			t.Errorf("%s: New = %v, want %v", tt.name, got, tt.wantNew)
+		}
+		if got := eventIDs(changes.Started); !equalIDs(got, tt.wantStarted) {
+			t.Errorf("%s: Started = %v, want %v", tt.name, got, tt.wantStarted)
+		}
+		if got := eventIDs(changes.Completed); !equalIDs(got, tt.wantCompleted) {
+			t.Errorf("%s: Completed = %v, want %v", tt.name, got, tt.wantCompleted)
+		}
+		if got := eventIDs(changes.Pending); !equalIDs(got, tt.wantPending) {
+			t.Errorf("%s: Pending = %v, want %v", tt.name, got, tt.wantPending)
+		}
+		wantChanges := len(tt.wantNew)+len(tt.wantStarted)+len(tt.wantCompleted) > 0
+		if changes.HasChanges() != wantChanges {
+			t.Errorf("%s: HasChanges() = %v, want %v", tt.name, changes.HasChanges(), wantChanges)
+		}
+	}
+}
+
+// TestScheduledEventProperties tests the event helpers
+func TestScheduledEventProperties(t *testing.T) {
+	e := ScheduledEvent{EventId: "id", EventType: ScheduledEventPreempt, EventStatus: ScheduledEventStatusScheduled, NotBefore: "Mon, 19 Sep 2016 18:29:47 GMT"}
+
+	notBefore, ok := e.NotBeforeTime()
+	if !ok || !notBefore.Equal(time.Date(2016, 9, 19, 18, 29, 47, 0, time.UTC)) {
+		t.Errorf("NotBeforeTime() = %v, %v", notBefore, ok)
+	}
+	if !e.IsSpotEviction() || !e.InterruptsVM() {
+		t.Errorf("Preempt: IsSpotEviction() = %v, InterruptsVM() = %v, want true, true", e.IsSpotEviction(), e.InterruptsVM())
+	}
+	if got, want := e.String(), "Preempt id (Scheduled, not before Mon, 19 Sep 2016 18:29:47 GMT)"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+
+	freeze := ScheduledEvent{EventType: ScheduledEventFreeze, EventStatus: ScheduledEventStatusStarted}
+	if freeze.IsSpotEviction() || freeze.InterruptsVM() {
+		t.Errorf("Freeze: IsSpotEviction() = %v, InterruptsVM() = %v, want false, false", freeze.IsSpotEviction(), freeze.InterruptsVM())
+	}
+	if _, ok := freeze.NotBeforeTime(); ok {
+		t.Error("NotBeforeTime() ok for a started event")
+	}
+}
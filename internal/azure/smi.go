@@ -7,15 +7,20 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"strings"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
 )
 
 const (
-	// Azure Instance Metadata Service endpoints
-	imdsTokenEndpoint    = "http://169.254.169.254/metadata/identity/oauth2/token"
-	imdsInstanceEndpoint = "http://169.254.169.254/metadata/instance"
+	// Azure Instance Metadata Service base URL (link-local, identical in every cloud)
+	DefaultIMDSEndpoint = "http://169.254.169.254"
 
-	// Azure API endpoints
-	azureManagementEndpoint = "https://management.azure.com"
+	// Azure Instance Metadata Service paths
+	imdsTokenPath    = "/metadata/identity/oauth2/token"
+	imdsInstancePath = "/metadata/instance"
 
 	// IMDS API versions
 	imdsTokenApiVersion    = "2018-02-01"
@@ -27,7 +32,6 @@ const (
 	// Legacy aliases for backward compatibility
 	apiVersion         = imdsTokenApiVersion
 	instanceApiVersion = imdsInstanceApiVersion
-	resource           = azureManagementEndpoint + "/"
 )
 
 type TokenResponse struct {
@@ -42,19 +46,82 @@ type TokenResponse struct {
 
 // IMDSComputeResponse represents the compute metadata response from Azure IMDS
 type IMDSComputeResponse struct {
-	SubscriptionID    string `json:"subscriptionId"`
-	ResourceGroupName string `json:"resourceGroupName"`
-	Name              string `json:"name"`
+	SubscriptionID    string    `json:"subscriptionId"`
+	ResourceGroupName string    `json:"resourceGroupName"`
+	Name              string    `json:"name"`
+	AzEnvironment     string    `json:"azEnvironment"`
+	VMSize            string    `json:"vmSize"`
+	Location          string    `json:"location"`
+	TagsList          []IMDSTag `json:"tagsList"`
+	UserData          string    `json:"userData"` // Base64-encoded user data
+}
+
+// IMDSTag is a VM tag as reported by IMDS compute metadata
+type IMDSTag struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// ManagedIdentity selects which managed identity IMDS issues tokens for.
+// The zero value selects the VM's system-assigned identity; otherwise exactly
+// one of the fields identifies a user-assigned identity.
+type ManagedIdentity struct {
+	ClientID   string // Client (application) ID of a user-assigned identity
+	ObjectID   string // Object (principal) ID of a user-assigned identity
+	ResourceID string // ARM resource ID of a user-assigned identity
+}
+
+// IsSystemAssigned reports whether the identity is the VM's system-assigned identity
+func (id ManagedIdentity) IsSystemAssigned() bool {
+	return id.ClientID == "" && id.ObjectID == "" && id.ResourceID == ""
+}
+
+// String returns a human-readable description of the identity
+func (id ManagedIdentity) String() string {
+	switch {
+	case id.ClientID != "":
+		return fmt.Sprintf("user-assigned (client_id=%s)", id.ClientID)
+	case id.ObjectID != "":
+		return fmt.Sprintf("user-assigned (object_id=%s)", id.ObjectID)
+	case id.ResourceID != "":
+		return fmt.Sprintf("user-assigned (msi_res_id=%s)", id.ResourceID)
+	default:
+		return "system-assigned"
+	}
+}
+
+// addTo adds the identity selection parameters to an IMDS token request
+func (id ManagedIdentity) addTo(params url.Values) {
+	switch {
+	case id.ClientID != "":
+		params.Add("client_id", id.ClientID)
+	case id.ObjectID != "":
+		params.Add("object_id", id.ObjectID)
+	case id.ResourceID != "":
+		params.Add("msi_res_id", id.ResourceID)
+	}
+}
+
+// imdsURL joins an IMDS path onto the base URL, using the default IMDS endpoint if none is set
+func imdsURL(endpoint, path string) string {
+	if endpoint == "" {
+		endpoint = DefaultIMDSEndpoint
+	}
+	return strings.TrimSuffix(endpoint, "/") + path
 }
 
-// GetManagedIdentityToken retrieves an access token using the VM's System Managed Identity
-func GetManagedIdentityToken(ctx context.Context) (string, error) {
+// getIMDSToken retrieves an access token for the given managed identity and resource from IMDS
+func getIMDSToken(ctx context.Context, endpoint string, identity ManagedIdentity, resource string) (token string, err error) {
+	ctx, span := startRequest(ctx, "imds token", apiIMDS, tracing.String("identity", identity.String()))
+	defer func() { span.End(err) }()
+
 	// Build the request URL
 	params := url.Values{}
 	params.Add("api-version", apiVersion)
 	params.Add("resource", resource)
+	identity.addTo(params)
 
-	reqUrl := fmt.Sprintf("%s?%s", imdsTokenEndpoint, params.Encode())
+	reqUrl := fmt.Sprintf("%s?%s", imdsURL(endpoint, imdsTokenPath), params.Encode())
 
 	// Create the request
 	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
@@ -66,17 +133,19 @@ func GetManagedIdentityToken(ctx context.Context) (string, error) {
 	req.Header.Set("Metadata", "true")
 
 	// Execute the request
-	client := &http.Client{}
+	client := httpclient.Default()
 	resp, err := client.Do(req)
+	observe(apiIMDS, resp, err)
+	traceResponse(span, resp)
 	if err != nil {
-		return "", fmt.Errorf("failed to get token from IMDS: %w", err)
+		return "", fmt.Errorf("failed to get token from IMDS for %s identity: %w", identity, err)
 	}
 	defer resp.Body.Close()
 
 	// Check response status
 	if resp.StatusCode != http.StatusOK {
 		body, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("IMDS returned status %d: %s", resp.StatusCode, string(body))
+		return "", fmt.Errorf("IMDS returned status %d for %s identity: %s", resp.StatusCode, identity, string(body))
 	}
 
 	// Read the response body
@@ -103,16 +172,20 @@ type VMMetadata struct {
 	SubscriptionId string
 	ResourceGroup  string
 	VMName         string
+	AzEnvironment  string // Azure cloud reported by IMDS (e.g. AzurePublicCloud)
+	VMSize         string // VM size (e.g. Standard_D4s_v5)
+	Location       string // Azure region (e.g. westeurope)
 }
 
-// GetVMMetadata retrieves VM metadata from Azure IMDS
-func GetVMMetadata(ctx context.Context) (*VMMetadata, error) {
+// getComputeMetadata retrieves the IMDS compute metadata document
+func getComputeMetadata(ctx context.Context, endpoint string) (*IMDSComputeResponse, error) {
 	// Build the request URL
 	params := url.Values{}
 	params.Add("api-version", instanceApiVersion)
 	params.Add("format", "json")
 
-	reqUrl := fmt.Sprintf("%s/compute?%s", imdsInstanceEndpoint, params.Encode())
+	instanceEndpoint := imdsURL(endpoint, imdsInstancePath)
+	reqUrl := fmt.Sprintf("%s/compute?%s", instanceEndpoint, params.Encode())
 
 	// Create the request
 	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
@@ -124,10 +197,11 @@ func GetVMMetadata(ctx context.Context) (*VMMetadata, error) {
 	req.Header.Set("Metadata", "true")
 
 	// Execute the request
-	client := &http.Client{}
+	client := httpclient.Default()
 	resp, err := client.Do(req)
+	observe(apiIMDS, resp, err)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get metadata from IMDS (endpoint: %s): %w", imdsInstanceEndpoint, err)
+		return nil, fmt.Errorf("failed to get metadata from IMDS (endpoint: %s): %w", instanceEndpoint, err)
 	}
 	defer resp.Body.Close()
 
@@ -149,6 +223,17 @@ func GetVMMetadata(ctx context.Context) (*VMMetadata, error) {
 		return nil, fmt.Errorf("failed to parse compute metadata response: %w", err)
 	}
 
+	return &computeResp, nil
+}
+
+// GetVMMetadata retrieves VM metadata from Azure IMDS.
+// endpoint overrides the IMDS base URL; leave empty to use DefaultIMDSEndpoint.
+func GetVMMetadata(ctx context.Context, endpoint string) (*VMMetadata, error) {
+	computeResp, err := getComputeMetadata(ctx, endpoint)
+	if err != nil {
+		return nil, err
+	}
+
 	// Validate required fields
 	if computeResp.SubscriptionID == "" {
 		return nil, fmt.Errorf("subscriptionId is empty in response")
@@ -164,6 +249,9 @@ func GetVMMetadata(ctx context.Context) (*VMMetadata, error) {
 		SubscriptionId: computeResp.SubscriptionID,
 		ResourceGroup:  computeResp.ResourceGroupName,
 		VMName:         computeResp.Name,
+		AzEnvironment:  computeResp.AzEnvironment,
+		VMSize:         computeResp.VMSize,
+		Location:       computeResp.Location,
 	}, nil
 }
 
@@ -172,6 +260,9 @@ type HibernationCapabilityResult struct {
 	IMDSAvailable       bool
 	IMDSError           error
 	VMMetadata          *VMMetadata
+	Cloud               Cloud
+	Credential          Credential
+	ConfigError         error // Cloud or credential configuration could not be resolved
 	TokenSuccess        bool
 	TokenError          error
 	HibernationEnabled  bool
@@ -179,12 +270,12 @@ type HibernationCapabilityResult struct {
 }
 
 // TestHibernationCapability checks if the VM can be hibernated via Azure
-// This tests IMDS connectivity, Managed Identity configuration, and VM hibernation capability
-func TestHibernationCapability(ctx context.Context) *HibernationCapabilityResult {
+// This tests IMDS connectivity, cloud and credential configuration, and VM hibernation capability
+func TestHibernationCapability(ctx context.Context, cfg *config.Config) *HibernationCapabilityResult {
 	result := &HibernationCapabilityResult{}
 
 	// Test 1: IMDS connectivity and VM metadata retrieval
-	vmMetadata, err := GetVMMetadata(ctx)
+	vmMetadata, err := GetVMMetadata(ctx, cfg.IMDSEndpoint)
 	if err != nil {
 		result.IMDSAvailable = false
 		result.IMDSError = err
@@ -194,8 +285,23 @@ func TestHibernationCapability(ctx context.Context) *HibernationCapabilityResult
 	result.IMDSAvailable = true
 	result.VMMetadata = vmMetadata
 
-	// Test 2: Managed Identity token retrieval
-	_, err = GetManagedIdentityToken(ctx)
+	// Test 2: Resolve the cloud and build the configured credential
+	cloud, err := ResolveCloud(cfg.Cloud, vmMetadata.AzEnvironment)
+	if err != nil {
+		result.ConfigError = err
+		return result
+	}
+	result.Cloud = cloud
+
+	credential, err := NewCredential(cfg, cloud)
+	if err != nil {
+		result.ConfigError = err
+		return result
+	}
+	result.Credential = credential
+
+	// Test 3: Access token retrieval using the configured credential
+	_, err = credential.GetToken(ctx, cloud.TokenAudience)
 	if err != nil {
 		result.TokenSuccess = false
 		result.TokenError = err
@@ -204,8 +310,8 @@ func TestHibernationCapability(ctx context.Context) *HibernationCapabilityResult
 
 	result.TokenSuccess = true
 
-	// Test 3: Check if hibernation is actually enabled on the VM via Azure API
-	client := NewAzureClient(vmMetadata.SubscriptionId, vmMetadata.ResourceGroup, vmMetadata.VMName)
+	// Test 4: Check if hibernation is actually enabled on the VM via Azure API
+	client := NewAzureClient(vmMetadata.SubscriptionId, vmMetadata.ResourceGroup, vmMetadata.VMName, cloud, credential)
 	hibernationEnabled, err := client.CheckHibernationEnabled(ctx)
 	if err != nil {
 		result.HibernationEnabled = false
@@ -0,0 +1,69 @@
+package azure
+
+import (
+	"net/url"
+	"testing"
+)
+
+// TestManagedIdentityParams tests that the identity selects the right IMDS token parameter
+func TestManagedIdentityParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		identity   ManagedIdentity
+		wantParam  string
+		wantValue  string
+		wantString string
+	}{
+		{
+			name:       "system-assigned",
+			identity:   ManagedIdentity{},
+			wantString: "system-assigned",
+		},
+		{
+			name:       "client id",
+			identity:   ManagedIdentity{ClientID: "client"},
+			wantParam:  "client_id",
+			wantValue:  "client",
+			wantString: "user-assigned (client_id=client)",
+		},
+		{
+			name:       "object id",
+			identity:   ManagedIdentity{ObjectID: "object"},
+			wantParam:  "object_id",
+			wantValue:  "object",
+			wantString: "user-assigned (object_id=object)",
+		},
+		{
+			name:       "resource id",
+			identity:   ManagedIdentity{ResourceID: "/subscriptions/s/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id"},
+			wantParam:  "msi_res_id",
+			wantValue:  "/subscriptions/s/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id",
+			wantString: "user-assigned (msi_res_id=/subscriptions/s/resourceGroups/rg/providers/Microsoft.ManagedIdentity/userAssignedIdentities/id)",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params := url.Values{}
+			tt.identity.addTo(params)
+
+			for _, key := range []string{"client_id", "object_id", "msi_res_id"} {
+				got := params.Get(key)
+				if key == tt.wantParam {
+					if got != tt.wantValue {
+						t.Errorf("%s = %q, want %q", key, got, tt.wantValue)
+					}
+				} else if got != "" {
+					t.Errorf("unexpected %s = %q", key, got)
+				}
+			}
+
+			if got := tt.identity.String(); got != tt.wantString {
+				t.Errorf("String() = %q, want %q", got, tt.wantString)
+			}
+			if got := tt.identity.IsSystemAssigned(); got != (tt.wantParam == "") {
+				t.Errorf("IsSystemAssigned() = %v, want %v", got, tt.wantParam == "")
+			}
+		})
+	}
+}
@@ -0,0 +1,173 @@
+package azure
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+const (
+	// Azure Resource Manager Tags API version
+	tagsApiVersion = "2021-04-01"
+
+	// maxTagValueLength is the maximum length of an Azure tag value, in characters
+	maxTagValueLength = 256
+
+	// tagsOperation names tag requests in errors
+	tagsOperation = "tags"
+)
+
+// Tags stamped on the VM before it is hibernated or deallocated
+const (
+	TagLastRequestedAt = "autohibernate:lastRequestedAt" // RFC3339 UTC time of the request, which may still fail
+	TagLastReason      = "autohibernate:lastReason"      // Human-readable idle reason
+	TagLastCondition   = "autohibernate:lastCondition"   // Idle condition (noUsers, allDisconnected, inactiveUser)
+	TagLastAction      = "autohibernate:lastAction"      // hibernate or deallocate
+	TagAgentVersion    = "autohibernate:agentVersion"    // Version of the agent that made the request
+)
+
+// HibernationInfo describes why the VM is being hibernated; it is stamped onto the VM as tags
+type HibernationInfo struct {
+	Reason    string
+	Condition string
+}
+
+type hibernationInfoKey struct{}
+
+// WithHibernationInfo returns a context carrying the reason for an upcoming hibernate or deallocate
+func WithHibernationInfo(ctx context.Context, info HibernationInfo) context.Context {
+	return context.WithValue(ctx, hibernationInfoKey{}, info)
+}
+
+// hibernationInfoFrom returns the hibernation info carried by the context, if any
+func hibernationInfoFrom(ctx context.Context) HibernationInfo {
+	info, _ := ctx.Value(hibernationInfoKey{}).(HibernationInfo)
+	return info
+}
+
+// EnableTagging makes the client stamp hibernation tags on the VM before each hibernate or
+// deallocate request. Tagging fails open: errors are passed to onError (if set) and never
+// prevent the request.
+func (c *AzureClient) EnableTagging(onError func(error)) {
+	c.tagging = true
+	c.onTagError = onError
+}
+
+// hibernationTags builds the tags stamped on the VM for a hibernate or deallocate request
+func hibernationTags(info HibernationInfo, operation string, now time.Time) map[string]string {
+	tags := map[string]string{
+		TagLastRequestedAt: now.UTC().Format(time.RFC3339),
+		TagLastAction:      operation,
+		TagAgentVersion:    version.Version,
+	}
+	if info.Reason != "" {
+		tags[TagLastReason] = info.Reason
+	}
+	if info.Condition != "" {
+		tags[TagLastCondition] = info.Condition
+	}
+	for name, value := range tags {
+		tags[name] = truncateTagValue(value)
+	}
+	return tags
+}
+
+// truncateTagValue cuts a value to maxTagValueLength characters, never within a character
+func truncateTagValue(value string) string {
+	n := 0
+	for i := range value {
+		if n == maxTagValueLength {
+			return value[:i]
+		}
+		n++
+	}
+	return value
+}
+
+// tagsMergeRequest is the body of a Tags API PATCH request
+type tagsMergeRequest struct {
+	Operation  string `json:"operation"`
+	Properties struct {
+		Tags map[string]string `json:"tags"`
+	} `json:"properties"`
+}
+
+// MergeTags merges tags onto the VM through the ARM Tags API, leaving other tags unchanged
+func (c *AzureClient) MergeTags(ctx context.Context, tags map[string]string) error {
+	// Get the access token
+	token, err := c.credential.GetToken(ctx, c.cloud.TokenAudience)
+	if err != nil {
+		return fmt.Errorf("failed to get access token: %w", err)
+	}
+
+	return c.mergeTags(ctx, token, tags)
+}
+
+// mergeTags sends a Tags API PATCH with an existing access token
+func (c *AzureClient) mergeTags(ctx context.Context, token string, tags map[string]string) (err error) {
+	ctx, span := startRequest(ctx, "arm tags", apiARM, tracing.Int("tag.count", len(tags)))
+	defer func() { span.End(err) }()
+
+	var body tagsMergeRequest
+	body.Operation = "Merge"
+	body.Properties.Tags = tags
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("failed to encode tags request: %w", err)
+	}
+
+	// Build the Tags API URL
+	// https://management.azure.com/{vmResourceId}/providers/Microsoft.Resources/tags/default?api-version=2021-04-01
+	url := c.cloud.tagsURL(c.subscriptionId, c.resourceGroup, c.vmName)
+
+	// Create the PATCH request
+	req, err := http.NewRequestWithContext(ctx, "PATCH", url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create tags request: %w", err)
+	}
+
+	// Set headers
+	req.Header.Set("Authorization", fmt.Sprintf("Bearer %s", token))
+	req.Header.Set("Content-Type", "application/json")
+
+	// Execute the request
+	client := httpclient.Default()
+	resp, err := client.Do(req)
+	observe(apiARM, resp, err)
+	traceResponse(span, resp)
+	if err != nil {
+		return fmt.Errorf("failed to send tags request to %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+
+	// Read response body for error details
+	respBody, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusOK {
+		return newARMError(tagsOperation, resp, respBody)
+	}
+
+	return nil
+}
+
+// stampHibernationTags merges hibernation tags onto the VM if tagging is enabled.
+// Errors are reported to the tag error handler and otherwise ignored.
+func (c *AzureClient) stampHibernationTags(ctx context.Context, token, operation string) {
+	if !c.tagging {
+		return
+	}
+
+	tags := hibernationTags(hibernationInfoFrom(ctx), operation, time.Now())
+	if err := c.mergeTags(ctx, token, tags); err != nil && c.onTagError != nil {
+		c.onTagError(err)
+	}
+}
@@ -0,0 +1,61 @@
+package azure
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+// TestHibernationTags tests the tags built for a hibernate request
+func TestHibernationTags(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60))
+
+	tags := hibernationTags(HibernationInfo{Reason: "All users disconnected", Condition: "allDisconnected"}, "deallocate", now)
+	want := map[string]string{
+		TagLastRequestedAt: "2025-06-01T10:30:00Z",
+		TagLastReason:      "All users disconnected",
+		TagLastCondition:   "allDisconnected",
+		TagLastAction:      "deallocate",
+	}
+	for name, value := range want {
+		if tags[name] != value {
+			t.Errorf("%s = %q, want %q", name, tags[name], value)
+		}
+	}
+
+	// Missing info omits the reason and condition tags
+	tags = hibernationTags(HibernationInfo{}, "hibernate", now)
+	if _, ok := tags[TagLastReason]; ok {
+		t.Errorf("unexpected %s tag", TagLastReason)
+	}
+	if _, ok := tags[TagLastCondition]; ok {
+		t.Errorf("unexpected %s tag", TagLastCondition)
+	}
+
+	// Values are truncated to the Azure tag value limit
+	tags = hibernationTags(HibernationInfo{Reason: strings.Repeat("x", 300)}, "hibernate", now)
+	if len(tags[TagLastReason]) != maxTagValueLength {
+		t.Errorf("len(%s) = %d, want %d", TagLastReason, len(tags[TagLastReason]), maxTagValueLength)
+	}
+
+	// Multi-byte characters are counted and never split
+	reason := strings.Repeat("é", 255) + "日本"
+	tags = hibernationTags(HibernationInfo{Reason: reason}, "hibernate", now)
+	if got := tags[TagLastReason]; !utf8.ValidString(got) || utf8.RuneCountInString(got) != maxTagValueLength || got != reason[:len(got)] {
+		t.Errorf("%s = %q, want the first %d characters", TagLastReason, got, maxTagValueLength)
+	}
+}
+
+// TestHibernationInfoContext tests carrying hibernation info on a context
+func TestHibernationInfoContext(t *testing.T) {
+	if info := hibernationInfoFrom(context.Background()); info != (HibernationInfo{}) {
+		t.Errorf("hibernationInfoFrom(empty) = %+v, want zero value", info)
+	}
+
+	info := HibernationInfo{Reason: "reason", Condition: "inactiveUser"}
+	if got := hibernationInfoFrom(WithHibernationInfo(context.Background(), info)); got != info {
+		t.Errorf("hibernationInfoFrom() = %+v, want %+v", got, info)
+	}
+}
@@ -0,0 +1,20 @@
+package azure
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+)
+
+// startRequest starts the span of a request to an Azure API (imds, arm, entra)
+func startRequest(ctx context.Context, name, api string, attributes ...tracing.Attribute) (context.Context, *tracing.Span) {
+	return tracing.Start(ctx, name, append([]tracing.Attribute{tracing.String("azure.api", api)}, attributes...)...)
+}
+
+// traceResponse records the status code of a response on its span (nothing if no response was received)
+func traceResponse(span *tracing.Span, resp *http.Response) {
+	if resp != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", resp.StatusCode))
+	}
+}
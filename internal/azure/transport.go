@@ -0,0 +1,33 @@
+package azure
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+)
+
+// ConfigureHTTP sets up the shared HTTP client used for ARM, Entra ID, IMDS and
+// GitHub requests. The proxy password is read from its DPAPI-protected file or
+// environment variable, so it never appears in config.json.
+func ConfigureHTTP(cfg *config.Config) error {
+	password, err := ReadSecret(cfg.HTTP.ProxyPasswordFile, cfg.HTTP.ProxyPasswordEnv)
+	if err != nil {
+		return fmt.Errorf("failed to read proxy password: %w", err)
+	}
+
+	err = httpclient.Configure(httpclient.Options{
+		ProxyURL:       cfg.HTTP.ProxyURL,
+		ProxyPassword:  password,
+		NoProxy:        cfg.HTTP.NoProxy,
+		IMDSEndpoint:   cfg.IMDSEndpoint,
+		CABundles:      cfg.HTTP.CABundles,
+		ConnectTimeout: time.Duration(cfg.HTTP.ConnectTimeoutSeconds) * time.Second,
+		Timeout:        time.Duration(cfg.HTTP.TimeoutSeconds) * time.Second,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to configure HTTP client: %w", err)
+	}
+	return nil
+}
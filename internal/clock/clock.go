@@ -0,0 +1,58 @@
+// Package clock measures the idle timers against a monotonic tick count, so that a change
+// of the wall clock (a time sync correction after resume, or a manual change) neither fires
+// a threshold early nor stretches it.
+package clock
+
+import (
+	"sync"
+	"time"
+)
+
+// Source reads the wall clock and a tick count that only moves forward at a steady rate,
+// such as the time since boot
+type Source interface {
+	Wall() time.Time
+	Ticks() time.Duration
+}
+
+// Timeline returns timestamps that advance with the tick count from an anchor on the wall
+// clock. The time between two of its timestamps is always the number of ticks between them,
+// whatever the wall clock does in between. When the wall clock moves away from the timeline
+// by more than the threshold, the timeline is anchored to the wall clock again and the jump
+// is reported, so that timers taken from the timeline can be shifted by the same amount.
+type Timeline struct {
+	mu          sync.Mutex
+	source      Source
+	threshold   time.Duration
+	anchor      time.Time     // Wall clock time at anchorTicks
+	anchorTicks time.Duration // Tick count at anchor
+}
+
+// NewTimeline returns a timeline anchored to the current wall clock
+func NewTimeline(source Source, threshold time.Duration) *Timeline {
+	return &Timeline{
+		source:      source,
+		threshold:   threshold,
+		anchor:      source.Wall().Round(0),
+		anchorTicks: source.Ticks(),
+	}
+}
+
+// Now returns the current time on the timeline and the wall clock jump it had to absorb,
+// positive when the wall clock moved forward (0 when there was none)
+func (t *Timeline) Now() (now time.Time, jump time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	ticks := t.source.Ticks()
+	wall := t.source.Wall().Round(0) // Compare wall clock readings, not the process monotonic clock
+	now = t.anchor.Add(ticks - t.anchorTicks)
+
+	drift := wall.Sub(now)
+	if drift.Abs() <= t.threshold {
+		return now, 0
+	}
+	t.anchor = wall
+	t.anchorTicks = ticks
+	return wall, drift
+}
@@ -0,0 +1,73 @@
+package clock
+
+import (
+	"testing"
+	"time"
+)
+
+// fakeSource is a Source whose wall clock and tick count are set by the test
+type fakeSource struct {
+	wall  time.Time
+	ticks time.Duration
+}
+
+func (f *fakeSource) Wall() time.Time      { return f.wall }
+func (f *fakeSource) Ticks() time.Duration { return f.ticks }
+
+// advance moves both clocks forward together, as when nothing changes the wall clock
+func (f *fakeSource) advance(d time.Duration) {
+	f.wall = f.wall.Add(d)
+	f.ticks += d
+}
+
+func TestTimeline(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	source := &fakeSource{wall: start, ticks: 10 * time.Hour}
+	timeline := NewTimeline(source, time.Minute)
+
+	source.advance(5 * time.Minute)
+	if now, jump := timeline.Now(); !now.Equal(start.Add(5*time.Minute)) || jump != 0 {
+		t.Errorf("Now() = %v, %v; want %v, 0", now, jump, start.Add(5*time.Minute))
+	}
+
+	// A small correction is absorbed: durations keep following the ticks
+	source.wall = source.wall.Add(20 * time.Second)
+	source.advance(time.Minute)
+	if now, jump := timeline.Now(); !now.Equal(start.Add(6*time.Minute)) || jump != 0 {
+		t.Errorf("Now() after a small correction = %v, %v; want %v, 0", now, jump, start.Add(6*time.Minute))
+	}
+
+	tests := []struct {
+		name string
+		jump time.Duration
+		want time.Duration // Includes the drift absorbed before
+	}{
+		{name: "forward", jump: 2 * time.Hour, want: 2*time.Hour + 20*time.Second},
+		{name: "backward", jump: -45 * time.Minute, want: -45 * time.Minute},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, _ := timeline.Now()
+			source.wall = source.wall.Add(tt.jump)
+			source.advance(10 * time.Second)
+
+			now, jump := timeline.Now()
+			if !now.Equal(source.wall) {
+				t.Errorf("Now() = %v, want the wall clock %v after a jump", now, source.wall)
+			}
+			// The jump excludes the ticks that passed
+			if jump != tt.want {
+				t.Errorf("jump = %v, want %v", jump, tt.want)
+			}
+			if elapsed := now.Sub(before) - jump; elapsed != 10*time.Second {
+				t.Errorf("elapsed ticks = %v, want 10s", elapsed)
+			}
+
+			// The timeline is anchored again, so the jump is reported once
+			source.advance(time.Minute)
+			if _, again := timeline.Now(); again != 0 {
+				t.Errorf("jump reported again: %v", again)
+			}
+		})
+	}
+}
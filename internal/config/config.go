@@ -3,10 +3,62 @@ package config
 import (
 	"encoding/json"
 	"fmt"
+	"net"
+	"net/mail"
+	"net/url"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
+// Idle actions that can be configured per idle condition
+const (
+	ActionHibernate      = "hibernate"       // Hibernate the VM via Azure (deallocate with hibernate=true)
+	ActionDeallocate     = "deallocate"      // Deallocate the VM via Azure without hibernating
+	ActionOSShutdown     = "os-shutdown"     // Shut down the guest OS (shutdown /s)
+	ActionLocalHibernate = "local-hibernate" // Hibernate the guest OS (shutdown /h)
+	ActionRunScript      = "run-script"      // Run the configured action script
+	ActionNone           = "none"            // Take no action
+)
+
+// validActions lists all supported action names
+var validActions = map[string]bool{
+	ActionHibernate:      true,
+	ActionDeallocate:     true,
+	ActionOSShutdown:     true,
+	ActionLocalHibernate: true,
+	ActionRunScript:      true,
+	ActionNone:           true,
+}
+
+// Credential types used to authenticate to Azure
+const (
+	CredentialManagedIdentity   = "managedIdentity"   // VM managed identity via IMDS
+	CredentialClientSecret      = "clientSecret"      // Service principal with a client secret
+	CredentialClientCertificate = "clientCertificate" // Service principal with a certificate (PFX/PEM)
+	CredentialWorkloadIdentity  = "workloadIdentity"  // Federated token file (workload identity)
+)
+
+// Environment variables that override Azure endpoints (take precedence over config.json)
+const (
+	EnvIMDSEndpoint = "AUTOHIBERNATE_IMDS_ENDPOINT" // Overrides imdsEndpoint
+	EnvARMEndpoint  = "AUTOHIBERNATE_ARM_ENDPOINT"  // Overrides cloud.resourceManagerEndpoint
+)
+
+// Azure cloud names, as reported by IMDS compute metadata (azEnvironment)
+const (
+	CloudAzurePublic       = "AzurePublicCloud"       // Global Azure
+	CloudAzureUSGovernment = "AzureUSGovernmentCloud" // Azure Government
+	CloudAzureChina        = "AzureChinaCloud"        // Azure operated by 21Vianet
+)
+
+// validClouds lists all built-in cloud names
+var validClouds = map[string]bool{
+	CloudAzurePublic:       true,
+	CloudAzureUSGovernment: true,
+	CloudAzureChina:        true,
+}
+
 type Config struct {
 	NoUsersIdleMinutes         int    `json:"noUsersIdleMinutes"`
 	AllDisconnectedIdleMinutes int    `json:"allDisconnectedIdleMinutes"`
@@ -15,11 +67,217 @@ type Config struct {
 	MinimumUptimeMinutes       int    `json:"minimumUptimeMinutes"`
 	LogLevel                   string `json:"logLevel"`
 
+	// Path is the file the configuration was loaded from (set by Load)
+	Path string `json:"-"`
+
+	// Warning period before the allDisconnected action; disconnected users are warned by email (default: 0, no warning)
+	AllDisconnectedWarningMinutes int `json:"allDisconnectedWarningMinutes"`
+
+	// Idle action settings
+	NoUsersAction         string   `json:"noUsersAction"`         // Action when no users are logged in (default: hibernate)
+	AllDisconnectedAction string   `json:"allDisconnectedAction"` // Action when all users are disconnected (default: hibernate)
+	InactiveUserAction    string   `json:"inactiveUserAction"`    // Action when logged-in users are inactive (default: hibernate)
+	FallbackAction        string   `json:"fallbackAction"`        // Action when the primary action fails (default: none)
+	ActionScript          string   `json:"actionScript"`          // Script or executable run by the run-script action
+	ActionScriptArgs      []string `json:"actionScriptArgs"`      // Arguments passed to the action script
+
+	// Retries of a failed idle action before escalating to the fallback action
+	ActionRetry ActionRetryConfig `json:"actionRetry"` // Attempts and backoff (default: 3 attempts, 30s doubling up to 10m)
+
+	// Hook settings
+	PreHibernateHooks []HookConfig `json:"preHibernateHooks"` // Commands run in order before the idle action; a hook can veto it (default: none)
+	PostResumeHooks   []HookConfig `json:"postResumeHooks"`   // Commands run in order in the background after the VM resumes (default: none)
+
+	// Azure identity settings
+	Credential      CredentialConfig      `json:"credential"`      // How to authenticate to Azure (default: managed identity)
+	ManagedIdentity ManagedIdentityConfig `json:"managedIdentity"` // Managed identity used for Azure requests (default: system-assigned)
+	Cloud           CloudConfig           `json:"cloud"`           // Azure cloud endpoints (default: detected from IMDS)
+	IMDSEndpoint    string                `json:"imdsEndpoint"`    // IMDS base URL (default: http://169.254.169.254)
+	StampVMTags     bool                  `json:"stampVMTags"`     // Stamp autohibernate:* tags on the VM before hibernating (default: false)
+
+	// Outbound HTTP settings (ARM, Entra ID, IMDS and GitHub)
+	HTTP HTTPConfig `json:"http"` // Proxy, extra root CAs and timeouts (default: proxy from HTTPS_PROXY / NO_PROXY)
+
+	// Azure Scheduled Events settings
+	ScheduledEvents ScheduledEventsConfig `json:"scheduledEvents"` // Pause idle actions during platform maintenance and Spot eviction (default: enabled)
+
+	// Webhook notification settings
+	Webhooks []WebhookConfig `json:"webhooks"` // Post lifecycle events to Teams, Slack or generic webhooks (default: none)
+
+	// Email notification settings
+	Email EmailConfig `json:"email"` // Email owners of disconnected sessions before the allDisconnected action (default: disabled)
+
+	// Prometheus metrics
+	Metrics MetricsConfig `json:"metrics"` // Serve Prometheus metrics on a loopback address (default: disabled)
+
+	// Tracing settings
+	Tracing TracingConfig `json:"tracing"` // Export OpenTelemetry spans to a local collector (default: disabled)
+
+	// Savings report settings
+	PriceTable string `json:"priceTable"` // Hourly price table used by -savings (default: prices.json next to the executable)
+
+	// Remote control settings
+	DisableRemoteControl bool `json:"disableRemoteControl"` // Ignore autohibernate:mode / pauseUntil from VM tags and user data (default: false)
+
+	// Service pause settings
+	ServicePauseMaxMinutes int `json:"servicePauseMaxMinutes"` // Continue automatically this long after Pause in the Services console or sc pause (default: 0, no limit)
+
+	// Watchdog settings
+	WatchdogTimeoutSeconds int `json:"watchdogTimeoutSeconds"` // Restart the monitor loop when it makes no progress for this long (default: 300)
+
 	// Auto-update settings
 	AutoUpdate            bool `json:"autoUpdate"`            // Enable automatic updates (default: false)
 	UpdateCheckIntervalHr int  `json:"updateCheckIntervalHr"` // Hours between update checks (default: 24)
 }
 
+// ManagedIdentityConfig selects a user-assigned managed identity.
+// Leave all fields empty to use the VM's system-assigned identity.
+type ManagedIdentityConfig struct {
+	ClientID   string `json:"clientId"`   // Client (application) ID of the identity
+	ObjectID   string `json:"objectId"`   // Object (principal) ID of the identity
+	ResourceID string `json:"resourceId"` // ARM resource ID of the identity
+}
+
+// CredentialConfig selects how the service authenticates to Azure
+type CredentialConfig struct {
+	Type            string `json:"type"`            // managedIdentity (default), clientSecret, clientCertificate or workloadIdentity
+	TenantID        string `json:"tenantId"`        // Entra ID tenant of the service principal
+	ClientID        string `json:"clientId"`        // Application (client) ID of the service principal
+	SecretFile      string `json:"secretFile"`      // DPAPI-protected file with the client secret or certificate password
+	SecretEnv       string `json:"secretEnv"`       // Environment variable with the client secret or certificate password
+	CertificateFile string `json:"certificateFile"` // PFX or PEM file with certificate and private key (clientCertificate)
+	TokenFile       string `json:"tokenFile"`       // Federated token file (workloadIdentity)
+}
+
+// CloudConfig overrides the Azure cloud the VM runs in.
+// Leave all fields empty to detect the cloud from IMDS (azEnvironment).
+// Endpoint fields override the named (or detected) cloud, e.g. for Azure Stack Hub.
+type CloudConfig struct {
+	Name                    string `json:"name"`                    // AzurePublicCloud, AzureUSGovernmentCloud or AzureChinaCloud
+	ResourceManagerEndpoint string `json:"resourceManagerEndpoint"` // Azure Resource Manager endpoint
+	TokenAudience           string `json:"tokenAudience"`           // Token resource for ARM (default: resourceManagerEndpoint + "/")
+	AuthorityHost           string `json:"authorityHost"`           // Entra ID authority used by service principal credentials
+}
+
+// ActionRetryConfig bounds the attempts at a failed idle action. Failures that can be
+// retried (throttling, server and network errors) are retried with a doubling delay; after
+// the last attempt, or a failure that cannot be retried, users are told and the fallback
+// action runs.
+type ActionRetryConfig struct {
+	MaxAttempts         int `json:"maxAttempts"`         // Attempts at the idle action, including the first (default: 3)
+	InitialDelaySeconds int `json:"initialDelaySeconds"` // Delay before the first retry, doubled after each failure (default: 30)
+	MaxDelaySeconds     int `json:"maxDelaySeconds"`     // Longest delay between attempts (default: 600)
+}
+
+// ScheduledEventsConfig controls polling of IMDS Scheduled Events.
+// While an event is pending, idle actions are paused and sessions are notified.
+type ScheduledEventsConfig struct {
+	Disabled            bool `json:"disabled"`            // Do not poll Scheduled Events (default: false)
+	PollIntervalSeconds int  `json:"pollIntervalSeconds"` // Seconds between polls (default: 15)
+	Acknowledge         bool `json:"acknowledge"`         // Approve events (StartRequests) once no user is active or NotBefore is near (default: false)
+}
+
+// HTTPConfig configures the HTTP transport shared by all outbound requests.
+// IMDS requests never go through the proxy.
+type HTTPConfig struct {
+	ProxyURL              string   `json:"proxyUrl"`              // Proxy URL, optionally with a user name (default: HTTPS_PROXY / HTTP_PROXY)
+	ProxyPasswordFile     string   `json:"proxyPasswordFile"`     // DPAPI-protected file with the proxy password
+	ProxyPasswordEnv      string   `json:"proxyPasswordEnv"`      // Environment variable with the proxy password
+	NoProxy               []string `json:"noProxy"`               // Hosts, domains or CIDRs that bypass the proxy, in addition to NO_PROXY
+	CABundles             []string `json:"caBundles"`             // PEM files with root CAs trusted in addition to the Windows store
+	ConnectTimeoutSeconds int      `json:"connectTimeoutSeconds"` // TCP connect and TLS handshake timeout (default: 10)
+	TimeoutSeconds        int      `json:"timeoutSeconds"`        // Overall timeout of one request (default: 60)
+}
+
+// Webhook payload formats
+const (
+	WebhookFormatGeneric = "generic" // Event JSON (see README)
+	WebhookFormatTeams   = "teams"   // Adaptive Card for a Teams workflow webhook
+	WebhookFormatSlack   = "slack"   // Slack incoming webhook message
+)
+
+// Webhook event types a sink can subscribe to
+var validWebhookEvents = map[string]bool{
+	"warning":           true,
+	"warningCanceled":   true,
+	"hibernated":        true,
+	"hibernationFailed": true,
+	"resumed":           true,
+}
+
+// WebhookConfig is a webhook receiving lifecycle events
+type WebhookConfig struct {
+	Name       string   `json:"name"`       // Name used in logs (default: webhook host)
+	URL        string   `json:"url"`        // Endpoint receiving a POST per event
+	Format     string   `json:"format"`     // generic (default), teams or slack
+	Events     []string `json:"events"`     // Event types to send (default: all)
+	SecretFile string   `json:"secretFile"` // DPAPI-protected file with the HMAC signing secret
+	SecretEnv  string   `json:"secretEnv"`  // Environment variable with the HMAC signing secret
+	MaxRetries int      `json:"maxRetries"` // Retries after a failed delivery (default: 3)
+}
+
+// Accounts a hook can run as
+const (
+	HookRunAsSystem = "system" // The service account (LocalSystem)
+	HookRunAsUser   = "user"   // Each user with a session on the VM
+)
+
+// HookConfig is a command run around hibernation, e.g. to stop containers or
+// checkpoint a database before the VM hibernates
+type HookConfig struct {
+	Name           string   `json:"name"`           // Name used in logs (default: command file name)
+	Command        string   `json:"command"`        // Executable or script to run
+	Args           []string `json:"args"`           // Arguments passed to the command
+	WorkingDir     string   `json:"workingDir"`     // Working directory (default: service directory, or the user profile for runAs user)
+	TimeoutSeconds int      `json:"timeoutSeconds"` // Seconds before the hook is killed (default: 60)
+	RunAs          string   `json:"runAs"`          // system (default) or user (once per user with a session)
+	VetoExitCode   int      `json:"vetoExitCode"`   // Exit code that vetoes the hibernation (default: 0, no veto; pre-hibernate hooks only)
+}
+
+// Email connection security modes
+const (
+	EmailSecurityStartTLS = "starttls" // Upgrade with STARTTLS (default port 587)
+	EmailSecurityTLS      = "tls"      // Implicit TLS (default port 465)
+	EmailSecurityNone     = "none"     // No encryption (default port 25)
+)
+
+// EmailConfig sends "your VM will hibernate" emails to the owners of disconnected
+// sessions during the allDisconnected warning period. Each email carries a link that
+// keeps the VM awake for a while.
+type EmailConfig struct {
+	SMTPHost         string            `json:"smtpHost"`         // SMTP server; empty disables email
+	SMTPPort         int               `json:"smtpPort"`         // SMTP port (default: 587, 465 for tls, 25 for none)
+	Security         string            `json:"security"`         // starttls (default), tls or none
+	Username         string            `json:"username"`         // User name for SMTP authentication (default: none)
+	PasswordFile     string            `json:"passwordFile"`     // DPAPI-protected file with the SMTP password
+	PasswordEnv      string            `json:"passwordEnv"`      // Environment variable with the SMTP password
+	From             string            `json:"from"`             // Sender address, e.g. "AzureAutoHibernate <noreply@contoso.com>"
+	Recipients       map[string]string `json:"recipients"`       // Addresses by user name or DOMAIN\user
+	AddressPattern   string            `json:"addressPattern"`   // Address for other users, e.g. {user}@contoso.com ({domain} is also replaced)
+	KeepAwakeMinutes int               `json:"keepAwakeMinutes"` // Length of the keep-awake lease granted by the link (default: 60)
+	KeepAwakeAddress string            `json:"keepAwakeAddress"` // Listen address of the keep-awake page (default: 127.0.0.1:8423; :8423 listens on all interfaces)
+	KeepAwakeURL     string            `json:"keepAwakeUrl"`     // Base URL of the keep-awake link (default: http://<listen IP or computer name>:<port>)
+}
+
+// Enabled reports whether email notifications are configured
+func (e *EmailConfig) Enabled() bool {
+	return e.SMTPHost != ""
+}
+
+// MetricsConfig serves idle state and counters for Prometheus at /metrics. The page has
+// no authentication, so it only listens on a loopback address for a local agent to scrape.
+type MetricsConfig struct {
+	Enabled bool   `json:"enabled"` // Serve /metrics (default: false)
+	Address string `json:"address"` // Listen address with a loopback host (default: 127.0.0.1:9464)
+}
+
+// TracingConfig exports spans of idle checks, Azure requests, update checks and notifier
+// commands over OTLP/HTTP, for a local OpenTelemetry Collector or agent to forward
+type TracingConfig struct {
+	Enabled  bool   `json:"enabled"`  // Export spans (default: false)
+	Endpoint string `json:"endpoint"` // OTLP/HTTP base URL; spans are posted to <endpoint>/v1/traces (default: http://127.0.0.1:4318)
+}
+
 // Load reads configuration from the specified path
 func Load(configPath string) (*Config, error) {
 	// If no path specified, look for config.json in the same directory as the executable
@@ -40,6 +298,10 @@ func Load(configPath string) (*Config, error) {
 	if err := json.Unmarshal(data, &cfg); err != nil {
 		return nil, fmt.Errorf("failed to parse config file: %w", err)
 	}
+	cfg.Path = configPath
+
+	// Apply endpoint overrides from the environment
+	cfg.applyEnv()
 
 	// Validate configuration
 	if err := cfg.Validate(); err != nil {
@@ -66,6 +328,9 @@ func (c *Config) Validate() error {
 	if c.MinimumUptimeMinutes < 0 {
 		return fmt.Errorf("minimumUptimeMinutes must be non-negative")
 	}
+	if c.AllDisconnectedWarningMinutes < 0 {
+		return fmt.Errorf("allDisconnectedWarningMinutes must be non-negative")
+	}
 
 	// Ensure at least one idle condition is enabled
 	if c.NoUsersIdleMinutes == 0 && c.AllDisconnectedIdleMinutes == 0 && c.InactiveUserIdleMinutes == 0 {
@@ -87,6 +352,241 @@ func (c *Config) Validate() error {
 		return fmt.Errorf("logLevel must be one of: debug, info, warn, warning, error (got: %s)", c.LogLevel)
 	}
 
+	// Default and validate idle actions
+	if c.NoUsersAction == "" {
+		c.NoUsersAction = ActionHibernate
+	}
+	if c.AllDisconnectedAction == "" {
+		c.AllDisconnectedAction = ActionHibernate
+	}
+	if c.InactiveUserAction == "" {
+		c.InactiveUserAction = ActionHibernate
+	}
+	if c.FallbackAction == "" {
+		c.FallbackAction = ActionNone
+	}
+	actions := []struct {
+		name  string
+		value string
+	}{
+		{"noUsersAction", c.NoUsersAction},
+		{"allDisconnectedAction", c.AllDisconnectedAction},
+		{"inactiveUserAction", c.InactiveUserAction},
+		{"fallbackAction", c.FallbackAction},
+	}
+	for _, a := range actions {
+		if !validActions[a.value] {
+			return fmt.Errorf("%s must be one of: hibernate, deallocate, os-shutdown, local-hibernate, run-script, none (got: %s)", a.name, a.value)
+		}
+		if a.value == ActionRunScript && c.ActionScript == "" {
+			return fmt.Errorf("%s is run-script but actionScript is not set", a.name)
+		}
+	}
+
+	// Validate managed identity selection (at most one identifier may be set)
+	identifiers := 0
+	for _, id := range []string{c.ManagedIdentity.ClientID, c.ManagedIdentity.ObjectID, c.ManagedIdentity.ResourceID} {
+		if id != "" {
+			identifiers++
+		}
+	}
+	if identifiers > 1 {
+		return fmt.Errorf("managedIdentity must set only one of clientId, objectId or resourceId")
+	}
+
+	// Validate credential selection
+	if c.Credential.Type == "" {
+		c.Credential.Type = CredentialManagedIdentity
+	}
+	switch c.Credential.Type {
+	case CredentialManagedIdentity:
+	case CredentialClientSecret, CredentialClientCertificate, CredentialWorkloadIdentity:
+		if c.Credential.TenantID == "" || c.Credential.ClientID == "" {
+			return fmt.Errorf("credential type %s requires tenantId and clientId", c.Credential.Type)
+		}
+		if c.Credential.SecretFile != "" && c.Credential.SecretEnv != "" {
+			return fmt.Errorf("credential must set only one of secretFile or secretEnv")
+		}
+		if c.Credential.Type == CredentialClientSecret && c.Credential.SecretFile == "" && c.Credential.SecretEnv == "" {
+			return fmt.Errorf("credential type clientSecret requires secretFile or secretEnv")
+		}
+		if c.Credential.Type == CredentialClientCertificate && c.Credential.CertificateFile == "" {
+			return fmt.Errorf("credential type clientCertificate requires certificateFile")
+		}
+		if c.Credential.Type == CredentialWorkloadIdentity && c.Credential.TokenFile == "" {
+			return fmt.Errorf("credential type workloadIdentity requires tokenFile")
+		}
+	default:
+		return fmt.Errorf("credential type must be one of: managedIdentity, clientSecret, clientCertificate, workloadIdentity (got: %s)", c.Credential.Type)
+	}
+
+	// Validate cloud selection
+	if c.Cloud.Name != "" && !validClouds[c.Cloud.Name] {
+		return fmt.Errorf("cloud name must be one of: AzurePublicCloud, AzureUSGovernmentCloud, AzureChinaCloud (got: %s)", c.Cloud.Name)
+	}
+	if err := validateURL("imdsEndpoint", c.IMDSEndpoint); err != nil {
+		return err
+	}
+	if err := validateURL("cloud.resourceManagerEndpoint", c.Cloud.ResourceManagerEndpoint); err != nil {
+		return err
+	}
+	if err := validateURL("cloud.tokenAudience", c.Cloud.TokenAudience); err != nil {
+		return err
+	}
+	if err := validateURL("cloud.authorityHost", c.Cloud.AuthorityHost); err != nil {
+		return err
+	}
+
+	// Validate outbound HTTP settings
+	if c.HTTP.ProxyURL != "" {
+		if u, err := url.Parse(c.HTTP.ProxyURL); err != nil || (u.Scheme != "http" && u.Scheme != "https" && u.Scheme != "socks5") || u.Host == "" {
+			return fmt.Errorf("http.proxyUrl must be an absolute http, https or socks5 URL")
+		} else if _, hasPassword := u.User.Password(); hasPassword {
+			return fmt.Errorf("http.proxyUrl must not contain a password; use http.proxyPasswordFile or http.proxyPasswordEnv")
+		}
+	}
+	if c.HTTP.ProxyPasswordFile != "" && c.HTTP.ProxyPasswordEnv != "" {
+		return fmt.Errorf("http must set only one of proxyPasswordFile or proxyPasswordEnv")
+	}
+	if (c.HTTP.ProxyPasswordFile != "" || c.HTTP.ProxyPasswordEnv != "") && c.HTTP.ProxyURL == "" {
+		return fmt.Errorf("http.proxyPasswordFile and http.proxyPasswordEnv require http.proxyUrl")
+	}
+	if c.HTTP.ConnectTimeoutSeconds < 0 {
+		return fmt.Errorf("http.connectTimeoutSeconds must be non-negative")
+	}
+	if c.HTTP.TimeoutSeconds < 0 {
+		return fmt.Errorf("http.timeoutSeconds must be non-negative")
+	}
+	if c.HTTP.ConnectTimeoutSeconds == 0 {
+		c.HTTP.ConnectTimeoutSeconds = 10
+	}
+	if c.HTTP.TimeoutSeconds == 0 {
+		c.HTTP.TimeoutSeconds = 60
+	}
+
+	// Default and validate webhooks
+	for i := range c.Webhooks {
+		w := &c.Webhooks[i]
+		field := fmt.Sprintf("webhooks[%d]", i)
+		if w.URL == "" {
+			return fmt.Errorf("%s.url is required", field)
+		}
+		if err := validateURL(field+".url", w.URL); err != nil {
+			return err
+		}
+		if w.Name == "" {
+			u, _ := url.Parse(w.URL)
+			w.Name = u.Host
+		}
+		if w.Format == "" {
+			w.Format = WebhookFormatGeneric
+		}
+		if w.Format != WebhookFormatGeneric && w.Format != WebhookFormatTeams && w.Format != WebhookFormatSlack {
+			return fmt.Errorf("%s.format must be one of: generic, teams, slack (got: %s)", field, w.Format)
+		}
+		for _, e := range w.Events {
+			if !validWebhookEvents[e] {
+				return fmt.Errorf("%s.events must contain only: warning, warningCanceled, hibernated, hibernationFailed, resumed (got: %s)", field, e)
+			}
+		}
+		if w.SecretFile != "" && w.SecretEnv != "" {
+			return fmt.Errorf("%s must set only one of secretFile or secretEnv", field)
+		}
+		if w.MaxRetries < 0 {
+			return fmt.Errorf("%s.maxRetries must be non-negative", field)
+		}
+		if w.MaxRetries == 0 {
+			w.MaxRetries = 3
+		}
+	}
+
+	// Default and validate hooks
+	if err := validateHooks("preHibernateHooks", c.PreHibernateHooks, true); err != nil {
+		return err
+	}
+	if err := validateHooks("postResumeHooks", c.PostResumeHooks, false); err != nil {
+		return err
+	}
+
+	// Default and validate email notifications
+	if c.Email.Enabled() {
+		if err := c.Email.validate(); err != nil {
+			return err
+		}
+		if c.AllDisconnectedWarningMinutes == 0 {
+			return fmt.Errorf("email requires allDisconnectedWarningMinutes to be greater than 0")
+		}
+	}
+
+	if c.ServicePauseMaxMinutes < 0 {
+		return fmt.Errorf("servicePauseMaxMinutes must be non-negative")
+	}
+
+	// Default and validate the watchdog deadline. A pre-hibernate hook runs on the monitor
+	// loop, so the deadline must leave it time to finish. Each run of a hook, including each
+	// per-user run of a runAs "user" hook, gets its own deadline.
+	if c.WatchdogTimeoutSeconds < 0 {
+		return fmt.Errorf("watchdogTimeoutSeconds must be non-negative")
+	}
+	longestHook := 0
+	for _, h := range c.PreHibernateHooks {
+		longestHook = max(longestHook, h.TimeoutSeconds)
+	}
+	if c.WatchdogTimeoutSeconds == 0 {
+		c.WatchdogTimeoutSeconds = max(300, longestHook+60)
+	}
+	if c.WatchdogTimeoutSeconds < 60 {
+		return fmt.Errorf("watchdogTimeoutSeconds must be at least 60")
+	}
+	if c.WatchdogTimeoutSeconds <= longestHook {
+		return fmt.Errorf("watchdogTimeoutSeconds (%d) must be greater than the longest preHibernateHooks timeoutSeconds (%d)", c.WatchdogTimeoutSeconds, longestHook)
+	}
+
+	// Default and validate the action retries
+	if c.ActionRetry.MaxAttempts < 0 || c.ActionRetry.InitialDelaySeconds < 0 || c.ActionRetry.MaxDelaySeconds < 0 {
+		return fmt.Errorf("actionRetry.maxAttempts, initialDelaySeconds and maxDelaySeconds must be non-negative")
+	}
+	if c.ActionRetry.MaxAttempts == 0 {
+		c.ActionRetry.MaxAttempts = 3
+	}
+	if c.ActionRetry.InitialDelaySeconds == 0 {
+		c.ActionRetry.InitialDelaySeconds = 30
+	}
+	if c.ActionRetry.MaxDelaySeconds == 0 {
+		c.ActionRetry.MaxDelaySeconds = 600
+	}
+	if c.ActionRetry.MaxDelaySeconds < c.ActionRetry.InitialDelaySeconds {
+		return fmt.Errorf("actionRetry.maxDelaySeconds must be at least initialDelaySeconds")
+	}
+
+	// Default and validate the metrics listen address
+	if c.Metrics.Enabled {
+		if c.Metrics.Address == "" {
+			c.Metrics.Address = "127.0.0.1:9464"
+		}
+		if !isLoopbackAddress(c.Metrics.Address) {
+			return fmt.Errorf("metrics.address must be a loopback host:port such as 127.0.0.1:9464 (got: %s)", c.Metrics.Address)
+		}
+	}
+
+	// Default and validate the trace collector endpoint
+	if c.Tracing.Enabled {
+		if c.Tracing.Endpoint == "" {
+			c.Tracing.Endpoint = "http://127.0.0.1:4318"
+		}
+		if err := validateURL("tracing.endpoint", c.Tracing.Endpoint); err != nil {
+			return err
+		}
+	}
+
+	// Default and validate the scheduled events poll interval
+	if c.ScheduledEvents.PollIntervalSeconds < 0 {
+		return fmt.Errorf("scheduledEvents.pollIntervalSeconds must be non-negative")
+	}
+	if c.ScheduledEvents.PollIntervalSeconds == 0 {
+		c.ScheduledEvents.PollIntervalSeconds = 15
+	}
+
 	// Default update check interval to 24 hours if not specified or invalid
 	if c.UpdateCheckIntervalHr <= 0 {
 		c.UpdateCheckIntervalHr = 24
@@ -94,3 +594,136 @@ func (c *Config) Validate() error {
 
 	return nil
 }
+
+// validateHooks fills in hook defaults and checks the settings. canVeto is false for
+// hooks that run when there is nothing left to veto.
+func validateHooks(field string, hooks []HookConfig, canVeto bool) error {
+	for i := range hooks {
+		h := &hooks[i]
+		name := fmt.Sprintf("%s[%d]", field, i)
+		if h.Command == "" {
+			return fmt.Errorf("%s.command is required", name)
+		}
+		if h.Name == "" {
+			h.Name = filepath.Base(h.Command)
+		}
+		if h.TimeoutSeconds < 0 {
+			return fmt.Errorf("%s.timeoutSeconds must be non-negative", name)
+		}
+		if h.TimeoutSeconds == 0 {
+			h.TimeoutSeconds = 60
+		}
+		if h.RunAs == "" {
+			h.RunAs = HookRunAsSystem
+		}
+		if h.RunAs != HookRunAsSystem && h.RunAs != HookRunAsUser {
+			return fmt.Errorf("%s.runAs must be one of: system, user (got: %s)", name, h.RunAs)
+		}
+		if h.VetoExitCode < 0 {
+			return fmt.Errorf("%s.vetoExitCode must be non-negative", name)
+		}
+		if h.VetoExitCode != 0 && !canVeto {
+			return fmt.Errorf("%s.vetoExitCode is not supported: %s cannot veto", name, field)
+		}
+	}
+	return nil
+}
+
+// validate fills in email defaults and checks the settings
+func (e *EmailConfig) validate() error {
+	if e.Security == "" {
+		e.Security = EmailSecurityStartTLS
+	}
+	switch e.Security {
+	case EmailSecurityStartTLS:
+		if e.SMTPPort == 0 {
+			e.SMTPPort = 587
+		}
+	case EmailSecurityTLS:
+		if e.SMTPPort == 0 {
+			e.SMTPPort = 465
+		}
+	case EmailSecurityNone:
+		if e.SMTPPort == 0 {
+			e.SMTPPort = 25
+		}
+	default:
+		return fmt.Errorf("email.security must be one of: starttls, tls, none (got: %s)", e.Security)
+	}
+	if e.SMTPPort < 0 || e.SMTPPort > 65535 {
+		return fmt.Errorf("email.smtpPort must be between 1 and 65535")
+	}
+	if e.PasswordFile != "" && e.PasswordEnv != "" {
+		return fmt.Errorf("email must set only one of passwordFile or passwordEnv")
+	}
+	if (e.PasswordFile != "" || e.PasswordEnv != "") && e.Username == "" {
+		return fmt.Errorf("email.passwordFile and email.passwordEnv require email.username")
+	}
+	if e.From == "" {
+		return fmt.Errorf("email.from is required")
+	}
+	if _, err := mail.ParseAddress(e.From); err != nil {
+		return fmt.Errorf("email.from must be an email address (got: %s)", e.From)
+	}
+	if len(e.Recipients) == 0 && e.AddressPattern == "" {
+		return fmt.Errorf("email requires recipients or addressPattern")
+	}
+	for user, address := range e.Recipients {
+		if _, err := mail.ParseAddress(address); err != nil {
+			return fmt.Errorf("email.recipients[%s] must be an email address (got: %s)", user, address)
+		}
+	}
+	if e.AddressPattern != "" && !strings.Contains(e.AddressPattern, "{user}") {
+		return fmt.Errorf("email.addressPattern must contain {user} (got: %s)", e.AddressPattern)
+	}
+	if e.KeepAwakeMinutes < 0 {
+		return fmt.Errorf("email.keepAwakeMinutes must be non-negative")
+	}
+	if e.KeepAwakeMinutes == 0 {
+		e.KeepAwakeMinutes = 60
+	}
+	if e.KeepAwakeAddress == "" {
+		// Loopback unless configured: the page is reachable only through a reverse proxy
+		// until a private address, or all interfaces, is set explicitly
+		e.KeepAwakeAddress = "127.0.0.1:8423"
+	}
+	if _, port, err := net.SplitHostPort(e.KeepAwakeAddress); err != nil || port == "" {
+		return fmt.Errorf("email.keepAwakeAddress must be host:port or :port (got: %s)", e.KeepAwakeAddress)
+	}
+	return validateURL("email.keepAwakeUrl", e.KeepAwakeURL)
+}
+
+// applyEnv overrides Azure endpoints from environment variables.
+// This lets tests and private deployments redirect IMDS and ARM without editing config.json.
+func (c *Config) applyEnv() {
+	if v := os.Getenv(EnvIMDSEndpoint); v != "" {
+		c.IMDSEndpoint = v
+	}
+	if v := os.Getenv(EnvARMEndpoint); v != "" {
+		c.Cloud.ResourceManagerEndpoint = v
+	}
+}
+
+// isLoopbackAddress reports whether addr is host:port with a loopback host
+func isLoopbackAddress(addr string) bool {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil || port == "" {
+		return false
+	}
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// validateURL checks that an optional setting is an absolute http(s) URL
+func validateURL(field, value string) error {
+	if value == "" {
+		return nil
+	}
+	if u, err := url.Parse(value); err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return fmt.Errorf("%s must be an absolute http(s) URL (got: %s)", field, value)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+//go:build windows
+
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/sys/windows"
+)
+
+// connectTimeout bounds the wait for a free pipe instance
+const connectTimeout = 5 * time.Second
+
+// Send sends a request to the running service and returns its response. An error is
+// returned if the service cannot be reached or rejects the request.
+func Send(req Request) (*Response, error) {
+	if err := req.Validate(); err != nil {
+		return nil, err
+	}
+
+	handle, err := openPipe(PipeName)
+	switch {
+	case errors.Is(err, windows.ERROR_FILE_NOT_FOUND):
+		return nil, fmt.Errorf("the service is not running")
+	case errors.Is(err, windows.ERROR_ACCESS_DENIED):
+		return nil, fmt.Errorf("access denied: run as administrator")
+	case err != nil:
+		return nil, err
+	}
+	conn := os.NewFile(uintptr(handle), PipeName)
+	defer conn.Close()
+
+	if err := json.NewEncoder(conn).Encode(req); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+
+	var resp Response
+	if err := json.NewDecoder(conn).Decode(&resp); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if !resp.OK {
+		return &resp, errors.New(resp.Error)
+	}
+	return &resp, nil
+}
+
+// openPipe connects to the pipe, waiting while all instances are busy
+func openPipe(name string) (windows.Handle, error) {
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("invalid pipe name: %w", err)
+	}
+
+	deadline := time.Now().Add(connectTimeout)
+	for {
+		handle, err := windows.CreateFile(
+			path,
+			windows.GENERIC_READ|windows.GENERIC_WRITE,
+			0,
+			nil,
+			windows.OPEN_EXISTING,
+			windows.FILE_ATTRIBUTE_NORMAL,
+			0,
+		)
+		if err == nil {
+			return handle, nil
+		}
+		if err != windows.ERROR_PIPE_BUSY || time.Now().After(deadline) {
+			return windows.InvalidHandle, err
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
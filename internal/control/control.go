@@ -0,0 +1,109 @@
+// Package control defines the local control API of the running service: a named pipe
+// that only SYSTEM and administrators can open, carrying one JSON request and one JSON
+// response per connection.
+package control
+
+import (
+	"fmt"
+	"time"
+)
+
+// PipeName is the path of the control pipe
+const PipeName = `\\.\pipe\azureautohibernate-control`
+
+// Commands accepted by the service
+const (
+	CommandStatus      = "status"      // Return the current Status
+	CommandCheck       = "check"       // Run an idle check now
+	CommandPause       = "pause"       // Pause idle actions for Seconds
+	CommandResume      = "resume"      // End a pause set with CommandPause
+	CommandHibernate   = "hibernate"   // Hibernate the VM now
+	CommandSetLogLevel = "setLogLevel" // Change the log level until the service restarts
+)
+
+// Commands lists all commands
+var Commands = []string{CommandStatus, CommandCheck, CommandPause, CommandResume, CommandHibernate, CommandSetLogLevel}
+
+// Request is sent by a client
+type Request struct {
+	Command string `json:"command"`
+	Seconds int    `json:"seconds,omitempty"` // Pause duration (pause)
+	Reason  string `json:"reason,omitempty"`  // Why, for the log and users (pause, hibernate)
+	Level   string `json:"level,omitempty"`   // debug, info, warn or error (setLogLevel)
+}
+
+// Validate checks the request before it is sent
+func (r Request) Validate() error {
+	switch r.Command {
+	case CommandStatus, CommandCheck, CommandResume, CommandHibernate:
+		return nil
+	case CommandPause:
+		if r.Seconds <= 0 {
+			return fmt.Errorf("pause requires a duration greater than 0")
+		}
+		return nil
+	case CommandSetLogLevel:
+		switch r.Level {
+		case "debug", "info", "warn", "warning", "error":
+			return nil
+		}
+		return fmt.Errorf("log level must be one of: debug, info, warn, error (got: %s)", r.Level)
+	default:
+		return fmt.Errorf("unknown command %q", r.Command)
+	}
+}
+
+// Response is returned by the service
+type Response struct {
+	OK      bool    `json:"ok"`
+	Error   string  `json:"error,omitempty"`   // Why the command failed
+	Message string  `json:"message,omitempty"` // What the command did
+	Status  *Status `json:"status,omitempty"`  // Current state (status)
+}
+
+// Status is a snapshot of the service state
+type Status struct {
+	Version     string      `json:"version"`
+	ConfigPath  string      `json:"configPath"`            // Configuration file in use
+	Mode        string      `json:"mode"`                  // Remote control mode: enabled, paused or dryrun
+	LogLevel    string      `json:"logLevel"`              // Current log level
+	Inhibitors  []string    `json:"inhibitors"`            // Why idle actions are paused (empty when they are not)
+	PausedUntil *time.Time  `json:"pausedUntil,omitempty"` // End of a pause set through this API
+	Sessions    []Session   `json:"sessions"`              // User sessions at the last check
+	Conditions  []Condition `json:"conditions"`            // Enabled idle conditions
+	Warning     *Warning    `json:"warning,omitempty"`     // Active warning, if any
+	LastCheckAt *time.Time  `json:"lastCheckAt,omitempty"` // When the last idle check ran
+	NextCheckAt *time.Time  `json:"nextCheckAt,omitempty"` // When the next idle check is due
+	ResumedAt   time.Time   `json:"resumedAt"`             // Service start or last resume from hibernation
+
+	// Monitor loop health, tracked by the watchdog
+	HeartbeatAgeSeconds int    `json:"heartbeatAgeSeconds"`    // Time since the monitor loop last made progress
+	Operation           string `json:"operation,omitempty"`    // What the monitor loop is busy with ("" while it waits)
+	LoopRestarts        int    `json:"loopRestarts,omitempty"` // Times the watchdog restarted a stalled monitor loop
+}
+
+// Session is a user session
+type Session struct {
+	ID           uint32 `json:"id"`
+	User         string `json:"user"`
+	Domain       string `json:"domain,omitempty"`
+	Disconnected bool   `json:"disconnected"`
+	IdleSeconds  int    `json:"idleSeconds,omitempty"` // Time since the last input (connected sessions)
+}
+
+// Condition is an idle condition and how long it has been met
+type Condition struct {
+	Name             string     `json:"name"`                // noUsers, allDisconnected or inactiveUser
+	Action           string     `json:"action"`              // Action run when the threshold is reached
+	ThresholdMinutes int        `json:"thresholdMinutes"`    // Idle time before the action
+	WarningMinutes   int        `json:"warningMinutes"`      // Warning period within the threshold (0: none)
+	IdleSince        *time.Time `json:"idleSince,omitempty"` // When the condition started to be met (nil: not met)
+}
+
+// Warning is an active hibernation warning
+type Warning struct {
+	Condition string    `json:"condition"`
+	Reason    string    `json:"reason"`
+	IssuedAt  time.Time `json:"issuedAt"`
+	ActionAt  time.Time `json:"actionAt"` // When the action runs unless the warning is canceled
+}
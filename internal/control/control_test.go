@@ -0,0 +1,114 @@
+package control
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRequestValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		req     Request
+		wantErr bool
+	}{
+		{name: "status", req: Request{Command: CommandStatus}},
+		{name: "check", req: Request{Command: CommandCheck}},
+		{name: "pause", req: Request{Command: CommandPause, Seconds: 7200, Reason: "release build"}},
+		{name: "pause without duration", req: Request{Command: CommandPause}, wantErr: true},
+		{name: "resume", req: Request{Command: CommandResume}},
+		{name: "hibernate", req: Request{Command: CommandHibernate}},
+		{name: "set log level", req: Request{Command: CommandSetLogLevel, Level: "debug"}},
+		{name: "unknown log level", req: Request{Command: CommandSetLogLevel, Level: "verbose"}, wantErr: true},
+		{name: "unknown command", req: Request{Command: "reboot"}, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.req.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+// testStatus returns a status with an inactive user warning at 14:00 local time
+func testStatus() (*Status, time.Time) {
+	now := time.Date(2026, 3, 2, 14, 0, 0, 0, time.Local)
+	at := func(d time.Duration) *time.Time { t := now.Add(d); return &t }
+	return &Status{
+		Version:    "1.4.0",
+		ConfigPath: `C:\Program Files\AzureAutoHibernate\config.json`,
+		Mode:       "enabled",
+		LogLevel:   "info",
+		Inhibitors: []string{},
+		Sessions: []Session{
+			{ID: 2, User: "alice", Domain: "CONTOSO", IdleSeconds: 1920},
+			{ID: 3, User: "bob", Disconnected: true},
+		},
+		Conditions: []Condition{
+			{Name: "noUsers", Action: "hibernate", ThresholdMinutes: 15},
+			{Name: "inactiveUser", Action: "deallocate", ThresholdMinutes: 30, WarningMinutes: 5, IdleSince: at(-32 * time.Minute)},
+		},
+		Warning: &Warning{
+			Condition: "inactiveUser",
+			Reason:    "No activity detected for over 30 minutes",
+			IssuedAt:  *at(-2 * time.Minute),
+			ActionAt:  *at(3 * time.Minute),
+		},
+		LastCheckAt:         at(-5 * time.Second),
+		NextCheckAt:         at(5 * time.Second),
+		ResumedAt:           *at(-2 * time.Hour),
+		HeartbeatAgeSeconds: 5,
+		Operation:           "idle check",
+	}, now
+}
+
+func TestWriteStatus(t *testing.T) {
+	st, now := testStatus()
+	var b strings.Builder
+	if err := WriteStatus(&b, st, now); err != nil {
+		t.Fatalf("WriteStatus() error = %v", err)
+	}
+	out := b.String()
+	for _, want := range []string{
+		"Mode:        enabled",
+		"Paused:      no",
+		"runs at 14:03:00 (in 3m)",
+		"Resumed:     12:00:00 (2h0m ago)",
+		"Heartbeat:   5s ago (idle check)",
+		`CONTOSO\alice  active`,
+		"bob            disconnected  -",
+		"inactiveUser  deallocate  30m + 5m warning  32m",
+		"noUsers       hibernate   15m               not met",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("WriteStatus() output does not contain %q:\n%s", want, out)
+		}
+	}
+}
+
+func TestExplain(t *testing.T) {
+	st, now := testStatus()
+	got := strings.Join(Explain(st, now), "\n")
+	for _, want := range []string{
+		"A warning is active: No activity detected for over 30 minutes.",
+		"noUsers: not met. hibernate runs once no users are logged in for 15m.",
+		"inactiveUser: met for 32m because there is no input in the connected sessions. deallocate runs at",
+		"Next check:",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Explain() does not contain %q:\n%s", want, got)
+		}
+	}
+
+	st.Inhibitors = []string{"Azure scheduled event Reboot is pending"}
+	st.Conditions[1].IdleSince = nil
+	st.Warning = nil
+	got = strings.Join(Explain(st, now), "\n")
+	for _, want := range []string{"Idle actions are paused: Azure scheduled event Reboot is pending.", "Nothing will happen until"} {
+		if !strings.Contains(got, want) {
+			t.Errorf("Explain() does not contain %q:\n%s", want, got)
+		}
+	}
+}
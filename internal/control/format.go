@@ -0,0 +1,152 @@
+package control
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// conditionDescriptions says in words when each idle condition is met
+var conditionDescriptions = map[string]string{
+	"noUsers":         "no users are logged in",
+	"allDisconnected": "all sessions are disconnected",
+	"inactiveUser":    "there is no input in the connected sessions",
+}
+
+// WriteStatus writes a status as human-readable text
+func WriteStatus(w io.Writer, st *Status, now time.Time) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+
+	fmt.Fprintf(tw, "Version:\t%s\n", st.Version)
+	fmt.Fprintf(tw, "Config:\t%s\n", st.ConfigPath)
+	fmt.Fprintf(tw, "Mode:\t%s\n", st.Mode)
+	fmt.Fprintf(tw, "Log level:\t%s\n", st.LogLevel)
+	if len(st.Inhibitors) == 0 {
+		fmt.Fprintf(tw, "Paused:\tno\n")
+	} else {
+		fmt.Fprintf(tw, "Paused:\t%s\n", strings.Join(st.Inhibitors, "; "))
+	}
+	fmt.Fprintf(tw, "Last check:\t%s\n", formatTime(st.LastCheckAt, now))
+	fmt.Fprintf(tw, "Next check:\t%s\n", formatTime(st.NextCheckAt, now))
+	if !st.ResumedAt.IsZero() {
+		fmt.Fprintf(tw, "Resumed:\t%s\n", formatTime(&st.ResumedAt, now))
+	}
+	fmt.Fprintf(tw, "Heartbeat:\t%s\n", formatHeartbeat(st))
+	if st.Warning != nil {
+		fmt.Fprintf(tw, "Warning:\t%s (%s runs at %s)\n", st.Warning.Reason, st.Warning.Condition, formatTime(&st.Warning.ActionAt, now))
+	} else {
+		fmt.Fprintf(tw, "Warning:\tnone\n")
+	}
+
+	fmt.Fprintf(tw, "\nSESSION\tUSER\tSTATE\tIDLE\n")
+	if len(st.Sessions) == 0 {
+		fmt.Fprintf(tw, "-\t-\t-\t-\n")
+	}
+	for _, s := range st.Sessions {
+		user := s.User
+		if s.Domain != "" {
+			user = s.Domain + `\` + s.User
+		}
+		state, idle := "active", formatDuration(time.Duration(s.IdleSeconds)*time.Second)
+		if s.Disconnected {
+			state, idle = "disconnected", "-"
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.ID, user, state, idle)
+	}
+
+	fmt.Fprintf(tw, "\nCONDITION\tACTION\tTHRESHOLD\tIDLE FOR\n")
+	for _, c := range st.Conditions {
+		idleFor := "not met"
+		if c.IdleSince != nil {
+			idleFor = formatDuration(now.Sub(*c.IdleSince))
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", c.Name, c.Action, formatThreshold(c), idleFor)
+	}
+	return tw.Flush()
+}
+
+// Explain says in sentences why the VM will or will not be acted on, and when
+func Explain(st *Status, now time.Time) []string {
+	var lines []string
+	for _, reason := range st.Inhibitors {
+		lines = append(lines, fmt.Sprintf("Idle actions are paused: %s.", reason))
+	}
+	if st.Mode == "dryrun" {
+		lines = append(lines, "Dry run mode: actions are logged but not run.")
+	}
+	if st.Warning != nil {
+		lines = append(lines, fmt.Sprintf("A warning is active: %s. The action runs at %s unless the condition ends first.",
+			st.Warning.Reason, formatTime(&st.Warning.ActionAt, now)))
+	}
+
+	met := false
+	for _, c := range st.Conditions {
+		description := conditionDescriptions[c.Name]
+		if description == "" {
+			description = c.Name
+		}
+		if c.IdleSince == nil {
+			lines = append(lines, fmt.Sprintf("%s: not met. %s runs once %s for %s.", c.Name, c.Action, description, formatThreshold(c)))
+			continue
+		}
+		met = true
+		actionAt := c.IdleSince.Add(time.Duration(c.ThresholdMinutes+c.WarningMinutes) * time.Minute)
+		if actionAt.After(now) {
+			lines = append(lines, fmt.Sprintf("%s: met for %s because %s. %s runs at %s.",
+				c.Name, formatDuration(now.Sub(*c.IdleSince)), description, c.Action, formatTime(&actionAt, now)))
+		} else {
+			lines = append(lines, fmt.Sprintf("%s: met for %s because %s. %s runs at the next check.",
+				c.Name, formatDuration(now.Sub(*c.IdleSince)), description, c.Action))
+		}
+	}
+	if len(st.Conditions) == 0 {
+		lines = append(lines, "No idle condition is enabled.")
+	} else if !met {
+		lines = append(lines, "Nothing will happen until one of the conditions is met.")
+	}
+	lines = append(lines, fmt.Sprintf("Next check: %s.", formatTime(st.NextCheckAt, now)))
+	return lines
+}
+
+// formatThreshold returns the threshold of a condition, with its warning period
+func formatThreshold(c Condition) string {
+	threshold := formatDuration(time.Duration(c.ThresholdMinutes) * time.Minute)
+	if c.WarningMinutes > 0 {
+		return fmt.Sprintf("%s + %s warning", threshold, formatDuration(time.Duration(c.WarningMinutes)*time.Minute))
+	}
+	return threshold
+}
+
+// formatTime returns a clock time with how far it is from now, or "-" if unknown
+func formatTime(t *time.Time, now time.Time) string {
+	if t == nil {
+		return "-"
+	}
+	clock := t.Local().Format("15:04:05")
+	if d := t.Sub(now); d < 0 {
+		return fmt.Sprintf("%s (%s ago)", clock, formatDuration(-d))
+	}
+	return fmt.Sprintf("%s (in %s)", clock, formatDuration(t.Sub(now)))
+}
+
+// formatHeartbeat describes the monitor loop heartbeat, e.g. 5s ago (idle check)
+func formatHeartbeat(st *Status) string {
+	heartbeat := formatDuration(time.Duration(st.HeartbeatAgeSeconds)*time.Second) + " ago"
+	if st.Operation != "" {
+		heartbeat += fmt.Sprintf(" (%s)", st.Operation)
+	}
+	if st.LoopRestarts > 0 {
+		heartbeat += fmt.Sprintf(", monitor loop restarted %d time(s)", st.LoopRestarts)
+	}
+	return heartbeat
+}
+
+// formatDuration returns a duration rounded for display, e.g. 1h5m or 42s
+func formatDuration(d time.Duration) string {
+	if d < time.Minute {
+		return d.Round(time.Second).String()
+	}
+	return strings.TrimSuffix(d.Round(time.Minute).String(), "0s")
+}
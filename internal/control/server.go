@@ -0,0 +1,166 @@
+//go:build windows
+
+package control
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"unsafe"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"golang.org/x/sys/windows"
+)
+
+const (
+	// pipeSDDL grants full access to SYSTEM and the Administrators group only
+	pipeSDDL = "D:P(A;;GA;;;SY)(A;;GA;;;BA)"
+	// maxRequestSize bounds a request read from the pipe
+	maxRequestSize = 64 * 1024
+	// pipeBufferSize is the buffer size requested for each pipe instance
+	pipeBufferSize = 64 * 1024
+)
+
+// Handler executes control requests
+type Handler interface {
+	HandleControl(req Request) Response
+}
+
+// Server serves the control pipe
+type Server struct {
+	name     string
+	handler  Handler
+	logger   logger.Logger
+	sa       *windows.SecurityAttributes
+	stopChan chan struct{}
+	stopOnce sync.Once
+	wg       sync.WaitGroup
+}
+
+// NewServer creates a control pipe server
+func NewServer(handler Handler, log logger.Logger) (*Server, error) {
+	sd, err := windows.SecurityDescriptorFromString(pipeSDDL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pipe security descriptor: %w", err)
+	}
+	sa := &windows.SecurityAttributes{SecurityDescriptor: sd}
+	sa.Length = uint32(unsafe.Sizeof(*sa))
+
+	return &Server{
+		name:     PipeName,
+		handler:  handler,
+		logger:   log,
+		sa:       sa,
+		stopChan: make(chan struct{}),
+	}, nil
+}
+
+// Start creates the pipe and serves connections in the background. It fails if another
+// process already owns the pipe name.
+func (s *Server) Start() error {
+	first, err := s.createInstance(true)
+	if err != nil {
+		return err
+	}
+
+	s.wg.Add(1)
+	go s.acceptLoop(first)
+	return nil
+}
+
+// Stop closes the pipe and waits for requests being handled
+func (s *Server) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopChan)
+		// Unblock the pending ConnectNamedPipe with a connection of our own
+		if h, err := openPipe(s.name); err == nil {
+			windows.CloseHandle(h)
+		}
+	})
+	s.wg.Wait()
+}
+
+// createInstance creates one pipe instance. The first instance must be new, so the
+// service never serves requests on a pipe created by another process.
+func (s *Server) createInstance(first bool) (windows.Handle, error) {
+	path, err := windows.UTF16PtrFromString(s.name)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("invalid pipe name: %w", err)
+	}
+
+	openMode := uint32(windows.PIPE_ACCESS_DUPLEX)
+	if first {
+		openMode |= windows.FILE_FLAG_FIRST_PIPE_INSTANCE
+	}
+	handle, err := windows.CreateNamedPipe(
+		path,
+		openMode,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT|windows.PIPE_REJECT_REMOTE_CLIENTS,
+		windows.PIPE_UNLIMITED_INSTANCES,
+		pipeBufferSize,
+		pipeBufferSize,
+		0, // default timeout
+		s.sa,
+	)
+	if err != nil {
+		return windows.InvalidHandle, fmt.Errorf("failed to create control pipe: %w", err)
+	}
+	return handle, nil
+}
+
+// acceptLoop waits for clients and handles each connection in its own goroutine
+func (s *Server) acceptLoop(handle windows.Handle) {
+	defer s.wg.Done()
+
+	for {
+		err := windows.ConnectNamedPipe(handle, nil)
+		select {
+		case <-s.stopChan:
+			windows.CloseHandle(handle)
+			return
+		default:
+		}
+		if err != nil && err != windows.ERROR_PIPE_CONNECTED {
+			s.logger.Warningf(logger.EventControlError, "Control pipe connection failed: %v", err)
+			windows.CloseHandle(handle)
+		} else {
+			s.wg.Add(1)
+			go s.serve(handle)
+		}
+
+		// Create the next instance before handling more clients
+		handle, err = s.createInstance(false)
+		if err != nil {
+			s.logger.Errorf(logger.EventControlError, "Control pipe stopped: %v", err)
+			return
+		}
+	}
+}
+
+// serve handles one request on a connected pipe instance
+func (s *Server) serve(handle windows.Handle) {
+	defer s.wg.Done()
+	conn := os.NewFile(uintptr(handle), s.name)
+	defer conn.Close()
+
+	var req Request
+	var resp Response
+	if err := json.NewDecoder(io.LimitReader(conn, maxRequestSize)).Decode(&req); err != nil {
+		resp = Response{Error: fmt.Sprintf("invalid request: %v", err)}
+	} else if err := req.Validate(); err != nil {
+		resp = Response{Error: err.Error()}
+	} else {
+		resp = s.handler.HandleControl(req)
+	}
+
+	if err := json.NewEncoder(conn).Encode(resp); err != nil && !errors.Is(err, windows.ERROR_NO_DATA) {
+		s.logger.Debugf(logger.EventControlError, "Failed to write control response: %v", err)
+		return
+	}
+	// Let the client read the response before the instance is closed
+	windows.FlushFileBuffers(handle)
+	windows.DisconnectNamedPipe(handle)
+}
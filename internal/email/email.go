@@ -0,0 +1,215 @@
+// Package email sends plain-text notification emails over SMTP and maps Windows
+// user names to email addresses.
+package email
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Connection security modes
+const (
+	SecurityStartTLS = "starttls" // Plain connection upgraded with STARTTLS (port 587)
+	SecurityTLS      = "tls"      // Implicit TLS (port 465)
+	SecurityNone     = "none"     // No encryption, e.g. a relay on the local network
+)
+
+// defaultTimeout bounds one delivery when the context has no deadline
+const defaultTimeout = 60 * time.Second
+
+// Sender delivers messages through an SMTP server
+type Sender struct {
+	Host      string      // SMTP server host name
+	Port      int         // SMTP server port
+	Security  string      // starttls (default), tls or none
+	Username  string      // User name for SMTP AUTH (empty: no authentication)
+	Password  string      // Password for SMTP AUTH
+	From      string      // Sender address, optionally with a display name
+	TLSConfig *tls.Config // TLS settings (default: verify Host against the system roots)
+}
+
+// Message is a plain-text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Send delivers a message
+func (s *Sender) Send(ctx context.Context, msg Message) error {
+	from, err := mail.ParseAddress(s.From)
+	if err != nil {
+		return fmt.Errorf("invalid sender address %q: %w", s.From, err)
+	}
+	to, err := mail.ParseAddress(msg.To)
+	if err != nil {
+		return fmt.Errorf("invalid recipient address %q: %w", msg.To, err)
+	}
+	data, err := compose(from, to, msg, time.Now())
+	if err != nil {
+		return err
+	}
+
+	if _, ok := ctx.Deadline(); !ok {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, defaultTimeout)
+		defer cancel()
+	}
+
+	client, err := s.dial(ctx)
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	if s.Username != "" {
+		if err := client.Auth(smtp.PlainAuth("", s.Username, s.Password, s.Host)); err != nil {
+			return fmt.Errorf("SMTP authentication failed: %w", err)
+		}
+	}
+	if err := client.Mail(from.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected sender: %w", err)
+	}
+	if err := client.Rcpt(to.Address); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient %s: %w", to.Address, err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("SMTP DATA failed: %w", err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write message: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("SMTP server rejected message: %w", err)
+	}
+	return client.Quit()
+}
+
+// dial connects to the server and negotiates TLS. The connection deadline follows ctx.
+func (s *Sender) dial(ctx context.Context) (*smtp.Client, error) {
+	addr := net.JoinHostPort(s.Host, strconv.Itoa(s.Port))
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to SMTP server %s: %w", addr, err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := s.TLSConfig
+	if tlsConfig == nil {
+		tlsConfig = &tls.Config{ServerName: s.Host}
+	}
+
+	if s.Security == SecurityTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+	client, err := smtp.NewClient(conn, s.Host)
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("SMTP handshake with %s failed: %w", addr, err)
+	}
+
+	if hostname, err := os.Hostname(); err == nil {
+		if err := client.Hello(hostname); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("SMTP EHLO failed: %w", err)
+		}
+	}
+
+	if s.Security == "" || s.Security == SecurityStartTLS {
+		if ok, _ := client.Extension("STARTTLS"); !ok {
+			client.Close()
+			return nil, fmt.Errorf("SMTP server %s does not support STARTTLS", addr)
+		}
+		if err := client.StartTLS(tlsConfig); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("STARTTLS failed: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// compose renders the message headers and quoted-printable body
+func compose(from, to *mail.Address, msg Message, now time.Time) ([]byte, error) {
+	var buf bytes.Buffer
+	header := func(name, value string) {
+		fmt.Fprintf(&buf, "%s: %s\r\n", name, value)
+	}
+	header("From", from.String())
+	header("To", to.String())
+	header("Subject", mime.QEncoding.Encode("utf-8", msg.Subject))
+	header("Date", now.Format(time.RFC1123Z))
+	header("Message-ID", messageID(from.Address, now))
+	header("MIME-Version", "1.0")
+	header("Content-Type", `text/plain; charset="utf-8"`)
+	header("Content-Transfer-Encoding", "quoted-printable")
+	header("Auto-Submitted", "auto-generated")
+	buf.WriteString("\r\n")
+
+	body := strings.ReplaceAll(msg.Body, "\r\n", "\n")
+	qp := quotedprintable.NewWriter(&buf)
+	if _, err := qp.Write([]byte(strings.ReplaceAll(body, "\n", "\r\n"))); err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	if err := qp.Close(); err != nil {
+		return nil, fmt.Errorf("failed to encode message: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+// messageID returns a unique Message-ID in the sender's domain
+func messageID(from string, now time.Time) string {
+	domain := "localhost"
+	if i := strings.LastIndex(from, "@"); i >= 0 {
+		domain = from[i+1:]
+	}
+	return fmt.Sprintf("<%d.%d@%s>", now.UnixNano(), os.Getpid(), domain)
+}
+
+// Directory maps Windows user names to email addresses
+type Directory struct {
+	Recipients map[string]string // Addresses by user name or DOMAIN\user (case-insensitive)
+	Pattern    string            // Address pattern for other users, e.g. {user}@contoso.com ({domain} is also replaced)
+}
+
+// Lookup returns the email address of a user. Explicit recipients take precedence over the pattern.
+func (d Directory) Lookup(domain, user string) (string, bool) {
+	if user == "" {
+		return "", false
+	}
+	var qualified string
+	if domain != "" {
+		qualified = domain + `\` + user
+	}
+	// An exact DOMAIN\user entry wins over a bare user name entry
+	for _, key := range []string{qualified, user} {
+		if key == "" {
+			continue
+		}
+		for name, address := range d.Recipients {
+			if strings.EqualFold(name, key) {
+				return address, true
+			}
+		}
+	}
+
+	if d.Pattern == "" {
+		return "", false
+	}
+	address := strings.ReplaceAll(d.Pattern, "{user}", strings.ToLower(user))
+	address = strings.ReplaceAll(address, "{domain}", strings.ToLower(domain))
+	return address, true
+}
@@ -0,0 +1,370 @@
+package email
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net"
+	"net/http/httptest"
+	"net/mail"
+	"net/textproto"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// smtpServer is a minimal SMTP stand-in that records the messages it receives
+type smtpServer struct {
+	ln          net.Listener
+	tlsConfig   *tls.Config // Enables STARTTLS (or implicit TLS when the listener is TLS)
+	rejectRcpt  bool
+	mu          sync.Mutex
+	messages    []received
+	wg          sync.WaitGroup
+	implicitTLS bool
+}
+
+type received struct {
+	from string
+	to   []string
+	auth string // Decoded AUTH PLAIN credentials
+	tls  bool
+	data string
+}
+
+// newSMTPServer starts the stand-in. With implicitTLS the listener speaks TLS from the start.
+func newSMTPServer(t *testing.T, tlsConfig *tls.Config, implicitTLS bool) *smtpServer {
+	t.Helper()
+	var ln net.Listener
+	var err error
+	if implicitTLS {
+		ln, err = tls.Listen("tcp", "127.0.0.1:0", tlsConfig)
+	} else {
+		ln, err = net.Listen("tcp", "127.0.0.1:0")
+	}
+	if err != nil {
+		t.Fatalf("failed to listen: %v", err)
+	}
+	s := &smtpServer{ln: ln, tlsConfig: tlsConfig, implicitTLS: implicitTLS}
+	go s.serve()
+	t.Cleanup(func() {
+		ln.Close()
+		s.wg.Wait()
+	})
+	return s
+}
+
+func (s *smtpServer) port() int {
+	return s.ln.Addr().(*net.TCPAddr).Port
+}
+
+func (s *smtpServer) received() []received {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]received(nil), s.messages...)
+}
+
+func (s *smtpServer) serve() {
+	for {
+		conn, err := s.ln.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.handle(conn)
+		}()
+	}
+}
+
+func (s *smtpServer) handle(conn net.Conn) {
+	defer func() { conn.Close() }()
+	tp := textproto.NewConn(conn)
+	msg := received{tls: s.implicitTLS}
+
+	tp.PrintfLine("220 fake ESMTP")
+	for {
+		line, err := tp.ReadLine()
+		if err != nil {
+			return
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO", "HELO":
+			lines := []string{"fake"}
+			if s.tlsConfig != nil && !msg.tls {
+				lines = append(lines, "STARTTLS")
+			}
+			lines = append(lines, "AUTH PLAIN", "8BITMIME")
+			for i, l := range lines {
+				sep := "-"
+				if i == len(lines)-1 {
+					sep = " "
+				}
+				tp.PrintfLine("250%s%s", sep, l)
+			}
+		case "STARTTLS":
+			tp.PrintfLine("220 ready")
+			tlsConn := tls.Server(conn, s.tlsConfig)
+			if err := tlsConn.Handshake(); err != nil {
+				return
+			}
+			conn = tlsConn
+			tp = textproto.NewConn(conn)
+			msg.tls = true
+		case "AUTH":
+			_, encoded, _ := strings.Cut(arg, " ")
+			decoded, _ := base64.StdEncoding.DecodeString(encoded)
+			msg.auth = string(decoded)
+			tp.PrintfLine("235 authenticated")
+		case "MAIL":
+			from, _, _ := strings.Cut(strings.TrimPrefix(arg, "FROM:"), " ")
+			msg.from = strings.Trim(from, "<>")
+			tp.PrintfLine("250 ok")
+		case "RCPT":
+			if s.rejectRcpt {
+				tp.PrintfLine("550 no such user")
+				continue
+			}
+			msg.to = append(msg.to, strings.Trim(strings.TrimPrefix(arg, "TO:"), "<>"))
+			tp.PrintfLine("250 ok")
+		case "DATA":
+			tp.PrintfLine("354 go ahead")
+			data, err := tp.ReadDotBytes()
+			if err != nil {
+				return
+			}
+			msg.data = string(data)
+			s.mu.Lock()
+			s.messages = append(s.messages, msg)
+			s.mu.Unlock()
+			tp.PrintfLine("250 queued")
+		case "QUIT":
+			tp.PrintfLine("221 bye")
+			return
+		default:
+			tp.PrintfLine("502 not implemented")
+		}
+	}
+}
+
+// testTLS returns a server certificate for 127.0.0.1 and a client config that trusts it
+func testTLS(t *testing.T) (*tls.Config, *tls.Config) {
+	t.Helper()
+	ts := httptest.NewTLSServer(nil)
+	t.Cleanup(ts.Close)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ts.Certificate())
+	server := &tls.Config{Certificates: ts.TLS.Certificates}
+	client := &tls.Config{RootCAs: roots, ServerName: "127.0.0.1"}
+	return server, client
+}
+
+// parse decodes a received message into its headers and body
+func parse(t *testing.T, data string) (*mail.Message, string) {
+	t.Helper()
+	m, err := mail.ReadMessage(bufio.NewReader(strings.NewReader(data)))
+	if err != nil {
+		t.Fatalf("failed to parse message: %v", err)
+	}
+	body, err := io.ReadAll(quotedprintable.NewReader(m.Body))
+	if err != nil {
+		t.Fatalf("failed to decode body: %v", err)
+	}
+	return m, string(body)
+}
+
+func TestSend(t *testing.T) {
+	server := newSMTPServer(t, nil, false)
+	sender := &Sender{
+		Host:     "127.0.0.1",
+		Port:     server.port(),
+		Security: SecurityNone,
+		Username: "relay",
+		Password: "p@ss",
+		From:     "AzureAutoHibernate <noreply@contoso.com>",
+	}
+
+	long := strings.Repeat("x", 120)
+	err := sender.Send(context.Background(), Message{
+		To:      "alice@contoso.com",
+		Subject: "VM-01 will hibernate in 15 minutes – save your work",
+		Body:    "Hi alice,\n\nKeep it awake: http://vm-01:8423/keep-awake?token=abc\n" + long + "\n",
+	})
+	if err != nil {
+		t.Fatalf("Send() error = %v", err)
+	}
+
+	msgs := server.received()
+	if len(msgs) != 1 {
+		t.Fatalf("received %d messages, want 1", len(msgs))
+	}
+	got := msgs[0]
+	if got.from != "noreply@contoso.com" {
+		t.Errorf("MAIL FROM = %q, want noreply@contoso.com", got.from)
+	}
+	if len(got.to) != 1 || got.to[0] != "alice@contoso.com" {
+		t.Errorf("RCPT TO = %v, want [alice@contoso.com]", got.to)
+	}
+	if got.auth != "\x00relay\x00p@ss" {
+		t.Errorf("AUTH PLAIN = %q, want relay credentials", got.auth)
+	}
+
+	m, body := parse(t, got.data)
+	subject, err := new(mime.WordDecoder).DecodeHeader(m.Header.Get("Subject"))
+	if err != nil || subject != "VM-01 will hibernate in 15 minutes – save your work" {
+		t.Errorf("Subject = %q (%v)", subject, err)
+	}
+	if to := m.Header.Get("To"); to != "<alice@contoso.com>" {
+		t.Errorf("To = %q", to)
+	}
+	if from := m.Header.Get("From"); from != `"AzureAutoHibernate" <noreply@contoso.com>` {
+		t.Errorf("From = %q", from)
+	}
+	for _, h := range []string{"Date", "Message-ID"} {
+		if m.Header.Get(h) == "" {
+			t.Errorf("missing %s header", h)
+		}
+	}
+	// ReadDotBytes has already turned CRLF line endings into LF
+	want := "Hi alice,\n\nKeep it awake: http://vm-01:8423/keep-awake?token=abc\n" + long + "\n"
+	if body != want {
+		t.Errorf("body = %q, want %q", body, want)
+	}
+}
+
+func TestSendTLS(t *testing.T) {
+	serverTLS, clientTLS := testTLS(t)
+
+	tests := []struct {
+		name        string
+		security    string
+		implicitTLS bool
+	}{
+		{"starttls", SecurityStartTLS, false},
+		{"default is starttls", "", false},
+		{"implicit tls", SecurityTLS, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newSMTPServer(t, serverTLS, tt.implicitTLS)
+			sender := &Sender{
+				Host:      "127.0.0.1",
+				Port:      server.port(),
+				Security:  tt.security,
+				From:      "noreply@contoso.com",
+				TLSConfig: clientTLS,
+			}
+			if err := sender.Send(context.Background(), Message{To: "bob@contoso.com", Subject: "s", Body: "b"}); err != nil {
+				t.Fatalf("Send() error = %v", err)
+			}
+			msgs := server.received()
+			if len(msgs) != 1 || !msgs[0].tls {
+				t.Fatalf("received %+v, want one message over TLS", msgs)
+			}
+		})
+	}
+}
+
+func TestSendErrors(t *testing.T) {
+	plain := newSMTPServer(t, nil, false)
+	rejecting := newSMTPServer(t, nil, false)
+	rejecting.rejectRcpt = true
+
+	closed, _ := net.Listen("tcp", "127.0.0.1:0")
+	closedPort := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+
+	tests := []struct {
+		name    string
+		sender  Sender
+		to      string
+		wantErr string
+	}{
+		{
+			name:    "starttls not offered",
+			sender:  Sender{Host: "127.0.0.1", Port: plain.port(), From: "noreply@contoso.com"},
+			to:      "bob@contoso.com",
+			wantErr: "does not support STARTTLS",
+		},
+		{
+			name:    "recipient rejected",
+			sender:  Sender{Host: "127.0.0.1", Port: rejecting.port(), Security: SecurityNone, From: "noreply@contoso.com"},
+			to:      "nobody@contoso.com",
+			wantErr: "rejected recipient",
+		},
+		{
+			name:    "connection refused",
+			sender:  Sender{Host: "127.0.0.1", Port: closedPort, Security: SecurityNone, From: "noreply@contoso.com"},
+			to:      "bob@contoso.com",
+			wantErr: "failed to connect",
+		},
+		{
+			name:    "invalid sender",
+			sender:  Sender{Host: "127.0.0.1", Port: plain.port(), From: "not an address"},
+			to:      "bob@contoso.com",
+			wantErr: "invalid sender address",
+		},
+		{
+			name:    "invalid recipient",
+			sender:  Sender{Host: "127.0.0.1", Port: plain.port(), From: "noreply@contoso.com"},
+			to:      "bob",
+			wantErr: "invalid recipient address",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.sender.Send(context.Background(), Message{To: tt.to, Subject: "s", Body: "b"})
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("Send() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+	if n := len(rejecting.received()); n != 0 {
+		t.Errorf("rejecting server received %d messages, want 0", n)
+	}
+}
+
+func TestDirectoryLookup(t *testing.T) {
+	dir := Directory{
+		Recipients: map[string]string{
+			"alice":        "alice.smith@contoso.com",
+			`FABRIKAM\bob`: "bob@fabrikam.com",
+		},
+		Pattern: "{user}@{domain}.example.com",
+	}
+
+	tests := []struct {
+		name   string
+		dir    Directory
+		domain string
+		user   string
+		want   string
+		found  bool
+	}{
+		{"explicit user", dir, "CONTOSO", "Alice", "alice.smith@contoso.com", true},
+		{"explicit domain user", dir, "fabrikam", "BOB", "bob@fabrikam.com", true},
+		{"other domain falls back to pattern", dir, "CONTOSO", "bob", "bob@contoso.example.com", true},
+		{"pattern", dir, "CONTOSO", "Carol", "carol@contoso.example.com", true},
+		{"no pattern", Directory{Recipients: dir.Recipients}, "CONTOSO", "carol", "", false},
+		{"empty user", dir, "CONTOSO", "", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, found := tt.dir.Lookup(tt.domain, tt.user)
+			if got != tt.want || found != tt.found {
+				t.Errorf("Lookup(%q, %q) = %q, %v; want %q, %v", tt.domain, tt.user, got, found, tt.want, tt.found)
+			}
+		})
+	}
+}
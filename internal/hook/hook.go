@@ -0,0 +1,143 @@
+// Package hook runs the user-supplied commands configured around hibernation
+// (pre-hibernate and post-resume hooks), with a timeout and captured output.
+package hook
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Accounts a hook can run as
+const (
+	RunAsSystem = "system" // The service account (LocalSystem)
+	RunAsUser   = "user"   // Each user with a session on the VM, with their environment and profile
+)
+
+const (
+	// DefaultTimeout bounds a hook when none is configured
+	DefaultTimeout = 60 * time.Second
+	// maxOutput caps the stdout and stderr kept for the log, per stream
+	maxOutput = 8 * 1024
+	// waitDelay bounds the wait for output pipes after the hook exits or is killed,
+	// in case it left a child process holding them open
+	waitDelay = 5 * time.Second
+)
+
+// Hook is a command run before hibernation or after resume
+type Hook struct {
+	Name         string        // Used in logs
+	Command      string        // Executable or script
+	Args         []string      // Arguments passed to Command
+	WorkingDir   string        // Working directory (default: the service's, or the user profile for RunAsUser)
+	Timeout      time.Duration // Time before the hook is killed (default: 60s)
+	RunAs        string        // system (default) or user
+	VetoExitCode int           // Exit code that vetoes the hibernation (0: the hook cannot veto)
+}
+
+// Result is the outcome of running a hook
+type Result struct {
+	ExitCode int           // Exit code, or -1 if the hook did not start or was killed
+	Stdout   string        // Captured standard output (truncated)
+	Stderr   string        // Captured standard error (truncated)
+	Duration time.Duration // Time the hook ran
+	TimedOut bool          // The hook was killed at its timeout
+	Err      error         // Why the hook failed: not started, timed out or non-zero exit
+}
+
+// Vetoes reports whether the result vetoes the hibernation
+func (h Hook) Vetoes(r Result) bool {
+	return h.VetoExitCode != 0 && r.ExitCode == h.VetoExitCode
+}
+
+// Run runs a hook to completion or until its timeout. prepare, if not nil, adjusts the
+// command before it starts, e.g. to run it as another user.
+func Run(ctx context.Context, h Hook, prepare func(*exec.Cmd) error) Result {
+	timeout := h.Timeout
+	if timeout <= 0 {
+		timeout = DefaultTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var stdout, stderr limitedBuffer
+	cmd := exec.CommandContext(ctx, h.Command, h.Args...)
+	cmd.Dir = h.WorkingDir
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	cmd.WaitDelay = waitDelay
+
+	if prepare != nil {
+		if err := prepare(cmd); err != nil {
+			return Result{ExitCode: -1, Err: err}
+		}
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	result := Result{
+		ExitCode: -1,
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(start),
+	}
+	if cmd.ProcessState != nil {
+		result.ExitCode = cmd.ProcessState.ExitCode()
+	}
+
+	switch {
+	case errors.Is(ctx.Err(), context.DeadlineExceeded):
+		// A killed hook reports an arbitrary exit code, which must not count as a veto
+		result.ExitCode = -1
+		result.TimedOut = true
+		result.Err = fmt.Errorf("timed out after %v", timeout)
+	case err != nil:
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			result.Err = fmt.Errorf("exited with code %d", result.ExitCode)
+		} else {
+			result.Err = err
+		}
+	}
+	return result
+}
+
+// Output formats the captured output for the log, or returns "" if there is none
+func (r Result) Output() string {
+	var b strings.Builder
+	if s := strings.TrimSpace(r.Stdout); s != "" {
+		b.WriteString("\nstdout:\n" + s)
+	}
+	if s := strings.TrimSpace(r.Stderr); s != "" {
+		b.WriteString("\nstderr:\n" + s)
+	}
+	return b.String()
+}
+
+// limitedBuffer keeps the first maxOutput bytes written to it and discards the rest
+type limitedBuffer struct {
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if room := maxOutput - b.buf.Len(); room < len(p) {
+		b.truncated = true
+		if room > 0 {
+			b.buf.Write(p[:room])
+		}
+		return len(p), nil
+	}
+	return b.buf.Write(p)
+}
+
+func (b *limitedBuffer) String() string {
+	if b.truncated {
+		return b.buf.String() + "\n[output truncated]"
+	}
+	return b.buf.String()
+}
@@ -0,0 +1,144 @@
+package hook
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMain lets the test binary act as a hook: with HOOK_HELPER set it runs the
+// helper named by its arguments instead of the tests
+func TestMain(m *testing.M) {
+	if os.Getenv("HOOK_HELPER") == "1" {
+		helper(os.Args[len(os.Args)-1])
+		return
+	}
+	os.Exit(m.Run())
+}
+
+func helper(mode string) {
+	switch mode {
+	case "ok":
+		fmt.Println("containers stopped")
+		fmt.Fprintln(os.Stderr, "1 warning")
+	case "veto":
+		fmt.Println("build in progress")
+		os.Exit(75)
+	case "fail":
+		os.Exit(2)
+	case "sleep":
+		time.Sleep(time.Minute)
+	case "pwd":
+		wd, _ := os.Getwd()
+		fmt.Print(wd)
+	case "flood":
+		fmt.Print(strings.Repeat("x", 3*maxOutput))
+	}
+	os.Exit(0)
+}
+
+// helperHook returns a hook that runs the test binary as the given helper
+func helperHook(mode string) Hook {
+	return Hook{Name: mode, Command: os.Args[0], Args: []string{"-test.run=^$", mode}, VetoExitCode: 75}
+}
+
+// withHelperEnv marks the command as a helper run
+func withHelperEnv(cmd *exec.Cmd) error {
+	cmd.Env = append(os.Environ(), "HOOK_HELPER=1")
+	return nil
+}
+
+func TestRun(t *testing.T) {
+	tests := []struct {
+		name         string
+		hook         Hook
+		wantExitCode int
+		wantErr      bool
+		wantVeto     bool
+		wantTimeout  bool
+		wantStdout   string
+		wantStderr   string
+	}{
+		{name: "success", hook: helperHook("ok"), wantExitCode: 0, wantStdout: "containers stopped", wantStderr: "1 warning"},
+		{name: "veto", hook: helperHook("veto"), wantExitCode: 75, wantErr: true, wantVeto: true, wantStdout: "build in progress"},
+		{name: "failure", hook: helperHook("fail"), wantExitCode: 2, wantErr: true},
+		{name: "failure without veto code", hook: func() Hook { h := helperHook("veto"); h.VetoExitCode = 0; return h }(), wantExitCode: 75, wantErr: true, wantStdout: "build in progress"},
+		{name: "timeout", hook: func() Hook { h := helperHook("sleep"); h.Timeout = 200 * time.Millisecond; return h }(), wantExitCode: -1, wantErr: true, wantTimeout: true},
+		{name: "missing command", hook: Hook{Command: filepath.Join(t.TempDir(), "missing.exe")}, wantExitCode: -1, wantErr: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := Run(context.Background(), tt.hook, withHelperEnv)
+			if r.ExitCode != tt.wantExitCode {
+				t.Errorf("ExitCode = %d, want %d", r.ExitCode, tt.wantExitCode)
+			}
+			if (r.Err != nil) != tt.wantErr {
+				t.Errorf("Err = %v, wantErr %v", r.Err, tt.wantErr)
+			}
+			if got := tt.hook.Vetoes(r); got != tt.wantVeto {
+				t.Errorf("Vetoes() = %v, want %v", got, tt.wantVeto)
+			}
+			if r.TimedOut != tt.wantTimeout {
+				t.Errorf("TimedOut = %v, want %v", r.TimedOut, tt.wantTimeout)
+			}
+			if strings.TrimSpace(r.Stdout) != tt.wantStdout {
+				t.Errorf("Stdout = %q, want %q", r.Stdout, tt.wantStdout)
+			}
+			if strings.TrimSpace(r.Stderr) != tt.wantStderr {
+				t.Errorf("Stderr = %q, want %q", r.Stderr, tt.wantStderr)
+			}
+		})
+	}
+}
+
+func TestRunWorkingDir(t *testing.T) {
+	dir := t.TempDir()
+	h := helperHook("pwd")
+	h.WorkingDir = dir
+
+	r := Run(context.Background(), h, withHelperEnv)
+	if r.Err != nil {
+		t.Fatalf("Run() error = %v", r.Err)
+	}
+	got, _ := filepath.EvalSymlinks(r.Stdout)
+	want, _ := filepath.EvalSymlinks(dir)
+	if got != want {
+		t.Errorf("working directory = %q, want %q", r.Stdout, dir)
+	}
+}
+
+func TestRunPrepareError(t *testing.T) {
+	r := Run(context.Background(), helperHook("ok"), func(*exec.Cmd) error {
+		return errors.New("no session token")
+	})
+	if r.Err == nil || r.ExitCode != -1 {
+		t.Errorf("Run() = %+v, want the prepare error", r)
+	}
+}
+
+func TestRunOutputTruncated(t *testing.T) {
+	r := Run(context.Background(), helperHook("flood"), withHelperEnv)
+	if r.Err != nil {
+		t.Fatalf("Run() error = %v", r.Err)
+	}
+	if !strings.HasSuffix(r.Stdout, "[output truncated]") || len(r.Stdout) > maxOutput+100 {
+		t.Errorf("Stdout has %d bytes, want %d and a truncation note", len(r.Stdout), maxOutput)
+	}
+}
+
+func TestOutput(t *testing.T) {
+	r := Result{Stdout: "done\n", Stderr: "  "}
+	if got := r.Output(); got != "\nstdout:\ndone" {
+		t.Errorf("Output() = %q", got)
+	}
+	if got := (Result{}).Output(); got != "" {
+		t.Errorf("Output() without output = %q, want empty", got)
+	}
+}
@@ -0,0 +1,314 @@
+// Package httpclient provides the shared HTTP client used for all outbound requests
+// (Azure Resource Manager, Entra ID, IMDS and GitHub), with proxy, custom CA and
+// timeout settings.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// Default timeouts
+const (
+	DefaultConnectTimeout = 10 * time.Second // TCP connect and TLS handshake
+	DefaultTimeout        = 60 * time.Second // Whole request, including reading the response body
+)
+
+// userAgentProduct is the product token of the User-Agent header
+const userAgentProduct = "AzureAutoHibernate"
+
+// imdsNetwork is the link-local range of the Azure Instance Metadata Service (169.254.169.254).
+// IMDS rejects proxied requests, so it is never sent through a proxy.
+var imdsNetwork = &net.IPNet{IP: net.IPv4(169, 254, 0, 0), Mask: net.CIDRMask(16, 32)}
+
+// Options configures the shared HTTP client
+type Options struct {
+	ProxyURL       string        // Proxy for all requests; empty uses HTTPS_PROXY / HTTP_PROXY
+	ProxyPassword  string        // Password for the user name in ProxyURL
+	NoProxy        []string      // Hosts, domains or CIDRs that bypass the proxy, in addition to NO_PROXY
+	IMDSEndpoint   string        // IMDS base URL; its host bypasses the proxy like 169.254.169.254
+	CABundles      []string      // PEM files with root CAs trusted in addition to the system store
+	ConnectTimeout time.Duration // TCP connect and TLS handshake timeout (default: 10s)
+	Timeout        time.Duration // Overall request timeout (default: 60s)
+}
+
+var (
+	mu      sync.RWMutex
+	current *http.Client
+)
+
+// Default returns the shared HTTP client. Until Configure is called it uses the
+// proxy from the environment and the default timeouts.
+func Default() *http.Client {
+	mu.RLock()
+	c := current
+	mu.RUnlock()
+	if c != nil {
+		return c
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if current == nil {
+		// Options without files or a proxy URL cannot fail
+		current, _ = New(Options{})
+	}
+	return current
+}
+
+// Download returns a client for large downloads such as release assets. It shares the
+// transport of the shared client (proxy, CAs and connect timeout) but has no overall
+// timeout, so the caller's context bounds the transfer.
+func Download() *http.Client {
+	c := *Default()
+	c.Timeout = 0
+	return &c
+}
+
+// Configure replaces the shared HTTP client
+func Configure(opts Options) error {
+	c, err := New(opts)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	current = c
+	mu.Unlock()
+	return nil
+}
+
+// UserAgent returns the User-Agent sent with every request
+func UserAgent() string {
+	return userAgentProduct + "/" + version.Version
+}
+
+// New creates an HTTP client from the given options
+func New(opts Options) (*http.Client, error) {
+	if opts.ConnectTimeout <= 0 {
+		opts.ConnectTimeout = DefaultConnectTimeout
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	proxy, err := newProxySelector(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = proxy.proxyFor
+	transport.DialContext = (&net.Dialer{
+		Timeout:   opts.ConnectTimeout,
+		KeepAlive: 30 * time.Second,
+	}).DialContext
+	transport.TLSHandshakeTimeout = opts.ConnectTimeout
+
+	if len(opts.CABundles) > 0 {
+		roots, err := loadRoots(opts.CABundles)
+		if err != nil {
+			return nil, err
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots}
+	}
+
+	return &http.Client{
+		Transport: &userAgentTransport{base: transport, userAgent: UserAgent()},
+		Timeout:   opts.Timeout,
+	}, nil
+}
+
+// loadRoots returns the system root CAs plus the certificates in the given PEM files
+func loadRoots(bundles []string) (*x509.CertPool, error) {
+	roots, err := x509.SystemCertPool()
+	if err != nil || roots == nil {
+		roots = x509.NewCertPool()
+	}
+	for _, path := range bundles {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read CA bundle: %w", err)
+		}
+		if !roots.AppendCertsFromPEM(data) {
+			return nil, fmt.Errorf("no PEM certificates found in CA bundle %s", path)
+		}
+	}
+	return roots, nil
+}
+
+// userAgentTransport adds the service User-Agent to each request. Clients that set their
+// own User-Agent (e.g. the GitHub API client) keep it after the service name.
+type userAgentTransport struct {
+	base      http.RoundTripper
+	userAgent string
+}
+
+func (t *userAgentTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ua := t.userAgent
+	if existing := req.Header.Get("User-Agent"); existing != "" {
+		if strings.HasPrefix(existing, t.userAgent) {
+			return t.base.RoundTrip(req)
+		}
+		ua += " " + existing
+	}
+
+	// RoundTrippers must not modify the caller's request
+	req = req.Clone(req.Context())
+	req.Header.Set("User-Agent", ua)
+	return t.base.RoundTrip(req)
+}
+
+// proxySelector chooses the proxy for each request
+type proxySelector struct {
+	httpProxy  *url.URL // Proxy for http:// requests (nil for direct)
+	httpsProxy *url.URL // Proxy for https:// requests (nil for direct)
+	noProxy    []string // NO_PROXY entries
+	directHost string   // Host of the configured IMDS endpoint
+}
+
+// newProxySelector builds the proxy settings from the options and environment
+func newProxySelector(opts Options) (*proxySelector, error) {
+	p := &proxySelector{
+		noProxy: append(splitList(getEnv("NO_PROXY", "no_proxy")), opts.NoProxy...),
+	}
+
+	if opts.IMDSEndpoint != "" {
+		if u, err := url.Parse(opts.IMDSEndpoint); err == nil {
+			p.directHost = strings.ToLower(u.Hostname())
+		}
+	}
+
+	if opts.ProxyURL != "" {
+		proxy, err := parseProxy(opts.ProxyURL)
+		if err != nil {
+			return nil, err
+		}
+		if opts.ProxyPassword != "" {
+			if proxy.User == nil || proxy.User.Username() == "" {
+				return nil, fmt.Errorf("proxy password is set but the proxy URL has no user name")
+			}
+			proxy.User = url.UserPassword(proxy.User.Username(), opts.ProxyPassword)
+		}
+		p.httpProxy, p.httpsProxy = proxy, proxy
+		return p, nil
+	}
+
+	var err error
+	if v := getEnv("HTTP_PROXY", "http_proxy"); v != "" {
+		if p.httpProxy, err = parseProxy(v); err != nil {
+			return nil, err
+		}
+	}
+	if v := getEnv("HTTPS_PROXY", "https_proxy"); v != "" {
+		if p.httpsProxy, err = parseProxy(v); err != nil {
+			return nil, err
+		}
+	} else {
+		p.httpsProxy = p.httpProxy
+	}
+	return p, nil
+}
+
+// proxyFor returns the proxy for a request, or nil to connect directly
+func (p *proxySelector) proxyFor(req *http.Request) (*url.URL, error) {
+	proxy := p.httpProxy
+	if req.URL.Scheme == "https" {
+		proxy = p.httpsProxy
+	}
+	if proxy == nil || !p.useProxy(req.URL.Hostname()) {
+		return nil, nil
+	}
+	return proxy, nil
+}
+
+// useProxy reports whether requests to host go through the proxy
+func (p *proxySelector) useProxy(host string) bool {
+	host = strings.ToLower(host)
+	if host == "" || host == "localhost" || host == p.directHost {
+		return false
+	}
+	if ip := net.ParseIP(host); ip != nil && (ip.IsLoopback() || imdsNetwork.Contains(ip)) {
+		return false
+	}
+	for _, entry := range p.noProxy {
+		if matchNoProxy(entry, host) {
+			return false
+		}
+	}
+	return true
+}
+
+// matchNoProxy reports whether host matches a NO_PROXY entry: "*", an IP address,
+// a CIDR, or a domain that also matches its subdomains (with or without a leading dot)
+func matchNoProxy(entry, host string) bool {
+	entry = strings.ToLower(strings.TrimSpace(entry))
+	if entry == "" {
+		return false
+	}
+	if entry == "*" {
+		return true
+	}
+	if _, network, err := net.ParseCIDR(entry); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && network.Contains(ip)
+	}
+	// Ports are ignored: the entry applies to every port of the host
+	if h, _, err := net.SplitHostPort(entry); err == nil {
+		entry = h
+	}
+	entry = strings.TrimPrefix(strings.TrimPrefix(entry, "*"), ".")
+	return host == entry || strings.HasSuffix(host, "."+entry)
+}
+
+// parseProxy parses a proxy URL, defaulting to http:// when no scheme is given
+func parseProxy(value string) (*url.URL, error) {
+	if !strings.Contains(value, "://") {
+		value = "http://" + value
+	}
+	u, err := url.Parse(value)
+	if err != nil || u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy URL: %s", redact(value))
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5":
+	default:
+		return nil, fmt.Errorf("proxy URL scheme must be http, https or socks5 (got: %s)", u.Scheme)
+	}
+	return u, nil
+}
+
+// redact hides the password in a proxy URL for error messages
+func redact(value string) string {
+	if u, err := url.Parse(value); err == nil {
+		return u.Redacted()
+	}
+	return "(unparsable)"
+}
+
+// getEnv returns the first non-empty environment variable
+func getEnv(names ...string) string {
+	for _, name := range names {
+		if v := os.Getenv(name); v != "" {
+			return v
+		}
+	}
+	return ""
+}
+
+// splitList splits a comma-separated list
+func splitList(value string) []string {
+	if value == "" {
+		return nil
+	}
+	return strings.Split(value, ",")
+}
@@ -0,0 +1,381 @@
+package httpclient
+
+import (
+	"encoding/base64"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// clearProxyEnv removes proxy variables inherited from the test environment
+func clearProxyEnv(t *testing.T) {
+	t.Helper()
+	for _, name := range []string{"HTTP_PROXY", "http_proxy", "HTTPS_PROXY", "https_proxy", "NO_PROXY", "no_proxy"} {
+		t.Setenv(name, "")
+	}
+}
+
+// TestProxySelection tests which requests go through the proxy
+func TestProxySelection(t *testing.T) {
+	tests := []struct {
+		name      string
+		env       map[string]string
+		opts      Options
+		url       string
+		wantProxy string // Empty for a direct connection
+	}{
+		{
+			name: "no proxy configured",
+			url:  "https://management.azure.com/subscriptions",
+		},
+		{
+			name:      "configured proxy",
+			opts:      Options{ProxyURL: "http://proxy.corp:8080"},
+			url:       "https://management.azure.com/subscriptions",
+			wantProxy: "http://proxy.corp:8080",
+		},
+		{
+			name:      "configured proxy without scheme",
+			opts:      Options{ProxyURL: "proxy.corp:8080"},
+			url:       "https://api.github.com/repos",
+			wantProxy: "http://proxy.corp:8080",
+		},
+		{
+			name:      "configured proxy wins over environment",
+			env:       map[string]string{"HTTPS_PROXY": "http://env-proxy:3128"},
+			opts:      Options{ProxyURL: "http://proxy.corp:8080"},
+			url:       "https://login.microsoftonline.com/tenant",
+			wantProxy: "http://proxy.corp:8080",
+		},
+		{
+			name:      "HTTPS_PROXY from environment",
+			env:       map[string]string{"HTTPS_PROXY": "http://env-proxy:3128"},
+			url:       "https://management.azure.com/subscriptions",
+			wantProxy: "http://env-proxy:3128",
+		},
+		{
+			name:      "lowercase https_proxy from environment",
+			env:       map[string]string{"https_proxy": "http://env-proxy:3128"},
+			url:       "https://management.azure.com/subscriptions",
+			wantProxy: "http://env-proxy:3128",
+		},
+		{
+			name: "HTTPS_PROXY does not apply to http requests",
+			env:  map[string]string{"HTTPS_PROXY": "http://env-proxy:3128"},
+			url:  "http://example.com/",
+		},
+		{
+			name:      "HTTP_PROXY applies to https requests without HTTPS_PROXY",
+			env:       map[string]string{"HTTP_PROXY": "http://env-proxy:3128"},
+			url:       "https://management.azure.com/subscriptions",
+			wantProxy: "http://env-proxy:3128",
+		},
+		{
+			name: "IMDS is never proxied",
+			env:  map[string]string{"HTTP_PROXY": "http://env-proxy:3128"},
+			opts: Options{ProxyURL: "http://proxy.corp:8080"},
+			url:  "http://169.254.169.254/metadata/instance",
+		},
+		{
+			name: "configured IMDS endpoint is never proxied",
+			opts: Options{ProxyURL: "http://proxy.corp:8080", IMDSEndpoint: "http://imds.test:8080"},
+			url:  "http://imds.test:8080/metadata/instance",
+		},
+		{
+			name: "loopback is never proxied",
+			opts: Options{ProxyURL: "http://proxy.corp:8080"},
+			url:  "http://127.0.0.1:8080/",
+		},
+		{
+			name: "NO_PROXY domain matches subdomains",
+			env:  map[string]string{"NO_PROXY": "corp.example, .internal"},
+			opts: Options{ProxyURL: "http://proxy.corp:8080"},
+			url:  "https://arm.corp.example/subscriptions",
+		},
+		{
+			name: "NO_PROXY leading dot matches subdomains",
+			env:  map[string]string{"no_proxy": ".internal"},
+			opts: Options{ProxyURL: "http://proxy.corp:8080"},
+			url:  "https://arm.internal/subscriptions",
+		},
+		{
+			name:      "NO_PROXY domain does not match other domains",
+			env:       map[string]string{"NO_PROXY": "corp.example"},
+			opts:      Options{ProxyURL: "http://proxy.corp:8080"},
+			url:       "https://notcorp.example/",
+			wantProxy: "http://proxy.corp:8080",
+		},
+		{
+			name: "configured noProxy CIDR",
+			opts: Options{ProxyURL: "http://proxy.corp:8080", NoProxy: []string{"10.0.0.0/8"}},
+			url:  "https://10.1.2.3/",
+		},
+		{
+			name: "noProxy entry with port",
+			opts: Options{ProxyURL: "http://proxy.corp:8080", NoProxy: []string{"azs.local:443"}},
+			url:  "https://management.azs.local/",
+		},
+		{
+			name: "NO_PROXY wildcard",
+			env:  map[string]string{"NO_PROXY": "*"},
+			opts: Options{ProxyURL: "http://proxy.corp:8080"},
+			url:  "https://management.azure.com/",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clearProxyEnv(t)
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			p, err := newProxySelector(tt.opts)
+			if err != nil {
+				t.Fatalf("newProxySelector() error = %v", err)
+			}
+
+			req := httptest.NewRequest("GET", tt.url, nil)
+			proxy, err := p.proxyFor(req)
+			if err != nil {
+				t.Fatalf("proxyFor() error = %v", err)
+			}
+
+			got := ""
+			if proxy != nil {
+				got = proxy.String()
+			}
+			if got != tt.wantProxy {
+				t.Errorf("proxy = %q, want %q", got, tt.wantProxy)
+			}
+		})
+	}
+}
+
+// TestProxyOptionErrors tests invalid proxy settings
+func TestProxyOptionErrors(t *testing.T) {
+	clearProxyEnv(t)
+
+	tests := []struct {
+		name string
+		opts Options
+	}{
+		{name: "unsupported scheme", opts: Options{ProxyURL: "ftp://proxy.corp"}},
+		{name: "password without user name", opts: Options{ProxyURL: "http://proxy.corp:8080", ProxyPassword: "secret"}},
+		{name: "missing CA bundle", opts: Options{CABundles: []string{filepath.Join(t.TempDir(), "missing.pem")}}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := New(tt.opts); err == nil {
+				t.Error("Expected error but got none")
+			}
+		})
+	}
+}
+
+// TestProxyAuthentication tests that requests carry the proxy credentials
+func TestProxyAuthentication(t *testing.T) {
+	clearProxyEnv(t)
+
+	var gotAuth, gotHost string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A forward proxy receives the absolute target URL
+		gotAuth = r.Header.Get("Proxy-Authorization")
+		gotHost = r.URL.Host
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer proxy.Close()
+
+	proxyURL := strings.Replace(proxy.URL, "http://", "http://svc-hibernate@", 1)
+	client, err := New(Options{ProxyURL: proxyURL, ProxyPassword: "s3cret"})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	resp, err := client.Get("http://management.example/subscriptions")
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	resp.Body.Close()
+
+	wantAuth := "Basic " + base64.StdEncoding.EncodeToString([]byte("svc-hibernate:s3cret"))
+	if gotAuth != wantAuth {
+		t.Errorf("Proxy-Authorization = %q, want %q", gotAuth, wantAuth)
+	}
+	if gotHost != "management.example" {
+		t.Errorf("proxied host = %q, want management.example", gotHost)
+	}
+}
+
+// TestCABundles tests that extra root CAs are trusted
+func TestCABundles(t *testing.T) {
+	clearProxyEnv(t)
+
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	// Without the bundle the test server's self-signed certificate is rejected
+	client, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	if resp, err := client.Get(server.URL); err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected certificate error without CA bundle")
+	}
+
+	bundle := filepath.Join(t.TempDir(), "corp-root.pem")
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err := os.WriteFile(bundle, certPEM, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err = New(Options{CABundles: []string{bundle}})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("Get() with CA bundle error = %v", err)
+	}
+	resp.Body.Close()
+
+	// A file without certificates is a configuration error
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, []byte("not a certificate"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(Options{CABundles: []string{empty}}); err == nil {
+		t.Error("Expected error for CA bundle without certificates")
+	}
+}
+
+// TestUserAgent tests that requests carry the service version
+func TestUserAgent(t *testing.T) {
+	clearProxyEnv(t)
+
+	var got []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = append(got, r.Header.Get("User-Agent"))
+	}))
+	defer server.Close()
+
+	client, err := New(Options{})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+
+	// Clients with their own User-Agent keep it after the service name
+	req, _ = http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("User-Agent", "go-github/v86")
+	resp, err = client.Do(req)
+	if err != nil {
+		t.Fatalf("Do() error = %v", err)
+	}
+	resp.Body.Close()
+	if req.Header.Get("User-Agent") != "go-github/v86" {
+		t.Error("caller's request was modified")
+	}
+
+	want := []string{
+		"AzureAutoHibernate/" + version.Version,
+		"AzureAutoHibernate/" + version.Version + " go-github/v86",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d requests, want %d", len(got), len(want))
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("request %d User-Agent = %q, want %q", i, got[i], want[i])
+		}
+	}
+}
+
+// TestTimeout tests that the overall timeout bounds slow responses
+func TestTimeout(t *testing.T) {
+	clearProxyEnv(t)
+
+	release := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+	defer close(release)
+
+	client, err := New(Options{Timeout: 100 * time.Millisecond})
+	if err != nil {
+		t.Fatalf("New() error = %v", err)
+	}
+
+	start := time.Now()
+	resp, err := client.Get(server.URL)
+	if err == nil {
+		resp.Body.Close()
+		t.Fatal("Expected timeout error")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("request took %v, want about 100ms", elapsed)
+	}
+}
+
+// TestDownload tests that the download client has no overall timeout but keeps the transport
+func TestDownload(t *testing.T) {
+	clearProxyEnv(t)
+
+	if err := Configure(Options{Timeout: 5 * time.Second}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	c := Download()
+	if c.Timeout != 0 {
+		t.Errorf("Download().Timeout = %v, want 0", c.Timeout)
+	}
+	if c.Transport != Default().Transport {
+		t.Error("Download() does not share the transport of the shared client")
+	}
+	if Default().Timeout != 5*time.Second {
+		t.Errorf("Default().Timeout = %v after Download(), want 5s", Default().Timeout)
+	}
+}
+
+// TestConfigure tests replacing the shared client
+func TestConfigure(t *testing.T) {
+	clearProxyEnv(t)
+
+	if Default() == nil {
+		t.Fatal("Default() returned nil")
+	}
+	if err := Configure(Options{Timeout: 5 * time.Second}); err != nil {
+		t.Fatalf("Configure() error = %v", err)
+	}
+	if got := Default().Timeout; got != 5*time.Second {
+		t.Errorf("Default().Timeout = %v, want 5s", got)
+	}
+
+	// A failed configuration keeps the previous client
+	if err := Configure(Options{ProxyURL: "ftp://proxy.corp"}); err == nil {
+		t.Fatal("Expected error for invalid proxy")
+	}
+	if got := Default().Timeout; got != 5*time.Second {
+		t.Errorf("Default().Timeout after failed Configure = %v, want 5s", got)
+	}
+}
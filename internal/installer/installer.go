@@ -4,6 +4,7 @@ package installer
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -12,6 +13,7 @@ import (
 
 	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
 	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
 	"golang.org/x/sys/windows"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/eventlog"
@@ -63,12 +65,12 @@ func registerEventLogSource(serviceName string) error {
 
 // testAzureCapabilities tests the VM's Azure hibernation capabilities and displays results.
 // Returns the test result or an error if critical requirements are not met.
-func testAzureCapabilities(ctx context.Context) (*azure.HibernationCapabilityResult, error) {
+func testAzureCapabilities(ctx context.Context, cfg *config.Config) (*azure.HibernationCapabilityResult, error) {
 	fmt.Println("")
 	fmt.Println("=== Testing Azure Hibernation Capability ===")
 	fmt.Println("Checking if this VM can be hibernated via Azure...")
 
-	result := azure.TestHibernationCapability(ctx)
+	result := azure.TestHibernationCapability(ctx, cfg)
 
 	// Display and validate IMDS availability
 	if !result.IMDSAvailable {
@@ -88,21 +90,44 @@ func testAzureCapabilities(ctx context.Context) (*azure.HibernationCapabilityRes
 	fmt.Printf("  Resource Group: %s\n", result.VMMetadata.ResourceGroup)
 	fmt.Printf("  Subscription: %s\n", result.VMMetadata.SubscriptionId)
 
-	// Display and validate managed identity
+	// Display and validate the cloud and credential configuration
+	if result.ConfigError != nil {
+		fmt.Println("")
+		fmt.Println("[FAILED] Azure Credential Configuration")
+		fmt.Printf("  Error: %v\n", result.ConfigError)
+		fmt.Println("  Check the 'cloud' and 'credential' settings in config.json.")
+		return nil, fmt.Errorf("failed to configure Azure credential: %w", result.ConfigError)
+	}
+
+	fmt.Printf("  Cloud: %s\n", result.Cloud)
+
+	// Display and validate the Azure credential
 	if !result.TokenSuccess {
 		fmt.Println("")
-		fmt.Println("[FAILED] Managed Identity Check")
+		fmt.Println("[FAILED] Azure Credential Check")
+		fmt.Printf("  Credential: %s\n", result.Credential)
 		fmt.Printf("  Error: %v\n", result.TokenError)
-		fmt.Println("  The VM's Managed Identity is not properly configured.")
-		fmt.Println("  Required actions:")
-		fmt.Println("  1. Enable System-Assigned Managed Identity on this VM")
+		if mi, ok := result.Credential.(*azure.ManagedIdentityCredential); ok {
+			fmt.Println("  The VM's Managed Identity is not properly configured.")
+			fmt.Println("  Required actions:")
+			if mi.Identity.IsSystemAssigned() {
+				fmt.Println("  1. Enable System-Assigned Managed Identity on this VM")
+			} else {
+				fmt.Println("  1. Assign the configured User-Assigned Managed Identity to this VM")
+			}
+		} else {
+			fmt.Println("  The service principal could not authenticate to Microsoft Entra ID.")
+			fmt.Println("  Required actions:")
+			fmt.Println("  1. Verify tenantId, clientId and the secret, certificate or token file")
+		}
 		fmt.Println("  2. Grant the identity 'Virtual Machine Contributor' role")
 		fmt.Println("  3. Ensure the role is scoped to this VM or resource group")
-		return nil, fmt.Errorf("managed identity not configured: %w", result.TokenError)
+		return nil, fmt.Errorf("azure credential not configured: %w", result.TokenError)
 	}
 
-	fmt.Println("[PASSED] Managed Identity Check")
-	fmt.Println("  Successfully retrieved access token from IMDS")
+	fmt.Println("[PASSED] Azure Credential Check")
+	fmt.Printf("  Credential: %s\n", result.Credential)
+	fmt.Println("  Successfully retrieved access token")
 
 	// Display and validate hibernation API access
 	if result.HibernationAPIError != nil {
@@ -110,10 +135,16 @@ func testAzureCapabilities(ctx context.Context) (*azure.HibernationCapabilityRes
 		fmt.Println("[FAILED] VM Hibernation API Check")
 		fmt.Printf("  Error: %v\n", result.HibernationAPIError)
 		fmt.Println("  Could not verify VM hibernation capability via Azure API.")
-		fmt.Println("  Possible causes:")
-		fmt.Println("  - Managed Identity lacks 'Virtual Machine Contributor' or 'Reader' role")
-		fmt.Println("  - Role assignment not yet propagated (can take a few minutes)")
-		fmt.Println("  - Network connectivity issues to Azure Management API")
+		var armErr *azure.ARMError
+		if errors.As(result.HibernationAPIError, &armErr) && armErr.Remediation() != "" {
+			fmt.Printf("  Cause: %s\n", armErr.Kind)
+			fmt.Printf("  Required action: %s\n", armErr.Remediation())
+		} else {
+			fmt.Println("  Possible causes:")
+			fmt.Println("  - Managed Identity lacks 'Virtual Machine Contributor' or 'Reader' role")
+			fmt.Println("  - Role assignment not yet propagated (can take a few minutes)")
+			fmt.Println("  - Network connectivity issues to Azure Management API")
+		}
 		return nil, fmt.Errorf("hibernation API check failed: %w", result.HibernationAPIError)
 	}
 
@@ -145,7 +176,7 @@ func testAzureCapabilities(ctx context.Context) (*azure.HibernationCapabilityRes
 
 // Install orchestrates the service installation process.
 // It tests Azure capabilities, registers the event log source, and creates the Windows service.
-func Install() error {
+func Install(cfg *config.Config) error {
 	// Check if running as administrator
 	admin, err := isAdmin()
 	if err != nil {
@@ -189,7 +220,7 @@ func Install() error {
 	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
 	defer cancel()
 
-	if _, err := testAzureCapabilities(ctx); err != nil {
+	if _, err := testAzureCapabilities(ctx, cfg); err != nil {
 		return err
 	}
 
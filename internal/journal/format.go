@@ -0,0 +1,94 @@
+package journal
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// ParseSince parses the start of a history range: a duration back from now such as 7d,
+// 12h or 90m, a date (2006-01-02, local time) or an RFC3339 time
+func ParseSince(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	if days, ok := strings.CutSuffix(value, "d"); ok {
+		if n, err := strconv.Atoi(days); err == nil && n >= 0 {
+			return now.AddDate(0, 0, -n), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil && d >= 0 {
+		return now.Add(-d), nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("since must be a duration such as 7d or 12h, a date (2006-01-02) or an RFC3339 time (got: %s)", value)
+}
+
+// WriteText writes records as a table, one line per record
+func WriteText(w io.Writer, records []Record) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "TIME\tEVENT\tDETAILS\n")
+	for _, r := range records {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", r.Time.Local().Format("2006-01-02 15:04:05"), r.Type, r.Summary())
+	}
+	return tw.Flush()
+}
+
+// Summary describes a record in one line
+func (r Record) Summary() string {
+	var parts []string
+	add := func(format string, args ...any) {
+		parts = append(parts, fmt.Sprintf(format, args...))
+	}
+
+	switch r.Type {
+	case TypeWarning:
+		add("%s", r.Reason)
+		if r.ActionAt != nil {
+			add("%s at %s", r.Action, r.ActionAt.Local().Format("15:04:05"))
+		}
+	case TypeWarningCanceled:
+		add("%s", r.Reason)
+		if r.User != "" {
+			add("by session %d (%s)", r.SessionID, r.User)
+		}
+	case TypeHibernationRequested, TypeHibernated:
+		add("%s", r.Action)
+		if r.Reason != "" {
+			add("%s", r.Reason)
+		}
+	case TypeHibernationFailed:
+		add("%s failed: %s", r.Action, r.Error)
+	case TypeResumed:
+		add("%s", r.Reason)
+	case TypePaused:
+		if r.Until != nil {
+			add("until %s", r.Until.Local().Format("2006-01-02 15:04"))
+		}
+		add("by %s", r.Source)
+		if r.Reason != "" {
+			add("%s", r.Reason)
+		}
+	case TypeLeaseGranted:
+		add("%s kept the VM awake", r.User)
+		if r.Until != nil {
+			add("until %s", r.Until.Local().Format("15:04"))
+		}
+	case TypeUpdateApplied:
+		add("%s -> %s", r.FromVersion, r.Version)
+	default:
+		if r.Reason != "" {
+			add("%s", r.Reason)
+		}
+	}
+	if r.Condition != "" && r.Type != TypeUpdateApplied {
+		parts = append([]string{r.Condition + ":"}, parts...)
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,209 @@
+// Package journal keeps an append-only history of significant events (warnings,
+// hibernation requests and their results, resumes, pauses and updates) in a JSON Lines
+// file that is rotated by size, so support can answer "why did my VM hibernate?".
+package journal
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// FileName is the name of the journal file
+	FileName = "history.jsonl"
+	// dirName is the folder under ProgramData holding the journal
+	dirName = "AzureAutoHibernate"
+	// DefaultMaxSize is the size at which the journal is rotated
+	DefaultMaxSize = 5 * 1024 * 1024
+	// DefaultKeep is the number of rotated files kept (history.jsonl.1 is the newest)
+	DefaultKeep = 3
+)
+
+// Record types
+const (
+	TypeWarning              = "warning"              // A hibernation warning started
+	TypeWarningCanceled      = "warningCanceled"      // A warning ended without an action
+	TypeHibernationRequested = "hibernationRequested" // An action is about to run
+	TypeHibernated           = "hibernated"           // The action completed
+	TypeHibernationFailed    = "hibernationFailed"    // The action and any fallback failed
+	TypeResumed              = "resumed"              // The VM resumed or booted after a request
+	TypePaused               = "paused"               // Idle actions were paused (control API or remote control)
+	TypeLeaseGranted         = "leaseGranted"         // A user kept the VM awake with a keep-awake link
+	TypeUpdateApplied        = "updateApplied"        // The service started with a new version
+)
+
+// Sources of a pause
+const (
+	SourceControl = "control" // The control API (pause command)
+	SourceRemote  = "remote"  // Remote control tags or user data
+	SourceService = "service" // Pause in the Services console or sc pause
+)
+
+// Record is a journal entry. Only the fields that apply to the type are set.
+type Record struct {
+	Time        time.Time  `json:"time"`
+	Type        string     `json:"type"`
+	Version     string     `json:"version,omitempty"`     // Version of the service that wrote the record
+	Condition   string     `json:"condition,omitempty"`   // Idle condition (noUsers, allDisconnected, inactiveUser or manual)
+	Action      string     `json:"action,omitempty"`      // Action requested or completed
+	Reason      string     `json:"reason,omitempty"`      // Why it happened, or the resume outcome
+	SessionID   uint32     `json:"sessionId,omitempty"`   // Session whose activity canceled a warning
+	User        string     `json:"user,omitempty"`        // User of that session, or who was granted a lease
+	Until       *time.Time `json:"until,omitempty"`       // End of a pause or lease
+	ActionAt    *time.Time `json:"actionAt,omitempty"`    // When a warned action is due
+	Error       string     `json:"error,omitempty"`       // Why an action failed
+	Source      string     `json:"source,omitempty"`      // What paused idle actions: control, remote or service
+	FromVersion string     `json:"fromVersion,omitempty"` // Version before an update
+}
+
+// Journal is an append-only JSON Lines file rotated by size
+type Journal struct {
+	path    string
+	maxSize int64
+	keep    int
+	mu      sync.Mutex
+}
+
+// New returns a journal stored at path, rotated at maxSize bytes keeping keep old files
+func New(path string, maxSize int64, keep int) *Journal {
+	return &Journal{path: path, maxSize: maxSize, keep: keep}
+}
+
+// DefaultPath returns the journal path under ProgramData
+func DefaultPath() string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, dirName, FileName)
+}
+
+// Path returns the journal file path
+func (j *Journal) Path() string {
+	return j.path
+}
+
+// Append adds a record, rotating the journal first if it would grow past its maximum size
+func (j *Journal) Append(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode history record: %w", err)
+	}
+	data = append(data, '\n')
+
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(j.path), 0755); err != nil {
+		return fmt.Errorf("failed to create history folder: %w", err)
+	}
+	if info, err := os.Stat(j.path); err == nil && info.Size() > 0 && info.Size()+int64(len(data)) > j.maxSize {
+		if err := j.rotate(); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		return fmt.Errorf("failed to write history: %w", err)
+	}
+	return nil
+}
+
+// rotate shifts history.jsonl.N to .N+1, dropping the oldest, and moves the journal to .1
+func (j *Journal) rotate() error {
+	if j.keep <= 0 {
+		if err := os.Remove(j.path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate history: %w", err)
+		}
+		return nil
+	}
+	os.Remove(j.rotated(j.keep))
+	for n := j.keep - 1; n >= 1; n-- {
+		if err := os.Rename(j.rotated(n), j.rotated(n+1)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to rotate history: %w", err)
+		}
+	}
+	if err := os.Rename(j.path, j.rotated(1)); err != nil {
+		return fmt.Errorf("failed to rotate history: %w", err)
+	}
+	return nil
+}
+
+// rotated returns the path of the nth rotated file
+func (j *Journal) rotated(n int) string {
+	return fmt.Sprintf("%s.%d", j.path, n)
+}
+
+// Records returns the records at or after since, oldest first, from the rotated files and
+// the journal. Missing files have no records; malformed lines (e.g. a partial write) are skipped.
+func (j *Journal) Records(since time.Time) ([]Record, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var records []Record
+	for n := j.keep; n >= 0; n-- {
+		path := j.path
+		if n > 0 {
+			path = j.rotated(n)
+		}
+		var err error
+		if records, err = readFile(path, since, records); err != nil {
+			return nil, err
+		}
+	}
+	return records, nil
+}
+
+// Last returns the newest record, or nil if the journal is empty
+func (j *Journal) Last() (*Record, error) {
+	records, err := j.Records(time.Time{})
+	if err != nil || len(records) == 0 {
+		return nil, err
+	}
+	return &records[len(records)-1], nil
+}
+
+// readFile appends the records of one file at or after since
+func readFile(path string, since time.Time, records []Record) ([]Record, error) {
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return records, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open history: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Time.IsZero() || r.Type == "" {
+			continue
+		}
+		if r.Time.Before(since) {
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read history: %w", err)
+	}
+	return records, nil
+}
@@ -0,0 +1,170 @@
+package journal
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAppendAndRecords(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", FileName)
+	j := New(path, DefaultMaxSize, DefaultKeep)
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+
+	records := []Record{
+		{Time: start, Type: TypeWarning, Condition: "inactiveUser", Action: "hibernate", Reason: "No activity detected for over 30 minutes"},
+		{Time: start.Add(2 * time.Minute), Type: TypeWarningCanceled, Condition: "inactiveUser", Reason: "user activity detected", SessionID: 2, User: `CONTOSO\alice`},
+		{Time: start.Add(time.Hour), Type: TypeHibernationRequested, Condition: "noUsers", Action: "hibernate"},
+	}
+	for _, r := range records {
+		if err := j.Append(r); err != nil {
+			t.Fatalf("Append() error = %v", err)
+		}
+	}
+
+	// A partial write is skipped
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"time":"2026-03-02T16:00:00Z","type":"hiber`)
+	f.Close()
+
+	got, err := j.Records(time.Time{})
+	if err != nil {
+		t.Fatalf("Records() error = %v", err)
+	}
+	if len(got) != 3 || got[1].User != `CONTOSO\alice` || got[2].Type != TypeHibernationRequested {
+		t.Errorf("Records() = %+v", got)
+	}
+
+	got, err = j.Records(start.Add(time.Minute))
+	if err != nil {
+		t.Fatalf("Records(since) error = %v", err)
+	}
+	if len(got) != 2 || got[0].Type != TypeWarningCanceled {
+		t.Errorf("Records(since) = %+v, want the last 2", got)
+	}
+
+	last, err := j.Last()
+	if err != nil || last == nil || last.Type != TypeHibernationRequested {
+		t.Errorf("Last() = %+v, %v", last, err)
+	}
+}
+
+func TestRecordsMissing(t *testing.T) {
+	j := New(filepath.Join(t.TempDir(), FileName), DefaultMaxSize, DefaultKeep)
+	records, err := j.Records(time.Time{})
+	if err != nil || len(records) != 0 {
+		t.Errorf("Records() = %v, %v; want none", records, err)
+	}
+	last, err := j.Last()
+	if err != nil || last != nil {
+		t.Errorf("Last() = %v, %v; want nil", last, err)
+	}
+}
+
+func TestRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), FileName)
+	// Each record is 50 bytes, so every file holds two records
+	j := New(path, 100, 2)
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	for i := range 7 {
+		if err := j.Append(Record{Time: start.Add(time.Duration(i) * time.Minute), Type: TypeResumed}); err != nil {
+			t.Fatalf("Append(%d) error = %v", i, err)
+		}
+	}
+
+	for _, name := range []string{FileName, FileName + ".1", FileName + ".2"} {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(path), name)); err != nil {
+			t.Errorf("%s missing: %v", name, err)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("history.jsonl.3 exists; only 2 rotated files should be kept")
+	}
+
+	records, err := j.Records(time.Time{})
+	if err != nil {
+		t.Fatalf("Records() error = %v", err)
+	}
+	// The oldest records were dropped; the rest are in order
+	if len(records) != 5 || !records[0].Time.Equal(start.Add(2*time.Minute)) || !records[4].Time.Equal(start.Add(6*time.Minute)) {
+		t.Errorf("Records() = %+v", records)
+	}
+}
+
+func TestParseSince(t *testing.T) {
+	now := time.Date(2026, 3, 9, 12, 0, 0, 0, time.Local)
+	tests := []struct {
+		value   string
+		want    time.Time
+		wantErr bool
+	}{
+		{value: "7d", want: time.Date(2026, 3, 2, 12, 0, 0, 0, time.Local)},
+		{value: "12h", want: now.Add(-12 * time.Hour)},
+		{value: "90m", want: now.Add(-90 * time.Minute)},
+		{value: "2026-03-01", want: time.Date(2026, 3, 1, 0, 0, 0, 0, time.Local)},
+		{value: "2026-03-01T08:00:00Z", want: time.Date(2026, 3, 1, 8, 0, 0, 0, time.UTC)},
+		{value: "last week", wantErr: true},
+		{value: "-2h", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := ParseSince(tt.value, now)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseSince(%q) = %v, want error", tt.value, got)
+				}
+				return
+			}
+			if err != nil || !got.Equal(tt.want) {
+				t.Errorf("ParseSince(%q) = %v, %v; want %v", tt.value, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestSummary(t *testing.T) {
+	until := time.Date(2026, 3, 2, 16, 0, 0, 0, time.Local)
+	tests := []struct {
+		record Record
+		want   string
+	}{
+		{
+			Record{Type: TypeWarningCanceled, Condition: "allDisconnected", Reason: "user activity detected", SessionID: 3, User: `CONTOSO\bob`},
+			`allDisconnected: user activity detected by session 3 (CONTOSO\bob)`,
+		},
+		{
+			Record{Type: TypeHibernationFailed, Condition: "noUsers", Action: "hibernate", Error: "hibernation request failed with status 403"},
+			"noUsers: hibernate failed: hibernation request failed with status 403",
+		},
+		{
+			Record{Type: TypePaused, Source: SourceControl, Until: &until, Reason: "release build"},
+			"until 2026-03-02 16:00 by control release build",
+		},
+		{
+			Record{Type: TypeLeaseGranted, User: "alice", Until: &until},
+			"alice kept the VM awake until 16:00",
+		},
+		{
+			Record{Type: TypeUpdateApplied, Version: "v1.5.0", FromVersion: "v1.4.2"},
+			"v1.4.2 -> v1.5.0",
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.record.Summary(); got != tt.want {
+			t.Errorf("Summary(%s) = %q, want %q", tt.record.Type, got, tt.want)
+		}
+	}
+
+	var b strings.Builder
+	if err := WriteText(&b, []Record{{Time: until, Type: TypeResumed, Reason: "hibernated"}}); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	if want := "2026-03-02 16:00:00  resumed  hibernated\n"; !strings.HasSuffix(b.String(), want) {
+		t.Errorf("WriteText() = %q, want suffix %q", b.String(), want)
+	}
+}
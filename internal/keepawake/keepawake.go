@@ -0,0 +1,186 @@
+// Package keepawake issues single-use keep-awake tokens and serves the page that
+// redeems them. A redeemed token grants a lease during which idle actions are paused.
+package keepawake
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Path is the URL path of the keep-awake page
+const Path = "/keep-awake"
+
+// tokenLifetime is how long an unredeemed token stays valid
+const tokenLifetime = 24 * time.Hour
+
+// ErrInvalidToken is returned for unknown, expired or already redeemed tokens
+var ErrInvalidToken = errors.New("keep-awake link is invalid or has expired")
+
+// Lease keeps the VM awake until a point in time
+type Lease struct {
+	User  string    // User who redeemed the token
+	Until time.Time // End of the lease
+}
+
+// Manager issues tokens and tracks the active lease
+type Manager struct {
+	duration time.Duration
+	onGrant  func(Lease)
+	now      func() time.Time
+
+	mu     sync.Mutex
+	tokens map[string]ticket
+	lease  Lease
+}
+
+// ticket is an unredeemed token
+type ticket struct {
+	user    string
+	expires time.Time
+}
+
+// NewManager creates a manager granting leases of the given duration. onGrant, if not
+// nil, is called after each redemption.
+func NewManager(duration time.Duration, onGrant func(Lease)) *Manager {
+	return &Manager{
+		duration: duration,
+		onGrant:  onGrant,
+		now:      time.Now,
+		tokens:   make(map[string]ticket),
+	}
+}
+
+// Duration returns the length of a lease
+func (m *Manager) Duration() time.Duration {
+	return m.duration
+}
+
+// Issue returns a new single-use token for a user
+func (m *Manager) Issue(user string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	token := base64.RawURLEncoding.EncodeToString(b)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := m.now()
+	for t, tk := range m.tokens {
+		if now.After(tk.expires) {
+			delete(m.tokens, t)
+		}
+	}
+	m.tokens[token] = ticket{user: user, expires: now.Add(tokenLifetime)}
+	return token, nil
+}
+
+// Redeem consumes a token and starts (or extends) the lease
+func (m *Manager) Redeem(token string) (Lease, error) {
+	m.mu.Lock()
+	now := m.now()
+	tk, ok := m.tokens[token]
+	if !ok || now.After(tk.expires) {
+		m.mu.Unlock()
+		return Lease{}, ErrInvalidToken
+	}
+	delete(m.tokens, token)
+
+	lease := Lease{User: tk.user, Until: now.Add(m.duration)}
+	if m.lease.Until.After(lease.Until) {
+		// Never shorten a lease another user already holds
+		lease.Until = m.lease.Until
+	}
+	m.lease = lease
+	m.mu.Unlock()
+
+	if m.onGrant != nil {
+		m.onGrant(lease)
+	}
+	return lease, nil
+}
+
+// Active returns the current lease, if one is in force
+func (m *Manager) Active() (Lease, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.now().Before(m.lease.Until) {
+		return m.lease, true
+	}
+	return Lease{}, false
+}
+
+// Link returns the keep-awake URL for a token under baseURL (e.g. http://vm-01:8423)
+func Link(baseURL, token string) string {
+	return strings.TrimSuffix(baseURL, "/") + Path + "?token=" + url.QueryEscape(token)
+}
+
+// Handler serves the keep-awake page. GET shows a confirmation form and POST redeems the
+// token, so mail scanners that prefetch links do not use up the token.
+func (m *Manager) Handler(vmName string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+
+		page := pageData{VMName: vmName, Minutes: int(m.duration.Minutes())}
+		switch r.Method {
+		case http.MethodGet, http.MethodHead:
+			page.Token = r.URL.Query().Get("token")
+			if page.Token == "" {
+				w.WriteHeader(http.StatusBadRequest)
+				page.Error = ErrInvalidToken.Error()
+			}
+		case http.MethodPost:
+			lease, err := m.Redeem(r.PostFormValue("token"))
+			if err != nil {
+				w.WriteHeader(http.StatusNotFound)
+				page.Error = err.Error()
+			} else {
+				page.Until = lease.Until.Local().Format("Mon 15:04 MST")
+			}
+		default:
+			w.Header().Set("Allow", "GET, HEAD, POST")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		pageTemplate.Execute(w, page)
+	})
+}
+
+type pageData struct {
+	VMName  string
+	Minutes int
+	Token   string // Set on the confirmation form
+	Until   string // Set once the lease is granted
+	Error   string
+}
+
+var pageTemplate = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<title>Keep {{.VMName}} awake</title>
+<style>body{font-family:Segoe UI,sans-serif;max-width:32em;margin:3em auto;padding:0 1em}button{font-size:1em;padding:.5em 1em}</style>
+</head>
+<body>
+<h1>{{.VMName}}</h1>
+{{if .Error}}<p>{{.Error}}.</p>
+{{else if .Until}}<p>{{.VMName}} will stay awake until {{.Until}}.</p>
+{{else}}<p>Keep {{.VMName}} awake for {{.Minutes}} minutes?</p>
+<form method="post" action="">
+<input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Keep awake</button>
+</form>
+{{end}}</body>
+</html>
+`))
@@ -0,0 +1,164 @@
+package keepawake
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestManager returns a manager whose clock is controlled by the returned pointer
+func newTestManager(duration time.Duration, onGrant func(Lease)) (*Manager, *time.Time) {
+	now := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	m := NewManager(duration, onGrant)
+	m.now = func() time.Time { return now }
+	return m, &now
+}
+
+func TestRedeem(t *testing.T) {
+	var granted []Lease
+	m, now := newTestManager(time.Hour, func(l Lease) { granted = append(granted, l) })
+
+	if _, ok := m.Active(); ok {
+		t.Fatal("Active() = true before any token was redeemed")
+	}
+
+	token, err := m.Issue("alice")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	lease, err := m.Redeem(token)
+	if err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	if lease.User != "alice" || !lease.Until.Equal(now.Add(time.Hour)) {
+		t.Errorf("Redeem() = %+v, want alice until %v", lease, now.Add(time.Hour))
+	}
+	if len(granted) != 1 {
+		t.Errorf("onGrant called %d times, want 1", len(granted))
+	}
+	if active, ok := m.Active(); !ok || active != lease {
+		t.Errorf("Active() = %+v, %v; want %+v", active, ok, lease)
+	}
+
+	// Tokens are single use
+	if _, err := m.Redeem(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("second Redeem() error = %v, want ErrInvalidToken", err)
+	}
+
+	// The lease ends after its duration
+	*now = now.Add(time.Hour)
+	if _, ok := m.Active(); ok {
+		t.Error("Active() = true after the lease ended")
+	}
+}
+
+func TestRedeemExtends(t *testing.T) {
+	m, now := newTestManager(time.Hour, nil)
+	alice, _ := m.Issue("alice")
+	bob, _ := m.Issue("bob")
+
+	first, _ := m.Redeem(alice)
+	*now = now.Add(30 * time.Minute)
+	second, err := m.Redeem(bob)
+	if err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	if !second.Until.Equal(first.Until.Add(30 * time.Minute)) {
+		t.Errorf("second lease until %v, want %v", second.Until, first.Until.Add(30*time.Minute))
+	}
+}
+
+func TestRedeemExpiredToken(t *testing.T) {
+	m, now := newTestManager(time.Hour, nil)
+	token, _ := m.Issue("alice")
+
+	*now = now.Add(tokenLifetime + time.Second)
+	if _, err := m.Redeem(token); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem() error = %v, want ErrInvalidToken", err)
+	}
+	if _, err := m.Redeem("unknown"); !errors.Is(err, ErrInvalidToken) {
+		t.Errorf("Redeem(unknown) error = %v, want ErrInvalidToken", err)
+	}
+
+	// Issuing prunes expired tokens
+	m.Issue("bob")
+	if len(m.tokens) != 1 {
+		t.Errorf("%d tokens kept, want 1", len(m.tokens))
+	}
+}
+
+func TestHandler(t *testing.T) {
+	m, _ := newTestManager(time.Hour, nil)
+	srv := httptest.NewServer(m.Handler("VM-01"))
+	defer srv.Close()
+
+	token, _ := m.Issue("alice")
+	link := Link(srv.URL+"/", token)
+	if !strings.HasPrefix(link, srv.URL+Path+"?token=") {
+		t.Fatalf("Link() = %q", link)
+	}
+
+	// GET shows the confirmation form without redeeming the token
+	resp, err := http.Get(link)
+	if err != nil {
+		t.Fatalf("GET error = %v", err)
+	}
+	body := readBody(t, resp)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, `<form method="post"`) || !strings.Contains(body, token) {
+		t.Fatalf("GET = %d %q, want confirmation form", resp.StatusCode, body)
+	}
+	if resp.Header.Get("Cache-Control") != "no-store" {
+		t.Errorf("Cache-Control = %q, want no-store", resp.Header.Get("Cache-Control"))
+	}
+	if _, ok := m.Active(); ok {
+		t.Fatal("GET granted a lease")
+	}
+
+	// POST redeems it
+	resp, err = http.PostForm(link, url.Values{"token": {token}})
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	body = readBody(t, resp)
+	if resp.StatusCode != http.StatusOK || !strings.Contains(body, "will stay awake until") {
+		t.Fatalf("POST = %d %q, want lease confirmation", resp.StatusCode, body)
+	}
+	if _, ok := m.Active(); !ok {
+		t.Fatal("POST did not grant a lease")
+	}
+
+	// A reused token is rejected
+	resp, err = http.PostForm(link, url.Values{"token": {token}})
+	if err != nil {
+		t.Fatalf("POST error = %v", err)
+	}
+	body = readBody(t, resp)
+	if resp.StatusCode != http.StatusNotFound || !strings.Contains(body, "invalid or has expired") {
+		t.Errorf("reused POST = %d %q, want 404", resp.StatusCode, body)
+	}
+
+	req, _ := http.NewRequest(http.MethodDelete, link, nil)
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("DELETE error = %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Errorf("DELETE = %d, want 405", resp.StatusCode)
+	}
+}
+
+func readBody(t *testing.T, resp *http.Response) string {
+	t.Helper()
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("failed to read body: %v", err)
+	}
+	return string(b)
+}
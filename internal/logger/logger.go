@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"strings"
+	"sync/atomic"
 
 	"golang.org/x/sys/windows/svc/eventlog"
 )
@@ -80,6 +81,7 @@ const (
 	EventSessionInfoWarning  = 20
 	EventIdleCheckWarning    = 21
 	EventNotificationWarning = 22
+	EventVMTagWarning        = 23
 
 	// Error events (30-39)
 	EventConfigError         = 30
@@ -88,6 +90,76 @@ const (
 	EventHibernationError    = 33
 	EventAzureAuthError      = 34
 	EventNotificationError   = 35
+
+	// Idle action events (40-49)
+	EventActionSkipped           = 40
+	EventFallbackActionTriggered = 41
+	EventActionRetrying          = 42
+	EventActionEscalated         = 43
+
+	// Azure Resource Manager error events (50-59)
+	EventARMAuthenticationFailed  = 50
+	EventARMAuthorizationFailed   = 51
+	EventARMScopeLocked           = 52
+	EventARMHibernationNotEnabled = 53
+	EventARMOperationNotAllowed   = 54
+	EventARMThrottled             = 55
+	EventARMNotFound              = 56
+
+	// Azure Scheduled Events (60-69)
+	EventScheduledEventReceived  = 60
+	EventScheduledEventStarted   = 61
+	EventScheduledEventCompleted = 62
+	EventSpotEviction            = 63
+	EventIdleChecksPaused        = 64
+	EventScheduledEventWarning   = 65
+
+	// Remote control (70-79)
+	EventRemoteControlChanged = 70
+	EventRemoteControlWarning = 71
+	EventDryRunAction         = 72
+
+	// Savings accounting (80-89)
+	EventSavingsRecorded = 80
+	EventSavingsWarning  = 81
+
+	// Webhook notifications (90-99)
+	EventWebhookDelivered = 90
+	EventWebhookError     = 91
+
+	// Email notifications and keep-awake leases (100-109)
+	EventEmailSent        = 100
+	EventEmailError       = 101
+	EventKeepAwakeGranted = 102
+
+	// Hooks and resume reconciliation (110-119)
+	EventHookCompleted    = 110
+	EventHookFailed       = 111
+	EventHookVetoed       = 112
+	EventResumeReconciled = 113
+
+	// Local control API (120-129)
+	EventControlCommand = 120
+	EventControlError   = 121
+
+	// Prometheus metrics (130-139)
+	EventMetricsError = 130
+
+	// History journal (140-149)
+	EventHistoryError = 140
+
+	// Service pause and continue (150-159)
+	EventServicePaused    = 150
+	EventServiceContinued = 151
+
+	// Monitor loop watchdog (160-169)
+	EventMonitorStalled = 160
+
+	// Clock (170-179)
+	EventClockJump = 170
+
+	// Tracing (180-189)
+	EventTracingError = 180
 )
 
 // Logger provides a unified interface for logging to Windows Event Log or console
@@ -103,15 +175,21 @@ type Logger interface {
 	Close() error
 }
 
+// LevelSetter is implemented by loggers whose level can be changed while running
+type LevelSetter interface {
+	Level() LogLevel
+	SetLevel(level LogLevel)
+}
+
 // EventLogger writes to Windows Event Log
 type EventLogger struct {
 	elog  *eventlog.Log
-	level LogLevel
+	level atomic.Int32
 }
 
 // ConsoleLogger writes to console (for debug mode)
 type ConsoleLogger struct {
-	level LogLevel
+	level atomic.Int32
 }
 
 // NewEventLogger creates a logger that writes to Windows Event Log
@@ -120,59 +198,71 @@ func NewEventLogger(serviceName string, level LogLevel) (*EventLogger, error) {
 	if err != nil {
 		return nil, fmt.Errorf("failed to open event log: %w", err)
 	}
-	return &EventLogger{elog: elog, level: level}, nil
+	l := &EventLogger{elog: elog}
+	l.SetLevel(level)
+	return l, nil
 }
 
 // NewConsoleLogger creates a logger that writes to console
 func NewConsoleLogger(level LogLevel) *ConsoleLogger {
-	return &ConsoleLogger{level: level}
+	l := &ConsoleLogger{}
+	l.SetLevel(level)
+	return l
 }
 
 // EventLogger methods
+func (l *EventLogger) Level() LogLevel {
+	return LogLevel(l.level.Load())
+}
+
+func (l *EventLogger) SetLevel(level LogLevel) {
+	l.level.Store(int32(level))
+}
+
 func (l *EventLogger) Debug(eventID uint32, msg string) {
-	if l.level <= LevelDebug {
+	if l.Level() <= LevelDebug {
 		l.elog.Info(eventID, "[DEBUG] "+msg)
 	}
 }
 
 func (l *EventLogger) Info(eventID uint32, msg string) {
-	if l.level <= LevelInfo {
+	if l.Level() <= LevelInfo {
 		l.elog.Info(eventID, msg)
 	}
 }
 
 func (l *EventLogger) Warning(eventID uint32, msg string) {
-	if l.level <= LevelWarning {
+	if l.Level() <= LevelWarning {
 		l.elog.Warning(eventID, msg)
 	}
 }
 
 func (l *EventLogger) Error(eventID uint32, msg string) {
-	if l.level <= LevelError {
+	if l.Level() <= LevelError {
 		l.elog.Error(eventID, msg)
 	}
 }
 
 func (l *EventLogger) Debugf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelDebug {
+	if l.Level() <= LevelDebug {
 		l.elog.Info(eventID, "[DEBUG] "+fmt.Sprintf(format, args...))
 	}
 }
 
 func (l *EventLogger) Infof(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelInfo {
+	if l.Level() <= LevelInfo {
 		l.elog.Info(eventID, fmt.Sprintf(format, args...))
 	}
 }
 
 func (l *EventLogger) Warningf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelWarning {
+	if l.Level() <= LevelWarning {
 		l.elog.Warning(eventID, fmt.Sprintf(format, args...))
 	}
 }
 
 func (l *EventLogger) Errorf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelError {
+	if l.Level() <= LevelError {
 		l.elog.Error(eventID, fmt.Sprintf(format, args...))
 	}
 }
@@ -182,50 +272,58 @@ func (l *EventLogger) Close() error {
 }
 
 // ConsoleLogger methods (event IDs are ignored in console mode)
+func (l *ConsoleLogger) Level() LogLevel {
+	return LogLevel(l.level.Load())
+}
+
+func (l *ConsoleLogger) SetLevel(level LogLevel) {
+	l.level.Store(int32(level))
+}
+
 func (l *ConsoleLogger) Debug(eventID uint32, msg string) {
-	if l.level <= LevelDebug {
+	if l.Level() <= LevelDebug {
 		log.Printf("[DEBUG] [%d] %s", eventID, msg)
 	}
 }
 
 func (l *ConsoleLogger) Info(eventID uint32, msg string) {
-	if l.level <= LevelInfo {
+	if l.Level() <= LevelInfo {
 		log.Printf("[INFO] [%d] %s", eventID, msg)
 	}
 }
 
 func (l *ConsoleLogger) Warning(eventID uint32, msg string) {
-	if l.level <= LevelWarning {
+	if l.Level() <= LevelWarning {
 		log.Printf("[WARN] [%d] %s", eventID, msg)
 	}
 }
 
 func (l *ConsoleLogger) Error(eventID uint32, msg string) {
-	if l.level <= LevelError {
+	if l.Level() <= LevelError {
 		log.Printf("[ERROR] [%d] %s", eventID, msg)
 	}
 }
 
 func (l *ConsoleLogger) Debugf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelDebug {
+	if l.Level() <= LevelDebug {
 		log.Printf("[DEBUG] [%d] "+format, append([]interface{}{eventID}, args...)...)
 	}
 }
 
 func (l *ConsoleLogger) Infof(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelInfo {
+	if l.Level() <= LevelInfo {
 		log.Printf("[INFO] [%d] "+format, append([]interface{}{eventID}, args...)...)
 	}
 }
 
 func (l *ConsoleLogger) Warningf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelWarning {
+	if l.Level() <= LevelWarning {
 		log.Printf("[WARN] [%d] "+format, append([]interface{}{eventID}, args...)...)
 	}
 }
 
 func (l *ConsoleLogger) Errorf(eventID uint32, format string, args ...interface{}) {
-	if l.level <= LevelError {
+	if l.Level() <= LevelError {
 		log.Printf("[ERROR] [%d] "+format, append([]interface{}{eventID}, args...)...)
 	}
 }
@@ -233,3 +331,49 @@ func (l *ConsoleLogger) Errorf(eventID uint32, format string, args ...interface{
 func (l *ConsoleLogger) Close() error {
 	return nil
 }
+
+// suffixLogger appends a suffix to every message of the logger it wraps
+type suffixLogger struct {
+	Logger
+	suffix string
+}
+
+// WithSuffix returns a logger that appends suffix to every message, such as the IDs of the
+// trace the message belongs to. It returns l when suffix is empty.
+func WithSuffix(l Logger, suffix string) Logger {
+	if suffix == "" {
+		return l
+	}
+	return &suffixLogger{Logger: l, suffix: suffix}
+}
+
+func (l *suffixLogger) Debug(eventID uint32, msg string)   { l.Logger.Debug(eventID, msg+l.suffix) }
+func (l *suffixLogger) Info(eventID uint32, msg string)    { l.Logger.Info(eventID, msg+l.suffix) }
+func (l *suffixLogger) Warning(eventID uint32, msg string) { l.Logger.Warning(eventID, msg+l.suffix) }
+func (l *suffixLogger) Error(eventID uint32, msg string)   { l.Logger.Error(eventID, msg+l.suffix) }
+
+func (l *suffixLogger) Debugf(eventID uint32, format string, args ...interface{}) {
+	l.Logger.Debugf(eventID, format+"%s", l.withSuffix(args)...)
+}
+
+func (l *suffixLogger) Infof(eventID uint32, format string, args ...interface{}) {
+	l.Logger.Infof(eventID, format+"%s", l.withSuffix(args)...)
+}
+
+func (l *suffixLogger) Warningf(eventID uint32, format string, args ...interface{}) {
+	l.Logger.Warningf(eventID, format+"%s", l.withSuffix(args)...)
+}
+
+func (l *suffixLogger) Errorf(eventID uint32, format string, args ...interface{}) {
+	l.Logger.Errorf(eventID, format+"%s", l.withSuffix(args)...)
+}
+
+// withSuffix returns args followed by the suffix, without writing into the caller's array
+func (l *suffixLogger) withSuffix(args []interface{}) []interface{} {
+	return append(args[:len(args):len(args)], l.suffix)
+}
+
+// Close does nothing: the wrapped logger is closed by its owner
+func (l *suffixLogger) Close() error {
+	return nil
+}
@@ -0,0 +1,266 @@
+// Package metrics keeps counters and gauges in memory and serves them in the Prometheus
+// text exposition format. Packages declare their metrics on the Default registry.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Path is the URL path of the metrics page
+const Path = "/metrics"
+
+// Default is the registry served by the service
+var Default = NewRegistry()
+
+// Metric types
+const (
+	typeCounter = "counter"
+	typeGauge   = "gauge"
+)
+
+// Registry holds metric families by name
+type Registry struct {
+	mu       sync.Mutex
+	families map[string]*family
+}
+
+// family is a metric and its series, one per combination of label values
+type family struct {
+	name   string
+	help   string
+	typ    string
+	labels []string
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is one value of a family
+type series struct {
+	labelValues []string
+	bits        atomic.Uint64 // math.Float64bits of the value
+}
+
+// NewRegistry creates an empty registry
+func NewRegistry() *Registry {
+	return &Registry{families: make(map[string]*family)}
+}
+
+// register adds a family. Registering a name twice is a programming error.
+func (r *Registry) register(name, help, typ string, labels []string) *family {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, exists := r.families[name]; exists {
+		panic(fmt.Sprintf("metrics: %s registered twice", name))
+	}
+	f := &family{name: name, help: help, typ: typ, labels: labels, series: make(map[string]*series)}
+	r.families[name] = f
+	return f
+}
+
+// with returns the series for the label values, creating it on first use
+func (f *family) with(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metrics: %s takes %d label values, got %d", f.name, len(f.labels), len(values)))
+	}
+	key := strings.Join(values, "\xff")
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{labelValues: append([]string(nil), values...)}
+		f.series[key] = s
+	}
+	return s
+}
+
+func (s *series) load() float64 {
+	return math.Float64frombits(s.bits.Load())
+}
+
+func (s *series) store(v float64) {
+	s.bits.Store(math.Float64bits(v))
+}
+
+func (s *series) add(delta float64) {
+	for {
+		old := s.bits.Load()
+		if s.bits.CompareAndSwap(old, math.Float64bits(math.Float64frombits(old)+delta)) {
+			return
+		}
+	}
+}
+
+// Counter is a value that only goes up
+type Counter struct{ s *series }
+
+// Inc adds one to the counter
+func (c Counter) Inc() { c.s.add(1) }
+
+// Add adds a non-negative value to the counter
+func (c Counter) Add(delta float64) {
+	if delta < 0 {
+		panic("metrics: counter cannot decrease")
+	}
+	c.s.add(delta)
+}
+
+// Value returns the current count
+func (c Counter) Value() float64 { return c.s.load() }
+
+// Gauge is a value that goes up and down
+type Gauge struct{ s *series }
+
+// Set sets the gauge
+func (g Gauge) Set(v float64) { g.s.store(v) }
+
+// SetBool sets the gauge to 1 or 0
+func (g Gauge) SetBool(b bool) {
+	if b {
+		g.s.store(1)
+	} else {
+		g.s.store(0)
+	}
+}
+
+// Value returns the current value
+func (g Gauge) Value() float64 { return g.s.load() }
+
+// CounterVec is a counter with labels
+type CounterVec struct{ f *family }
+
+// With returns the counter for the label values, in the order the labels were declared
+func (v *CounterVec) With(values ...string) Counter { return Counter{v.f.with(values)} }
+
+// GaugeVec is a gauge with labels
+type GaugeVec struct{ f *family }
+
+// With returns the gauge for the label values, in the order the labels were declared
+func (v *GaugeVec) With(values ...string) Gauge { return Gauge{v.f.with(values)} }
+
+// NewCounter registers a counter without labels
+func (r *Registry) NewCounter(name, help string) Counter {
+	return Counter{r.register(name, help, typeCounter, nil).with(nil)}
+}
+
+// NewCounterVec registers a counter with labels
+func (r *Registry) NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return &CounterVec{r.register(name, help, typeCounter, labels)}
+}
+
+// NewGauge registers a gauge without labels
+func (r *Registry) NewGauge(name, help string) Gauge {
+	return Gauge{r.register(name, help, typeGauge, nil).with(nil)}
+}
+
+// NewGaugeVec registers a gauge with labels
+func (r *Registry) NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return &GaugeVec{r.register(name, help, typeGauge, labels)}
+}
+
+// NewCounter registers a counter without labels on the Default registry
+func NewCounter(name, help string) Counter { return Default.NewCounter(name, help) }
+
+// NewCounterVec registers a counter with labels on the Default registry
+func NewCounterVec(name, help string, labels ...string) *CounterVec {
+	return Default.NewCounterVec(name, help, labels...)
+}
+
+// NewGauge registers a gauge without labels on the Default registry
+func NewGauge(name, help string) Gauge { return Default.NewGauge(name, help) }
+
+// NewGaugeVec registers a gauge with labels on the Default registry
+func NewGaugeVec(name, help string, labels ...string) *GaugeVec {
+	return Default.NewGaugeVec(name, help, labels...)
+}
+
+// WriteText writes all metrics in the Prometheus text exposition format, sorted by name
+// and label values. Labeled families with no series yet are written without samples.
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mu.Lock()
+	families := make([]*family, 0, len(r.families))
+	for _, f := range r.families {
+		families = append(families, f)
+	}
+	r.mu.Unlock()
+	sort.Slice(families, func(i, j int) bool { return families[i].name < families[j].name })
+
+	var sb strings.Builder
+	for _, f := range families {
+		fmt.Fprintf(&sb, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(&sb, "# TYPE %s %s\n", f.name, f.typ)
+
+		f.mu.Lock()
+		all := make([]*series, 0, len(f.series))
+		for _, s := range f.series {
+			all = append(all, s)
+		}
+		f.mu.Unlock()
+		sort.Slice(all, func(i, j int) bool {
+			return strings.Join(all[i].labelValues, "\xff") < strings.Join(all[j].labelValues, "\xff")
+		})
+
+		for _, s := range all {
+			sb.WriteString(f.name)
+			if len(f.labels) > 0 {
+				sb.WriteByte('{')
+				for i, label := range f.labels {
+					if i > 0 {
+						sb.WriteByte(',')
+					}
+					fmt.Fprintf(&sb, "%s=\"%s\"", label, escapeLabel(s.labelValues[i]))
+				}
+				sb.WriteByte('}')
+			}
+			sb.WriteByte(' ')
+			sb.WriteString(formatValue(s.load()))
+			sb.WriteByte('\n')
+		}
+	}
+	_, err := io.WriteString(w, sb.String())
+	return err
+}
+
+// Handler serves the registry in the Prometheus text format
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			w.Header().Set("Allow", "GET, HEAD")
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		w.Header().Set("Cache-Control", "no-store")
+		r.WriteText(w)
+	})
+}
+
+// formatValue formats a sample value as Prometheus expects
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string  { return helpEscaper.Replace(s) }
+func escapeLabel(s string) string { return labelEscaper.Replace(s) }
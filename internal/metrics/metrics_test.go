@@ -0,0 +1,115 @@
+package metrics
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	hibernations := r.NewCounterVec("test_hibernations_total", "Hibernations by action.", "action", "result")
+	sessions := r.NewGaugeVec("test_sessions", "Sessions by state.", "state")
+	warning := r.NewGauge("test_warning_active", "1 while a warning\nis active.")
+	restarts := r.NewCounter("test_restarts_total", "Restarts.")
+	r.NewCounterVec("test_unused_total", "No series yet.", "code")
+
+	hibernations.With("hibernate", "failed").Inc()
+	hibernations.With("deallocate", "succeeded").Add(2)
+	hibernations.With("hibernate", "failed").Inc()
+	sessions.With("disconnected").Set(1)
+	sessions.With(`a"b\c`).Set(0.5)
+	warning.SetBool(true)
+	restarts.Inc()
+
+	var b strings.Builder
+	if err := r.WriteText(&b); err != nil {
+		t.Fatalf("WriteText() error = %v", err)
+	}
+	want := `# HELP test_hibernations_total Hibernations by action.
+# TYPE test_hibernations_total counter
+test_hibernations_total{action="deallocate",result="succeeded"} 2
+test_hibernations_total{action="hibernate",result="failed"} 2
+# HELP test_restarts_total Restarts.
+# TYPE test_restarts_total counter
+test_restarts_total 1
+# HELP test_sessions Sessions by state.
+# TYPE test_sessions gauge
+test_sessions{state="a\"b\\c"} 0.5
+test_sessions{state="disconnected"} 1
+# HELP test_unused_total No series yet.
+# TYPE test_unused_total counter
+# HELP test_warning_active 1 while a warning\nis active.
+# TYPE test_warning_active gauge
+test_warning_active 1
+`
+	if got := b.String(); got != want {
+		t.Errorf("WriteText() =\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFormatValue(t *testing.T) {
+	tests := []struct {
+		value float64
+		want  string
+	}{
+		{0, "0"},
+		{42, "42"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatValue(tt.value); got != tt.want {
+			t.Errorf("formatValue(%v) = %q, want %q", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestRegisterTwice(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.")
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	r.NewGauge("test_total", "Test.")
+}
+
+func TestWrongLabelCount(t *testing.T) {
+	v := NewRegistry().NewCounterVec("test_total", "Test.", "a", "b")
+	defer func() {
+		if recover() == nil {
+			t.Error("With() with a missing label value did not panic")
+		}
+	}()
+	v.With("only-one")
+}
+
+func TestHandler(t *testing.T) {
+	r := NewRegistry()
+	r.NewCounter("test_total", "Test.").Inc()
+
+	rec := httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodGet, Path, nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("GET status = %d, want 200", rec.Code)
+	}
+	if ct := rec.Header().Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("Content-Type = %q", ct)
+	}
+	if !strings.Contains(rec.Body.String(), "test_total 1\n") {
+		t.Errorf("body = %q", rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.Handler().ServeHTTP(rec, httptest.NewRequest(http.MethodPost, Path, nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("POST status = %d, want 405", rec.Code)
+	}
+}
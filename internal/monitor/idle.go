@@ -4,8 +4,10 @@ package monitor
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/smitstech/AzureAutoHibernate/internal/clock"
 	"github.com/smitstech/AzureAutoHibernate/internal/logger"
 )
 
@@ -13,6 +15,10 @@ const (
 	// recentActivityThreshold is the duration used to detect recent user activity
 	// Activity within this threshold cancels active hibernation warnings
 	recentActivityThreshold = 30 * time.Second
+
+	// clockJumpThreshold is how far the wall clock can move away from the tick count before
+	// it is treated as a jump and the idle timers are re-baselined
+	clockJumpThreshold = time.Minute
 )
 
 // IdleCondition represents the type of idle condition that triggered
@@ -25,6 +31,20 @@ const (
 	IdleConditionInactiveUser                         // User logged in but inactive
 )
 
+// String returns the condition name used in configuration keys and logs
+func (c IdleCondition) String() string {
+	switch c {
+	case IdleConditionNoUsers:
+		return "noUsers"
+	case IdleConditionAllDisconnected:
+		return "allDisconnected"
+	case IdleConditionInactiveUser:
+		return "inactiveUser"
+	default:
+		return "none"
+	}
+}
+
 // WarningState represents the current warning FSM state
 type WarningState int
 
@@ -34,6 +54,9 @@ const (
 	WarningStateCanceled                     // Warning was canceled due to user activity
 )
 
+// IdleState holds the idle timers. Its times are taken from a timeline that follows the tick
+// count (see clock.Timeline), so they match the wall clock but the time between them does not
+// change when the wall clock does.
 type IdleState struct {
 	NoUsersIdleSince     *time.Time
 	AllDisconnectedSince *time.Time
@@ -43,20 +66,40 @@ type IdleState struct {
 	WarningIssuedAt      *time.Time
 	WarningReason        string
 	WarningState         WarningState
+	RearmedAt            *time.Time // Set when a hook vetoed the inactive user action or the wall clock jumped forward; idle time counts from here
 }
 
+// IdleMonitor tracks the idle conditions. It is safe for concurrent use: the monitor loop
+// checks it while the control API reads its state.
 type IdleMonitor struct {
-	state                    IdleState
-	noUsersThreshold         time.Duration
-	allDisconnectedThreshold time.Duration
-	inactiveUserThreshold    time.Duration
-	warningPeriod            time.Duration
-	minimumUptimeThreshold   time.Duration
-	resumeAt                 time.Time // Tracks when system resumed from hibernate/sleep
+	mu                        sync.Mutex // Guards the fields below
+	state                     IdleState
+	noUsersThreshold          time.Duration
+	allDisconnectedThreshold  time.Duration
+	inactiveUserThreshold     time.Duration
+	warningPeriod             time.Duration // Warning period of the inactive user condition
+	disconnectedWarningPeriod time.Duration // Warning period of the all disconnected condition (default: none)
+	minimumUptimeThreshold    time.Duration
+	resumeAt                  time.Time       // Tracks when system resumed from hibernate/sleep
+	timeline                  *clock.Timeline // Source of the current time for all idle timers
+	jumped                    time.Duration   // Wall clock jumps absorbed since the last check, logged by Check
+
+	// sessionInput reads the last input time of a session and the current time, as FILETIMEs
+	sessionInput func(sessionId uint32) (lastInput, current int64, err error)
+	inputSeen    map[uint32]int64        // Last input time of each session when it was last read
+	inputSkew    map[uint32]backwardSkew // Sessions whose idle time a backward clock jump shortened
+}
+
+// backwardSkew is how much a backward wall clock jump shortened the idle time of a session,
+// which holds until the session's last input time changes
+type backwardSkew struct {
+	lastInput int64         // Last input time read before the jump
+	amount    time.Duration // Time taken off the idle time
 }
 
 func NewIdleMonitor(noUsersMinutes, allDisconnectedMinutes, inactiveUserMinutes, inactiveUserWarningMinutes, minimumUptimeMinutes int) *IdleMonitor {
-	now := time.Now()
+	timeline := clock.NewTimeline(tickSource{}, clockJumpThreshold)
+	now, _ := timeline.Now()
 	return &IdleMonitor{
 		state: IdleState{
 			LastActivityTime: now,
@@ -67,18 +110,145 @@ func NewIdleMonitor(noUsersMinutes, allDisconnectedMinutes, inactiveUserMinutes,
 		warningPeriod:            time.Duration(inactiveUserWarningMinutes) * time.Minute,
 		minimumUptimeThreshold:   time.Duration(minimumUptimeMinutes) * time.Minute,
 		resumeAt:                 now, // Initialize to creation time
+		timeline:                 timeline,
+		sessionInput:             getSessionInputTime,
+		inputSeen:                make(map[uint32]int64),
+		inputSkew:                make(map[uint32]backwardSkew),
+	}
+}
+
+// tickSource reads the wall clock and GetTickCount64, which is not affected by changes of
+// the system time and keeps counting while the VM hibernates
+type tickSource struct{}
+
+func (tickSource) Wall() time.Time { return time.Now() }
+
+func (tickSource) Ticks() time.Duration {
+	uptime, _ := GetSystemUptime() // GetTickCount64 cannot fail
+	return uptime
+}
+
+// now returns the current time on the idle timeline. If the wall clock jumped, the timers
+// are re-baselined first. Callers must hold m.mu.
+func (m *IdleMonitor) now() time.Time {
+	now, jump := m.timeline.Now()
+	if jump != 0 {
+		m.rebaseline(jump)
+	}
+	return now
+}
+
+// rebaseline shifts the idle timers by a wall clock jump, so each keeps the time it has run
+// and matches the wall clock again
+func (m *IdleMonitor) rebaseline(jump time.Duration) {
+	shift := func(t *time.Time) *time.Time {
+		if t == nil {
+			return nil
+		}
+		shifted := t.Add(jump)
+		return &shifted
+	}
+	m.state.NoUsersIdleSince = shift(m.state.NoUsersIdleSince)
+	m.state.AllDisconnectedSince = shift(m.state.AllDisconnectedSince)
+	m.state.WarningIssuedAt = shift(m.state.WarningIssuedAt)
+	m.state.RearmedAt = shift(m.state.RearmedAt)
+	m.state.LastActivityTime = m.state.LastActivityTime.Add(jump)
+	m.resumeAt = m.resumeAt.Add(jump)
+
+	// Session idle time is the wall clock time since the last input, so a forward jump makes it
+	// look longer: the inactive user timer counts at most from the last input seen before the jump
+	if jump > 0 && (m.state.RearmedAt == nil || m.state.RearmedAt.Before(m.state.LastActivityTime)) {
+		lastActivity := m.state.LastActivityTime
+		m.state.RearmedAt = &lastActivity
+	}
+
+	// A backward jump makes it look shorter, or puts the last input ahead of the clock: add the
+	// jump back for each session until it sees new input
+	for sessionId, lastInput := range m.inputSeen {
+		skew := m.inputSkew[sessionId]
+		if skew.lastInput != lastInput {
+			skew = backwardSkew{lastInput: lastInput}
+		}
+		skew.amount -= jump
+		if skew.amount > 0 {
+			m.inputSkew[sessionId] = skew
+		} else {
+			delete(m.inputSkew, sessionId)
+		}
+	}
+	m.jumped += jump
+}
+
+// sessionIdle returns the time since the last input of a session, corrected for backward
+// wall clock jumps since that input. Callers must hold m.mu and call m.now() first.
+func (m *IdleMonitor) sessionIdle(sessionId uint32) (time.Duration, error) {
+	lastInput, current, err := m.sessionInput(sessionId)
+	if err != nil {
+		return 0, err
+	}
+	m.inputSeen[sessionId] = lastInput
+
+	idle := filetimeDuration(current - lastInput)
+	if skew, ok := m.inputSkew[sessionId]; ok {
+		if skew.lastInput == lastInput {
+			idle += skew.amount
+		} else {
+			delete(m.inputSkew, sessionId) // New input since the jump
+		}
+	}
+	// Input made after the jump but stamped before it cannot be told apart; count it as recent
+	return max(idle, 0), nil
+}
+
+// inactiveFor returns how long the inactive user timer has run, given the idle time of the
+// most recently active session. After a veto or a forward clock jump the timer counts from
+// RearmedAt, so the user must be idle for the full threshold again. Callers must hold m.mu.
+func (m *IdleMonitor) inactiveFor(sessionIdle time.Duration, now time.Time) time.Duration {
+	if m.state.RearmedAt != nil {
+		if sinceRearm := max(now.Sub(*m.state.RearmedAt), 0); sinceRearm < sessionIdle {
+			return sinceRearm
+		}
+	}
+	return sessionIdle
+}
+
+// forgetSessions drops the input times of sessions that have ended. Callers must hold m.mu.
+func (m *IdleMonitor) forgetSessions(sessions []SessionInfo) {
+	current := make(map[uint32]bool, len(sessions))
+	for _, session := range sessions {
+		current[session.SessionId] = true
+	}
+	for sessionId := range m.inputSeen {
+		if !current[sessionId] {
+			delete(m.inputSeen, sessionId)
+			delete(m.inputSkew, sessionId)
+		}
 	}
 }
 
 // SetResumeTime updates the resume timestamp (called on power resume events)
 func (m *IdleMonitor) SetResumeTime(t time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now() // Apply a pending jump first, so it does not shift t
 	m.resumeAt = t
 }
 
+// SetAllDisconnectedWarning sets the warning period of the all disconnected condition.
+// Disconnected users cannot see a notification, so the service emails them instead.
+func (m *IdleMonitor) SetAllDisconnectedWarning(minutes int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.disconnectedWarningPeriod = time.Duration(minutes) * time.Minute
+}
+
 // Logger interface for idle monitor logging
 type Logger interface {
 	Debugf(eventID uint32, format string, args ...interface{})
 	Infof(eventID uint32, format string, args ...interface{})
+	Warningf(eventID uint32, format string, args ...interface{})
 }
 
 // CheckResult represents the result of an idle check
@@ -125,7 +295,7 @@ func (m *IdleMonitor) shouldCancelWarning(sessions []SessionInfo, hasUsers, allD
 					continue
 				}
 
-				sessionIdleTime, err := GetSessionIdleTime(session.SessionId)
+				sessionIdleTime, err := m.sessionIdle(session.SessionId)
 				if err != nil {
 					log.Debugf(logger.EventIdleCheckError, "Failed to get idle time for session %d: %v", session.SessionId, err)
 					continue
@@ -146,7 +316,19 @@ func (m *IdleMonitor) shouldCancelWarning(sessions []SessionInfo, hasUsers, allD
 
 // Check evaluates all idle conditions and returns the check result
 func (m *IdleMonitor) Check(log Logger) (*CheckResult, error) {
-	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	if m.jumped != 0 {
+		direction := "forward"
+		if m.jumped < 0 {
+			direction = "back"
+		}
+		log.Warningf(logger.EventClockJump, "System clock moved %s by %v - idle timers re-baselined, time already idle is kept",
+			direction, m.jumped.Abs().Round(time.Second))
+		m.jumped = 0
+	}
 
 	// Get current sessions
 	sessions, err := GetActiveSessions()
@@ -154,6 +336,7 @@ func (m *IdleMonitor) Check(log Logger) (*CheckResult, error) {
 		return nil, fmt.Errorf("failed to get active sessions: %w", err)
 	}
 	m.state.CurrentSessions = sessions
+	m.forgetSessions(sessions)
 
 	log.Debugf(logger.EventIdleCheckInfo, "Session check: %d session(s) found", len(sessions))
 	for i, session := range sessions {
@@ -284,7 +467,7 @@ func (m *IdleMonitor) Check(log Logger) (*CheckResult, error) {
 				continue
 			}
 
-			sessionIdleTime, err := GetSessionIdleTime(session.SessionId)
+			sessionIdleTime, err := m.sessionIdle(session.SessionId)
 			if err != nil {
 				log.Debugf(logger.EventIdleCheckError, "Failed to get idle time for session %d (%s): %v", session.SessionId, session.Username, err)
 				continue
@@ -298,6 +481,10 @@ func (m *IdleMonitor) Check(log Logger) (*CheckResult, error) {
 			activeSessionCount++
 		}
 
+		if activeSessionCount > 0 {
+			minIdleDuration = m.inactiveFor(minIdleDuration, now)
+		}
+
 		if activeSessionCount == 0 {
 			log.Debugf(logger.EventIdleCheckInfo, "No active sessions to check for input activity")
 		} else {
@@ -338,61 +525,79 @@ func (m *IdleMonitor) Check(log Logger) (*CheckResult, error) {
 	// Idle condition met - determine how to handle based on condition type
 	log.Debugf(logger.EventIdleThresholdMet, "Idle condition triggered: %s (type: %d)", idleReason, idleCondition)
 
-	// Inactive user conditions get a warning period, others hibernate immediately
-	if idleCondition == IdleConditionInactiveUser && m.warningPeriod > 0 {
-		// Inactive user with warning period configured - warn before hibernating
-		if m.state.WarningIssuedAt == nil {
-			// FSM State Transition: None -> Active
-			// Start warning period
-			log.Debugf(logger.EventHibernationWarningStart, "FSM: Transition None -> Active, starting warning period (%v)", m.warningPeriod)
-			m.state.WarningIssuedAt = &now
-			m.state.WarningReason = idleReason
-			m.state.WarningState = WarningStateActive
-			return &CheckResult{
-				Condition:       idleCondition,
-				ShouldWarn:      true,
-				ShouldHibernate: false,
-				Reason:          idleReason,
-				TimeRemaining:   m.warningPeriod,
-			}, nil
-		} else {
-			// Warning already issued - check if warning period expired
-			warnDuration := now.Sub(*m.state.WarningIssuedAt)
-			log.Debugf(logger.EventWarningPeriodActive, "Warning period elapsed: %v / %v", warnDuration.Round(time.Second), m.warningPeriod)
-			if warnDuration >= m.warningPeriod {
-				// FSM State Transition: Active -> Hibernate
-				// Warning period expired, hibernate now
-				log.Debugf(logger.EventHibernationTriggered, "FSM: Warning period expired, proceeding with hibernation")
-				return &CheckResult{
-					Condition:       idleCondition,
-					ShouldWarn:      false,
-					ShouldHibernate: true,
-					Reason:          idleReason,
-					TimeRemaining:   0,
-				}, nil
-			} else {
-				// Still in warning period, maintain Active state
-				timeRemaining := m.warningPeriod - warnDuration
-				log.Debugf(logger.EventWarningPeriodActive, "FSM: Still in Active state, %v remaining", timeRemaining.Round(time.Second))
-				return &CheckResult{
-					Condition:       idleCondition,
-					ShouldWarn:      true,
-					ShouldHibernate: false,
-					Reason:          idleReason,
-					TimeRemaining:   timeRemaining,
-				}, nil
-			}
+	return m.evaluate(idleCondition, idleReason, now, log), nil
+}
+
+// evaluate runs the warning FSM for an idle condition that has been met. Conditions with a
+// warning period are warned before the action; the others trigger the action immediately.
+func (m *IdleMonitor) evaluate(idleCondition IdleCondition, idleReason string, now time.Time, log Logger) *CheckResult {
+	warningPeriod := m.warningPeriodFor(idleCondition)
+	if warningPeriod <= 0 {
+		// No warning period for this condition - hibernate immediately
+		log.Debugf(logger.EventHibernationTriggered, "No warning period for condition %s, hibernating immediately", idleCondition)
+		return &CheckResult{
+			Condition:       idleCondition,
+			ShouldWarn:      false,
+			ShouldHibernate: true,
+			Reason:          idleReason,
+			TimeRemaining:   0,
 		}
-	} else {
-		// No users or all disconnected - hibernate immediately (no one to warn)
-		log.Debugf(logger.EventHibernationTriggered, "No active users to warn, hibernating immediately (condition: %d)", idleCondition)
+	}
+
+	if m.state.WarningIssuedAt == nil {
+		// FSM State Transition: None -> Active
+		// Start warning period
+		log.Debugf(logger.EventHibernationWarningStart, "FSM: Transition None -> Active, starting warning period (%v)", warningPeriod)
+		m.state.WarningIssuedAt = &now
+		m.state.WarningReason = idleReason
+		m.state.WarningState = WarningStateActive
+		return &CheckResult{
+			Condition:       idleCondition,
+			ShouldWarn:      true,
+			ShouldHibernate: false,
+			Reason:          idleReason,
+			TimeRemaining:   warningPeriod,
+		}
+	}
+
+	// Warning already issued - check if warning period expired
+	warnDuration := now.Sub(*m.state.WarningIssuedAt)
+	log.Debugf(logger.EventWarningPeriodActive, "Warning period elapsed: %v / %v", warnDuration.Round(time.Second), warningPeriod)
+	if warnDuration >= warningPeriod {
+		// FSM State Transition: Active -> Hibernate
+		// Warning period expired, hibernate now
+		log.Debugf(logger.EventHibernationTriggered, "FSM: Warning period expired, proceeding with hibernation")
 		return &CheckResult{
 			Condition:       idleCondition,
 			ShouldWarn:      false,
 			ShouldHibernate: true,
 			Reason:          idleReason,
 			TimeRemaining:   0,
-		}, nil
+		}
+	}
+
+	// Still in warning period, maintain Active state
+	timeRemaining := warningPeriod - warnDuration
+	log.Debugf(logger.EventWarningPeriodActive, "FSM: Still in Active state, %v remaining", timeRemaining.Round(time.Second))
+	return &CheckResult{
+		Condition:       idleCondition,
+		ShouldWarn:      true,
+		ShouldHibernate: false,
+		Reason:          idleReason,
+		TimeRemaining:   timeRemaining,
+	}
+}
+
+// warningPeriodFor returns the warning period of an idle condition (0: no warning).
+// Nobody can be warned when no users are logged in.
+func (m *IdleMonitor) warningPeriodFor(condition IdleCondition) time.Duration {
+	switch condition {
+	case IdleConditionInactiveUser:
+		return m.warningPeriod
+	case IdleConditionAllDisconnected:
+		return m.disconnectedWarningPeriod
+	default:
+		return 0
 	}
 }
 
@@ -406,28 +611,63 @@ func (m *IdleMonitor) resetWarning() {
 	m.state.AllDisconnectedSince = nil
 }
 
+// Rearm restarts the idle timer of a condition whose action was vetoed, keeping the rest
+// of the state: the condition must then be met for its full threshold again.
+func (m *IdleMonitor) Rearm(condition IdleCondition, now time.Time) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.now() // Apply a pending jump first, so it does not shift now
+
+	m.state.IdleCondition = IdleConditionNone
+	m.state.WarningIssuedAt = nil
+	m.state.WarningReason = ""
+	m.state.WarningState = WarningStateNone
+
+	switch condition {
+	case IdleConditionNoUsers:
+		m.state.NoUsersIdleSince = &now
+	case IdleConditionAllDisconnected:
+		m.state.AllDisconnectedSince = &now
+	case IdleConditionInactiveUser:
+		m.state.RearmedAt = &now
+	}
+}
+
 // Reset completely resets all idle monitor state
 // This should be called before hibernation to ensure clean state after resume
 func (m *IdleMonitor) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
 	m.state.IdleCondition = IdleConditionNone
 	m.state.WarningIssuedAt = nil
 	m.state.WarningReason = ""
 	m.state.WarningState = WarningStateNone
 	m.state.NoUsersIdleSince = nil
 	m.state.AllDisconnectedSince = nil
-	m.state.LastActivityTime = time.Now()
+	m.state.LastActivityTime = m.now()
 	m.state.CurrentSessions = nil
+	m.state.RearmedAt = nil
 }
 
-// GetState returns the current idle state for debugging/monitoring
+// GetState returns a copy of the current idle state for debugging/monitoring
 func (m *IdleMonitor) GetState() IdleState {
-	return m.state
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	state := m.state
+	state.CurrentSessions = append([]SessionInfo(nil), m.state.CurrentSessions...)
+	return state
 }
 
 // GetTimeUntilThresholds returns the time remaining until each enabled threshold
 // Returns the minimum time until any threshold is reached, or 0 if already exceeded
 func (m *IdleMonitor) GetTimeUntilThresholds() (time.Duration, error) {
-	now := time.Now()
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
 	minTimeUntil := time.Duration(0)
 	hasActiveCondition := false
 
@@ -480,7 +720,7 @@ func (m *IdleMonitor) GetTimeUntilThresholds() (time.Duration, error) {
 					continue
 				}
 
-				sessionIdleTime, err := GetSessionIdleTime(session.SessionId)
+				sessionIdleTime, err := m.sessionIdle(session.SessionId)
 				if err != nil {
 					continue
 				}
@@ -492,7 +732,7 @@ func (m *IdleMonitor) GetTimeUntilThresholds() (time.Duration, error) {
 			}
 
 			if foundSession {
-				timeUntil := m.inactiveUserThreshold - minSessionIdle
+				timeUntil := m.inactiveUserThreshold - m.inactiveFor(minSessionIdle, now)
 				// Clamp to 0 if threshold already exceeded (negative time)
 				if timeUntil < 0 {
 					timeUntil = 0
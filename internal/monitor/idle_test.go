@@ -5,6 +5,8 @@ package monitor
 import (
 	"testing"
 	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/clock"
 )
 
 // mockLogger is a simple logger for testing that captures log messages
@@ -413,6 +415,31 @@ func TestGetTimeUntilThresholds(t *testing.T) {
 	}
 }
 
+// TestGetTimeUntilThresholdsAfterRearm tests that the inactive user timer counts from a veto
+func TestGetTimeUntilThresholdsAfterRearm(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	source := &fakeSource{wall: start, ticks: time.Hour}
+	monitor := NewIdleMonitor(0, 0, 120, 15, 10)
+	monitor.timeline = clock.NewTimeline(source, clockJumpThreshold)
+	monitor.state.CurrentSessions = []SessionInfo{{SessionId: 2, Username: "alice", State: WTSActive, IsActive: true}}
+
+	lastInput := filetime(start.Add(-3 * time.Hour))
+	monitor.sessionInput = func(sessionId uint32) (int64, int64, error) {
+		return lastInput, filetime(source.wall), nil
+	}
+
+	// Idle for three hours, but a hook vetoed the hibernation 30 minutes ago
+	monitor.Rearm(IdleConditionInactiveUser, start.Add(-30*time.Minute))
+
+	got, err := monitor.GetTimeUntilThresholds()
+	if err != nil {
+		t.Fatalf("GetTimeUntilThresholds() error = %v", err)
+	}
+	if got != 90*time.Minute {
+		t.Errorf("GetTimeUntilThresholds() = %v, want 90m", got)
+	}
+}
+
 // TestWarningStateFSM tests the finite state machine transitions
 func TestWarningStateFSM(t *testing.T) {
 	tests := []struct {
@@ -606,3 +633,254 @@ func TestMinimumUptimeBoundary(t *testing.T) {
 func durationPtr(d time.Duration) *time.Duration {
 	return &d
 }
+
+// TestAllDisconnectedWarning tests the warning period of the all disconnected condition
+func TestAllDisconnectedWarning(t *testing.T) {
+	log := &mockLogger{}
+	start := time.Now()
+	reason := "All users disconnected for over 60 minutes"
+
+	// Without a warning period the action runs immediately
+	monitor := NewIdleMonitor(30, 60, 120, 5, 10)
+	result := monitor.evaluate(IdleConditionAllDisconnected, reason, start, log)
+	if !result.ShouldHibernate || result.ShouldWarn {
+		t.Fatalf("evaluate() without warning period = %+v, want immediate hibernation", result)
+	}
+
+	monitor.SetAllDisconnectedWarning(15)
+	steps := []struct {
+		name          string
+		elapsed       time.Duration
+		wantWarn      bool
+		wantHibernate bool
+		wantRemaining time.Duration
+	}{
+		{name: "warning starts", elapsed: 0, wantWarn: true, wantRemaining: 15 * time.Minute},
+		{name: "warning continues", elapsed: 10 * time.Minute, wantWarn: true, wantRemaining: 5 * time.Minute},
+		{name: "warning expires", elapsed: 15 * time.Minute, wantHibernate: true},
+	}
+	for _, step := range steps {
+		result := monitor.evaluate(IdleConditionAllDisconnected, reason, start.Add(step.elapsed), log)
+		if result.ShouldWarn != step.wantWarn || result.ShouldHibernate != step.wantHibernate || result.TimeRemaining != step.wantRemaining {
+			t.Errorf("%s: evaluate() = %+v, want warn=%v hibernate=%v remaining=%v",
+				step.name, result, step.wantWarn, step.wantHibernate, step.wantRemaining)
+		}
+		if result.Condition != IdleConditionAllDisconnected {
+			t.Errorf("%s: Condition = %v, want allDisconnected", step.name, result.Condition)
+		}
+	}
+	if monitor.state.WarningState != WarningStateActive {
+		t.Errorf("WarningState = %v, want Active", monitor.state.WarningState)
+	}
+
+	// The inactive user warning period is unaffected, and no users is never warned
+	if got := monitor.warningPeriodFor(IdleConditionInactiveUser); got != 5*time.Minute {
+		t.Errorf("inactive user warning period = %v, want 5m", got)
+	}
+	if got := monitor.warningPeriodFor(IdleConditionNoUsers); got != 0 {
+		t.Errorf("no users warning period = %v, want 0", got)
+	}
+
+	// Reconnecting cancels the warning
+	monitor.state.IdleCondition = IdleConditionAllDisconnected
+	sessions := []SessionInfo{{SessionId: 2, Username: "alice", State: WTSActive, IsActive: true}}
+	if !monitor.shouldCancelWarning(sessions, true, false, log) {
+		t.Error("shouldCancelWarning() = false after the user reconnected")
+	}
+}
+
+func TestRearm(t *testing.T) {
+	log := &mockLogger{}
+	start := time.Now()
+	monitor := NewIdleMonitor(30, 60, 120, 5, 10)
+	monitor.SetAllDisconnectedWarning(15)
+
+	since := start.Add(-time.Hour)
+	monitor.state.AllDisconnectedSince = &since
+	monitor.evaluate(IdleConditionAllDisconnected, "All users disconnected", start, log)
+	if monitor.state.WarningState != WarningStateActive {
+		t.Fatalf("WarningState = %v, want Active", monitor.state.WarningState)
+	}
+
+	vetoedAt := start.Add(15 * time.Minute)
+	monitor.Rearm(IdleConditionAllDisconnected, vetoedAt)
+
+	if monitor.state.WarningState != WarningStateNone || monitor.state.WarningIssuedAt != nil || monitor.state.IdleCondition != IdleConditionNone {
+		t.Errorf("warning state not cleared: %+v", monitor.state)
+	}
+	if monitor.state.AllDisconnectedSince == nil || !monitor.state.AllDisconnectedSince.Equal(vetoedAt) {
+		t.Errorf("AllDisconnectedSince = %v, want %v", monitor.state.AllDisconnectedSince, vetoedAt)
+	}
+
+	// The timers of other conditions are kept
+	noUsers := start.Add(-10 * time.Minute)
+	monitor.state.NoUsersIdleSince = &noUsers
+	monitor.Rearm(IdleConditionInactiveUser, vetoedAt)
+	if monitor.state.RearmedAt == nil || !monitor.state.RearmedAt.Equal(vetoedAt) {
+		t.Errorf("RearmedAt = %v, want %v", monitor.state.RearmedAt, vetoedAt)
+	}
+	if monitor.state.NoUsersIdleSince != &noUsers {
+		t.Error("Rearm(inactiveUser) changed NoUsersIdleSince")
+	}
+
+	monitor.Rearm(IdleConditionNoUsers, vetoedAt)
+	if !monitor.state.NoUsersIdleSince.Equal(vetoedAt) {
+		t.Errorf("NoUsersIdleSince = %v, want %v", monitor.state.NoUsersIdleSince, vetoedAt)
+	}
+
+	monitor.Reset()
+	if monitor.state.RearmedAt != nil {
+		t.Error("Reset() kept RearmedAt")
+	}
+}
+
+// fakeSource is a clock source whose wall clock and tick count are set by the test
+type fakeSource struct {
+	wall  time.Time
+	ticks time.Duration
+}
+
+func (f *fakeSource) Wall() time.Time      { return f.wall }
+func (f *fakeSource) Ticks() time.Duration { return f.ticks }
+
+// TestClockJump tests that a wall clock jump neither fires nor stretches the idle timers
+func TestClockJump(t *testing.T) {
+	tests := []struct {
+		name string
+		jump time.Duration
+	}{
+		{name: "forward after resume", jump: 3 * time.Hour},
+		{name: "back", jump: -2 * time.Hour},
+		{name: "within threshold", jump: 20 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &mockLogger{}
+			start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+			source := &fakeSource{wall: start, ticks: time.Hour}
+			monitor := NewIdleMonitor(30, 60, 120, 15, 10)
+			monitor.timeline = clock.NewTimeline(source, clockJumpThreshold)
+			monitor.resumeAt = start
+			monitor.state.LastActivityTime = start
+			monitor.state.NoUsersIdleSince = &start
+			monitor.state.WarningIssuedAt = &start
+			monitor.state.WarningState = WarningStateActive
+
+			// Ten minutes pass, and the wall clock jumps meanwhile
+			source.ticks += 10 * time.Minute
+			source.wall = start.Add(10*time.Minute + tt.jump)
+
+			remaining, err := monitor.GetTimeUntilThresholds()
+			if err != nil {
+				t.Fatalf("GetTimeUntilThresholds() error = %v", err)
+			}
+			if remaining != 20*time.Minute {
+				t.Errorf("GetTimeUntilThresholds() = %v, want 20m", remaining)
+			}
+
+			result := monitor.evaluate(IdleConditionInactiveUser, "No activity detected for over 120 minutes", monitor.now(), log)
+			if !result.ShouldWarn || result.ShouldHibernate || result.TimeRemaining != 5*time.Minute {
+				t.Errorf("evaluate() = %+v, want a warning with 5m remaining", result)
+			}
+
+			if tt.jump.Abs() <= clockJumpThreshold {
+				if monitor.jumped != 0 {
+					t.Errorf("jumped = %v, want no jump within the threshold", monitor.jumped)
+				}
+				return
+			}
+			if monitor.jumped != tt.jump {
+				t.Errorf("jumped = %v, want %v", monitor.jumped, tt.jump)
+			}
+			// The timers match the new wall clock
+			if want := start.Add(tt.jump); !monitor.state.NoUsersIdleSince.Equal(want) || !monitor.resumeAt.Equal(want) {
+				t.Errorf("NoUsersIdleSince = %v, resumeAt = %v, want %v", monitor.state.NoUsersIdleSince, monitor.resumeAt, want)
+			}
+			// Session idle times include a forward jump, so the inactive user timer is capped at the last input seen
+			if rearmed := monitor.state.RearmedAt != nil; rearmed != (tt.jump > 0) {
+				t.Errorf("RearmedAt = %v after a jump of %v", monitor.state.RearmedAt, tt.jump)
+			}
+		})
+	}
+}
+
+// TestSetResumeTimeAfterJump tests that a pending clock jump does not shift a new resume time
+func TestSetResumeTimeAfterJump(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	source := &fakeSource{wall: start, ticks: time.Hour}
+	monitor := NewIdleMonitor(30, 60, 120, 15, 10)
+	monitor.timeline = clock.NewTimeline(source, clockJumpThreshold)
+
+	// The VM resumes with a clock an hour behind, and the service records the resume
+	source.ticks += 2 * time.Hour
+	source.wall = start.Add(time.Hour)
+	monitor.SetResumeTime(source.wall)
+
+	// The next check reads the timeline again
+	source.ticks += time.Minute
+	source.wall = source.wall.Add(time.Minute)
+	monitor.mu.Lock()
+	monitor.now()
+	monitor.mu.Unlock()
+
+	if want := start.Add(time.Hour); !monitor.resumeAt.Equal(want) {
+		t.Errorf("resumeAt = %v, want %v", monitor.resumeAt, want)
+	}
+}
+
+// filetime converts t to a FILETIME, as WTS reports session times
+func filetime(t time.Time) int64 {
+	return t.UnixNano()/100 + 116444736000000000
+}
+
+// TestSessionIdleAfterBackwardJump tests that a backward clock jump neither shortens the idle
+// time of a session nor drops it for having its last input ahead of the clock
+func TestSessionIdleAfterBackwardJump(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	source := &fakeSource{wall: start, ticks: time.Hour}
+	monitor := NewIdleMonitor(30, 60, 120, 15, 10)
+	monitor.timeline = clock.NewTimeline(source, clockJumpThreshold)
+
+	lastInput := filetime(start.Add(-30 * time.Minute))
+	monitor.sessionInput = func(sessionId uint32) (int64, int64, error) {
+		return lastInput, filetime(source.wall), nil
+	}
+	idle := func() time.Duration {
+		t.Helper()
+		monitor.mu.Lock()
+		defer monitor.mu.Unlock()
+		monitor.now()
+		d, err := monitor.sessionIdle(2)
+		if err != nil {
+			t.Fatalf("sessionIdle() error = %v", err)
+		}
+		return d
+	}
+
+	if got := idle(); got != 30*time.Minute {
+		t.Fatalf("sessionIdle() = %v, want 30m", got)
+	}
+
+	// Ten minutes pass and the clock moves back two hours, putting the last input ahead of it
+	source.ticks += 10 * time.Minute
+	source.wall = start.Add(10*time.Minute - 2*time.Hour)
+	if got := idle(); got != 40*time.Minute {
+		t.Errorf("sessionIdle() after the jump = %v, want 40m", got)
+	}
+
+	// The correction holds until the user gives input
+	source.ticks += 5 * time.Minute
+	source.wall = source.wall.Add(5 * time.Minute)
+	if got := idle(); got != 45*time.Minute {
+		t.Errorf("sessionIdle() = %v, want 45m", got)
+	}
+
+	lastInput = filetime(source.wall.Add(-time.Minute))
+	if got := idle(); got != time.Minute {
+		t.Errorf("sessionIdle() after new input = %v, want 1m", got)
+	}
+	if len(monitor.inputSkew) != 0 {
+		t.Errorf("inputSkew = %v, want it cleared by new input", monitor.inputSkew)
+	}
+}
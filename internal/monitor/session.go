@@ -31,6 +31,7 @@ const (
 type SessionInfo struct {
 	SessionId      uint32
 	Username       string
+	Domain         string // Logon domain (or computer name for local accounts)
 	State          uint32
 	IsActive       bool
 	IsDisconnected bool
@@ -54,6 +55,7 @@ type WTS_SESSION_INFO struct {
 
 const (
 	WTSUserName    = 5
+	WTSDomainName  = 7
 	WTSSessionInfo = 24
 )
 
@@ -80,6 +82,19 @@ type WTSINFO struct {
 // GetSessionIdleTime returns the idle time for a specific session
 // Returns the duration since last input for that session
 func GetSessionIdleTime(sessionId uint32) (time.Duration, error) {
+	lastInput, current, err := getSessionInputTime(sessionId)
+	if err != nil {
+		return 0, err
+	}
+	if lastInput > current {
+		return 0, fmt.Errorf("session %d has LastInputTime > CurrentTime", sessionId)
+	}
+	return filetimeDuration(current - lastInput), nil
+}
+
+// getSessionInputTime returns the last input time of a session and the current time, as
+// FILETIMEs (100-nanosecond intervals since 1601) on the wall clock
+func getSessionInputTime(sessionId uint32) (lastInput, current int64, err error) {
 	var buffer *WTSINFO
 	var bytesReturned uint32
 
@@ -92,25 +107,20 @@ func GetSessionIdleTime(sessionId uint32) (time.Duration, error) {
 	)
 
 	if ret == 0 {
-		return 0, fmt.Errorf("WTSQuerySessionInformation failed for session %d: %v", sessionId, err)
+		return 0, 0, fmt.Errorf("WTSQuerySessionInformation failed for session %d: %v", sessionId, err)
 	}
 	defer procWTSFreeMemory.Call(uintptr(unsafe.Pointer(buffer)))
 
-	// Validate that we got meaningful data
 	// CurrentTime should be non-zero (it's a timestamp since 1601)
-	// LastInputTime should be <= CurrentTime
 	if buffer.CurrentTime == 0 {
-		return 0, fmt.Errorf("session %d returned invalid CurrentTime (0)", sessionId)
+		return 0, 0, fmt.Errorf("session %d returned invalid CurrentTime (0)", sessionId)
 	}
-	if buffer.LastInputTime > buffer.CurrentTime {
-		return 0, fmt.Errorf("session %d has LastInputTime > CurrentTime", sessionId)
-	}
-
-	// CurrentTime and LastInputTime are in FILETIME format (100-nanosecond intervals since 1601)
-	// Calculate idle time
-	idleTime := time.Duration(buffer.CurrentTime-buffer.LastInputTime) * 100 * time.Nanosecond
+	return buffer.LastInputTime, buffer.CurrentTime, nil
+}
 
-	return idleTime, nil
+// filetimeDuration converts a difference of FILETIMEs to a duration
+func filetimeDuration(d int64) time.Duration {
+	return time.Duration(d) * 100 * time.Nanosecond
 }
 
 // GetActiveSessions returns information about all user sessions (Active or Disconnected state only)
@@ -149,14 +159,17 @@ func GetActiveSessions() ([]SessionInfo, error) {
 			continue
 		}
 
-		username, err := getSessionUsername(session.SessionId)
+		username, err := querySessionString(session.SessionId, WTSUserName)
 		if err != nil || username == "" {
 			continue
 		}
+		// The domain is only used to look up email addresses, so a failure is not fatal
+		domain, _ := querySessionString(session.SessionId, WTSDomainName)
 
 		info := SessionInfo{
 			SessionId:      session.SessionId,
 			Username:       username,
+			Domain:         domain,
 			State:          session.State,
 			IsActive:       session.State == WTSActive,
 			IsDisconnected: session.State == WTSDisconnected,
@@ -168,14 +181,15 @@ func GetActiveSessions() ([]SessionInfo, error) {
 	return sessions, nil
 }
 
-func getSessionUsername(sessionId uint32) (string, error) {
+// querySessionString returns a string property of a session, such as WTSUserName
+func querySessionString(sessionId uint32, infoClass uintptr) (string, error) {
 	var buffer *uint16
 	var bytesReturned uint32
 
 	ret, _, err := procWTSQuerySessionInfo.Call(
 		WTS_CURRENT_SERVER_HANDLE,
 		uintptr(sessionId),
-		WTSUserName,
+		infoClass,
 		uintptr(unsafe.Pointer(&buffer)),
 		uintptr(unsafe.Pointer(&bytesReturned)),
 	)
@@ -3,16 +3,21 @@
 package pipe
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io"
 	"sync"
 	"time"
 
 	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
 	"golang.org/x/sys/windows"
 )
 
+// ioTimeout bounds writing a command and reading the response, so a notifier that stopped
+// reading its pipe cannot hold up the service
+const ioTimeout = 10 * time.Second
+
 // Server represents a named pipe server that sends commands to notifiers
 type Server struct {
 	pipeName string
@@ -30,6 +35,22 @@ func NewServer(sessionID int, logger logger.Logger) *Server {
 
 // SendCommand sends a command to the notifier and waits for a response
 func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
+	return s.SendCommandContext(context.Background(), cmd)
+}
+
+// SendCommandContext is SendCommand as part of the operation in ctx: it is traced under the
+// operation's span, and stops waiting for the pipe when ctx is canceled or after ioTimeout
+func (s *Server) SendCommandContext(ctx context.Context, cmd NotifyCommand) (response *NotifyResponse, err error) {
+	_, span := tracing.Start(ctx, "pipe send", tracing.String("pipe.name", s.pipeName), tracing.String("command.type", string(cmd.Type)))
+	retries := 0
+	defer func() {
+		span.SetAttributes(tracing.Int("retry.count", retries))
+		if response != nil {
+			span.SetAttributes(tracing.String("response.status", string(response.Status)))
+		}
+		span.End(err)
+	}()
+
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
@@ -58,19 +79,23 @@ func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
 			0,
 			nil,
 			windows.OPEN_EXISTING,
-			windows.FILE_ATTRIBUTE_NORMAL,
+			windows.FILE_ATTRIBUTE_NORMAL|windows.FILE_FLAG_OVERLAPPED,
 			0,
 		)
 
 		if err == nil {
 			break
 		}
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("gave up opening the pipe: %w", ctx.Err())
+		}
 
 		// Check if it's a "file not found" error (pipe doesn't exist)
 		if err == windows.ERROR_FILE_NOT_FOUND {
 			if time.Now().After(deadline) {
 				return nil, fmt.Errorf("notifier not available (pipe not found)")
 			}
+			retries++
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
@@ -81,6 +106,7 @@ func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
 				return nil, fmt.Errorf("notifier busy (timeout waiting for pipe)")
 			}
 			// Wait a bit and retry
+			retries++
 			time.Sleep(100 * time.Millisecond)
 			continue
 		}
@@ -98,8 +124,12 @@ func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
 	// Add newline delimiter
 	cmdBytes = append(cmdBytes, '\n')
 
-	var written uint32
-	err = windows.WriteFile(handle, cmdBytes, &written, nil)
+	ioCtx, cancel := context.WithTimeout(ctx, ioTimeout)
+	defer cancel()
+
+	written, err := overlappedIO(ioCtx, handle, func(overlapped *windows.Overlapped) error {
+		return windows.WriteFile(handle, cmdBytes, nil, overlapped)
+	})
 	if err != nil {
 		return nil, fmt.Errorf("failed to write to pipe: %w", err)
 	}
@@ -108,9 +138,10 @@ func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
 
 	// Read response
 	buf := make([]byte, 4096)
-	var read uint32
-	err = windows.ReadFile(handle, buf, &read, nil)
-	if err != nil && err != io.EOF {
+	read, err := overlappedIO(ioCtx, handle, func(overlapped *windows.Overlapped) error {
+		return windows.ReadFile(handle, buf, nil, overlapped)
+	})
+	if err != nil && err != windows.ERROR_BROKEN_PIPE {
 		return nil, fmt.Errorf("failed to read from pipe: %w", err)
 	}
 
@@ -121,17 +152,55 @@ func (s *Server) SendCommand(cmd NotifyCommand) (*NotifyResponse, error) {
 	s.logger.Debugf(1, "Received %d bytes from notifier", read)
 
 	// Parse response
-	var response NotifyResponse
-	err = json.Unmarshal(buf[:read], &response)
+	response = &NotifyResponse{}
+	err = json.Unmarshal(buf[:read], response)
 	if err != nil {
 		return nil, fmt.Errorf("failed to unmarshal response: %w", err)
 	}
 
 	if response.Status == ResponseError {
-		return &response, fmt.Errorf("notifier error: %s", response.Error)
+		return response, fmt.Errorf("notifier error: %s", response.Error)
+	}
+
+	return response, nil
+}
+
+// overlappedIO starts an overlapped read or write on handle and waits for it to complete.
+// The operation is canceled with CancelIoEx when ctx ends, and ctx.Err() is returned.
+func overlappedIO(ctx context.Context, handle windows.Handle, start func(*windows.Overlapped) error) (uint32, error) {
+	event, err := windows.CreateEvent(nil, 1, 0, nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create event: %w", err)
+	}
+	defer windows.CloseHandle(event)
+	overlapped := &windows.Overlapped{HEvent: event}
+
+	if err := start(overlapped); err != nil && err != windows.ERROR_IO_PENDING {
+		return 0, err
 	}
 
-	return &response, nil
+	// Cancel the operation if ctx ends first. The goroutine has exited before the caller
+	// can close the handle, so it never cancels I/O on a reused handle.
+	completed := make(chan struct{})
+	exited := make(chan struct{})
+	go func() {
+		defer close(exited)
+		select {
+		case <-ctx.Done():
+			windows.CancelIoEx(handle, overlapped)
+		case <-completed:
+		}
+	}()
+
+	var n uint32
+	err = windows.GetOverlappedResult(handle, overlapped, &n, true)
+	close(completed)
+	<-exited
+
+	if err == windows.ERROR_OPERATION_ABORTED && ctx.Err() != nil {
+		return n, ctx.Err()
+	}
+	return n, err
 }
 
 // SendCommandNoWait sends a command without waiting for a response
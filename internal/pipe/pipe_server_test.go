@@ -0,0 +1,58 @@
+//go:build windows
+
+package pipe
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"golang.org/x/sys/windows"
+)
+
+// TestSendCommandUnresponsiveNotifier checks that a notifier that accepts the connection but
+// never reads or replies cannot hold up the caller past its context
+func TestSendCommandUnresponsiveNotifier(t *testing.T) {
+	name := fmt.Sprintf(`\\.\pipe\azureautohibernate-test-%d`, os.Getpid())
+	path, err := windows.UTF16PtrFromString(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	handle, err := windows.CreateNamedPipe(
+		path,
+		windows.PIPE_ACCESS_DUPLEX,
+		windows.PIPE_TYPE_BYTE|windows.PIPE_READMODE_BYTE|windows.PIPE_WAIT,
+		1,
+		4096,
+		4096,
+		0,
+		nil,
+	)
+	if err != nil {
+		t.Fatalf("failed to create named pipe: %v", err)
+	}
+	defer windows.CloseHandle(handle)
+
+	server := &Server{pipeName: name, logger: logger.NewConsoleLogger(logger.LevelError)}
+	ctx, cancel := context.WithTimeout(context.Background(), 500*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	_, err = server.SendCommandContext(ctx, NotifyCommand{Type: CommandPing})
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SendCommandContext() = %v, want the context deadline", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("SendCommandContext() returned after %v, want soon after the 500ms deadline", elapsed)
+	}
+
+	// The server is free for the next command
+	if !server.mu.TryLock() {
+		t.Fatal("server still locked after the command gave up")
+	}
+	server.mu.Unlock()
+}
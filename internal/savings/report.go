@@ -0,0 +1,217 @@
+package savings
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Period is a span during which the VM was stopped by auto-hibernation
+type Period struct {
+	Start  time.Time
+	End    time.Time
+	Action string
+	VMSize string
+	Region string
+}
+
+// Totals are the hibernated hours and estimated savings over a span of time
+type Totals struct {
+	HibernatedHours float64 `json:"hibernatedHours"`
+	Savings         float64 `json:"savings"`       // Estimated compute cost saved
+	UnpricedHours   float64 `json:"unpricedHours"` // Hours whose VM size or region is missing from the price table
+}
+
+// DayTotal are the totals for one calendar day
+type DayTotal struct {
+	Date string `json:"date"` // YYYY-MM-DD
+	Totals
+}
+
+// MonthTotal are the totals for one calendar month
+type MonthTotal struct {
+	Month string `json:"month"` // YYYY-MM
+	Totals
+}
+
+// Report summarizes the savings over a range of time
+type Report struct {
+	Currency     string       `json:"currency"`
+	PricesAsOf   string       `json:"pricesAsOf,omitempty"`
+	From         time.Time    `json:"from"`
+	To           time.Time    `json:"to"`
+	Hibernations int          `json:"hibernations"` // Stop periods overlapping the range
+	Total        Totals       `json:"total"`
+	Days         []DayTotal   `json:"days"`
+	Months       []MonthTotal `json:"months"`
+}
+
+// Periods pairs each stop record with the next resume record. A stop that is
+// followed by another stop, or not followed by a resume, has no known end and is skipped.
+func Periods(records []Record) []Period {
+	var periods []Period
+	var open *Record
+	for i := range records {
+		r := records[i]
+		switch r.Type {
+		case RecordStopped:
+			open = &records[i]
+		case RecordResumed:
+			if open != nil && r.Time.After(open.Time) {
+				periods = append(periods, Period{
+					Start:  open.Time,
+					End:    r.Time,
+					Action: open.Action,
+					VMSize: open.VMSize,
+					Region: open.Region,
+				})
+			}
+			open = nil
+		}
+	}
+	return periods
+}
+
+// Compute builds the savings report for periods overlapping [from, to), splitting
+// hours by calendar day and month in loc
+func Compute(records []Record, prices *PriceTable, from, to time.Time, loc *time.Location) *Report {
+	report := &Report{From: from, To: to, Days: []DayTotal{}, Months: []MonthTotal{}}
+	if prices != nil {
+		report.Currency = prices.Currency
+		report.PricesAsOf = prices.Updated
+	}
+
+	days := make(map[string]*Totals)
+	months := make(map[string]*Totals)
+
+	add := func(totals map[string]*Totals, key string, hours, price float64, priced bool) {
+		t, ok := totals[key]
+		if !ok {
+			t = &Totals{}
+			totals[key] = t
+		}
+		t.add(hours, price, priced)
+	}
+
+	for _, p := range Periods(records) {
+		start, end := p.Start, p.End
+		if start.Before(from) {
+			start = from
+		}
+		if end.After(to) {
+			end = to
+		}
+		if !end.After(start) {
+			continue
+		}
+		report.Hibernations++
+
+		price, priced := prices.HourlyPrice(p.Region, p.VMSize)
+
+		// Split the period at local midnight so each day gets its own hours
+		for segStart := start; segStart.Before(end); {
+			local := segStart.In(loc)
+			nextDay := time.Date(local.Year(), local.Month(), local.Day()+1, 0, 0, 0, 0, loc)
+			segEnd := end
+			if nextDay.Before(segEnd) {
+				segEnd = nextDay
+			}
+			hours := segEnd.Sub(segStart).Hours()
+
+			add(days, local.Format("2006-01-02"), hours, price, priced)
+			add(months, local.Format("2006-01"), hours, price, priced)
+			report.Total.add(hours, price, priced)
+
+			segStart = segEnd
+		}
+	}
+
+	for _, key := range sortedKeys(days) {
+		report.Days = append(report.Days, DayTotal{Date: key, Totals: days[key].rounded()})
+	}
+	for _, key := range sortedKeys(months) {
+		report.Months = append(report.Months, MonthTotal{Month: key, Totals: months[key].rounded()})
+	}
+	report.Total = report.Total.rounded()
+	return report
+}
+
+// add accumulates hours at the given hourly price
+func (t *Totals) add(hours, price float64, priced bool) {
+	t.HibernatedHours += hours
+	if priced {
+		t.Savings += hours * price
+	} else {
+		t.UnpricedHours += hours
+	}
+}
+
+// rounded returns the totals rounded to two decimals
+func (t Totals) rounded() Totals {
+	return Totals{
+		HibernatedHours: round2(t.HibernatedHours),
+		Savings:         round2(t.Savings),
+		UnpricedHours:   round2(t.UnpricedHours),
+	}
+}
+
+func round2(v float64) float64 {
+	return math.Round(v*100) / 100
+}
+
+// sortedKeys returns the date keys of totals in chronological order
+func sortedKeys(totals map[string]*Totals) []string {
+	keys := make([]string, 0, len(totals))
+	for key := range totals {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// WriteJSON writes the report as indented JSON
+func (r *Report) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteCSV writes one row per day, per month and for the total
+func (r *Report) WriteCSV(w io.Writer) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write([]string{"period", "key", "hibernatedHours", "savings", "unpricedHours", "currency"}); err != nil {
+		return err
+	}
+
+	row := func(period, key string, t Totals) []string {
+		return []string{
+			period,
+			key,
+			strconv.FormatFloat(t.HibernatedHours, 'f', 2, 64),
+			strconv.FormatFloat(t.Savings, 'f', 2, 64),
+			strconv.FormatFloat(t.UnpricedHours, 'f', 2, 64),
+			r.Currency,
+		}
+	}
+
+	for _, d := range r.Days {
+		if err := cw.Write(row("day", d.Date, d.Totals)); err != nil {
+			return err
+		}
+	}
+	for _, m := range r.Months {
+		if err := cw.Write(row("month", m.Month, m.Totals)); err != nil {
+			return err
+		}
+	}
+	if err := cw.Write(row("total", "", r.Total)); err != nil {
+		return err
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
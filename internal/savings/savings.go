@@ -0,0 +1,182 @@
+// Package savings records when the VM is stopped by auto-hibernation and when it
+// resumes, and estimates the compute cost saved from a local price table.
+package savings
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Default file names. The ledger is kept under ProgramData, the price table next to the executable.
+const (
+	LedgerFileName     = "savings.jsonl" // Append-only ledger of stop and resume records
+	PriceTableFileName = "prices.json"   // Hourly compute prices by region and VM size
+
+	// dirName is the folder under ProgramData holding the ledger
+	dirName = "AzureAutoHibernate"
+)
+
+// Record types
+const (
+	RecordStopped = "stopped" // The VM was hibernated or deallocated
+	RecordResumed = "resumed" // The VM is running again
+)
+
+// Record is a ledger entry
+type Record struct {
+	Type   string    `json:"type"`             // stopped or resumed
+	Time   time.Time `json:"time"`             // When the VM stopped or resumed
+	Action string    `json:"action,omitempty"` // Action that stopped the VM (hibernate or deallocate)
+	VMSize string    `json:"vmSize,omitempty"` // VM size while stopped (e.g. Standard_D4s_v5)
+	Region string    `json:"region,omitempty"` // Azure region (e.g. westeurope)
+}
+
+// Ledger is an append-only JSON Lines file of stop and resume records
+type Ledger struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewLedger returns a ledger stored at path
+func NewLedger(path string) *Ledger {
+	return &Ledger{path: path}
+}
+
+// DefaultPath returns the path of the named file next to the executable
+func DefaultPath(name string) (string, error) {
+	exePath, err := os.Executable()
+	if err != nil {
+		return "", fmt.Errorf("failed to get executable path: %w", err)
+	}
+	return filepath.Join(filepath.Dir(exePath), name), nil
+}
+
+// DataPath returns the path of the named file under ProgramData, where the service keeps
+// state that must survive updates
+func DataPath(name string) string {
+	programData := os.Getenv("ProgramData")
+	if programData == "" {
+		programData = `C:\ProgramData`
+	}
+	return filepath.Join(programData, dirName, name)
+}
+
+// Path returns the ledger file path
+func (l *Ledger) Path() string {
+	return l.path
+}
+
+// Append adds a record to the ledger
+func (l *Ledger) Append(r Record) error {
+	data, err := json.Marshal(r)
+	if err != nil {
+		return fmt.Errorf("failed to encode savings record: %w", err)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(l.path), 0755); err != nil {
+		return fmt.Errorf("failed to create savings folder: %w", err)
+	}
+	f, err := os.OpenFile(l.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open savings ledger: %w", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write savings ledger: %w", err)
+	}
+	return nil
+}
+
+// Records returns all records in the ledger. A missing ledger has no records;
+// malformed lines (e.g. a partial write) are skipped.
+func (l *Ledger) Records() ([]Record, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, err := os.Open(l.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open savings ledger: %w", err)
+	}
+	defer f.Close()
+
+	var records []Record
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var r Record
+		if err := json.Unmarshal([]byte(line), &r); err != nil || r.Time.IsZero() {
+			continue
+		}
+		records = append(records, r)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read savings ledger: %w", err)
+	}
+	return records, nil
+}
+
+// Stopped reports whether the last record is a stop without a matching resume
+func (l *Ledger) Stopped() (bool, error) {
+	records, err := l.Records()
+	if err != nil {
+		return false, err
+	}
+	return len(records) > 0 && records[len(records)-1].Type == RecordStopped, nil
+}
+
+// PriceTable holds hourly compute prices. It is a local file so it can be
+// updated without a new release (e.g. from the Azure Retail Prices API).
+type PriceTable struct {
+	Currency string                        `json:"currency"` // e.g. USD
+	Updated  string                        `json:"updated"`  // When the prices were last refreshed
+	Regions  map[string]map[string]float64 `json:"regions"`  // Region -> VM size -> hourly compute price
+}
+
+// LoadPriceTable reads a price table from a JSON file
+func LoadPriceTable(path string) (*PriceTable, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read price table: %w", err)
+	}
+
+	var table PriceTable
+	if err := json.Unmarshal(data, &table); err != nil {
+		return nil, fmt.Errorf("failed to parse price table: %w", err)
+	}
+	return &table, nil
+}
+
+// HourlyPrice returns the hourly compute price of a VM size in a region,
+// matching names case-insensitively
+func (p *PriceTable) HourlyPrice(region, vmSize string) (float64, bool) {
+	if p == nil {
+		return 0, false
+	}
+	for r, sizes := range p.Regions {
+		if !strings.EqualFold(r, region) {
+			continue
+		}
+		for size, price := range sizes {
+			if strings.EqualFold(size, vmSize) {
+				return price, true
+			}
+		}
+	}
+	return 0, false
+}
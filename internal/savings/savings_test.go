@@ -0,0 +1,248 @@
+package savings
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var testPrices = &PriceTable{
+	Currency: "USD",
+	Updated:  "2025-06-01",
+	Regions: map[string]map[string]float64{
+		"westeurope": {"Standard_D4s_v5": 0.25},
+	},
+}
+
+// at returns a UTC time on June 2025
+func at(day, hour, minute int) time.Time {
+	return time.Date(2025, 6, day, hour, minute, 0, 0, time.UTC)
+}
+
+func stopped(t time.Time) Record {
+	return Record{Type: RecordStopped, Time: t, Action: "hibernate", VMSize: "Standard_D4s_v5", Region: "westeurope"}
+}
+
+func resumed(t time.Time) Record {
+	return Record{Type: RecordResumed, Time: t}
+}
+
+// TestPeriods tests pairing stop and resume records
+func TestPeriods(t *testing.T) {
+	records := []Record{
+		resumed(at(1, 7, 0)),  // resume without a stop is ignored
+		stopped(at(1, 18, 0)), // 18:00 - 08:00 next day
+		resumed(at(2, 8, 0)),
+		stopped(at(2, 18, 0)), // no resume before the next stop: skipped
+		stopped(at(2, 20, 0)),
+		resumed(at(2, 21, 0)),
+		stopped(at(3, 18, 0)), // still open: skipped
+	}
+
+	periods := Periods(records)
+	if len(periods) != 2 {
+		t.Fatalf("Periods() = %d periods, want 2: %+v", len(periods), periods)
+	}
+	if !periods[0].Start.Equal(at(1, 18, 0)) || !periods[0].End.Equal(at(2, 8, 0)) {
+		t.Errorf("periods[0] = %v - %v", periods[0].Start, periods[0].End)
+	}
+	if !periods[1].Start.Equal(at(2, 20, 0)) || !periods[1].End.Equal(at(2, 21, 0)) {
+		t.Errorf("periods[1] = %v - %v", periods[1].Start, periods[1].End)
+	}
+}
+
+// TestCompute tests splitting hibernated hours by day and month and pricing them
+func TestCompute(t *testing.T) {
+	unpriced := stopped(at(30, 22, 0))
+	unpriced.VMSize = "Standard_NC6"
+
+	records := []Record{
+		stopped(at(1, 18, 0)), resumed(at(2, 8, 0)), // 6h on the 1st, 8h on the 2nd
+		stopped(at(2, 19, 30)), resumed(at(2, 21, 0)), // 1.5h on the 2nd
+		unpriced, resumed(time.Date(2025, 7, 1, 2, 0, 0, 0, time.UTC)), // 2h in June, 2h in July, unpriced
+	}
+
+	report := Compute(records, testPrices, at(1, 0, 0), time.Date(2025, 8, 1, 0, 0, 0, 0, time.UTC), time.UTC)
+
+	if report.Currency != "USD" || report.PricesAsOf != "2025-06-01" {
+		t.Errorf("Currency = %q, PricesAsOf = %q", report.Currency, report.PricesAsOf)
+	}
+	if report.Hibernations != 3 {
+		t.Errorf("Hibernations = %d, want 3", report.Hibernations)
+	}
+
+	wantDays := []DayTotal{
+		{Date: "2025-06-01", Totals: Totals{HibernatedHours: 6, Savings: 1.5}},
+		{Date: "2025-06-02", Totals: Totals{HibernatedHours: 9.5, Savings: 2.38}},
+		{Date: "2025-06-30", Totals: Totals{HibernatedHours: 2, UnpricedHours: 2}},
+		{Date: "2025-07-01", Totals: Totals{HibernatedHours: 2, UnpricedHours: 2}},
+	}
+	if len(report.Days) != len(wantDays) {
+		t.Fatalf("Days = %+v, want %+v", report.Days, wantDays)
+	}
+	for i, want := range wantDays {
+		if report.Days[i] != want {
+			t.Errorf("Days[%d] = %+v, want %+v", i, report.Days[i], want)
+		}
+	}
+
+	wantMonths := []MonthTotal{
+		{Month: "2025-06", Totals: Totals{HibernatedHours: 17.5, Savings: 3.88, UnpricedHours: 2}},
+		{Month: "2025-07", Totals: Totals{HibernatedHours: 2, UnpricedHours: 2}},
+	}
+	if len(report.Months) != len(wantMonths) {
+		t.Fatalf("Months = %+v, want %+v", report.Months, wantMonths)
+	}
+	for i, want := range wantMonths {
+		if report.Months[i] != want {
+			t.Errorf("Months[%d] = %+v, want %+v", i, report.Months[i], want)
+		}
+	}
+
+	if want := (Totals{HibernatedHours: 19.5, Savings: 3.88, UnpricedHours: 4}); report.Total != want {
+		t.Errorf("Total = %+v, want %+v", report.Total, want)
+	}
+}
+
+// TestComputeRangeAndTimeZone tests clamping periods to the range and bucketing days in a time zone
+func TestComputeRangeAndTimeZone(t *testing.T) {
+	records := []Record{stopped(at(1, 20, 0)), resumed(at(2, 4, 0))}
+
+	// 20:00-04:00 UTC is 22:00-06:00 in UTC+2: 2h on the 1st, 6h on the 2nd
+	report := Compute(records, testPrices, at(1, 0, 0), at(3, 0, 0), time.FixedZone("UTC+2", 2*60*60))
+	if len(report.Days) != 2 || report.Days[0].HibernatedHours != 2 || report.Days[1].HibernatedHours != 6 {
+		t.Errorf("Days = %+v, want 2h and 6h", report.Days)
+	}
+
+	// Only the part of the period inside the range counts
+	report = Compute(records, testPrices, at(2, 0, 0), at(2, 2, 0), time.UTC)
+	if report.Total.HibernatedHours != 2 || report.Hibernations != 1 {
+		t.Errorf("Total = %+v, Hibernations = %d, want 2h in 1 period", report.Total, report.Hibernations)
+	}
+
+	// Periods outside the range are excluded
+	report = Compute(records, testPrices, at(5, 0, 0), at(6, 0, 0), time.UTC)
+	if report.Hibernations != 0 || len(report.Days) != 0 {
+		t.Errorf("report outside range = %+v, want empty", report)
+	}
+
+	// Without a price table every hour is unpriced
+	report = Compute(records, nil, at(1, 0, 0), at(3, 0, 0), time.UTC)
+	if report.Total.UnpricedHours != 8 || report.Total.Savings != 0 {
+		t.Errorf("Total without prices = %+v, want 8 unpriced hours", report.Total)
+	}
+}
+
+// TestReportOutput tests the JSON and CSV report formats
+func TestReportOutput(t *testing.T) {
+	records := []Record{stopped(at(1, 18, 0)), resumed(at(1, 22, 0))}
+	report := Compute(records, testPrices, at(1, 0, 0), at(2, 0, 0), time.UTC)
+
+	var buf bytes.Buffer
+	if err := report.WriteJSON(&buf); err != nil {
+		t.Fatalf("WriteJSON() error: %v", err)
+	}
+	var decoded Report
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("WriteJSON() wrote invalid JSON: %v", err)
+	}
+	if decoded.Total.Savings != 1 || decoded.Days[0].Date != "2025-06-01" {
+		t.Errorf("decoded report = %+v", decoded)
+	}
+
+	buf.Reset()
+	if err := report.WriteCSV(&buf); err != nil {
+		t.Fatalf("WriteCSV() error: %v", err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("WriteCSV() wrote invalid CSV: %v", err)
+	}
+	want := [][]string{
+		{"period", "key", "hibernatedHours", "savings", "unpricedHours", "currency"},
+		{"day", "2025-06-01", "4.00", "1.00", "0.00", "USD"},
+		{"month", "2025-06", "4.00", "1.00", "0.00", "USD"},
+		{"total", "", "4.00", "1.00", "0.00", "USD"},
+	}
+	if len(rows) != len(want) {
+		t.Fatalf("CSV rows = %v, want %v", rows, want)
+	}
+	for i := range want {
+		for j := range want[i] {
+			if rows[i][j] != want[i][j] {
+				t.Errorf("CSV row %d = %v, want %v", i, rows[i], want[i])
+				break
+			}
+		}
+	}
+}
+
+// TestLedger tests appending and reading ledger records
+func TestLedger(t *testing.T) {
+	ledger := NewLedger(filepath.Join(t.TempDir(), LedgerFileName))
+
+	records, err := ledger.Records()
+	if err != nil || len(records) != 0 {
+		t.Fatalf("Records() on a missing ledger = %v, %v, want none", records, err)
+	}
+	if stopped, err := ledger.Stopped(); err != nil || stopped {
+		t.Errorf("Stopped() on a missing ledger = %v, %v, want false", stopped, err)
+	}
+
+	if err := ledger.Append(stopped(at(1, 18, 0))); err != nil {
+		t.Fatalf("Append() error: %v", err)
+	}
+	if stopped, err := ledger.Stopped(); err != nil || !stopped {
+		t.Errorf("Stopped() after a stop = %v, %v, want true", stopped, err)
+	}
+
+	// A partial line from an interrupted write is skipped
+	f, err := os.OpenFile(ledger.Path(), os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"type":"resu` + "\n")
+	f.Close()
+
+	if err := ledger.Append(resumed(at(2, 8, 0))); err != nil {
+		t.Fatalf("Append() error: %v", err)
+	}
+
+	records, err = ledger.Records()
+	if err != nil {
+		t.Fatalf("Records() error: %v", err)
+	}
+	if len(records) != 2 || records[0].VMSize != "Standard_D4s_v5" || records[1].Type != RecordResumed {
+		t.Errorf("Records() = %+v", records)
+	}
+	if stopped, _ := ledger.Stopped(); stopped {
+		t.Error("Stopped() after a resume = true, want false")
+	}
+}
+
+// TestPriceTable tests loading prices and case-insensitive lookup
+func TestPriceTable(t *testing.T) {
+	path := filepath.Join(t.TempDir(), PriceTableFileName)
+	if err := os.WriteFile(path, []byte(`{"currency":"EUR","updated":"2025-06-01","regions":{"WestEurope":{"standard_d4s_v5":0.21}}}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	table, err := LoadPriceTable(path)
+	if err != nil {
+		t.Fatalf("LoadPriceTable() error: %v", err)
+	}
+	if price, ok := table.HourlyPrice("westeurope", "Standard_D4s_v5"); !ok || price != 0.21 {
+		t.Errorf("HourlyPrice() = %v, %v, want 0.21, true", price, ok)
+	}
+	if _, ok := table.HourlyPrice("eastus", "Standard_D4s_v5"); ok {
+		t.Error("HourlyPrice() found a price for a missing region")
+	}
+
+	if _, err := LoadPriceTable(filepath.Join(t.TempDir(), "missing.json")); err == nil {
+		t.Error("LoadPriceTable() error = nil for a missing file")
+	}
+}
@@ -0,0 +1,289 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/control"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// hibernateNowTimeout bounds the wait for a hibernate request, including pre-hibernate hooks
+const hibernateNowTimeout = 5 * time.Minute
+
+// defaultControlReason is used when a control request gives no reason
+const defaultControlReason = "requested through the control API"
+
+// manualCondition is the condition reported for a hibernate request
+const manualCondition = "manual"
+
+// hibernateRequest asks the monitor loop to hibernate now
+type hibernateRequest struct {
+	reason string
+	result chan control.Response
+}
+
+// startControl serves the control pipe. The service runs without it if the pipe cannot be created.
+func (s *AutoHibernateService) startControl() {
+	server, err := control.NewServer(s, s.logger)
+	if err == nil {
+		err = server.Start()
+	}
+	if err != nil {
+		s.logger.Errorf(logger.EventControlError, "Control API unavailable: %v", err)
+		return
+	}
+	s.controlServer = server
+	s.logger.Infof(logger.EventServiceStart, "Control API listening on %s", control.PipeName)
+}
+
+// HandleControl executes a control request
+func (s *AutoHibernateService) HandleControl(req control.Request) control.Response {
+	if req.Reason == "" {
+		req.Reason = defaultControlReason
+	}
+
+	switch req.Command {
+	case control.CommandStatus:
+		return control.Response{OK: true, Status: s.status(time.Now())}
+
+	case control.CommandCheck:
+		s.logger.Debug(logger.EventControlCommand, "Idle check requested through the control API")
+		s.requestCheck()
+		return control.Response{OK: true, Message: "Idle check requested"}
+
+	case control.CommandPause:
+		until := time.Now().Add(time.Duration(req.Seconds) * time.Second)
+		s.pauseLocally(until, req.Reason)
+		s.requestCheck()
+		return control.Response{OK: true, Message: fmt.Sprintf("Idle actions paused until %s", until.Format("Mon 15:04"))}
+
+	case control.CommandResume:
+		if !s.resumeLocally() {
+			return control.Response{OK: true, Message: "Idle actions were not paused through the control API"}
+		}
+		s.requestCheck()
+		return control.Response{OK: true, Message: "Idle actions resumed"}
+
+	case control.CommandHibernate:
+		r := hibernateRequest{reason: req.Reason, result: make(chan control.Response, 1)}
+		select {
+		case s.hibernateRequests <- r:
+		case <-s.stopChan:
+			return control.Response{Error: "the service is stopping"}
+		}
+		select {
+		case resp := <-r.result:
+			return resp
+		case <-time.After(hibernateNowTimeout):
+			return control.Response{Error: "hibernation is still in progress; see the event log for the outcome"}
+		}
+
+	case control.CommandSetLogLevel:
+		setter, ok := s.logger.(logger.LevelSetter)
+		if !ok {
+			return control.Response{Error: "the log level of this logger cannot be changed"}
+		}
+		level := logger.ParseLogLevel(req.Level)
+		setter.SetLevel(level)
+		s.logger.Infof(logger.EventControlCommand, "Log level set to %s through the control API", level)
+		return control.Response{OK: true, Message: fmt.Sprintf("Log level set to %s until the service restarts", level)}
+	}
+	return control.Response{Error: fmt.Sprintf("unknown command %q", req.Command)}
+}
+
+// requestCheck wakes the monitor loop for an idle check, unless one is already requested
+func (s *AutoHibernateService) requestCheck() {
+	select {
+	case s.checkNow <- struct{}{}:
+	default:
+	}
+}
+
+// pauseLocally pauses idle actions until a point in time and tells users
+func (s *AutoHibernateService) pauseLocally(until time.Time, reason string) {
+	s.statusMu.Lock()
+	s.localPauseUntil = until
+	s.localPauseReason = reason
+	s.statusMu.Unlock()
+
+	s.logger.Infof(logger.EventControlCommand, "Idle actions paused until %s through the control API: %s", until.Format(time.RFC3339), reason)
+	s.record(journal.Record{Type: journal.TypePaused, Source: journal.SourceControl, Until: &until, Reason: reason})
+	if s.notifierManager != nil {
+		message := fmt.Sprintf("Automatic hibernation is paused by your administrator until %s.", until.Format("Mon 15:04"))
+		if err := s.notifierManager.SendInfo(context.Background(), message); err != nil {
+			s.logger.Warningf(logger.EventNotificationError, "Failed to notify sessions of pause: %v", err)
+		}
+	}
+}
+
+// resumeLocally ends a pause set through the control API and reports whether one was in force
+func (s *AutoHibernateService) resumeLocally() bool {
+	s.statusMu.Lock()
+	paused := time.Now().Before(s.localPauseUntil)
+	s.localPauseUntil = time.Time{}
+	s.localPauseReason = ""
+	s.statusMu.Unlock()
+
+	if paused {
+		s.logger.Info(logger.EventControlCommand, "Idle actions resumed through the control API")
+	}
+	return paused
+}
+
+// localPause returns the pause set through the control API, if one is in force
+func (s *AutoHibernateService) localPause(now time.Time) (until time.Time, reason string, paused bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	if now.Before(s.localPauseUntil) {
+		return s.localPauseUntil, s.localPauseReason, true
+	}
+	return time.Time{}, "", false
+}
+
+// hibernateNow runs the hibernate action for an operator, after the pre-hibernate hooks.
+// It runs on the monitor loop so it never overlaps an idle check.
+func (s *AutoHibernateService) hibernateNow(ctx context.Context, reason string) control.Response {
+	ctx, span := tracing.Start(ctx, "hibernate request", tracing.String("reason", reason))
+	defer span.End(nil)
+	log := s.tracedLogger(ctx)
+
+	if action.IsNone(s.manualAction) {
+		return control.Response{Error: "the hibernate action is unavailable; see the event log"}
+	}
+	name := s.manualAction.Name()
+	span.SetAttributes(tracing.String("action", name))
+	if s.currentMode() == azure.ModeDryRun {
+		log.Infof(logger.EventDryRunAction, "Dry run: would run %s: %s", name, reason)
+		return control.Response{OK: true, Message: fmt.Sprintf("Dry run mode: would run %s", name)}
+	}
+	vetoedBy := s.runPreHibernateHooks(ctx)
+	if abandoned(ctx) {
+		return control.Response{Error: "the monitor loop stalled and was restarted; try again"}
+	}
+	if vetoedBy != "" {
+		span.SetAttributes(tracing.String("vetoed_by", vetoedBy))
+		return control.Response{Error: fmt.Sprintf("vetoed by pre-hibernate hook %s", vetoedBy)}
+	}
+
+	log.Infof(logger.EventHibernationTriggered, "Hibernation triggered: %s (action: %s)", reason, name)
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	ctx = azure.WithHibernationInfo(ctx, azure.HibernationInfo{Reason: reason, Condition: manualCondition})
+
+	// The operator sees a failure, so it is not retried and the idle timers are kept
+	if err := s.runAction(ctx, s.manualAction, &monitor.CheckResult{Reason: reason}); err != nil {
+		span.RecordError(err)
+		return control.Response{Error: describeError(err)}
+	}
+	if abandoned(ctx) {
+		return control.Response{OK: true, Message: fmt.Sprintf("Action %s completed after the monitor loop was restarted", name)}
+	}
+	s.idleMonitor.Reset()
+	s.attempts.Reset()
+	return control.Response{OK: true, Message: fmt.Sprintf("Action %s completed", name)}
+}
+
+// status returns a snapshot of the service state
+func (s *AutoHibernateService) status(now time.Time) *control.Status {
+	state := s.idleMonitor.GetState()
+	mode, _ := s.remoteControl()
+
+	st := &control.Status{
+		Version:    version.Version,
+		ConfigPath: s.config.Path,
+		Mode:       mode,
+		LogLevel:   s.config.LogLevel,
+		Inhibitors: s.inhibitors(),
+		Sessions:   []control.Session{},
+	}
+	if setter, ok := s.logger.(logger.LevelSetter); ok {
+		st.LogLevel = setter.Level().String()
+	}
+	if until, _, paused := s.localPause(now); paused {
+		st.PausedUntil = &until
+	}
+
+	s.statusMu.Lock()
+	st.LastCheckAt = timePtr(s.lastCheckAt)
+	st.NextCheckAt = timePtr(s.nextCheckAt)
+	if s.resumeAt != nil {
+		st.ResumedAt = *s.resumeAt
+	}
+	s.statusMu.Unlock()
+
+	if s.watchdog != nil {
+		age, operation := s.watchdog.HeartbeatAge(now)
+		st.HeartbeatAgeSeconds = int(age.Seconds())
+		st.Operation = operation
+	}
+	st.LoopRestarts = int(s.loopRestarts.Load())
+
+	// The least idle connected session decides the inactive user condition
+	var leastIdle *time.Duration
+	for _, session := range state.CurrentSessions {
+		cs := control.Session{ID: session.SessionId, User: session.Username, Domain: session.Domain, Disconnected: session.IsDisconnected}
+		if !session.IsDisconnected {
+			if idle, err := monitor.GetSessionIdleTime(session.SessionId); err == nil {
+				cs.IdleSeconds = int(idle.Seconds())
+				if leastIdle == nil || idle < *leastIdle {
+					leastIdle = &idle
+				}
+			}
+		}
+		st.Sessions = append(st.Sessions, cs)
+	}
+
+	conditions := []struct {
+		condition monitor.IdleCondition
+		threshold int
+		warning   int
+		since     *time.Time
+	}{
+		{monitor.IdleConditionNoUsers, s.config.NoUsersIdleMinutes, 0, state.NoUsersIdleSince},
+		{monitor.IdleConditionAllDisconnected, s.config.AllDisconnectedIdleMinutes, s.config.AllDisconnectedWarningMinutes, state.AllDisconnectedSince},
+		{monitor.IdleConditionInactiveUser, s.config.InactiveUserIdleMinutes, s.config.InactiveUserWarningMinutes, nil},
+	}
+	if leastIdle != nil {
+		conditions[2].since = timePtr(now.Add(-*leastIdle))
+	}
+	for _, c := range conditions {
+		if c.threshold <= 0 {
+			continue
+		}
+		st.Conditions = append(st.Conditions, control.Condition{
+			Name:             c.condition.String(),
+			Action:           s.actionName(c.condition),
+			ThresholdMinutes: c.threshold,
+			WarningMinutes:   c.warning,
+			IdleSince:        c.since,
+		})
+		if state.WarningState == monitor.WarningStateActive && state.IdleCondition == c.condition && state.WarningIssuedAt != nil {
+			st.Warning = &control.Warning{
+				Condition: c.condition.String(),
+				Reason:    state.WarningReason,
+				IssuedAt:  *state.WarningIssuedAt,
+				ActionAt:  state.WarningIssuedAt.Add(time.Duration(c.warning) * time.Minute),
+			}
+		}
+	}
+	return st
+}
+
+// timePtr returns a pointer to t, or nil for the zero time
+func timePtr(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
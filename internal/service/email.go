@@ -0,0 +1,209 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/email"
+	"github.com/smitstech/AzureAutoHibernate/internal/keepawake"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+)
+
+// emailSendTimeout bounds the delivery of one warning email
+const emailSendTimeout = 60 * time.Second
+
+// mailer emails the owners of disconnected sessions when an allDisconnected warning
+// starts, and serves the keep-awake page linked from the emails
+type mailer struct {
+	sender    *email.Sender
+	directory email.Directory
+	leases    *keepawake.Manager
+	server    *http.Server
+	onLease   func(keepawake.Lease) // Called after a keep-awake link is redeemed (may be nil)
+	baseURL   string                // Base URL of the keep-awake links
+	vmName    string
+	log       logger.Logger
+}
+
+// newMailer returns the mailer for the email settings, or nil if email is not configured
+// or the SMTP password cannot be read
+func newMailer(cfg *config.Config, vmName string, log logger.Logger) *mailer {
+	if !cfg.Email.Enabled() {
+		return nil
+	}
+	password, err := azure.ReadSecret(cfg.Email.PasswordFile, cfg.Email.PasswordEnv)
+	if err != nil {
+		log.Errorf(logger.EventEmailError, "Email notifications disabled: failed to read SMTP password: %v", err)
+		return nil
+	}
+
+	baseURL := cfg.Email.KeepAwakeURL
+	if baseURL == "" {
+		baseURL = defaultKeepAwakeURL(cfg.Email.KeepAwakeAddress)
+		if host, _, _ := net.SplitHostPort(cfg.Email.KeepAwakeAddress); isLoopbackHost(host) {
+			log.Warningf(logger.EventEmailError, "Keep-awake page listens on %s only, so links in emails will not work: set email.keepAwakeAddress to a private address or email.keepAwakeUrl to a reverse proxy", cfg.Email.KeepAwakeAddress)
+		}
+	}
+
+	m := &mailer{
+		sender: &email.Sender{
+			Host:     cfg.Email.SMTPHost,
+			Port:     cfg.Email.SMTPPort,
+			Security: cfg.Email.Security,
+			Username: cfg.Email.Username,
+			Password: password,
+			From:     cfg.Email.From,
+		},
+		directory: email.Directory{
+			Recipients: cfg.Email.Recipients,
+			Pattern:    cfg.Email.AddressPattern,
+		},
+		baseURL: baseURL,
+		vmName:  vmName,
+		log:     log,
+	}
+	m.leases = keepawake.NewManager(time.Duration(cfg.Email.KeepAwakeMinutes)*time.Minute, func(lease keepawake.Lease) {
+		log.Infof(logger.EventKeepAwakeGranted, "Keep-awake requested by %s: idle actions paused until %s", lease.User, lease.Until.Format("15:04"))
+		if m.onLease != nil {
+			m.onLease(lease)
+		}
+	})
+
+	mux := http.NewServeMux()
+	mux.Handle(keepawake.Path, m.leases.Handler(vmName))
+	m.server = &http.Server{
+		Addr:              cfg.Email.KeepAwakeAddress,
+		Handler:           mux,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	return m
+}
+
+// defaultKeepAwakeURL returns http://<host>:<port> for the listen address: its IP address
+// if it listens on one, otherwise the computer name
+func defaultKeepAwakeURL(addr string) string {
+	host, port, _ := net.SplitHostPort(addr)
+	if ip := net.ParseIP(host); ip != nil && !ip.IsUnspecified() && !ip.IsLoopback() {
+		return "http://" + net.JoinHostPort(host, port)
+	}
+	host, err := os.Hostname()
+	if err != nil {
+		host = "localhost"
+	}
+	return "http://" + net.JoinHostPort(strings.ToLower(host), port)
+}
+
+// isLoopbackHost reports whether a listen host accepts only local connections
+func isLoopbackHost(host string) bool {
+	if strings.EqualFold(host, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// start serves the keep-awake page. Emails are still sent if the port cannot be opened.
+func (m *mailer) start() {
+	ln, err := net.Listen("tcp", m.server.Addr)
+	if err != nil {
+		m.log.Errorf(logger.EventEmailError, "Failed to listen for keep-awake requests on %s: %v - keep-awake links will not work", m.server.Addr, err)
+		return
+	}
+	m.log.Infof(logger.EventServiceStart, "Keep-awake page listening on %s (links: %s%s)", m.server.Addr, m.baseURL, keepawake.Path)
+
+	go func() {
+		if err := m.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			m.log.Errorf(logger.EventEmailError, "Keep-awake page stopped: %v", err)
+		}
+	}()
+}
+
+// stop closes the keep-awake page. Emails still being delivered are not waited for.
+func (m *mailer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	m.server.Shutdown(ctx)
+}
+
+// activeLease returns the keep-awake lease in force, if any
+func (m *mailer) activeLease() (keepawake.Lease, bool) {
+	return m.leases.Active()
+}
+
+// warnDisconnected emails each owner of a disconnected session a warning with a
+// keep-awake link. Emails are sent in the background.
+func (m *mailer) warnDisconnected(sessions []monitor.SessionInfo, actionName string, remaining time.Duration) {
+	deadline := time.Now().Add(remaining)
+	notified := make(map[string]bool)
+
+	for _, session := range sessions {
+		if !session.IsDisconnected {
+			continue
+		}
+		key := strings.ToLower(session.Domain + `\` + session.Username)
+		if notified[key] {
+			continue
+		}
+		notified[key] = true
+
+		address, ok := m.directory.Lookup(session.Domain, session.Username)
+		if !ok {
+			m.log.Debugf(logger.EventEmailError, "No email address configured for %s, not emailing a warning", session.Username)
+			continue
+		}
+		token, err := m.leases.Issue(session.Username)
+		if err != nil {
+			m.log.Warningf(logger.EventEmailError, "Failed to issue keep-awake token for %s: %v", session.Username, err)
+			continue
+		}
+
+		msg := disconnectedWarning(address, session.Username, m.vmName, actionName, remaining, m.leases.Duration(), deadline, keepawake.Link(m.baseURL, token))
+		go m.send(session.Username, msg)
+	}
+}
+
+// send delivers one warning email and logs the outcome
+func (m *mailer) send(user string, msg email.Message) {
+	ctx, cancel := context.WithTimeout(context.Background(), emailSendTimeout)
+	defer cancel()
+
+	if err := m.sender.Send(ctx, msg); err != nil {
+		m.log.Warningf(logger.EventEmailError, "Failed to email hibernation warning to %s (%s): %v", user, msg.To, err)
+		return
+	}
+	m.log.Infof(logger.EventEmailSent, "Emailed hibernation warning to %s (%s)", user, msg.To)
+}
+
+// disconnectedWarning composes the warning email for the owner of a disconnected session
+func disconnectedWarning(to, user, vmName, actionName string, remaining, keepAwake time.Duration, deadline time.Time, link string) email.Message {
+	minutes := int(remaining.Round(time.Minute).Minutes())
+	verb := action.Verb(actionName)
+
+	var body strings.Builder
+	fmt.Fprintf(&body, "Hi %s,\n\n", user)
+	fmt.Fprintf(&body, "All sessions on %s are disconnected, so it will %s in %d minutes (at %s).\n\n",
+		vmName, verb, minutes, deadline.Format("15:04 MST"))
+	fmt.Fprintf(&body, "To keep %s awake for another %d minutes, open this link and confirm:\n%s\n\n",
+		vmName, int(keepAwake.Minutes()), link)
+	fmt.Fprintf(&body, "Reconnecting to %s also cancels the warning.\n\n", vmName)
+	fmt.Fprintf(&body, "-- \n%s on %s\n", appinfo.Name, vmName)
+
+	return email.Message{
+		To:      to,
+		Subject: fmt.Sprintf("%s will %s in %d minutes", vmName, verb, minutes),
+		Body:    body.String(),
+	}
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package service
+
+import (
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/keepawake"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// record adds a record to the history journal, stamped with the time and service version
+func (s *AutoHibernateService) record(r journal.Record) {
+	if s.journal == nil {
+		return
+	}
+	if r.Time.IsZero() {
+		r.Time = time.Now()
+	}
+	r.Version = version.Version
+	if err := s.journal.Append(r); err != nil {
+		s.logger.Warningf(logger.EventHistoryError, "Failed to write history: %v", err)
+	}
+}
+
+// recordUpdate adds an updateApplied record when the service starts with another version
+// than the one that wrote the last record
+func (s *AutoHibernateService) recordUpdate(now time.Time) {
+	if s.journal == nil {
+		return
+	}
+	last, err := s.journal.Last()
+	if err != nil {
+		s.logger.Warningf(logger.EventHistoryError, "Failed to read history: %v", err)
+		return
+	}
+	if last == nil || last.Version == "" || last.Version == version.Version {
+		return
+	}
+	s.record(journal.Record{Time: now, Type: journal.TypeUpdateApplied, FromVersion: last.Version})
+}
+
+// recordLease adds a leaseGranted record when a user redeems a keep-awake link
+func (s *AutoHibernateService) recordLease(lease keepawake.Lease) {
+	until := lease.Until
+	s.record(journal.Record{Type: journal.TypeLeaseGranted, User: lease.User, Until: &until})
+}
+
+// activeSession returns the connected session with the most recent input, which is the
+// one that ended a warning when users become active
+func (s *AutoHibernateService) activeSession() (monitor.SessionInfo, bool) {
+	var found monitor.SessionInfo
+	var leastIdle time.Duration = -1
+	for _, session := range s.idleMonitor.GetState().CurrentSessions {
+		if session.IsDisconnected {
+			continue
+		}
+		idle, err := monitor.GetSessionIdleTime(session.SessionId)
+		if err != nil {
+			continue
+		}
+		if leastIdle < 0 || idle < leastIdle {
+			found, leastIdle = session, idle
+		}
+	}
+	return found, leastIdle >= 0
+}
+
+// sessionUser returns DOMAIN\user for a session, or the user name alone without a domain
+func sessionUser(session monitor.SessionInfo) string {
+	if session.Domain == "" {
+		return session.Username
+	}
+	return session.Domain + `\` + session.Username
+}
@@ -0,0 +1,213 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+	"unsafe"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/hook"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/webhook"
+	"golang.org/x/sys/windows"
+)
+
+// newHooks converts configured hooks for the hook runner
+func newHooks(cfgs []config.HookConfig) []hook.Hook {
+	hooks := make([]hook.Hook, 0, len(cfgs))
+	for _, c := range cfgs {
+		hooks = append(hooks, hook.Hook{
+			Name:         c.Name,
+			Command:      c.Command,
+			Args:         c.Args,
+			WorkingDir:   c.WorkingDir,
+			Timeout:      time.Duration(c.TimeoutSeconds) * time.Second,
+			RunAs:        c.RunAs,
+			VetoExitCode: c.VetoExitCode,
+		})
+	}
+	return hooks
+}
+
+// runPreHibernateHooks runs the pre-hibernate hooks in order and returns the name of the
+// hook that vetoed the hibernation, or "" to go ahead. A failing hook is logged and the
+// remaining hooks still run.
+func (s *AutoHibernateService) runPreHibernateHooks(ctx context.Context) (vetoedBy string) {
+	return s.runHooks(ctx, s.preHibernateHooks, "pre-hibernate hook")
+}
+
+// runPostResumeHooks runs the post-resume hooks in the background. A resume while the
+// hooks of the previous one are still running does not start them again.
+func (s *AutoHibernateService) runPostResumeHooks() {
+	if len(s.postResumeHooks) == 0 || !s.resumeHooksRunning.CompareAndSwap(false, true) {
+		return
+	}
+	go func() {
+		defer s.resumeHooksRunning.Store(false)
+		s.runHooks(context.Background(), s.postResumeHooks, "")
+	}()
+}
+
+// runHooks runs hooks in order until one vetoes, and returns the name of that hook.
+// User hooks run once per user logged in to the VM. With an operation name, each run is a
+// watchdog operation of its own: every run has its own timeout, so each one gets a full
+// deadline however many users are logged in.
+func (s *AutoHibernateService) runHooks(ctx context.Context, hooks []hook.Hook, operation string) (vetoedBy string) {
+	watch := func(name string) (context.Context, func()) {
+		if operation == "" {
+			return ctx, func() {}
+		}
+		return s.beginOperation(ctx, operation+" "+name)
+	}
+
+	for _, h := range hooks {
+		if ctx.Err() != nil {
+			return ""
+		}
+		if h.RunAs != hook.RunAsUser {
+			hookCtx, end := watch(h.Name)
+			vetoed := s.runHook(hookCtx, h, "", nil)
+			end()
+			if vetoed {
+				return h.Name
+			}
+			continue
+		}
+
+		sessions, err := monitor.GetActiveSessions()
+		if err != nil {
+			s.logger.Warningf(logger.EventHookFailed, "Hook %s skipped: failed to list sessions: %v", h.Name, err)
+			continue
+		}
+		for _, session := range userSessions(sessions) {
+			if ctx.Err() != nil {
+				return ""
+			}
+			hookCtx, end := watch(fmt.Sprintf("%s (as %s)", h.Name, session.Username))
+			vetoed := s.runUserHook(hookCtx, h, session)
+			end()
+			if vetoed {
+				return h.Name
+			}
+		}
+	}
+	return ""
+}
+
+// vetoHibernation re-arms the idle timer of the condition a hook vetoed and tells users
+// the warning is canceled
+func (s *AutoHibernateService) vetoHibernation(ctx context.Context, result *monitor.CheckResult, vetoedBy string) {
+	reason := fmt.Sprintf("vetoed by pre-hibernate hook %s", vetoedBy)
+	s.idleMonitor.Rearm(result.Condition, time.Now())
+	s.logger.Infof(logger.EventHookVetoed, "Hibernation canceled: %s - idle timer re-armed (%s)", reason, result.Reason)
+	warningsCanceled.With(cancelCauseVetoed).Inc()
+	s.record(journal.Record{Type: journal.TypeWarningCanceled, Condition: result.Condition.String(), Reason: reason})
+	s.publish(webhook.Event{
+		Type:      webhook.EventWarningCanceled,
+		Condition: result.Condition.String(),
+		Reason:    reason,
+		Action:    s.actionName(result.Condition),
+	})
+
+	if s.notifierManager != nil {
+		if err := s.notifierManager.DismissWarning(ctx); err != nil {
+			s.logger.Debugf(logger.EventNotificationError, "Failed to dismiss warning notification: %v", err)
+		}
+		if err := s.notifierManager.SendInfo(ctx, fmt.Sprintf("Hibernation was canceled: %s", reason)); err != nil {
+			s.logger.Warningf(logger.EventNotificationError, "Failed to send veto notification: %v", err)
+		}
+	}
+}
+
+// runHook runs one hook, logs its outcome and output and reports whether it vetoed
+func (s *AutoHibernateService) runHook(ctx context.Context, h hook.Hook, user string, prepare func(*exec.Cmd) error) (vetoed bool) {
+	name := h.Name
+	if user != "" {
+		name = fmt.Sprintf("%s (as %s)", h.Name, user)
+	}
+
+	s.logger.Debugf(logger.EventHookCompleted, "Running hook %s", name)
+	r := hook.Run(ctx, h, prepare)
+
+	switch {
+	case h.Vetoes(r):
+		s.logger.Warningf(logger.EventHookVetoed, "Hook %s vetoed the hibernation (exit code %d)%s", name, r.ExitCode, r.Output())
+		return true
+	case r.Err != nil:
+		s.logger.Warningf(logger.EventHookFailed, "Hook %s failed after %v: %v%s", name, r.Duration.Round(time.Millisecond), r.Err, r.Output())
+	default:
+		s.logger.Infof(logger.EventHookCompleted, "Hook %s completed in %v%s", name, r.Duration.Round(time.Millisecond), r.Output())
+	}
+	return false
+}
+
+// userSessions returns one session per distinct user, so a user hook runs once per user
+// even if the user has several sessions
+func userSessions(sessions []monitor.SessionInfo) []monitor.SessionInfo {
+	seen := make(map[string]bool)
+	var unique []monitor.SessionInfo
+	for _, session := range sessions {
+		if session.Username == "" {
+			continue
+		}
+		key := strings.ToLower(session.Domain + `\` + session.Username)
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		unique = append(unique, session)
+	}
+	return unique
+}
+
+// runUserHook runs a hook as the user of a session, with the user's environment and,
+// unless the hook sets a working directory, their profile directory
+func (s *AutoHibernateService) runUserHook(ctx context.Context, h hook.Hook, session monitor.SessionInfo) (vetoed bool) {
+	token, err := sessionUserToken(session.SessionId)
+	if err != nil {
+		s.logger.Warningf(logger.EventHookFailed, "Hook %s (as %s) skipped: %v", h.Name, session.Username, err)
+		return false
+	}
+	// The token must stay open until the process has started
+	defer token.Close()
+
+	return s.runHook(ctx, h, session.Username, func(cmd *exec.Cmd) error {
+		env, err := token.Environ(false)
+		if err != nil {
+			return fmt.Errorf("failed to create user environment: %w", err)
+		}
+		if cmd.Dir == "" {
+			if dir, err := token.GetUserProfileDirectory(); err == nil {
+				cmd.Dir = dir
+			}
+		}
+		cmd.Env = env
+		cmd.SysProcAttr = &syscall.SysProcAttr{Token: syscall.Token(token), HideWindow: true}
+		return nil
+	})
+}
+
+// sessionUserToken returns a primary token for the user logged on to a session
+func sessionUserToken(sessionID uint32) (windows.Token, error) {
+	var userToken windows.Token
+	ret, _, err := procWTSQueryUserToken.Call(uintptr(sessionID), uintptr(unsafe.Pointer(&userToken)))
+	if ret == 0 {
+		return 0, fmt.Errorf("WTSQueryUserToken failed: %w", err)
+	}
+	defer userToken.Close()
+
+	var primaryToken windows.Token
+	err = windows.DuplicateTokenEx(userToken, windows.MAXIMUM_ALLOWED, nil, windows.SecurityImpersonation, windows.TokenPrimary, &primaryToken)
+	if err != nil {
+		return 0, fmt.Errorf("DuplicateTokenEx failed: %w", err)
+	}
+	return primaryToken, nil
+}
@@ -0,0 +1,129 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/metrics"
+)
+
+// Causes of a canceled warning, reported in metrics
+const (
+	cancelCauseActivity = "activity" // A user became active
+	cancelCausePaused   = "paused"   // Idle actions were paused
+	cancelCauseVetoed   = "vetoed"   // A pre-hibernate hook vetoed the action
+	cancelCauseDryRun   = "dryrun"   // Remote control switched to dry run
+)
+
+// Idle state, updated after each idle check
+var (
+	idleSecondsGauge = metrics.NewGaugeVec("autohibernate_idle_seconds",
+		"How long each enabled idle condition has been met, in seconds (0 when not met).", "condition")
+	sessionsGauge = metrics.NewGaugeVec("autohibernate_sessions",
+		"User sessions by state (active or disconnected).", "state")
+	warningActiveGauge = metrics.NewGauge("autohibernate_warning_active",
+		"1 while a hibernation warning is active, otherwise 0.")
+	nextThresholdGauge = metrics.NewGauge("autohibernate_next_threshold_seconds",
+		"Seconds until the next idle threshold is reached (0 when no condition is met).")
+)
+
+// Monitor loop health, updated by the watchdog
+var (
+	heartbeatAgeGauge = metrics.NewGauge("autohibernate_heartbeat_age_seconds",
+		"Seconds since the monitor loop last made progress.")
+	monitorRestarts = metrics.NewCounter("autohibernate_monitor_restarts_total",
+		"Monitor loops restarted by the watchdog after they stalled.")
+)
+
+// Counters of lifecycle events
+var (
+	warningsSent = metrics.NewCounterVec("autohibernate_warnings_sent_total",
+		"Hibernation warnings started, by idle condition.", "condition")
+	warningsCanceled = metrics.NewCounterVec("autohibernate_warnings_canceled_total",
+		"Hibernation warnings canceled, by cause (activity, paused, vetoed or dryrun).", "cause")
+	hibernationsAttempted = metrics.NewCounterVec("autohibernate_hibernations_attempted_total",
+		"Idle or manual actions started, by primary action.", "action")
+	hibernationsSucceeded = metrics.NewCounterVec("autohibernate_hibernations_succeeded_total",
+		"Actions that completed, by the action that completed (primary or fallback).", "action")
+	hibernationsFailed = metrics.NewCounterVec("autohibernate_hibernations_failed_total",
+		"Actions that failed, including the fallback action, by primary action.", "action")
+	notifierRestarts = metrics.NewCounter("autohibernate_notifier_restarts_total",
+		"Notifier processes restarted after they exited.")
+	updateChecks = metrics.NewCounterVec("autohibernate_update_checks_total",
+		"Update checks by result (available, current or failed).", "result")
+)
+
+// startMetrics serves /metrics if enabled. The service runs without it if the port cannot be opened.
+func (s *AutoHibernateService) startMetrics() {
+	if !s.config.Metrics.Enabled {
+		return
+	}
+	addr := s.config.Metrics.Address
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		s.logger.Errorf(logger.EventMetricsError, "Failed to listen for metrics on %s: %v", addr, err)
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(metrics.Path, metrics.Default.Handler())
+	s.metricsServer = &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	s.logger.Infof(logger.EventServiceStart, "Metrics listening on http://%s%s", addr, metrics.Path)
+
+	go func(server *http.Server) {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			s.logger.Errorf(logger.EventMetricsError, "Metrics endpoint stopped: %v", err)
+		}
+	}(s.metricsServer)
+}
+
+// stopMetrics closes the metrics endpoint
+func (s *AutoHibernateService) stopMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	s.metricsServer.Shutdown(ctx)
+}
+
+// updateMetrics sets the idle state gauges from the current status
+func (s *AutoHibernateService) updateMetrics() {
+	if s.metricsServer == nil {
+		return
+	}
+	now := time.Now()
+	st := s.status(now)
+
+	var active, disconnected int
+	for _, session := range st.Sessions {
+		if session.Disconnected {
+			disconnected++
+		} else {
+			active++
+		}
+	}
+	sessionsGauge.With("active").Set(float64(active))
+	sessionsGauge.With("disconnected").Set(float64(disconnected))
+
+	for _, c := range st.Conditions {
+		idle := 0.0
+		if c.IdleSince != nil {
+			idle = now.Sub(*c.IdleSince).Seconds()
+		}
+		idleSecondsGauge.With(c.Name).Set(idle)
+	}
+	warningActiveGauge.SetBool(st.Warning != nil)
+
+	next, err := s.idleMonitor.GetTimeUntilThresholds()
+	if err != nil || next < 0 {
+		next = 0
+	}
+	nextThresholdGauge.Set(next.Seconds())
+}
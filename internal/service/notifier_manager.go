@@ -3,6 +3,7 @@
 package service
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -19,6 +20,9 @@ import (
 
 const (
 	SE_TCB_NAME = "SeTcbPrivilege"
+
+	// sessionPollInterval is how often sessions are reconciled in case a session change was missed
+	sessionPollInterval = 5 * time.Minute
 )
 
 var (
@@ -64,6 +68,7 @@ type NotifierManager struct {
 	logger                  logger.Logger
 	notifierExePath         string
 	stopChan                chan struct{}
+	sessionChanges          chan sessionChange // Session changes handled by monitorSessions
 	wg                      sync.WaitGroup
 	startupNotificationSent bool
 }
@@ -90,6 +95,7 @@ func NewNotifierManager(log logger.Logger) (*NotifierManager, error) {
 		logger:          log,
 		notifierExePath: notifierPath,
 		stopChan:        make(chan struct{}),
+		sessionChanges:  make(chan sessionChange, 32),
 	}, nil
 }
 
@@ -200,13 +206,60 @@ func (nm *NotifierManager) enableTcbPrivilege() error {
 	return nil
 }
 
-// monitorSessions performs initial session check and setup
-// Notifier health is now checked on-demand before sending notifications
+// monitorSessions starts notifiers for existing sessions, then follows session changes.
+// Sessions are also reconciled periodically and before each notification, in case a
+// change was missed.
 func (nm *NotifierManager) monitorSessions() {
 	defer nm.wg.Done()
 
 	// Do an initial check to start notifiers for existing sessions
 	nm.checkSessions()
+
+	ticker := time.NewTicker(sessionPollInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case change := <-nm.sessionChanges:
+			nm.applySessionChange(change)
+		case <-ticker.C:
+			nm.checkSessions()
+		case <-nm.stopChan:
+			return
+		}
+	}
+}
+
+// SessionChanged queues a session change for monitorSessions without blocking. If the
+// queue is full, the change is dropped and the next reconciliation picks it up.
+func (nm *NotifierManager) SessionChanged(change sessionChange) {
+	select {
+	case nm.sessionChanges <- change:
+	default:
+		nm.logger.Warningf(logger.EventSessionInfoWarning, "Session change queue full, dropping %s for session %d", sessionChangeName(change.eventType), change.sessionID)
+	}
+}
+
+// applySessionChange starts, stops or updates the notifier of a session after a session change
+func (nm *NotifierManager) applySessionChange(change sessionChange) {
+	switch change.eventType {
+	case windows.WTS_SESSION_LOGON, windows.WTS_CONSOLE_CONNECT, windows.WTS_REMOTE_CONNECT:
+		// Starts the notifier of a new session and marks a reconnected one connected
+		nm.checkSessions()
+	case windows.WTS_SESSION_LOGOFF:
+		nm.mu.Lock()
+		if notifier, exists := nm.notifiers[change.sessionID]; exists {
+			nm.logger.Infof(logger.EventMonitoringStarted, "Session %d logged off, stopping notifier", change.sessionID)
+			nm.stopNotifier(change.sessionID, notifier)
+		}
+		nm.mu.Unlock()
+	case windows.WTS_CONSOLE_DISCONNECT, windows.WTS_REMOTE_DISCONNECT:
+		nm.mu.Lock()
+		if notifier, exists := nm.notifiers[change.sessionID]; exists {
+			notifier.IsConnected = false
+		}
+		nm.mu.Unlock()
+	}
 }
 
 // checkSessions checks for active sessions and ensures notifiers are running
@@ -242,6 +295,7 @@ func (nm *NotifierManager) checkSessions() {
 			}
 			// Process died, clean up
 			nm.logger.Infof(logger.EventMonitoringStarted, "Notifier process for session %d died, restarting", sessionID)
+			notifierRestarts.Inc()
 			nm.stopNotifier(sessionID, notifier)
 		}
 
@@ -279,7 +333,7 @@ func (nm *NotifierManager) checkSessions() {
 	if shouldSendStartupNotification {
 		// Give the notifier a moment to fully initialize
 		time.Sleep(500 * time.Millisecond)
-		err := nm.SendInfo("Service started and monitoring for idle activity")
+		err := nm.SendInfo(context.Background(), "Service started and monitoring for idle activity")
 		if err != nil {
 			nm.logger.Warningf(logger.EventSessionInfoWarning, "Failed to send startup notification: %v", err)
 		}
@@ -418,7 +472,7 @@ func (nm *NotifierManager) ensureNotifiersReady() {
 }
 
 // SendWarning sends a warning notification to all connected sessions
-func (nm *NotifierManager) SendWarning(reason string, timeRemaining time.Duration) error {
+func (nm *NotifierManager) SendWarning(ctx context.Context, reason string, timeRemaining time.Duration) error {
 	// Ensure notifiers are running before sending
 	nm.ensureNotifiersReady()
 
@@ -448,7 +502,7 @@ func (nm *NotifierManager) SendWarning(reason string, timeRemaining time.Duratio
 			continue
 		}
 
-		_, err := notifier.PipeServer.SendCommand(cmd)
+		_, err := notifier.PipeServer.SendCommandContext(ctx, cmd)
 		if err != nil {
 			nm.logger.Warningf(logger.EventSessionInfoWarning, "Failed to send warning to session %d: %v", sessionID, err)
 			lastErr = err
@@ -465,7 +519,7 @@ func (nm *NotifierManager) SendWarning(reason string, timeRemaining time.Duratio
 }
 
 // SendCancellation sends a cancellation notification to all connected sessions
-func (nm *NotifierManager) SendCancellation() error {
+func (nm *NotifierManager) SendCancellation(ctx context.Context) error {
 	// Ensure notifiers are running before sending
 	nm.ensureNotifiersReady()
 
@@ -492,7 +546,7 @@ func (nm *NotifierManager) SendCancellation() error {
 			continue
 		}
 
-		_, err := notifier.PipeServer.SendCommand(cmd)
+		_, err := notifier.PipeServer.SendCommandContext(ctx, cmd)
 		if err != nil {
 			nm.logger.Warningf(logger.EventSessionInfoWarning, "Failed to send cancellation to session %d: %v", sessionID, err)
 			lastErr = err
@@ -509,7 +563,7 @@ func (nm *NotifierManager) SendCancellation() error {
 }
 
 // DismissWarning sends a dismiss command to all active sessions
-func (nm *NotifierManager) DismissWarning() error {
+func (nm *NotifierManager) DismissWarning(ctx context.Context) error {
 	nm.mu.RLock()
 	defer nm.mu.RUnlock()
 
@@ -523,7 +577,7 @@ func (nm *NotifierManager) DismissWarning() error {
 	}
 
 	for sessionID, notifier := range nm.notifiers {
-		err := notifier.PipeServer.SendCommandNoWait(cmd)
+		_, err := notifier.PipeServer.SendCommandContext(ctx, cmd)
 		if err != nil {
 			nm.logger.Debugf(logger.EventMonitoringStarted, "Failed to send dismiss to session %d: %v", sessionID, err)
 		}
@@ -533,7 +587,7 @@ func (nm *NotifierManager) DismissWarning() error {
 }
 
 // SendInfo sends an informational notification to all connected sessions
-func (nm *NotifierManager) SendInfo(message string) error {
+func (nm *NotifierManager) SendInfo(ctx context.Context, message string) error {
 	// Ensure notifiers are running before sending
 	nm.ensureNotifiersReady()
 
@@ -563,7 +617,7 @@ func (nm *NotifierManager) SendInfo(message string) error {
 			continue
 		}
 
-		_, err := notifier.PipeServer.SendCommand(cmd)
+		_, err := notifier.PipeServer.SendCommandContext(ctx, cmd)
 		if err != nil {
 			nm.logger.Warningf(logger.EventSessionInfoWarning, "Failed to send info to session %d: %v", sessionID, err)
 			lastErr = err
@@ -0,0 +1,83 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+)
+
+// pauseService handles a Pause from the Services console or sc pause. Sessions and notifiers
+// keep being tracked, but no idle action runs; the next idle check cancels an active warning.
+// It returns a channel that fires when the pause reaches servicePauseMaxMinutes (nil for no limit).
+func (s *AutoHibernateService) pauseService(now time.Time) <-chan time.Time {
+	var timeout <-chan time.Time
+	var until time.Time
+	if s.config.ServicePauseMaxMinutes > 0 {
+		limit := time.Duration(s.config.ServicePauseMaxMinutes) * time.Minute
+		until = now.Add(limit)
+		timeout = time.After(limit)
+	}
+
+	s.statusMu.Lock()
+	s.servicePausedAt = now
+	s.servicePauseUntil = until
+	s.statusMu.Unlock()
+
+	message := "Automatic hibernation is paused by your administrator."
+	record := journal.Record{Time: now, Type: journal.TypePaused, Source: journal.SourceService, Reason: "service paused"}
+	if until.IsZero() {
+		s.logger.Info(logger.EventServicePaused, "Service paused: idle actions are suspended until the service is continued")
+	} else {
+		message = fmt.Sprintf("Automatic hibernation is paused by your administrator until %s.", until.Format("Mon 15:04"))
+		record.Until = &until
+		s.logger.Infof(logger.EventServicePaused, "Service paused: idle actions are suspended until the service is continued or %s", until.Format(time.RFC3339))
+	}
+	s.record(record)
+
+	// Cancel an active warning now rather than at the next scheduled check
+	s.requestCheck()
+	s.notifySessions(message)
+	return timeout
+}
+
+// continueService ends a service pause. The idle timers start again from now, so time spent
+// paused does not count towards a threshold.
+func (s *AutoHibernateService) continueService(now time.Time, reason string) {
+	s.statusMu.Lock()
+	s.servicePausedAt = time.Time{}
+	s.servicePauseUntil = time.Time{}
+	s.statusMu.Unlock()
+
+	s.idleMonitor.Reset()
+	s.idleMonitor.Rearm(monitor.IdleConditionInactiveUser, now)
+	s.logger.Infof(logger.EventServiceContinued, "Service continued (%s): idle timers restarted", reason)
+
+	s.requestCheck()
+	s.notifySessions("Automatic hibernation has resumed.")
+}
+
+// servicePause returns the pause set through the service control manager, if one is in force
+func (s *AutoHibernateService) servicePause() (since, until time.Time, paused bool) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.servicePausedAt, s.servicePauseUntil, !s.servicePausedAt.IsZero()
+}
+
+// notifySessions shows an informational notification in the background, so the service
+// control handler is not held up while notifiers start
+func (s *AutoHibernateService) notifySessions(message string) {
+	if s.notifierManager == nil {
+		return
+	}
+	go func() {
+		if err := s.notifierManager.SendInfo(context.Background(), message); err != nil {
+			s.logger.Warningf(logger.EventNotificationError, "Failed to notify sessions: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,147 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+)
+
+// controlTimeout bounds reading the remote control value from IMDS
+const controlTimeout = 5 * time.Second
+
+// refreshControl reads the remote control value (autohibernate:mode / pauseUntil) from
+// IMDS and applies it. If it cannot be read, the mode in force is kept.
+func (s *AutoHibernateService) refreshControl(ctx context.Context) {
+	if s.config.DisableRemoteControl {
+		return
+	}
+
+	ctx, end := s.beginOperation(ctx, "remote control refresh")
+	defer end()
+	ctx, cancel := context.WithTimeout(ctx, controlTimeout)
+	defer cancel()
+
+	control, err := azure.GetControl(ctx, s.config.IMDSEndpoint)
+	if err != nil {
+		// Warn once per run of failures to avoid flooding the event log
+		if !s.controlFailing {
+			s.logger.Warningf(logger.EventRemoteControlWarning, "Failed to read remote control value, keeping mode %s: %v", s.controlMode, err)
+		} else {
+			s.logger.Debugf(logger.EventRemoteControlWarning, "Failed to read remote control value: %v", err)
+		}
+		s.controlFailing = true
+		return
+	}
+	s.controlFailing = false
+
+	s.applyControl(ctx, control, time.Now())
+}
+
+// applyControl applies a remote control value, logging changes and notifying
+// users when a pause begins or ends
+func (s *AutoHibernateService) applyControl(ctx context.Context, control azure.Control, now time.Time) {
+	if !reflect.DeepEqual(control, s.control) {
+		s.logger.Infof(logger.EventRemoteControlChanged, "Remote control value: %s", control)
+		for _, warning := range control.Warnings {
+			s.logger.Warningf(logger.EventRemoteControlWarning, "Ignoring invalid remote control value: %s", warning)
+		}
+		s.statusMu.Lock()
+		s.control = control
+		s.statusMu.Unlock()
+	}
+
+	mode := control.EffectiveMode(now)
+	previous := s.controlMode
+	if mode == previous {
+		return
+	}
+	s.statusMu.Lock()
+	s.controlMode = mode
+	s.statusMu.Unlock()
+	s.logger.Infof(logger.EventRemoteControlChanged, "Auto-hibernation mode changed from %s to %s", previous, mode)
+
+	var message string
+	switch {
+	case mode == azure.ModePaused:
+		message = pauseMessage(control, now)
+		paused := journal.Record{Time: now, Type: journal.TypePaused, Source: journal.SourceRemote, Reason: control.String()}
+		if now.Before(control.PauseUntil) {
+			until := control.PauseUntil
+			paused.Until = &until
+		}
+		s.record(paused)
+	case previous == azure.ModePaused:
+		message = "Automatic hibernation has resumed."
+	default:
+		return
+	}
+
+	if s.notifierManager != nil {
+		if err := s.notifierManager.SendInfo(ctx, message); err != nil {
+			s.logger.Warningf(logger.EventNotificationError, "Failed to notify sessions of remote control change: %v", err)
+		}
+	}
+}
+
+// pauseReason reports why idle checks are paused, if they are
+func (s *AutoHibernateService) pauseReason() (string, bool) {
+	if reasons := s.inhibitors(); len(reasons) > 0 {
+		return reasons[0], true
+	}
+	return "", false
+}
+
+// inhibitors returns every reason idle checks are paused, most important first
+func (s *AutoHibernateService) inhibitors() []string {
+	reasons := []string{}
+	if event, pending := s.pendingScheduledEvent(); pending {
+		reasons = append(reasons, fmt.Sprintf("Azure scheduled event %s is pending", event))
+	}
+	if since, until, paused := s.servicePause(); paused {
+		if until.IsZero() {
+			reasons = append(reasons, fmt.Sprintf("the service is paused (since %s)", since.Format("15:04")))
+		} else {
+			reasons = append(reasons, fmt.Sprintf("the service is paused until %s", until.Format("15:04")))
+		}
+	}
+	if mode, control := s.remoteControl(); mode == azure.ModePaused {
+		reasons = append(reasons, fmt.Sprintf("auto-hibernation is paused by remote control (%s)", control))
+	}
+	if until, reason, paused := s.localPause(time.Now()); paused {
+		reasons = append(reasons, fmt.Sprintf("auto-hibernation is paused until %s: %s", until.Format("15:04"), reason))
+	}
+	if s.mailer != nil {
+		if lease, ok := s.mailer.activeLease(); ok {
+			reasons = append(reasons, fmt.Sprintf("%s asked to keep the VM awake until %s", lease.User, lease.Until.Format("15:04")))
+		}
+	}
+	return reasons
+}
+
+// remoteControl returns the remote control mode in force and the value it came from
+func (s *AutoHibernateService) remoteControl() (string, azure.Control) {
+	s.statusMu.Lock()
+	defer s.statusMu.Unlock()
+	return s.controlMode, s.control
+}
+
+// currentMode returns the remote control mode in force
+func (s *AutoHibernateService) currentMode() string {
+	mode, _ := s.remoteControl()
+	return mode
+}
+
+// pauseMessage returns the notification shown to users when a remote pause begins
+func pauseMessage(control azure.Control, now time.Time) string {
+	if now.Before(control.PauseUntil) {
+		return fmt.Sprintf("Automatic hibernation is paused by your administrator until %s.", control.PauseUntil.Local().Format("Mon 15:04"))
+	}
+	return "Automatic hibernation is paused by your administrator."
+}
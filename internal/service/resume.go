@@ -0,0 +1,183 @@
+//go:build windows
+
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+)
+
+const (
+	// requestFileName is written under ProgramData when an action takes the VM down
+	requestFileName = "hibernation.json"
+	// requestConfirmWindow is how soon after a request the VM must go down for the
+	// request to count as the cause; a later suspend is an ordinary sleep
+	requestConfirmWindow = 10 * time.Minute
+)
+
+// Resume outcomes recorded for the last hibernation request
+const (
+	outcomeHibernated = "hibernated" // The request took the VM down
+	outcomeSleep      = "sleep"      // An ordinary sleep; no request was pending
+	outcomeNotApplied = "notApplied" // A request was pending, but the VM went down too late for it to be the cause
+)
+
+// hibernationRequest is the last action that should take the VM down, and what happened
+// to it. The outcome is empty until the VM resumes.
+type hibernationRequest struct {
+	RequestedAt time.Time  `json:"requestedAt,omitzero"`
+	Action      string     `json:"action,omitempty"`
+	Condition   string     `json:"condition,omitempty"`
+	Reason      string     `json:"reason,omitempty"`
+	SuspendedAt *time.Time `json:"suspendedAt,omitempty"` // When the VM suspended (unknown after a shutdown)
+	ResumedAt   *time.Time `json:"resumedAt,omitempty"`   // When the VM resumed or booted again
+	Outcome     string     `json:"outcome,omitempty"`
+}
+
+// pending reports whether the request has not been reconciled with a resume yet
+func (r *hibernationRequest) pending() bool {
+	return r != nil && r.Outcome == ""
+}
+
+// stopsVM reports whether a completed action takes the VM down
+func stopsVM(completed action.Action) bool {
+	switch completed.Name() {
+	case config.ActionHibernate, config.ActionDeallocate, config.ActionLocalHibernate, config.ActionOSShutdown:
+		return true
+	}
+	return false
+}
+
+// recordRequest persists a completed action that takes the VM down, so the resume can
+// be matched against it, even if the service restarts in between
+func (s *AutoHibernateService) recordRequest(completed action.Action, idle *monitor.CheckResult, now time.Time) {
+	if s.requestPath == "" || !stopsVM(completed) {
+		return
+	}
+	req := &hibernationRequest{
+		RequestedAt: now,
+		Action:      completed.Name(),
+		Condition:   idle.Condition.String(),
+		Reason:      idle.Reason,
+	}
+	if err := writeRequest(s.requestPath, req); err != nil {
+		s.logger.Warningf(logger.EventResumeReconciled, "Failed to record hibernation request: %v", err)
+	}
+}
+
+// reconcileResume classifies a resume from suspend against the last request, records
+// the outcome and returns a description of it
+func (s *AutoHibernateService) reconcileResume(now time.Time) string {
+	suspendAt := s.suspendAt
+	s.suspendAt = nil
+	if suspendAt == nil {
+		// The suspend notification was missed; assume the VM went down just before the resume
+		suspendAt = &now
+	}
+	return s.reconcile(suspendAt, now)
+}
+
+// reconcileBoot checks at service start whether the VM restarted because of the last
+// request, e.g. after a deallocate. A service restart without a reboot leaves it pending.
+func (s *AutoHibernateService) reconcileBoot(now time.Time) {
+	if s.requestPath == "" {
+		return
+	}
+	req, err := readRequest(s.requestPath)
+	if err != nil || !req.pending() {
+		return
+	}
+	uptime, err := monitor.GetSystemUptime()
+	if err != nil {
+		return
+	}
+	if bootAt := now.Add(-uptime); bootAt.After(req.RequestedAt) {
+		outcome := s.reconcile(nil, bootAt)
+		s.record(journal.Record{Time: bootAt, Type: journal.TypeResumed, Action: req.Action, Reason: outcome})
+	}
+}
+
+// reconcile records the outcome of the VM suspending at suspendAt (nil after a shutdown)
+// and resuming at resumedAt
+func (s *AutoHibernateService) reconcile(suspendAt *time.Time, resumedAt time.Time) string {
+	if s.requestPath == "" {
+		return ""
+	}
+	req, err := readRequest(s.requestPath)
+	if err != nil {
+		s.logger.Warningf(logger.EventResumeReconciled, "Failed to read hibernation request: %v", err)
+		return ""
+	}
+
+	outcome, description := classifyResume(req, suspendAt)
+	if !req.pending() {
+		// Earlier requests were already reconciled; record this sleep on its own
+		req = &hibernationRequest{}
+	}
+	req.SuspendedAt = suspendAt
+	req.ResumedAt = &resumedAt
+	req.Outcome = outcome
+
+	if outcome == outcomeNotApplied {
+		s.logger.Warningf(logger.EventResumeReconciled, "Resume reconciled: %s", description)
+	} else {
+		s.logger.Infof(logger.EventResumeReconciled, "Resume reconciled: %s", description)
+	}
+	if err := writeRequest(s.requestPath, req); err != nil {
+		s.logger.Warningf(logger.EventResumeReconciled, "Failed to record resume outcome: %v", err)
+	}
+	return description
+}
+
+// classifyResume decides whether the VM suspending at suspendAt was caused by the request.
+// A nil suspendAt is a reboot after the request, which the request caused.
+func classifyResume(req *hibernationRequest, suspendAt *time.Time) (outcome, description string) {
+	if !req.pending() {
+		return outcomeSleep, "ordinary sleep, no hibernation was requested"
+	}
+	if suspendAt != nil {
+		if after := suspendAt.Sub(req.RequestedAt); after < 0 || after > requestConfirmWindow {
+			return outcomeNotApplied, fmt.Sprintf("%s requested at %s did not take effect; the VM suspended at %s in an ordinary sleep",
+				req.Action, req.RequestedAt.Format(time.RFC3339), suspendAt.Format(time.RFC3339))
+		}
+	}
+	return outcomeHibernated, fmt.Sprintf("%s requested at %s took effect (%s)",
+		req.Action, req.RequestedAt.Format(time.RFC3339), req.Reason)
+}
+
+// readRequest returns the recorded hibernation request, or nil if there is none
+func readRequest(path string) (*hibernationRequest, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var req hibernationRequest
+	if err := json.Unmarshal(data, &req); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &req, nil
+}
+
+// writeRequest saves a hibernation request and its outcome
+func writeRequest(path string, req *hibernationRequest) error {
+	data, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0644)
+}
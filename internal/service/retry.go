@@ -0,0 +1,136 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+)
+
+// actionTimeout bounds one attempt at an action
+const actionTimeout = 30 * time.Second
+
+// attemptAction runs one attempt at the idle action. The idle monitor is reset only once
+// the action is accepted; a failure schedules a retry or escalates to the fallback action.
+func (s *AutoHibernateService) attemptAction(ctx context.Context, primary action.Action, idle *monitor.CheckResult) {
+	attempt := s.attempts.Failures() + 1
+	log := s.tracedLogger(ctx)
+	if attempt == 1 {
+		log.Infof(logger.EventHibernationTriggered, "Hibernation triggered: %s (action: %s)", idle.Reason, primary.Name())
+	} else {
+		log.Infof(logger.EventHibernationTriggered, "Retrying %s (attempt %d of %d): %s", primary.Name(), attempt, s.attempts.MaxAttempts(), idle.Reason)
+	}
+
+	actionCtx, end := s.beginOperation(ctx, "action "+primary.Name())
+	actionCtx, span := tracing.Start(actionCtx, "action "+primary.Name(),
+		tracing.String("condition", idle.Condition.String()),
+		tracing.Int("retry.count", attempt-1))
+	actionCtx, cancel := actionContext(actionCtx, idle)
+	err := s.runActionWithFallback(actionCtx, primary, nil, idle)
+	cancel()
+	span.End(err)
+	end()
+	if abandoned(ctx) {
+		// The loop was restarted while the action ran: the new loop decides whether to retry
+		log.Warningf(logger.EventMonitorStalled, "Action %s returned after the monitor loop was restarted - leaving the outcome to the new loop", primary.Name())
+		return
+	}
+	if err == nil {
+		s.actionAccepted()
+		return
+	}
+
+	state := s.attempts.Failed(time.Now(), azure.IsRetryable(err), azure.RetryAfter(err))
+	if state == action.AttemptRetrying {
+		log.Warningf(logger.EventActionRetrying, "Action %s failed (attempt %d of %d) - retrying at %s",
+			primary.Name(), attempt, s.attempts.MaxAttempts(), s.attempts.RetryAt().Format("15:04:05"))
+		return
+	}
+	s.escalate(ctx, primary, idle, err)
+}
+
+// escalate gives up on the idle action: it logs an error, tells users and runs the fallback
+// action. Whatever the outcome, the idle monitor is reset, so the next attempt waits for a
+// full idle period.
+func (s *AutoHibernateService) escalate(ctx context.Context, primary action.Action, idle *monitor.CheckResult, err error) {
+	log := s.tracedLogger(ctx)
+	why := fmt.Sprintf("after %d attempt(s)", s.attempts.Failures())
+	if !azure.IsRetryable(err) {
+		why = "with an error that cannot be retried"
+	}
+
+	fallback := s.fallbackAction
+	if action.IsNone(fallback) || fallback.Name() == primary.Name() {
+		fallback = nil
+	}
+
+	message := fmt.Sprintf("%s could not %s and will stay running", s.vmName(), action.Verb(primary.Name()))
+	if fallback != nil {
+		message = fmt.Sprintf("%s could not %s; it will %s instead", s.vmName(), action.Verb(primary.Name()), action.Verb(fallback.Name()))
+		log.Errorf(logger.EventActionEscalated, "Action %s failed %s - running fallback action %s: %s", primary.Name(), why, fallback.Name(), describeError(err))
+	} else {
+		log.Errorf(logger.EventActionEscalated, "Action %s failed %s and no fallback action is configured - waiting for the next idle period: %s", primary.Name(), why, describeError(err))
+	}
+
+	if s.notifierManager != nil {
+		if err := s.notifierManager.SendInfo(ctx, message); err != nil {
+			log.Warningf(logger.EventNotificationError, "Failed to send escalation notification: %v", err)
+		}
+	}
+	if abandoned(ctx) {
+		return
+	}
+
+	if fallback != nil {
+		ctx, end := s.beginOperation(ctx, "action "+fallback.Name())
+		defer end()
+		ctx, span := tracing.Start(ctx, "action "+fallback.Name(),
+			tracing.String("condition", idle.Condition.String()),
+			tracing.String("escalated_from", primary.Name()))
+		ctx, cancel := actionContext(ctx, idle)
+		defer cancel()
+		err := s.runActionWithFallback(ctx, fallback, nil, idle)
+		span.End(err)
+		if abandoned(ctx) {
+			return
+		}
+		if err == nil {
+			s.actionAccepted()
+			return
+		}
+	}
+	s.attempts.Reset()
+	s.idleMonitor.Reset()
+}
+
+// actionAccepted resets the idle monitor and the attempts once an action was accepted,
+// so the state is clean when the VM resumes
+func (s *AutoHibernateService) actionAccepted() {
+	s.attempts.Reset()
+	s.idleMonitor.Reset()
+	s.logger.Debug(logger.EventHibernationTriggered, "Idle monitor state reset for clean resume")
+}
+
+// actionContext returns the context of one attempt, carrying the hibernation details
+func actionContext(parent context.Context, idle *monitor.CheckResult) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithTimeout(parent, actionTimeout)
+	return azure.WithHibernationInfo(ctx, azure.HibernationInfo{
+		Reason:    idle.Reason,
+		Condition: idle.Condition.String(),
+	}), cancel
+}
+
+// vmName returns the VM name used in user notifications
+func (s *AutoHibernateService) vmName() string {
+	if s.vm != nil && s.vm.VMName != "" {
+		return s.vm.VMName
+	}
+	return "The VM"
+}
@@ -0,0 +1,58 @@
+//go:build windows
+
+package service
+
+import (
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/savings"
+)
+
+// recordStopped adds a stop record to the savings ledger when the completed action
+// stops compute billing (hibernate or deallocate)
+func (s *AutoHibernateService) recordStopped(completed action.Action, now time.Time) {
+	if s.ledger == nil {
+		return
+	}
+	name := completed.Name()
+	if name != config.ActionHibernate && name != config.ActionDeallocate {
+		return
+	}
+
+	err := s.ledger.Append(savings.Record{
+		Type:   savings.RecordStopped,
+		Time:   now,
+		Action: name,
+		VMSize: s.vmSize,
+		Region: s.region,
+	})
+	if err != nil {
+		s.logger.Warningf(logger.EventSavingsWarning, "Failed to record hibernation for savings report: %v", err)
+		return
+	}
+	s.logger.Debugf(logger.EventSavingsRecorded, "Recorded %s of %s in %s for savings report", name, s.vmSize, s.region)
+}
+
+// recordResumed adds a resume record to the savings ledger if the VM was recorded as stopped
+func (s *AutoHibernateService) recordResumed(now time.Time) {
+	if s.ledger == nil {
+		return
+	}
+	stopped, err := s.ledger.Stopped()
+	if err != nil {
+		s.logger.Warningf(logger.EventSavingsWarning, "Failed to read savings ledger: %v", err)
+		return
+	}
+	if !stopped {
+		return
+	}
+
+	if err := s.ledger.Append(savings.Record{Type: savings.RecordResumed, Time: now}); err != nil {
+		s.logger.Warningf(logger.EventSavingsWarning, "Failed to record resume for savings report: %v", err)
+		return
+	}
+	s.logger.Debugf(logger.EventSavingsRecorded, "Recorded resume at %s for savings report", now.Format("15:04:05"))
+}
@@ -0,0 +1,283 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/savings"
+)
+
+const (
+	// scheduledEventsTimeout bounds a single poll; the first request can take up to two
+	// minutes while IMDS enables Scheduled Events for the VM
+	scheduledEventsTimeout = 2 * time.Minute
+
+	// checkpointFileName is written under ProgramData when an event will interrupt the VM
+	checkpointFileName = "checkpoint.json"
+
+	// acknowledgeLead is how long before NotBefore an event is approved while a user is
+	// active, so users keep the grace period to save their work
+	acknowledgeLead = 2 * time.Minute
+)
+
+// checkpoint records the service state when a scheduled event is about to interrupt the VM,
+// so the next start can report why the previous run ended
+type checkpoint struct {
+	Time  time.Time            `json:"time"`  // When the event was received
+	Event azure.ScheduledEvent `json:"event"` // The event interrupting the VM
+}
+
+// scheduledEventsLoop polls IMDS Scheduled Events until the service stops
+func (s *AutoHibernateService) scheduledEventsLoop() {
+	interval := time.Duration(s.config.ScheduledEvents.PollIntervalSeconds) * time.Second
+	s.logger.Infof(logger.EventServiceStart, "Polling Azure Scheduled Events every %v", interval)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	failing := false
+	for {
+		ctx, cancel := context.WithTimeout(context.Background(), scheduledEventsTimeout)
+		changes, err := s.eventPoller.Poll(ctx)
+		cancel()
+
+		if err != nil {
+			// Warn once per run of failures to avoid flooding the event log
+			if !failing {
+				s.logger.Warningf(logger.EventScheduledEventWarning, "Failed to poll Azure Scheduled Events: %v", err)
+			} else {
+				s.logger.Debugf(logger.EventScheduledEventWarning, "Failed to poll Azure Scheduled Events: %v", err)
+			}
+			failing = true
+		} else {
+			if failing {
+				s.logger.Info(logger.EventScheduledEventReceived, "Azure Scheduled Events polling recovered")
+			}
+			failing = false
+			s.handleScheduledEvents(changes)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-s.stopChan:
+			s.logger.Info(logger.EventServiceStop, "Scheduled events loop stopping")
+			return
+		}
+	}
+}
+
+// handleScheduledEvents logs event changes, notifies sessions of new events and
+// pauses idle checks while any event is pending
+func (s *AutoHibernateService) handleScheduledEvents(changes *azure.ScheduledEventChanges) {
+	for _, e := range changes.New {
+		if e.IsSpotEviction() {
+			s.logger.Warningf(logger.EventSpotEviction, "Azure Spot eviction scheduled: %s", e)
+		} else {
+			s.logger.Infof(logger.EventScheduledEventReceived, "Azure scheduled event received: %s - %s", e, e.Description)
+		}
+
+		if e.InterruptsVM() {
+			s.saveCheckpoint(e)
+
+			if s.notifierManager != nil {
+				if err := s.notifierManager.SendInfo(context.Background(), scheduledEventMessage(e)); err != nil {
+					s.logger.Warningf(logger.EventNotificationError, "Failed to notify sessions of scheduled event %s: %v", e.EventId, err)
+				}
+			}
+		}
+	}
+	for _, e := range changes.Started {
+		s.logger.Infof(logger.EventScheduledEventStarted, "Azure scheduled event started: %s", e)
+	}
+	for _, e := range changes.Completed {
+		s.logger.Infof(logger.EventScheduledEventCompleted, "Azure scheduled event completed: %s %s", e.EventType, e.EventId)
+	}
+
+	s.eventsMu.Lock()
+	wasPaused := len(s.pendingEvents) > 0
+	s.pendingEvents = changes.Pending
+	s.eventsMu.Unlock()
+
+	if !wasPaused && len(changes.Pending) > 0 {
+		s.logger.Infof(logger.EventIdleChecksPaused, "Idle checks paused while %d Azure scheduled event(s) are pending", len(changes.Pending))
+	} else if wasPaused && len(changes.Pending) == 0 {
+		s.logger.Info(logger.EventIdleChecksPaused, "Azure scheduled events completed, idle checks resumed")
+		s.removeCheckpoint()
+	}
+
+	if s.config.ScheduledEvents.Acknowledge {
+		s.acknowledgeScheduledEvents(changes.Pending)
+	}
+}
+
+// acknowledgeScheduledEvents approves pending events so maintenance starts early, but only
+// once no user is active or NotBefore is near
+func (s *AutoHibernateService) acknowledgeScheduledEvents(pending []azure.ScheduledEvent) {
+	s.eventsMu.Lock()
+	// Forget events that are no longer pending
+	for id := range s.acknowledgedEvents {
+		if !containsEvent(pending, id) {
+			delete(s.acknowledgedEvents, id)
+		}
+	}
+	acknowledged := maps.Clone(s.acknowledgedEvents)
+	s.eventsMu.Unlock()
+
+	events := eventsToAcknowledge(pending, acknowledged, time.Now(), s.vmIdle)
+	if len(events) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := s.eventPoller.Acknowledge(ctx, events...); err != nil {
+		s.logger.Warningf(logger.EventScheduledEventWarning, "Failed to acknowledge Azure scheduled events: %v", err)
+		return
+	}
+	s.logger.Infof(logger.EventScheduledEventReceived, "Acknowledged %d Azure scheduled event(s)", len(events))
+
+	s.eventsMu.Lock()
+	if s.acknowledgedEvents == nil {
+		s.acknowledgedEvents = make(map[string]bool)
+	}
+	for _, e := range events {
+		s.acknowledgedEvents[e.EventId] = true
+	}
+	s.eventsMu.Unlock()
+}
+
+// eventsToAcknowledge returns the scheduled events not yet acknowledged that may be approved
+// at now: those starting within acknowledgeLead, or all of them if idle reports that no user
+// is active. idle is only called when an event is not yet due.
+func eventsToAcknowledge(pending []azure.ScheduledEvent, acknowledged map[string]bool, now time.Time, idle func() bool) []azure.ScheduledEvent {
+	var events []azure.ScheduledEvent
+	checkedIdle, isIdle := false, false
+	for _, e := range pending {
+		if e.EventStatus != azure.ScheduledEventStatusScheduled || acknowledged[e.EventId] {
+			continue
+		}
+		if notBefore, ok := e.NotBeforeTime(); ok && notBefore.Sub(now) > acknowledgeLead {
+			if !checkedIdle {
+				checkedIdle, isIdle = true, idle()
+			}
+			if !isIdle {
+				continue
+			}
+		}
+		events = append(events, e)
+	}
+	return events
+}
+
+// containsEvent reports whether events holds the event with the given ID
+func containsEvent(events []azure.ScheduledEvent, id string) bool {
+	for _, e := range events {
+		if e.EventId == id {
+			return true
+		}
+	}
+	return false
+}
+
+// vmIdle reports whether no user session is active, so an early start interrupts nobody.
+// A failure to list sessions counts as not idle.
+func (s *AutoHibernateService) vmIdle() bool {
+	sessions, err := monitor.GetActiveSessions()
+	if err != nil {
+		s.logger.Debugf(logger.EventScheduledEventWarning, "Failed to list sessions: %v", err)
+		return false
+	}
+	for _, session := range sessions {
+		if session.IsActive {
+			return false
+		}
+	}
+	return true
+}
+
+// pendingScheduledEvent returns the first pending scheduled event, if any
+func (s *AutoHibernateService) pendingScheduledEvent() (azure.ScheduledEvent, bool) {
+	s.eventsMu.Lock()
+	defer s.eventsMu.Unlock()
+	if len(s.pendingEvents) == 0 {
+		return azure.ScheduledEvent{}, false
+	}
+	return s.pendingEvents[0], true
+}
+
+// scheduledEventMessage returns the notification shown to users for a scheduled event
+func scheduledEventMessage(e azure.ScheduledEvent) string {
+	when := ""
+	if notBefore, ok := e.NotBeforeTime(); ok {
+		when = " at " + notBefore.Local().Format("15:04")
+	}
+
+	switch e.EventType {
+	case azure.ScheduledEventPreempt:
+		return fmt.Sprintf("Azure is evicting this Spot VM%s. Save your work now.", when)
+	case azure.ScheduledEventTerminate:
+		return fmt.Sprintf("Azure is deleting this VM%s. Save your work now.", when)
+	default:
+		return fmt.Sprintf("Azure platform maintenance (%s) will interrupt this VM%s. Save your work; automatic hibernation is paused until it completes.", e.EventType, when)
+	}
+}
+
+// checkpointPath returns the path of the checkpoint file under ProgramData
+func checkpointPath() string {
+	return savings.DataPath(checkpointFileName)
+}
+
+// saveCheckpoint records the service state before a scheduled event interrupts the VM
+func (s *AutoHibernateService) saveCheckpoint(e azure.ScheduledEvent) {
+	path := checkpointPath()
+	data, err := json.MarshalIndent(checkpoint{Time: time.Now(), Event: e}, "", "  ")
+	if err != nil {
+		s.logger.Warningf(logger.EventScheduledEventWarning, "Failed to encode checkpoint: %v", err)
+		return
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		s.logger.Warningf(logger.EventScheduledEventWarning, "Failed to save checkpoint: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		s.logger.Warningf(logger.EventScheduledEventWarning, "Failed to save checkpoint: %v", err)
+		return
+	}
+	s.logger.Debugf(logger.EventScheduledEventReceived, "Checkpoint saved to %s", path)
+}
+
+// removeCheckpoint deletes the checkpoint once no event is pending
+func (s *AutoHibernateService) removeCheckpoint() {
+	if err := os.Remove(checkpointPath()); err != nil && !os.IsNotExist(err) {
+		s.logger.Debugf(logger.EventScheduledEventWarning, "Failed to remove checkpoint: %v", err)
+	}
+}
+
+// restoreCheckpoint reports a checkpoint left by a previous run and removes it
+func (s *AutoHibernateService) restoreCheckpoint() {
+	path := checkpointPath()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	defer s.removeCheckpoint()
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		s.logger.Warningf(logger.EventScheduledEventWarning, "Ignoring unreadable checkpoint %s: %v", path, err)
+		return
+	}
+	s.logger.Infof(logger.EventScheduledEventCompleted, "Previous run was interrupted by Azure scheduled event %s (checkpoint saved %s)",
+		cp.Event, cp.Time.Format(time.RFC3339))
+}
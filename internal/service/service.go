@@ -4,16 +4,28 @@ package service
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"net/http"
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
 	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
 	"github.com/smitstech/AzureAutoHibernate/internal/azure"
 	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/control"
+	"github.com/smitstech/AzureAutoHibernate/internal/hook"
+	"github.com/smitstech/AzureAutoHibernate/internal/journal"
 	"github.com/smitstech/AzureAutoHibernate/internal/logger"
 	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/savings"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
 	"github.com/smitstech/AzureAutoHibernate/internal/updater"
 	"github.com/smitstech/AzureAutoHibernate/internal/version"
+	"github.com/smitstech/AzureAutoHibernate/internal/watchdog"
+	"github.com/smitstech/AzureAutoHibernate/internal/webhook"
 	"golang.org/x/sys/windows/svc"
 	"golang.org/x/sys/windows/svc/debug"
 )
@@ -25,22 +37,70 @@ const (
 	warningCheckInterval = 5 * time.Second
 	// minCheckInterval is the minimum interval for idle state checks
 	minCheckInterval = 5 * time.Second
+	// updateDownloadTimeout bounds downloading an update after the check found one
+	updateDownloadTimeout = 30 * time.Minute
 )
 
 type AutoHibernateService struct {
 	config               *config.Config
 	idleMonitor          *monitor.IdleMonitor
 	azureClient          *azure.AzureClient
+	eventPoller          *azure.ScheduledEventPoller             // Polls IMDS Scheduled Events (nil if disabled)
+	eventsMu             sync.Mutex                              // Guards pendingEvents and acknowledgedEvents
+	pendingEvents        []azure.ScheduledEvent                  // Scheduled events pending for the VM; idle checks pause while set
+	acknowledgedEvents   map[string]bool                         // IDs of pending events already approved
+	actions              map[monitor.IdleCondition]action.Action // Action to run for each idle condition
+	fallbackAction       action.Action                           // Action to run when the primary action fails
+	attempts             action.Attempts                         // Failed attempts at the idle action and the next retry
+	preHibernateHooks    []hook.Hook                             // Commands run before the action; one can veto it
+	postResumeHooks      []hook.Hook                             // Commands run in the background after a resume
+	resumeHooksRunning   atomic.Bool                             // Set while post-resume hooks run
+	requestPath          string                                  // File holding the last hibernation request and its outcome ("" if unavailable)
+	suspendAt            *time.Time                              // When the system last suspended (nil once reconciled)
 	notifierManager      *NotifierManager
+	webhooks             *webhook.Dispatcher // Posts lifecycle events to webhooks (nil if none configured)
+	mailer               *mailer             // Emails disconnected users and serves keep-awake links (nil if not configured)
+	metricsServer        *http.Server        // Serves Prometheus metrics (nil if disabled)
+	tracer               *tracing.Exporter   // Exports spans to the OTLP collector (nil if disabled)
+	tracingFailing       atomic.Bool         // Set after a failed span export was logged as a warning
+	journal              *journal.Journal    // History of warnings, hibernations, resumes, pauses and updates
+	vm                   *azure.VMMetadata   // VM identity reported in webhook events
 	logger               logger.Logger
 	stopChan             chan struct{}
-	stopOnce             sync.Once  // Ensures stopChan is only closed once
+	stopOnce             sync.Once // Ensures stopChan is only closed once
 	lastNotificationTime time.Time
-	resumeAt             *time.Time // Tracks when system resumed from hibernate/sleep
-	updatePending        bool       // Flag to indicate an update is ready to apply
+	warningAnnounced     bool            // Set once webhooks and emails were sent for the current warning period
+	control              azure.Control   // Last remote control value read from tags or user data
+	controlMode          string          // Remote control mode in force (enabled, paused or dryrun)
+	controlFailing       bool            // Set while the remote control value cannot be read
+	resumeAt             *time.Time      // Service start or last resume from hibernate/sleep (guarded by statusMu)
+	ledger               *savings.Ledger // Records stops and resumes for the savings report (nil if unavailable)
+	vmSize               string          // VM size from IMDS, recorded with each stop
+	region               string          // Azure region from IMDS, recorded with each stop
+	updatePending        bool            // Flag to indicate an update is ready to apply
+
+	// Monitor loop watchdog
+	watchdog       *watchdog.Watchdog // Tracks the monitor loop heartbeat and cancels a stalled operation (nil disables it)
+	loopGeneration atomic.Uint64      // Generation of the running monitor loop; older loops exit
+	loopRestarts   atomic.Int64       // Monitor loops restarted by the watchdog
+
+	// Local control API
+	controlServer     *control.Server       // Serves the control pipe (nil if unavailable)
+	manualAction      action.Action         // Action run by a hibernate request
+	checkNow          chan struct{}         // Wakes the monitor loop for an idle check
+	hibernateRequests chan hibernateRequest // Hibernate requests run by the monitor loop
+	statusMu          sync.Mutex            // Guards the fields below and remote control writes
+	lastCheckAt       time.Time             // When the last idle check ran
+	nextCheckAt       time.Time             // When the next idle check is due
+	localPauseUntil   time.Time             // End of a pause set through the control API
+	localPauseReason  string                // Reason given for that pause
+	servicePausedAt   time.Time             // When the service control manager paused the service (zero if running)
+	servicePauseUntil time.Time             // When that pause ends on its own (zero for no limit)
 }
 
-func NewAutoHibernateService(cfg *config.Config, vmMetadata *azure.VMMetadata, log logger.Logger) *AutoHibernateService {
+// NewAutoHibernateService builds the service. It fails if the configured Azure credential
+// cannot be created, rather than acting under another identity.
+func NewAutoHibernateService(cfg *config.Config, vmMetadata *azure.VMMetadata, log logger.Logger) (*AutoHibernateService, error) {
 	now := time.Now()
 
 	// Create notifier manager (optional - will be nil if notifier executable not found)
@@ -50,29 +110,112 @@ func NewAutoHibernateService(cfg *config.Config, vmMetadata *azure.VMMetadata, l
 		notifierManager = nil
 	}
 
-	return &AutoHibernateService{
-		config: cfg,
-		idleMonitor: monitor.NewIdleMonitor(
-			cfg.NoUsersIdleMinutes,
-			cfg.AllDisconnectedIdleMinutes,
-			cfg.InactiveUserIdleMinutes,
-			cfg.InactiveUserWarningMinutes,
-			cfg.MinimumUptimeMinutes,
-		),
-		azureClient: azure.NewAzureClient(
-			vmMetadata.SubscriptionId,
-			vmMetadata.ResourceGroup,
-			vmMetadata.VMName,
-		),
-		notifierManager: notifierManager,
-		logger:          log,
-		stopChan:        make(chan struct{}),
-		resumeAt:        &now, // Initialize to service start time
+	cloud, err := azure.ResolveCloud(cfg.Cloud, vmMetadata.AzEnvironment)
+	if err != nil {
+		log.Errorf(logger.EventConfigError, "Failed to resolve Azure cloud: %v - falling back to %s", err, azure.AzurePublicCloud.Name)
+		cloud = azure.AzurePublicCloud
+	}
+
+	credential, err := azure.NewCredential(cfg, cloud)
+	if err != nil {
+		log.Errorf(logger.EventAzureAuthError, "Failed to create Azure credential: %v", err)
+		return nil, fmt.Errorf("failed to create Azure credential: %w", err)
+	}
+
+	azureClient := azure.NewAzureClient(
+		vmMetadata.SubscriptionId,
+		vmMetadata.ResourceGroup,
+		vmMetadata.VMName,
+		cloud,
+		credential,
+	)
+
+	if cfg.StampVMTags {
+		azureClient.EnableTagging(func(err error) {
+			log.Warningf(logger.EventVMTagWarning, "Failed to stamp hibernation tags on VM (continuing): %s", describeError(err))
+		})
+	}
+
+	// Build the configured idle actions
+	deps := action.Deps{
+		Client:     azureClient,
+		Script:     cfg.ActionScript,
+		ScriptArgs: cfg.ActionScriptArgs,
+	}
+
+	requestPath := savings.DataPath(requestFileName)
+
+	var eventPoller *azure.ScheduledEventPoller
+	if !cfg.ScheduledEvents.Disabled {
+		eventPoller = azure.NewScheduledEventPoller(cfg.IMDSEndpoint, vmMetadata.VMName)
+	}
+
+	idleMonitor := monitor.NewIdleMonitor(
+		cfg.NoUsersIdleMinutes,
+		cfg.AllDisconnectedIdleMinutes,
+		cfg.InactiveUserIdleMinutes,
+		cfg.InactiveUserWarningMinutes,
+		cfg.MinimumUptimeMinutes,
+	)
+	idleMonitor.SetAllDisconnectedWarning(cfg.AllDisconnectedWarningMinutes)
+
+	// A failed idle action is retried, then escalates to the fallback action
+	retryPolicy := action.RetryPolicy{
+		MaxAttempts:  cfg.ActionRetry.MaxAttempts,
+		InitialDelay: time.Duration(cfg.ActionRetry.InitialDelaySeconds) * time.Second,
+		MaxDelay:     time.Duration(cfg.ActionRetry.MaxDelaySeconds) * time.Second,
+	}
+
+	s := &AutoHibernateService{
+		config:      cfg,
+		idleMonitor: idleMonitor,
+		azureClient: azureClient,
+		eventPoller: eventPoller,
+		actions: map[monitor.IdleCondition]action.Action{
+			monitor.IdleConditionNoUsers:         newAction(cfg.NoUsersAction, deps, log),
+			monitor.IdleConditionAllDisconnected: newAction(cfg.AllDisconnectedAction, deps, log),
+			monitor.IdleConditionInactiveUser:    newAction(cfg.InactiveUserAction, deps, log),
+		},
+		fallbackAction:    newAction(cfg.FallbackAction, deps, log),
+		attempts:          action.NewAttempts(retryPolicy),
+		preHibernateHooks: newHooks(cfg.PreHibernateHooks),
+		postResumeHooks:   newHooks(cfg.PostResumeHooks),
+		requestPath:       requestPath,
+		notifierManager:   notifierManager,
+		webhooks:          newWebhookDispatcher(cfg, log),
+		mailer:            newMailer(cfg, vmMetadata.VMName, log),
+		vm:                vmMetadata,
+		logger:            log,
+		stopChan:          make(chan struct{}),
+		resumeAt:          &now, // Initialize to service start time
+		ledger:            savings.NewLedger(savings.DataPath(savings.LedgerFileName)),
+		journal:           journal.New(journal.DefaultPath(), journal.DefaultMaxSize, journal.DefaultKeep),
+		vmSize:            vmMetadata.VMSize,
+		region:            vmMetadata.Location,
+		controlMode:       azure.ModeEnabled,
+		manualAction:      newAction(config.ActionHibernate, deps, log),
+		checkNow:          make(chan struct{}, 1),
+		hibernateRequests: make(chan hibernateRequest),
+		watchdog:          watchdog.New(time.Duration(cfg.WatchdogTimeoutSeconds)*time.Second, now),
+	}
+	if s.mailer != nil {
+		s.mailer.onLease = s.recordLease
+	}
+	return s, nil
+}
+
+// newAction creates the named action, returning nil (no action) if it cannot be built
+func newAction(name string, deps action.Deps, log logger.Logger) action.Action {
+	a, err := action.New(name, deps)
+	if err != nil {
+		log.Errorf(logger.EventConfigError, "Failed to create action %q: %v - no action will be taken", name, err)
+		return nil
 	}
+	return a
 }
 
 func (s *AutoHibernateService) Execute(args []string, r <-chan svc.ChangeRequest, changes chan<- svc.Status) (ssec bool, errno uint32) {
-	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPowerEvent
+	const cmdsAccepted = svc.AcceptStop | svc.AcceptShutdown | svc.AcceptPauseAndContinue | svc.AcceptPowerEvent | svc.AcceptSessionChange
 
 	changes <- svc.Status{State: svc.StartPending}
 
@@ -85,8 +228,39 @@ func (s *AutoHibernateService) Execute(args []string, r <-chan svc.ChangeRequest
 		}
 	}
 
-	// Start the monitoring loop
-	go s.monitorLoop()
+	// Serve the keep-awake links sent in warning emails
+	if s.mailer != nil {
+		s.mailer.start()
+	}
+
+	// Accept status queries and commands from administrators
+	s.startControl()
+
+	// Serve Prometheus metrics on the loopback address
+	s.startMetrics()
+
+	// Export spans to the local OTLP collector
+	s.startTracing()
+
+	// Report a checkpoint left by a scheduled event that interrupted the previous run
+	s.restoreCheckpoint()
+
+	// Note in the history if this run is a new version
+	s.recordUpdate(time.Now())
+
+	// A VM that was deallocated starts the service again on boot: close the stop in the savings ledger
+	// and check whether the last request is what took it down
+	s.recordResumed(time.Now())
+	s.reconcileBoot(time.Now())
+
+	// Start the monitoring loop, and the watchdog that restarts it if it stalls
+	s.startMonitorLoop()
+	go s.watchdogLoop()
+
+	// Start polling Azure Scheduled Events (pauses idle checks during maintenance)
+	if s.eventPoller != nil {
+		go s.scheduledEventsLoop()
+	}
 
 	// Start the update check loop if auto-update is enabled
 	if s.config.AutoUpdate {
@@ -96,19 +270,46 @@ func (s *AutoHibernateService) Execute(args []string, r <-chan svc.ChangeRequest
 	changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 	s.logger.Info(logger.EventServiceStart, "Service started and running")
 	s.logger.Infof(logger.EventServiceStart, "Running version: %s", version.Version)
+	s.logger.Infof(logger.EventServiceStart, "Azure cloud: %s", s.azureClient.Cloud())
+	s.logger.Infof(logger.EventServiceStart, "Azure credential: %s", s.azureClient.Credential())
+
+	// Fires when a service pause reaches servicePauseMaxMinutes (nil while running or without a limit)
+	var pauseTimeout <-chan time.Time
 
 loop:
-	for c := range r {
+	for {
+		var c svc.ChangeRequest
+		select {
+		case c = <-r:
+		case <-pauseTimeout:
+			pauseTimeout = nil
+			s.continueService(time.Now(), fmt.Sprintf("pause reached servicePauseMaxMinutes (%d)", s.config.ServicePauseMaxMinutes))
+			changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
+			continue
+		}
+
 		switch c.Cmd {
 		case svc.Interrogate:
 			changes <- c.CurrentStatus
 		case svc.Stop, svc.Shutdown:
 			s.logger.Info(logger.EventServiceStop, "Service stop requested")
 			break loop
+		case svc.Pause:
+			// Suspend idle actions; sessions and notifiers are still tracked
+			pauseTimeout = s.pauseService(time.Now())
+			changes <- svc.Status{State: svc.Paused, Accepts: cmdsAccepted}
+		case svc.Continue:
+			pauseTimeout = nil
+			s.continueService(time.Now(), "requested by the service control manager")
+			changes <- svc.Status{State: svc.Running, Accepts: cmdsAccepted}
 		case svc.PowerEvent:
 			// Handle power management events
 			s.handlePowerEvent(c.EventType)
 			changes <- c.CurrentStatus
+		case svc.SessionChange:
+			// Start and stop notifiers and re-evaluate idle state as users come and go
+			s.handleSessionChange(c.EventType, c.EventData)
+			changes <- c.CurrentStatus
 		default:
 			s.logger.Warningf(logger.EventSessionInfoWarning, "Unexpected control request #%d", c)
 		}
@@ -125,32 +326,70 @@ loop:
 		s.notifierManager.Stop()
 	}
 
+	// Close the keep-awake page
+	if s.mailer != nil {
+		s.mailer.stop()
+	}
+
+	// Close the control pipe
+	if s.controlServer != nil {
+		s.controlServer.Stop()
+	}
+
+	// Close the metrics endpoint
+	s.stopMetrics()
+
+	// Deliver queued webhook events before exiting
+	s.closeWebhooks()
+
+	// Deliver the remaining spans
+	s.stopTracing()
+
 	return
 }
 
 // handlePowerEvent handles Windows power management events
 func (s *AutoHibernateService) handlePowerEvent(eventType uint32) {
 	const (
+		PBT_APMSUSPEND         = 4  // System is suspending
 		PBT_APMRESUMEAUTOMATIC = 18 // System resumed from suspend (automatic)
 		PBT_APMRESUMESUSPEND   = 7  // System resumed from suspend (user-initiated)
 	)
 
 	switch eventType {
+	case PBT_APMSUSPEND:
+		// Remembered to tell on resume whether this suspend came from the last hibernation request
+		now := time.Now()
+		s.suspendAt = &now
+		s.logger.Debugf(logger.EventServiceStop, "System suspending at %s", now.Format("15:04:05"))
 	case PBT_APMRESUMEAUTOMATIC:
-		// System resumed from hibernation or sleep (automatic)
+		// System resumed from hibernation or sleep (automatic). This is sent on every resume,
+		// so reconciliation and post-resume hooks run here only.
 		now := time.Now()
-		s.resumeAt = &now
-		s.idleMonitor.SetResumeTime(now)
+		s.setResumeAt(now)
+		s.recordResumed(now)
+		outcome := s.reconcileResume(now)
+		s.publish(webhook.Event{Type: webhook.EventResumed, Time: now, Reason: outcome})
+		s.record(journal.Record{Time: now, Type: journal.TypeResumed, Reason: outcome})
 		s.logger.Infof(logger.EventServiceStart, "System resumed from hibernation/sleep (automatic) at %s", now.Format("15:04:05"))
+		s.runPostResumeHooks()
 	case PBT_APMRESUMESUSPEND:
 		// System resumed from hibernation or sleep (user-initiated)
 		now := time.Now()
-		s.resumeAt = &now
-		s.idleMonitor.SetResumeTime(now)
+		s.setResumeAt(now)
+		s.recordResumed(now)
 		s.logger.Infof(logger.EventServiceStart, "System resumed from hibernation/sleep (user-initiated) at %s", now.Format("15:04:05"))
 	}
 }
 
+// setResumeAt records a resume from hibernation or sleep
+func (s *AutoHibernateService) setResumeAt(now time.Time) {
+	s.statusMu.Lock()
+	s.resumeAt = &now
+	s.statusMu.Unlock()
+	s.idleMonitor.SetResumeTime(now)
+}
+
 // calculateNextCheckTime determines when to check next based on current state
 func (s *AutoHibernateService) calculateNextCheckTime(inWarningMode bool) time.Duration {
 	// If in warning mode, check frequently for cancellation detection
@@ -158,6 +397,11 @@ func (s *AutoHibernateService) calculateNextCheckTime(inWarningMode bool) time.D
 		return warningCheckInterval
 	}
 
+	// While a failed action waits for its retry, check again when it is due
+	if s.attempts.State() == action.AttemptRetrying {
+		return max(time.Until(s.attempts.RetryAt()), minCheckInterval)
+	}
+
 	// Calculate default check interval from minimum configured threshold
 	// This ensures responsive behavior even when no active conditions exist (e.g., after hibernation)
 	minThreshold := s.config.NoUsersIdleMinutes
@@ -194,11 +438,12 @@ func (s *AutoHibernateService) calculateNextCheckTime(inWarningMode bool) time.D
 	return timeUntil
 }
 
-func (s *AutoHibernateService) monitorLoop() {
+func (s *AutoHibernateService) monitorLoop(generation uint64) {
 	s.logger.Infof(logger.EventMonitoringStarted, "Monitor loop started with dynamic polling")
-	s.logger.Infof(logger.EventMonitoringStarted, "Idle thresholds: NoUsers=%dm, AllDisconnected=%dm, InactiveUser=%dm, InactiveUserWarning=%dm",
+	s.logger.Infof(logger.EventMonitoringStarted, "Idle thresholds: NoUsers=%dm, AllDisconnected=%dm, AllDisconnectedWarning=%dm, InactiveUser=%dm, InactiveUserWarning=%dm",
 		s.config.NoUsersIdleMinutes,
 		s.config.AllDisconnectedIdleMinutes,
+		s.config.AllDisconnectedWarningMinutes,
 		s.config.InactiveUserIdleMinutes,
 		s.config.InactiveUserWarningMinutes)
 
@@ -216,134 +461,405 @@ func (s *AutoHibernateService) monitorLoop() {
 			}()
 
 			// Perform the check
-			s.performMonitorCheck(&inWarningMode)
+			ctx, end := s.beginOperation(context.Background(), "idle check")
+			defer end()
+			s.performMonitorCheck(ctx, &inWarningMode)
 		}()
 
+		// The watchdog started a replacement while this check was stalled
+		if s.superseded(generation) {
+			s.logger.Warning(logger.EventMonitorStalled, "Stalled monitor loop returned and exits; its replacement keeps running")
+			return
+		}
+
 		// Calculate next check time dynamically
 		// Note: If hibernation was triggered, the VM will hibernate and this service
 		// will be suspended along with the OS. When the VM resumes, execution will
 		// continue from here and monitoring will resume automatically.
 		nextCheckDuration := s.calculateNextCheckTime(inWarningMode)
+		s.statusMu.Lock()
+		s.nextCheckAt = time.Now().Add(nextCheckDuration)
+		s.statusMu.Unlock()
 		s.logger.Debugf(logger.EventIdleCheckInfo, "Next check in %v", nextCheckDuration.Round(time.Second))
 
 		// Sleep until next check
+		if !s.waitForNextCheck(generation, nextCheckDuration) {
+			return
+		}
+	}
+}
+
+// waitForNextCheck waits until the next check is due or requested, running hibernate
+// requests meanwhile. The heartbeat keeps beating while it waits. It returns false when
+// the loop must exit.
+func (s *AutoHibernateService) waitForNextCheck(generation uint64, d time.Duration) bool {
+	next := time.NewTimer(d)
+	defer next.Stop()
+	beat := time.NewTicker(watchdogInterval)
+	defer beat.Stop()
+
+	for {
 		select {
-		case <-time.After(nextCheckDuration):
+		case <-next.C:
 			// Continue to next iteration
+			return true
+		case <-s.checkNow:
+			// Check requested through the control API
+			return true
+		case req := <-s.hibernateRequests:
+			ctx, end := s.beginOperation(context.Background(), "hibernate request")
+			req.result <- s.hibernateNow(ctx, req.reason)
+			end()
+			return !s.superseded(generation)
+		case <-beat.C:
+			if s.superseded(generation) {
+				return false
+			}
+			s.heartbeat()
 		case <-s.stopChan:
 			s.logger.Info(logger.EventServiceStop, "Monitor loop stopping")
-			return
+			return false
 		}
 	}
 }
 
 // performMonitorCheck executes a single monitor check iteration
-func (s *AutoHibernateService) performMonitorCheck(inWarningMode *bool) {
+func (s *AutoHibernateService) performMonitorCheck(ctx context.Context, inWarningMode *bool) {
+	ctx, span := tracing.Start(ctx, "idle check", tracing.Bool("warning_mode", *inWarningMode))
+	defer span.End(nil)
+	log := s.tracedLogger(ctx)
+
+	s.statusMu.Lock()
+	s.lastCheckAt = time.Now()
+	s.statusMu.Unlock()
+	defer s.updateMetrics()
+
+	// Apply the remote control value (tags or user data) before checking
+	s.refreshControl(ctx)
+	if ctx.Err() != nil {
+		// The watchdog canceled this check while it was stalled; the replacement loop checks again
+		span.RecordError(ctx.Err())
+		return
+	}
+
+	// Pause idle checks while an Azure scheduled event is pending, remote control pauses them or a keep-awake link was used
+	if reason, paused := s.pauseReason(); paused {
+		log.Debugf(logger.EventIdleChecksPaused, "Skipping idle check: %s", reason)
+		span.SetAttributes(tracing.String("paused", reason))
+		if *inWarningMode {
+			s.idleMonitor.Reset()
+			s.endWarning(ctx, log, inWarningMode, reason, cancelCausePaused)
+		}
+		return
+	}
+
 	// Perform the check
-	shouldWarn, isHibernating := s.checkAndHibernate()
+	shouldWarn, isHibernating := s.checkAndHibernate(ctx)
+	if abandoned(ctx) {
+		span.RecordError(ctx.Err())
+		return
+	}
 
 	// Handle warning mode transitions
 	if shouldWarn && !*inWarningMode {
 		// Entering warning mode - switch to faster checks
 		*inWarningMode = true
-		s.logger.Debugf(logger.EventIdleCheckInfo, "Entering warning mode, increasing check frequency to 5s")
+		log.Debugf(logger.EventIdleCheckInfo, "Entering warning mode, increasing check frequency to 5s")
 	} else if !shouldWarn && *inWarningMode {
 		// Exiting warning mode due to user activity or hibernation
 		if isHibernating {
-			// VM is hibernating - just reset state, no notifications needed
+			// VM is hibernating (or a retry is pending, or a hook vetoed it) - just reset state, no notifications needed
 			*inWarningMode = false
 			s.lastNotificationTime = time.Time{} // Reset notification timer
-			s.logger.Debugf(logger.EventIdleCheckInfo, "Exiting warning mode due to hibernation")
+			s.warningAnnounced = false
+			log.Debugf(logger.EventIdleCheckInfo, "Exiting warning mode due to hibernation, a pending retry or a hook veto")
+		} else if s.controlMode == azure.ModeDryRun {
+			// Remote control switched to dry run, which only logs warnings; the idle state is kept
+			// so the dry run still logs the action it would run
+			s.endWarning(ctx, log, inWarningMode, "dry run", cancelCauseDryRun)
 		} else {
 			// User activity detected - send cancellation notification
 			*inWarningMode = false
 			s.lastNotificationTime = time.Time{} // Reset notification timer
-			s.logger.Debugf(logger.EventIdleCheckInfo, "Exiting warning mode, returning to dynamic polling")
+			s.warningAnnounced = false
+			log.Debugf(logger.EventIdleCheckInfo, "Exiting warning mode, returning to dynamic polling")
+			warningsCanceled.With(cancelCauseActivity).Inc()
+			s.publish(webhook.Event{Type: webhook.EventWarningCanceled, Reason: "user activity detected"})
+			canceled := journal.Record{Type: journal.TypeWarningCanceled, Reason: "user activity detected"}
+			if session, ok := s.activeSession(); ok {
+				canceled.SessionID = session.SessionId
+				canceled.User = sessionUser(session)
+			}
+			s.record(canceled)
 
 			if s.notifierManager != nil {
 				// First, dismiss any active warning notification
-				err := s.notifierManager.DismissWarning()
+				err := s.notifierManager.DismissWarning(ctx)
 				if err != nil {
-					s.logger.Debugf(logger.EventNotificationError, "Failed to dismiss warning notification: %v", err)
+					log.Debugf(logger.EventNotificationError, "Failed to dismiss warning notification: %v", err)
 				} else {
-					s.logger.Debugf(logger.EventHibernationWarningCancel, "Warning notification dismissed")
+					log.Debugf(logger.EventHibernationWarningCancel, "Warning notification dismissed")
 				}
 
 				// Then send cancellation notification to user
-				err = s.notifierManager.SendCancellation()
+				err = s.notifierManager.SendCancellation(ctx)
 				if err != nil {
-					s.logger.Warningf(logger.EventNotificationError, "Failed to send cancellation notification: %v", err)
+					log.Warningf(logger.EventNotificationError, "Failed to send cancellation notification: %v", err)
 				} else {
-					s.logger.Infof(logger.EventHibernationWarningCancel, "Cancellation notification sent: activity detected")
+					log.Infof(logger.EventHibernationWarningCancel, "Cancellation notification sent: activity detected")
 				}
 			}
 		}
 	}
 }
 
-func (s *AutoHibernateService) checkAndHibernate() (shouldWarn bool, isHibernating bool) {
-	s.logger.Debug(logger.EventIdleCheckInfo, "Starting idle state check")
+// endWarning leaves warning mode for a reason other than user activity: the warning
+// notification is dismissed and the cancellation is recorded with its reason and cause
+func (s *AutoHibernateService) endWarning(ctx context.Context, log logger.Logger, inWarningMode *bool, reason, cause string) {
+	*inWarningMode = false
+	s.lastNotificationTime = time.Time{}
+	s.warningAnnounced = false
+	if s.notifierManager != nil {
+		if err := s.notifierManager.DismissWarning(ctx); err != nil {
+			log.Debugf(logger.EventNotificationError, "Failed to dismiss warning notification: %v", err)
+		}
+	}
+	log.Infof(logger.EventHibernationWarningCancel, "Hibernation warning canceled: %s", reason)
+	warningsCanceled.With(cause).Inc()
+	s.publish(webhook.Event{Type: webhook.EventWarningCanceled, Reason: reason})
+	s.record(journal.Record{Type: journal.TypeWarningCanceled, Reason: reason})
+}
+
+func (s *AutoHibernateService) checkAndHibernate(ctx context.Context) (shouldWarn bool, isHibernating bool) {
+	span := tracing.FromContext(ctx)
+	log := s.tracedLogger(ctx)
+	log.Debug(logger.EventIdleCheckInfo, "Starting idle state check")
 
-	result, err := s.idleMonitor.Check(s.logger)
+	result, err := s.idleMonitor.Check(log)
 	if err != nil {
-		s.logger.Errorf(logger.EventIdleCheckError, "Error checking idle state: %v", err)
+		log.Errorf(logger.EventIdleCheckError, "Error checking idle state: %v", err)
+		span.RecordError(err)
+		return false, false
+	}
+	if abandoned(ctx) {
 		return false, false
 	}
+	span.SetAttributes(
+		tracing.String("condition", result.Condition.String()),
+		tracing.Int("session.count", len(s.idleMonitor.GetState().CurrentSessions)),
+		tracing.Bool("should_warn", result.ShouldWarn),
+		tracing.Bool("should_hibernate", result.ShouldHibernate))
 
-	s.logger.Debugf(logger.EventIdleCheckInfo, "Idle check result: ShouldWarn=%v, ShouldHibernate=%v, Reason=%s",
+	log.Debugf(logger.EventIdleCheckInfo, "Idle check result: ShouldWarn=%v, ShouldHibernate=%v, Reason=%s",
 		result.ShouldWarn, result.ShouldHibernate, result.Reason)
 
-	if result.ShouldWarn {
+	// A pending retry is dropped once the idle condition no longer holds
+	if !result.ShouldHibernate && s.attempts.State() != action.AttemptIdle {
+		log.Infof(logger.EventActionRetrying, "Retry of the idle action canceled: idle condition no longer met")
+		s.attempts.Reset()
+	}
+
+	if result.ShouldWarn && s.controlMode == azure.ModeDryRun {
+		// Dry run - log the warning instead of notifying users
+		log.Debugf(logger.EventDryRunAction, "Dry run: would warn users: %s (time remaining: %v)", result.Reason, result.TimeRemaining.Round(time.Second))
+		return false, false
+	} else if result.ShouldWarn {
 		// In warning period - send notification (throttled)
 		now := time.Now()
 		timeSinceLastNotification := now.Sub(s.lastNotificationTime)
 
 		if timeSinceLastNotification >= notificationThrottleDuration || s.lastNotificationTime.IsZero() {
-			s.logger.Debugf(logger.EventHibernationWarningSent, "Sending hibernation warning: %s (time remaining: %v)",
+			// Webhooks and emails get one message per warning period, not every repeated notification
+			if !s.warningAnnounced {
+				s.warningAnnounced = true
+				warningsSent.With(result.Condition.String()).Inc()
+				actionAt := now.Add(result.TimeRemaining)
+				s.record(journal.Record{
+					Time:      now,
+					Type:      journal.TypeWarning,
+					Condition: result.Condition.String(),
+					Action:    s.actionName(result.Condition),
+					Reason:    result.Reason,
+					ActionAt:  &actionAt,
+				})
+				s.publish(webhook.Event{
+					Type:                 webhook.EventWarning,
+					Condition:            result.Condition.String(),
+					Reason:               result.Reason,
+					Action:               s.actionName(result.Condition),
+					TimeRemainingSeconds: int(result.TimeRemaining.Round(time.Second).Seconds()),
+				})
+				// Disconnected users cannot see the notification, so they are emailed instead
+				if result.Condition == monitor.IdleConditionAllDisconnected && s.mailer != nil {
+					s.mailer.warnDisconnected(s.idleMonitor.GetState().CurrentSessions, s.actionName(result.Condition), result.TimeRemaining)
+				}
+			}
+
+			log.Debugf(logger.EventHibernationWarningSent, "Sending hibernation warning: %s (time remaining: %v)",
 				result.Reason, result.TimeRemaining.Round(time.Second))
 
 			if s.notifierManager != nil {
-				err := s.notifierManager.SendWarning(result.Reason, result.TimeRemaining)
+				err := s.notifierManager.SendWarning(ctx, result.Reason, result.TimeRemaining)
+				if abandoned(ctx) {
+					return false, false
+				}
 				if err != nil {
-					s.logger.Warningf(logger.EventNotificationError, "Failed to send warning notification: %v", err)
+					log.Warningf(logger.EventNotificationError, "Failed to send warning notification: %v", err)
 				} else {
 					s.lastNotificationTime = now
-					s.logger.Infof(logger.EventHibernationWarningSent, "Warning sent: %s (time remaining: %v)",
+					log.Infof(logger.EventHibernationWarningSent, "Warning sent: %s (time remaining: %v)",
 						result.Reason, result.TimeRemaining.Round(time.Second))
 				}
 			}
 		} else {
-			s.logger.Debugf(logger.EventHibernationWarningSent, "Skipping notification (throttled): %s (last sent %v ago)",
+			log.Debugf(logger.EventHibernationWarningSent, "Skipping notification (throttled): %s (last sent %v ago)",
 				result.Reason, timeSinceLastNotification.Round(time.Second))
 		}
 		return true, false
 	} else if result.ShouldHibernate {
-		// Warning period expired or no warning configured - hibernate now
-		s.logger.Infof(logger.EventHibernationTriggered, "Hibernation triggered: %s", result.Reason)
-		s.logger.Debug(logger.EventHibernationTriggered, "Initiating Azure hibernation API call")
-
-		// Reset idle monitor state before hibernation
-		// This ensures clean state when VM resumes from hibernation
-		s.idleMonitor.Reset()
-		s.logger.Debug(logger.EventHibernationTriggered, "Idle monitor state reset for clean resume")
+		// Warning period expired or no warning configured - run the configured action now
+		primary := s.actions[result.Condition]
+		if action.IsNone(primary) {
+			log.Infof(logger.EventActionSkipped, "Idle condition met but no action is configured: %s", result.Reason)
+			s.idleMonitor.Reset()
+			return false, false
+		}
+		if s.controlMode == azure.ModeDryRun {
+			log.Infof(logger.EventDryRunAction, "Dry run: idle condition met, would run %s: %s", primary.Name(), result.Reason)
+			s.idleMonitor.Reset()
+			return false, false
+		}
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
-		defer cancel()
+		// After a failed attempt, the idle monitor keeps its state until the retry is due
+		if !s.attempts.Due(time.Now()) {
+			log.Debugf(logger.EventActionRetrying, "Waiting to retry %s at %s", primary.Name(), s.attempts.RetryAt().Format("15:04:05"))
+			return false, true
+		}
 
-		if err := s.azureClient.HibernateVM(ctx); err != nil {
-			s.logger.Errorf(logger.EventHibernationError, "Failed to hibernate VM: %v", err)
-			return false, false
+		// Pre-hibernate hooks run once, before the first attempt and before anything is reset,
+		// so a veto only re-arms the idle timer
+		if s.attempts.Failures() == 0 {
+			vetoedBy := s.runPreHibernateHooks(ctx)
+			if abandoned(ctx) {
+				return false, true
+			}
+			if vetoedBy != "" {
+				s.vetoHibernation(ctx, result, vetoedBy)
+				// The veto already told users, so leave warning mode without the activity cancellation
+				return false, true
+			}
 		}
 
-		s.logger.Info(logger.EventHibernationSuccess, "Hibernation request sent successfully")
-		// The VM will hibernate, service will stop
+		// Leave warning mode quietly whether the action is accepted or a retry is scheduled
+		s.attemptAction(ctx, primary, result)
 		return false, true
 	} else {
-		s.logger.Debug(logger.EventIdleCheckInfo, "System is active, no hibernation needed")
+		log.Debug(logger.EventIdleCheckInfo, "System is active, no hibernation needed")
 		return false, false
 	}
 }
 
+// runAction executes the primary action and falls back to the configured fallback action if it fails
+func (s *AutoHibernateService) runAction(ctx context.Context, primary action.Action, idle *monitor.CheckResult) error {
+	return s.runActionWithFallback(ctx, primary, s.fallbackAction, idle)
+}
+
+// runActionWithFallback executes the primary action and, if it fails, the fallback action (nil for none)
+func (s *AutoHibernateService) runActionWithFallback(ctx context.Context, primary, fallback action.Action, idle *monitor.CheckResult) error {
+	condition := idle.Condition.String()
+	if idle.Condition == monitor.IdleConditionNone {
+		condition = manualCondition
+	}
+	log := s.tracedLogger(ctx)
+	hibernationsAttempted.With(primary.Name()).Inc()
+	s.record(journal.Record{Type: journal.TypeHibernationRequested, Condition: condition, Action: primary.Name(), Reason: idle.Reason})
+	result := action.RunWithFallback(ctx, primary, fallback)
+
+	if result.PrimaryError != nil {
+		log.Errorf(errorEventID(result.PrimaryError), "Action %s failed: %s", primary.Name(), describeError(result.PrimaryError))
+	}
+	if result.FallbackUsed {
+		if result.FallbackError != nil {
+			log.Errorf(errorEventID(result.FallbackError), "Fallback action %s failed: %s", fallback.Name(), describeError(result.FallbackError))
+		} else {
+			log.Warningf(logger.EventFallbackActionTriggered, "Fallback action %s used after %s failed", fallback.Name(), primary.Name())
+		}
+	}
+	if result.Completed != nil {
+		log.Infof(logger.EventHibernationSuccess, "Action %s completed successfully", result.Completed.Name())
+		hibernationsSucceeded.With(result.Completed.Name()).Inc()
+		s.record(journal.Record{Type: journal.TypeHibernated, Condition: condition, Action: result.Completed.Name(), Reason: idle.Reason})
+		s.recordStopped(result.Completed, time.Now())
+		s.recordRequest(result.Completed, idle, time.Now())
+		s.publish(webhook.Event{
+			Type:      webhook.EventHibernated,
+			Condition: condition,
+			Reason:    idle.Reason,
+			Action:    result.Completed.Name(),
+		})
+	}
+
+	err := result.Err()
+	if err != nil {
+		hibernationsFailed.With(primary.Name()).Inc()
+		s.record(journal.Record{Type: journal.TypeHibernationFailed, Condition: condition, Action: primary.Name(), Error: describeError(err)})
+		s.publish(webhook.Event{
+			Type:      webhook.EventHibernationFailed,
+			Condition: condition,
+			Reason:    idle.Reason,
+			Action:    primary.Name(),
+			Error:     describeError(err),
+		})
+	}
+	return err
+}
+
+// actionName returns the name of the action configured for an idle condition
+func (s *AutoHibernateService) actionName(condition monitor.IdleCondition) string {
+	if a := s.actions[condition]; !action.IsNone(a) {
+		return a.Name()
+	}
+	return config.ActionNone
+}
+
+// errorEventID returns the event ID for an action error, using a dedicated ID for each kind of ARM error
+func errorEventID(err error) uint32 {
+	var armErr *azure.ARMError
+	if !errors.As(err, &armErr) {
+		return logger.EventHibernationError
+	}
+
+	switch armErr.Kind {
+	case azure.ErrorKindAuthentication:
+		return logger.EventARMAuthenticationFailed
+	case azure.ErrorKindAuthorization:
+		return logger.EventARMAuthorizationFailed
+	case azure.ErrorKindScopeLocked:
+		return logger.EventARMScopeLocked
+	case azure.ErrorKindHibernationNotEnabled:
+		return logger.EventARMHibernationNotEnabled
+	case azure.ErrorKindOperationNotAllowed:
+		return logger.EventARMOperationNotAllowed
+	case azure.ErrorKindThrottled:
+		return logger.EventARMThrottled
+	case azure.ErrorKindNotFound:
+		return logger.EventARMNotFound
+	default:
+		return logger.EventHibernationError
+	}
+}
+
+// describeError formats an error for the log, adding the remediation for ARM errors
+func describeError(err error) string {
+	var armErr *azure.ARMError
+	if errors.As(err, &armErr) {
+		if remediation := armErr.Remediation(); remediation != "" {
+			return fmt.Sprintf("%v. %s", err, remediation)
+		}
+	}
+	return err.Error()
+}
+
 // updateLoop periodically checks for updates when auto-update is enabled
 func (s *AutoHibernateService) updateLoop() {
 	checkInterval := time.Duration(s.config.UpdateCheckIntervalHr) * time.Hour
@@ -377,49 +893,64 @@ func (s *AutoHibernateService) updateLoop() {
 
 // checkAndApplyUpdate checks for updates and applies them if available
 func (s *AutoHibernateService) checkAndApplyUpdate() {
-	s.logger.Debug(logger.EventServiceStart, "Checking for updates...")
-
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
+	ctx, span := tracing.Start(ctx, "update check", tracing.String("version.current", version.Version))
+	defer span.End(nil)
+	log := s.tracedLogger(ctx)
+
+	log.Debug(logger.EventServiceStart, "Checking for updates...")
 
 	info, err := updater.CheckForUpdate(ctx)
 	if err != nil {
-		s.logger.Warningf(logger.EventConfigError, "Failed to check for updates: %v", err)
+		span.RecordError(err)
+		updateChecks.With("failed").Inc()
+		log.Warningf(logger.EventConfigError, "Failed to check for updates: %v", err)
 		return
 	}
 
+	span.SetAttributes(tracing.Bool("update.available", info.UpdateAvailable), tracing.String("version.latest", info.LatestVersion))
 	if !info.UpdateAvailable {
-		s.logger.Debug(logger.EventServiceStart, "No updates available")
+		updateChecks.With("current").Inc()
+		log.Debug(logger.EventServiceStart, "No updates available")
 		return
 	}
 
-	s.logger.Infof(logger.EventServiceStart, "Update available: %s -> %s", info.CurrentVersion, info.LatestVersion)
+	updateChecks.With("available").Inc()
+	log.Infof(logger.EventServiceStart, "Update available: %s -> %s", info.CurrentVersion, info.LatestVersion)
 
 	// Download the update
-	s.logger.Info(logger.EventServiceStart, "Downloading update...")
-	tempDir, err := updater.DownloadUpdate(ctx)
+	log.Info(logger.EventServiceStart, "Downloading update...")
+	downloadCtx, cancelDownload := context.WithTimeout(context.WithoutCancel(ctx), updateDownloadTimeout)
+	defer cancelDownload()
+	tempDir, err := updater.DownloadUpdate(downloadCtx)
 	if err != nil {
-		s.logger.Errorf(logger.EventConfigError, "Failed to download update: %v", err)
+		span.RecordError(err)
+		log.Errorf(logger.EventConfigError, "Failed to download update: %v", err)
 		return
 	}
 
-	s.logger.Infof(logger.EventServiceStart, "Update downloaded to %s", tempDir)
+	log.Infof(logger.EventServiceStart, "Update downloaded to %s", tempDir)
 
 	// Trigger the update (spawns helper which will stop the service)
-	s.logger.Info(logger.EventServiceStart, "Triggering update process...")
+	log.Info(logger.EventServiceStart, "Triggering update process...")
 	if err := updater.TriggerUpdate(tempDir); err != nil {
-		s.logger.Errorf(logger.EventConfigError, "Failed to trigger update: %v", err)
+		span.RecordError(err)
+		log.Errorf(logger.EventConfigError, "Failed to trigger update: %v", err)
 		return
 	}
 
 	// Mark that an update is pending - the updater will stop this service externally
 	s.updatePending = true
-	s.logger.Info(logger.EventServiceStop, "Update triggered, updater will stop and restart the service")
+	log.Info(logger.EventServiceStop, "Update triggered, updater will stop and restart the service")
 }
 
 // Run executes the service
 func Run(cfg *config.Config, vmMetadata *azure.VMMetadata, log logger.Logger, isDebug bool) error {
-	service := NewAutoHibernateService(cfg, vmMetadata, log)
+	service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+	if err != nil {
+		return err
+	}
 
 	if isDebug {
 		// Run in debug mode (console)
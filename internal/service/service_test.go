@@ -3,12 +3,33 @@
 package service
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
 	"strings"
+	"sync"
 	"testing"
 	"time"
+	"unsafe"
 
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
 	"github.com/smitstech/AzureAutoHibernate/internal/azure"
 	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/control"
+	"github.com/smitstech/AzureAutoHibernate/internal/hook"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/monitor"
+	"github.com/smitstech/AzureAutoHibernate/internal/savings"
+	"github.com/smitstech/AzureAutoHibernate/internal/watchdog"
+	"github.com/smitstech/AzureAutoHibernate/internal/webhook"
+	"golang.org/x/sys/windows"
 )
 
 // mockLogger is a simple logger for testing
@@ -74,10 +95,9 @@ func TestNewAutoHibernateService(t *testing.T) {
 
 	log := &mockLogger{}
 
-	service := NewAutoHibernateService(cfg, vmMetadata, log)
-
-	if service == nil {
-		t.Fatal("NewAutoHibernateService returned nil")
+	service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
 	}
 
 	if service.config != cfg {
@@ -199,7 +219,10 @@ func TestCalculateNextCheckTime(t *testing.T) {
 			}
 			log := &mockLogger{}
 
-			service := NewAutoHibernateService(tt.config, vmMetadata, log)
+			service, err := NewAutoHibernateService(tt.config, vmMetadata, log)
+			if err != nil {
+				t.Fatalf("NewAutoHibernateService() error: %v", err)
+			}
 			duration := service.calculateNextCheckTime(tt.inWarningMode)
 
 			if duration < tt.expectedMin {
@@ -232,7 +255,10 @@ func TestCalculateNextCheckTimeMinimumBoundary(t *testing.T) {
 	}
 	log := &mockLogger{}
 
-	service := NewAutoHibernateService(cfg, vmMetadata, log)
+	service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
 
 	// Test that we never check less frequently than the minimum
 	duration := service.calculateNextCheckTime(false)
@@ -260,7 +286,10 @@ func TestCalculateNextCheckTimeWarningModeTransition(t *testing.T) {
 	}
 	log := &mockLogger{}
 
-	service := NewAutoHibernateService(cfg, vmMetadata, log)
+	service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
 
 	// Warning mode should be 5 seconds
 	warningDuration := service.calculateNextCheckTime(true)
@@ -323,7 +352,10 @@ func TestHandlePowerEvent(t *testing.T) {
 			}
 			log := &mockLogger{}
 
-			service := NewAutoHibernateService(cfg, vmMetadata, log)
+			service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+			if err != nil {
+				t.Fatalf("NewAutoHibernateService() error: %v", err)
+			}
 
 			// Store the initial resume time
 			initialResumeAt := *service.resumeAt
@@ -403,7 +435,10 @@ func TestHandlePowerEventLogging(t *testing.T) {
 			}
 			log := &mockLogger{}
 
-			service := NewAutoHibernateService(cfg, vmMetadata, log)
+			service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+			if err != nil {
+				t.Fatalf("NewAutoHibernateService() error: %v", err)
+			}
 
 			// Handle the power event
 			service.handlePowerEvent(tt.eventType)
@@ -442,7 +477,10 @@ func TestHandlePowerEventDifferentiation(t *testing.T) {
 
 	t.Run("automatic resume event", func(t *testing.T) {
 		log := &mockLogger{}
-		service := NewAutoHibernateService(cfg, vmMetadata, log)
+		service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+		if err != nil {
+			t.Fatalf("NewAutoHibernateService() error: %v", err)
+		}
 
 		service.handlePowerEvent(PBT_APMRESUMEAUTOMATIC)
 
@@ -465,7 +503,10 @@ func TestHandlePowerEventDifferentiation(t *testing.T) {
 
 	t.Run("user-initiated resume event", func(t *testing.T) {
 		log := &mockLogger{}
-		service := NewAutoHibernateService(cfg, vmMetadata, log)
+		service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+		if err != nil {
+			t.Fatalf("NewAutoHibernateService() error: %v", err)
+		}
 
 		service.handlePowerEvent(PBT_APMRESUMESUSPEND)
 
@@ -488,7 +529,10 @@ func TestHandlePowerEventDifferentiation(t *testing.T) {
 
 	t.Run("both events logged separately", func(t *testing.T) {
 		log := &mockLogger{}
-		service := NewAutoHibernateService(cfg, vmMetadata, log)
+		service, err := NewAutoHibernateService(cfg, vmMetadata, log)
+		if err != nil {
+			t.Fatalf("NewAutoHibernateService() error: %v", err)
+		}
 
 		// Both events should be logged with different messages
 		service.handlePowerEvent(PBT_APMRESUMEAUTOMATIC)
@@ -615,7 +659,10 @@ func TestServiceInitialization(t *testing.T) {
 			}
 			log := &mockLogger{}
 
-			service := NewAutoHibernateService(tt.config, vmMetadata, log)
+			service, err := NewAutoHibernateService(tt.config, vmMetadata, log)
+			if err != nil {
+				t.Fatalf("NewAutoHibernateService() error: %v", err)
+			}
 
 			// Verify service is properly initialized
 			if service == nil {
@@ -642,3 +689,961 @@ func TestServiceInitialization(t *testing.T) {
 		})
 	}
 }
+
+// fakeAction is an action.Action that records executions
+type fakeAction struct {
+	name  string
+	err   error
+	calls int
+}
+
+func (f *fakeAction) Name() string {
+	return f.name
+}
+
+func (f *fakeAction) Execute(ctx context.Context) error {
+	f.calls++
+	return f.err
+}
+
+// TestNewAutoHibernateServiceCredentialError tests that startup fails instead of falling back
+// to another identity when the configured credential cannot be created
+func TestNewAutoHibernateServiceCredentialError(t *testing.T) {
+	cfg := &config.Config{
+		NoUsersIdleMinutes: 30,
+		Credential: config.CredentialConfig{
+			Type: config.CredentialClientSecret, TenantID: "t", ClientID: "c", SecretEnv: "AUTOHIBERNATE_TEST_MISSING",
+		},
+	}
+	log := &mockLogger{}
+
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, log)
+	if err == nil || service != nil {
+		t.Fatalf("NewAutoHibernateService() = %v, %v; want an error", service, err)
+	}
+	if len(log.errorLogs) == 0 {
+		t.Error("credential failure was not logged")
+	}
+}
+
+// TestNewAutoHibernateServiceActions tests that configured actions are mapped to idle conditions
+func TestNewAutoHibernateServiceActions(t *testing.T) {
+	cfg := &config.Config{
+		NoUsersIdleMinutes:    30,
+		NoUsersAction:         config.ActionHibernate,
+		AllDisconnectedAction: config.ActionDeallocate,
+		InactiveUserAction:    config.ActionNone,
+		FallbackAction:        config.ActionLocalHibernate,
+	}
+	vmMetadata := &azure.VMMetadata{
+		SubscriptionId: "test-sub",
+		ResourceGroup:  "test-rg",
+		VMName:         "test-vm",
+	}
+
+	service, err := NewAutoHibernateService(cfg, vmMetadata, &mockLogger{})
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+
+	want := map[monitor.IdleCondition]string{
+		monitor.IdleConditionNoUsers:         config.ActionHibernate,
+		monitor.IdleConditionAllDisconnected: config.ActionDeallocate,
+		monitor.IdleConditionInactiveUser:    config.ActionNone,
+	}
+	for condition, name := range want {
+		a := service.actions[condition]
+		if a == nil || a.Name() != name {
+			t.Errorf("action for condition %d = %v, want %q", condition, a, name)
+		}
+	}
+	if service.fallbackAction == nil || service.fallbackAction.Name() != config.ActionLocalHibernate {
+		t.Errorf("fallbackAction = %v, want %q", service.fallbackAction, config.ActionLocalHibernate)
+	}
+}
+
+// TestAttemptAction tests retries of a failed idle action and the escalation to the fallback action
+func TestAttemptAction(t *testing.T) {
+	throttled := &azure.ARMError{StatusCode: http.StatusTooManyRequests, Kind: azure.ErrorKindThrottled}
+	notEnabled := &azure.ARMError{StatusCode: http.StatusConflict, Kind: azure.ErrorKindHibernationNotEnabled}
+
+	tests := []struct {
+		name         string
+		primaryErr   error
+		fallbackName string
+		attempts     int // Calls to attemptAction
+		wantPrimary  int
+		wantFallback int
+		wantState    action.AttemptState
+	}{
+		{
+			name:        "accepted on the first attempt",
+			attempts:    1,
+			wantPrimary: 1,
+			wantState:   action.AttemptIdle,
+		},
+		{
+			name:         "retryable failure schedules a retry",
+			primaryErr:   throttled,
+			fallbackName: config.ActionDeallocate,
+			attempts:     1,
+			wantPrimary:  1,
+			wantState:    action.AttemptRetrying,
+		},
+		{
+			name:         "attempts used up escalate to the fallback",
+			primaryErr:   throttled,
+			fallbackName: config.ActionDeallocate,
+			attempts:     2,
+			wantPrimary:  2,
+			wantFallback: 1,
+			wantState:    action.AttemptIdle,
+		},
+		{
+			name:         "failure that cannot be retried escalates at once",
+			primaryErr:   notEnabled,
+			fallbackName: config.ActionDeallocate,
+			attempts:     1,
+			wantPrimary:  1,
+			wantFallback: 1,
+			wantState:    action.AttemptIdle,
+		},
+		{
+			name:         "no fallback waits for the next idle period",
+			primaryErr:   notEnabled,
+			fallbackName: config.ActionNone,
+			attempts:     1,
+			wantPrimary:  1,
+			wantState:    action.AttemptIdle,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &mockLogger{}
+			primary := &fakeAction{name: config.ActionHibernate, err: tt.primaryErr}
+			fallback := &fakeAction{name: tt.fallbackName}
+			service := &AutoHibernateService{
+				logger:         log,
+				idleMonitor:    monitor.NewIdleMonitor(30, 0, 0, 0, 0),
+				fallbackAction: fallback,
+				attempts:       action.NewAttempts(action.RetryPolicy{MaxAttempts: 2}),
+				vm:             &azure.VMMetadata{VMName: "test-vm"},
+			}
+
+			idle := &monitor.CheckResult{Condition: monitor.IdleConditionNoUsers, ShouldHibernate: true, Reason: "No users logged in"}
+			for i := 0; i < tt.attempts; i++ {
+				service.attemptAction(context.Background(), primary, idle)
+			}
+
+			if primary.calls != tt.wantPrimary {
+				t.Errorf("primary calls = %d, want %d", primary.calls, tt.wantPrimary)
+			}
+			if fallback.calls != tt.wantFallback {
+				t.Errorf("fallback calls = %d, want %d", fallback.calls, tt.wantFallback)
+			}
+			if got := service.attempts.State(); got != tt.wantState {
+				t.Errorf("attempts state = %v, want %v", got, tt.wantState)
+			}
+		})
+	}
+}
+
+// blockingAction is an action that ignores its context and blocks until released, like an
+// ARM call that never returns
+type blockingAction struct {
+	fakeAction
+	started chan struct{}
+	release chan struct{}
+}
+
+func (b *blockingAction) Execute(ctx context.Context) error {
+	close(b.started)
+	<-b.release
+	return b.fakeAction.Execute(ctx)
+}
+
+// TestAttemptActionStalled tests that an attempt the watchdog canceled changes nothing when
+// it finally returns, since the replacement loop owns the retry state by then
+func TestAttemptActionStalled(t *testing.T) {
+	notEnabled := &azure.ARMError{StatusCode: http.StatusConflict, Kind: azure.ErrorKindHibernationNotEnabled}
+	primary := &blockingAction{
+		fakeAction: fakeAction{name: config.ActionHibernate, err: notEnabled},
+		started:    make(chan struct{}),
+		release:    make(chan struct{}),
+	}
+	fallback := &fakeAction{name: config.ActionDeallocate}
+	start := time.Now()
+	service := &AutoHibernateService{
+		logger:         &mockLogger{},
+		idleMonitor:    monitor.NewIdleMonitor(30, 0, 0, 0, 0),
+		fallbackAction: fallback,
+		attempts:       action.NewAttempts(action.RetryPolicy{MaxAttempts: 2}),
+		vm:             &azure.VMMetadata{VMName: "test-vm"},
+		watchdog:       watchdog.New(time.Minute, start),
+	}
+
+	ctx, end := service.beginOperation(context.Background(), "idle check")
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		defer end()
+		idle := &monitor.CheckResult{Condition: monitor.IdleConditionNoUsers, ShouldHibernate: true, Reason: "No users logged in"}
+		service.attemptAction(ctx, primary, idle)
+	}()
+
+	// The action stalls past the watchdog deadline
+	<-primary.started
+	service.watchdog.Check(start.Add(40 * time.Second))
+	if _, stalled := service.watchdog.Check(start.Add(80 * time.Second)); !stalled {
+		t.Fatal("watchdog did not report the stalled action")
+	}
+
+	// Released after the cancellation, the failure is neither counted nor escalated
+	close(primary.release)
+	<-done
+	if got := service.attempts.Failures(); got != 0 {
+		t.Errorf("failures = %d, want 0", got)
+	}
+	if fallback.calls != 0 {
+		t.Errorf("fallback calls = %d, want 0", fallback.calls)
+	}
+}
+
+// TestRunAction tests primary action execution with fallback
+func TestRunAction(t *testing.T) {
+	tests := []struct {
+		name          string
+		primaryErr    error
+		fallbackErr   error
+		wantErr       bool
+		wantFallback  int
+		wantErrorLogs int
+		wantEvent     webhook.Event // Event posted to the webhook
+	}{
+		{
+			name:      "primary succeeds",
+			wantEvent: webhook.Event{Type: webhook.EventHibernated, Action: config.ActionHibernate},
+		},
+		{
+			name:          "primary fails, fallback succeeds",
+			primaryErr:    errors.New("hibernation is not enabled"),
+			wantFallback:  1,
+			wantErrorLogs: 1,
+			wantEvent:     webhook.Event{Type: webhook.EventHibernated, Action: config.ActionDeallocate},
+		},
+		{
+			name:          "primary and fallback fail",
+			primaryErr:    errors.New("hibernation is not enabled"),
+			fallbackErr:   errors.New("deallocate failed"),
+			wantErr:       true,
+			wantFallback:  1,
+			wantErrorLogs: 2,
+			wantEvent:     webhook.Event{Type: webhook.EventHibernationFailed, Action: config.ActionHibernate},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			log := &mockLogger{}
+			primary := &fakeAction{name: config.ActionHibernate, err: tt.primaryErr}
+			fallback := &fakeAction{name: config.ActionDeallocate, err: tt.fallbackErr}
+			var mu sync.Mutex
+			var events []webhook.Event
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				var e webhook.Event
+				if err := json.NewDecoder(r.Body).Decode(&e); err != nil {
+					t.Errorf("failed to decode webhook event: %v", err)
+				}
+				mu.Lock()
+				events = append(events, e)
+				mu.Unlock()
+			}))
+			defer server.Close()
+
+			service := &AutoHibernateService{
+				logger:         log,
+				fallbackAction: fallback,
+				webhooks:       webhook.NewDispatcher([]webhook.Sink{{Name: "test", URL: server.URL}}, webhook.Options{}),
+				vm:             &azure.VMMetadata{VMName: "test-vm", ResourceGroup: "test-rg"},
+			}
+
+			idle := &monitor.CheckResult{Condition: monitor.IdleConditionNoUsers, ShouldHibernate: true, Reason: "No users logged in"}
+			err := service.runAction(context.Background(), primary, idle)
+			service.closeWebhooks()
+
+			if (err != nil) != tt.wantErr {
+				t.Errorf("runAction() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if primary.calls != 1 {
+				t.Errorf("primary calls = %d, want 1", primary.calls)
+			}
+			if fallback.calls != tt.wantFallback {
+				t.Errorf("fallback calls = %d, want %d", fallback.calls, tt.wantFallback)
+			}
+			if len(log.errorLogs) != tt.wantErrorLogs {
+				t.Errorf("error logs = %d, want %d: %v", len(log.errorLogs), tt.wantErrorLogs, log.errorLogs)
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if len(events) != 1 {
+				t.Fatalf("webhook events = %d, want 1: %+v", len(events), events)
+			}
+			e := events[0]
+			if e.Type != tt.wantEvent.Type || e.Action != tt.wantEvent.Action {
+				t.Errorf("webhook event = %s/%s, want %s/%s", e.Type, e.Action, tt.wantEvent.Type, tt.wantEvent.Action)
+			}
+			if e.VMName != "test-vm" || e.Reason != idle.Reason || e.Condition != idle.Condition.String() {
+				t.Errorf("webhook event details = %+v", e)
+			}
+			if (e.Error != "") != tt.wantErr {
+				t.Errorf("webhook event error = %q, wantErr %v", e.Error, tt.wantErr)
+			}
+		})
+	}
+}
+
+// TestErrorEventID tests that ARM errors are logged with a stable event ID and remediation
+func TestErrorEventID(t *testing.T) {
+	tests := []struct {
+		name            string
+		err             error
+		wantEventID     uint32
+		wantRemediation bool
+	}{
+		{name: "plain error", err: errors.New("network unreachable"), wantEventID: logger.EventHibernationError},
+		{
+			name:            "authorization failed",
+			err:             &azure.ARMError{Operation: "hibernation", StatusCode: 403, Code: "AuthorizationFailed", Kind: azure.ErrorKindAuthorization},
+			wantEventID:     logger.EventARMAuthorizationFailed,
+			wantRemediation: true,
+		},
+		{
+			name:            "wrapped scope lock",
+			err:             fmt.Errorf("action failed: %w", &azure.ARMError{Operation: "hibernation", StatusCode: 409, Code: "ScopeLocked", Kind: azure.ErrorKindScopeLocked}),
+			wantEventID:     logger.EventARMScopeLocked,
+			wantRemediation: true,
+		},
+		{
+			name:            "hibernation not enabled",
+			err:             &azure.ARMError{Operation: "hibernation", StatusCode: 409, Code: "OperationNotAllowed", Kind: azure.ErrorKindHibernationNotEnabled},
+			wantEventID:     logger.EventARMHibernationNotEnabled,
+			wantRemediation: true,
+		},
+		{
+			name:        "unknown ARM error",
+			err:         &azure.ARMError{Operation: "hibernation", StatusCode: 500, Code: "InternalServerError"},
+			wantEventID: logger.EventHibernationError,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := errorEventID(tt.err); got != tt.wantEventID {
+				t.Errorf("errorEventID() = %d, want %d", got, tt.wantEventID)
+			}
+			description := describeError(tt.err)
+			if hasRemediation := description != tt.err.Error(); hasRemediation != tt.wantRemediation {
+				t.Errorf("describeError() = %q, want remediation: %v", description, tt.wantRemediation)
+			}
+		})
+	}
+}
+
+// Ensure fakeAction satisfies the action interface
+var _ action.Action = (*fakeAction)(nil)
+
+// TestHandleScheduledEvents tests that pending scheduled events pause idle checks until they complete
+func TestHandleScheduledEvents(t *testing.T) {
+	cfg := &config.Config{NoUsersIdleMinutes: 30, DisableRemoteControl: true}
+	log := &mockLogger{}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.notifierManager = nil
+
+	freeze := azure.ScheduledEvent{EventId: "freeze", EventType: azure.ScheduledEventFreeze, EventStatus: azure.ScheduledEventStatusScheduled}
+
+	service.handleScheduledEvents(&azure.ScheduledEventChanges{
+		New:     []azure.ScheduledEvent{freeze},
+		Pending: []azure.ScheduledEvent{freeze},
+	})
+	if event, pending := service.pendingScheduledEvent(); !pending || event.EventId != "freeze" {
+		t.Fatalf("pendingScheduledEvent() = %v, %v, want freeze, true", event, pending)
+	}
+
+	// Idle checks are skipped while the event is pending, ending warning mode
+	inWarningMode := true
+	service.performMonitorCheck(context.Background(), &inWarningMode)
+	if inWarningMode {
+		t.Error("warning mode not canceled while a scheduled event is pending")
+	}
+
+	service.handleScheduledEvents(&azure.ScheduledEventChanges{Completed: []azure.ScheduledEvent{freeze}})
+	if _, pending := service.pendingScheduledEvent(); pending {
+		t.Error("scheduled event still pending after completion")
+	}
+
+	found := false
+	for _, msg := range log.infoLogs {
+		if strings.Contains(msg, "idle checks resumed") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expected idle checks resumed log, got %v", log.infoLogs)
+	}
+}
+
+// TestEndWarning tests that leaving warning mode for dry run logs its own reason
+func TestEndWarning(t *testing.T) {
+	cfg := &config.Config{NoUsersIdleMinutes: 30, DisableRemoteControl: true}
+	log := &mockLogger{}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.notifierManager = nil
+	service.warningAnnounced = true
+
+	inWarningMode := true
+	service.endWarning(context.Background(), log, &inWarningMode, "dry run", cancelCauseDryRun)
+	if inWarningMode || service.warningAnnounced {
+		t.Error("warning mode not ended")
+	}
+	if len(log.infoLogs) == 0 || !strings.Contains(log.infoLogs[len(log.infoLogs)-1], "Hibernation warning canceled: dry run") {
+		t.Errorf("expected dry run cancellation log, got %v", log.infoLogs)
+	}
+}
+
+// TestScheduledEventMessage tests the notifications sent for scheduled events
+func TestScheduledEventMessage(t *testing.T) {
+	tests := []struct {
+		eventType string
+		want      string
+	}{
+		{azure.ScheduledEventPreempt, "Spot VM"},
+		{azure.ScheduledEventTerminate, "deleting this VM"},
+		{azure.ScheduledEventReboot, "maintenance (Reboot)"},
+		{azure.ScheduledEventRedeploy, "maintenance (Redeploy)"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.eventType, func(t *testing.T) {
+			msg := scheduledEventMessage(azure.ScheduledEvent{EventType: tt.eventType, NotBefore: "Mon, 19 Sep 2016 18:29:47 GMT"})
+			if !strings.Contains(msg, tt.want) || !strings.Contains(msg, " at ") {
+				t.Errorf("scheduledEventMessage() = %q, want it to mention %q and the start time", msg, tt.want)
+			}
+		})
+	}
+}
+
+// TestEventsToAcknowledge tests that events keep their NotBefore grace period while a user is active
+func TestEventsToAcknowledge(t *testing.T) {
+	now := time.Date(2026, 10, 18, 12, 0, 0, 0, time.UTC)
+	event := func(id string, notBefore time.Duration) azure.ScheduledEvent {
+		return azure.ScheduledEvent{
+			EventId:     id,
+			EventType:   azure.ScheduledEventReboot,
+			EventStatus: azure.ScheduledEventStatusScheduled,
+			NotBefore:   now.Add(notBefore).Format(http.TimeFormat),
+		}
+	}
+	started := azure.ScheduledEvent{EventId: "started", EventType: azure.ScheduledEventFreeze, EventStatus: azure.ScheduledEventStatusStarted}
+	pending := []azure.ScheduledEvent{event("later", 10*time.Minute), event("soon", time.Minute), event("acked", 0), started}
+	acknowledged := map[string]bool{"acked": true}
+
+	tests := []struct {
+		name string
+		idle bool
+		want []string
+	}{
+		{name: "user active", idle: false, want: []string{"soon"}},
+		{name: "vm idle", idle: true, want: []string{"later", "soon"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			events := eventsToAcknowledge(pending, acknowledged, now, func() bool { return tt.idle })
+
+			var got []string
+			for _, e := range events {
+				got = append(got, e.EventId)
+			}
+			if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+				t.Errorf("eventsToAcknowledge() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Events that are all due do not need the sessions
+	events := eventsToAcknowledge([]azure.ScheduledEvent{event("soon", time.Minute)}, nil, now, func() bool {
+		t.Error("sessions checked although every event is due")
+		return false
+	})
+	if len(events) != 1 {
+		t.Errorf("eventsToAcknowledge() = %v, want the due event", events)
+	}
+}
+
+// TestApplyControl tests remote control mode changes and pausing idle checks
+func TestApplyControl(t *testing.T) {
+	cfg := &config.Config{NoUsersIdleMinutes: 30}
+	log := &mockLogger{}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.notifierManager = nil
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	if _, paused := service.pauseReason(); paused {
+		t.Fatal("idle checks paused before any remote control value")
+	}
+
+	steps := []struct {
+		name       string
+		control    azure.Control
+		wantMode   string
+		wantPaused bool
+	}{
+		{name: "paused by tag", control: azure.Control{Mode: azure.ModePaused, Source: azure.ControlSourceTags}, wantMode: azure.ModePaused, wantPaused: true},
+		{name: "pause until", control: azure.Control{PauseUntil: now.Add(time.Hour), Source: azure.ControlSourceTags}, wantMode: azure.ModePaused, wantPaused: true},
+		{name: "pause expired", control: azure.Control{PauseUntil: now.Add(-time.Minute), Source: azure.ControlSourceTags}, wantMode: azure.ModeEnabled},
+		{name: "dry run", control: azure.Control{Mode: azure.ModeDryRun, Source: azure.ControlSourceUserData}, wantMode: azure.ModeDryRun},
+		{name: "cleared", control: azure.Control{}, wantMode: azure.ModeEnabled},
+	}
+
+	for _, step := range steps {
+		service.applyControl(context.Background(), step.control, now)
+		if service.controlMode != step.wantMode {
+			t.Errorf("%s: controlMode = %q, want %q", step.name, service.controlMode, step.wantMode)
+		}
+		if _, paused := service.pauseReason(); paused != step.wantPaused {
+			t.Errorf("%s: paused = %v, want %v", step.name, paused, step.wantPaused)
+		}
+	}
+}
+
+// TestPauseMessage tests the notification sent when a remote pause begins
+func TestPauseMessage(t *testing.T) {
+	now := time.Date(2025, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	if msg := pauseMessage(azure.Control{Mode: azure.ModePaused}, now); strings.Contains(msg, "until") {
+		t.Errorf("pauseMessage() without pauseUntil = %q, want no end time", msg)
+	}
+	if msg := pauseMessage(azure.Control{PauseUntil: now.Add(time.Hour)}, now); !strings.Contains(msg, "until") {
+		t.Errorf("pauseMessage() with pauseUntil = %q, want the end time", msg)
+	}
+}
+
+// TestRecordSavings tests recording stops and resumes in the savings ledger
+func TestRecordSavings(t *testing.T) {
+	log := &mockLogger{}
+	service := &AutoHibernateService{
+		logger: log,
+		ledger: savings.NewLedger(filepath.Join(t.TempDir(), savings.LedgerFileName)),
+		vmSize: "Standard_D4s_v5",
+		region: "westeurope",
+	}
+	start := time.Date(2025, 6, 1, 18, 0, 0, 0, time.UTC)
+
+	// Resuming without a recorded stop (e.g. a normal boot) records nothing
+	service.recordResumed(start)
+
+	// In-guest actions do not stop compute billing
+	service.recordStopped(&fakeAction{name: config.ActionOSShutdown}, start)
+	service.recordStopped(&fakeAction{name: config.ActionHibernate}, start)
+	service.recordResumed(start.Add(2 * time.Hour))
+	service.recordResumed(start.Add(3 * time.Hour))
+
+	records, err := service.ledger.Records()
+	if err != nil {
+		t.Fatalf("Records() error: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("records = %+v, want one stop and one resume", records)
+	}
+	if records[0].Type != savings.RecordStopped || records[0].Action != config.ActionHibernate || records[0].VMSize != "Standard_D4s_v5" || records[0].Region != "westeurope" {
+		t.Errorf("stop record = %+v", records[0])
+	}
+	if records[1].Type != savings.RecordResumed || !records[1].Time.Equal(start.Add(2*time.Hour)) {
+		t.Errorf("resume record = %+v", records[1])
+	}
+	if len(log.warnLogs) != 0 {
+		t.Errorf("unexpected warnings: %v", log.warnLogs)
+	}
+}
+
+// TestDisconnectedWarning tests the warning email sent to owners of disconnected sessions
+func TestDisconnectedWarning(t *testing.T) {
+	deadline := time.Date(2025, 6, 1, 14, 5, 0, 0, time.UTC)
+	link := "http://vm-01:8423/keep-awake?token=abc"
+
+	msg := disconnectedWarning("alice@contoso.com", "alice", "VM-01", config.ActionDeallocate, 15*time.Minute, time.Hour, deadline, link)
+
+	if msg.To != "alice@contoso.com" {
+		t.Errorf("To = %q, want alice@contoso.com", msg.To)
+	}
+	if msg.Subject != "VM-01 will be deallocated in 15 minutes" {
+		t.Errorf("Subject = %q", msg.Subject)
+	}
+	for _, want := range []string{"Hi alice", "at 14:05 UTC", "another 60 minutes", link, "Reconnecting"} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("Body does not contain %q:\n%s", want, msg.Body)
+		}
+	}
+}
+
+// TestDefaultKeepAwakeURL tests the link host for each kind of listen address
+func TestDefaultKeepAwakeURL(t *testing.T) {
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skipf("no computer name: %v", err)
+	}
+	computer := "http://" + net.JoinHostPort(strings.ToLower(hostname), "8423")
+
+	tests := []struct {
+		addr string
+		want string
+	}{
+		{addr: "10.0.0.4:8423", want: "http://10.0.0.4:8423"},
+		{addr: ":8423", want: computer},
+		{addr: "0.0.0.0:8423", want: computer},
+		{addr: "127.0.0.1:8423", want: computer},
+	}
+	for _, tt := range tests {
+		if got := defaultKeepAwakeURL(tt.addr); got != tt.want {
+			t.Errorf("defaultKeepAwakeURL(%q) = %q, want %q", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// TestKeepAwakePausesChecks tests that a redeemed keep-awake link pauses idle checks
+func TestKeepAwakePausesChecks(t *testing.T) {
+	cfg := &config.Config{
+		NoUsersIdleMinutes:            30,
+		AllDisconnectedIdleMinutes:    60,
+		AllDisconnectedWarningMinutes: 15,
+		Email: config.EmailConfig{
+			SMTPHost:       "127.0.0.1",
+			From:           "noreply@contoso.com",
+			AddressPattern: "{user}@contoso.com",
+		},
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	log := &mockLogger{}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, log)
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.notifierManager = nil
+	if service.mailer == nil {
+		t.Fatal("mailer not created for email settings")
+	}
+
+	if _, paused := service.pauseReason(); paused {
+		t.Fatal("idle checks paused before any keep-awake link was used")
+	}
+
+	token, err := service.mailer.leases.Issue("alice")
+	if err != nil {
+		t.Fatalf("Issue() error = %v", err)
+	}
+	if _, err := service.mailer.leases.Redeem(token); err != nil {
+		t.Fatalf("Redeem() error = %v", err)
+	}
+	reason, paused := service.pauseReason()
+	if !paused || !strings.Contains(reason, "alice") {
+		t.Errorf("pauseReason() = %q, %v; want paused by alice", reason, paused)
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	sessions := []monitor.SessionInfo{
+		{SessionId: 1, Username: "alice", Domain: "CONTOSO"},
+		{SessionId: 2, Username: "", Domain: ""},
+		{SessionId: 3, Username: "Alice", Domain: "contoso"},
+		{SessionId: 4, Username: "alice", Domain: "VM-01"},
+		{SessionId: 5, Username: "bob", Domain: "CONTOSO"},
+	}
+
+	got := userSessions(sessions)
+	var ids []uint32
+	for _, s := range got {
+		ids = append(ids, s.SessionId)
+	}
+	if want := []uint32{1, 4, 5}; !reflect.DeepEqual(ids, want) {
+		t.Errorf("userSessions() sessions = %v, want %v", ids, want)
+	}
+}
+
+func TestNewHooks(t *testing.T) {
+	hooks := newHooks([]config.HookConfig{
+		{Name: "docker", Command: "docker.exe", Args: []string{"stop", "-a"}, TimeoutSeconds: 90, RunAs: config.HookRunAsSystem, VetoExitCode: 75},
+	})
+	want := []hook.Hook{
+		{Name: "docker", Command: "docker.exe", Args: []string{"stop", "-a"}, Timeout: 90 * time.Second, RunAs: hook.RunAsSystem, VetoExitCode: 75},
+	}
+	if !reflect.DeepEqual(hooks, want) {
+		t.Errorf("newHooks() = %+v, want %+v", hooks, want)
+	}
+}
+
+func TestClassifyResume(t *testing.T) {
+	requested := time.Date(2026, 3, 2, 18, 0, 0, 0, time.UTC)
+	pending := &hibernationRequest{RequestedAt: requested, Action: config.ActionHibernate, Reason: "No users logged in for 30 minutes"}
+	reconciled := &hibernationRequest{RequestedAt: requested, Action: config.ActionHibernate, Outcome: outcomeHibernated}
+	at := func(d time.Duration) *time.Time { t := requested.Add(d); return &t }
+
+	tests := []struct {
+		name      string
+		req       *hibernationRequest
+		suspendAt *time.Time
+		want      string
+	}{
+		{name: "suspend right after the request", req: pending, suspendAt: at(30 * time.Second), want: outcomeHibernated},
+		{name: "reboot after the request", req: pending, suspendAt: nil, want: outcomeHibernated},
+		{name: "suspend long after the request", req: pending, suspendAt: at(2 * time.Hour), want: outcomeNotApplied},
+		{name: "suspend before the request", req: pending, suspendAt: at(-time.Minute), want: outcomeNotApplied},
+		{name: "no request", req: nil, suspendAt: at(time.Hour), want: outcomeSleep},
+		{name: "request already reconciled", req: reconciled, suspendAt: at(time.Minute), want: outcomeSleep},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, description := classifyResume(tt.req, tt.suspendAt)
+			if got != tt.want {
+				t.Errorf("classifyResume() = %q (%s), want %q", got, description, tt.want)
+			}
+		})
+	}
+}
+
+// TestReconcileResume tests that a resume is matched against the recorded request
+func TestReconcileResume(t *testing.T) {
+	cfg := &config.Config{NoUsersIdleMinutes: 30}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, &mockLogger{})
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.requestPath = filepath.Join(t.TempDir(), requestFileName)
+
+	completed, _ := action.New(config.ActionLocalHibernate, action.Deps{})
+	requested := time.Now().Add(-time.Hour)
+	idle := &monitor.CheckResult{Condition: monitor.IdleConditionNoUsers, Reason: "No users logged in for 30 minutes"}
+	service.recordRequest(completed, idle, requested)
+
+	suspendAt := requested.Add(time.Minute)
+	service.suspendAt = &suspendAt
+	if got := service.reconcileResume(time.Now()); !strings.Contains(got, "took effect") {
+		t.Errorf("reconcileResume() = %q, want the request to have taken effect", got)
+	}
+	if service.suspendAt != nil {
+		t.Error("suspendAt not cleared after reconciliation")
+	}
+
+	req, err := readRequest(service.requestPath)
+	if err != nil {
+		t.Fatalf("readRequest() error = %v", err)
+	}
+	if req.Outcome != outcomeHibernated || req.Condition != "noUsers" || req.SuspendedAt == nil || req.ResumedAt == nil {
+		t.Errorf("recorded request = %+v, want hibernated with suspend and resume times", req)
+	}
+
+	// The next sleep has no request left to match
+	service.reconcileResume(time.Now())
+	if req, _ := readRequest(service.requestPath); req.Outcome != outcomeSleep || !req.RequestedAt.IsZero() {
+		t.Errorf("recorded request = %+v, want an ordinary sleep", req)
+	}
+
+	// Actions that leave the VM running are not recorded
+	script, _ := action.New(config.ActionRunScript, action.Deps{Script: "notify.cmd"})
+	service.recordRequest(script, idle, time.Now())
+	if req, _ := readRequest(service.requestPath); req.Outcome != outcomeSleep {
+		t.Errorf("run-script recorded a hibernation request: %+v", req)
+	}
+}
+
+// TestHandleControl tests the control API commands that do not need a Windows session
+func TestHandleControl(t *testing.T) {
+	cfg := &config.Config{
+		NoUsersIdleMinutes:         30,
+		InactiveUserIdleMinutes:    60,
+		InactiveUserWarningMinutes: 5,
+		LogLevel:                   "info",
+		Path:                       `C:\Program Files\AzureAutoHibernate\config.json`,
+	}
+	if err := cfg.Validate(); err != nil {
+		t.Fatalf("Validate() error = %v", err)
+	}
+	service, err := NewAutoHibernateService(cfg, &azure.VMMetadata{VMName: "test-vm"}, &mockLogger{})
+	if err != nil {
+		t.Fatalf("NewAutoHibernateService() error: %v", err)
+	}
+	service.notifierManager = nil
+
+	resp := service.HandleControl(control.Request{Command: control.CommandStatus})
+	if !resp.OK || resp.Status == nil {
+		t.Fatalf("status = %+v, want a status", resp)
+	}
+	st := resp.Status
+	if st.ConfigPath != cfg.Path || st.Mode != azure.ModeEnabled || st.LogLevel != "info" || len(st.Inhibitors) != 0 {
+		t.Errorf("status = %+v", st)
+	}
+	if !st.ResumedAt.Equal(*service.resumeAt) {
+		t.Errorf("ResumedAt = %v, want the service start %v", st.ResumedAt, *service.resumeAt)
+	}
+	var names []string
+	for _, c := range st.Conditions {
+		names = append(names, c.Name)
+	}
+	if want := []string{"noUsers", "inactiveUser"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("conditions = %v, want %v", names, want)
+	}
+
+	// A check request wakes the monitor loop once
+	service.HandleControl(control.Request{Command: control.CommandCheck})
+	service.HandleControl(control.Request{Command: control.CommandCheck})
+	if len(service.checkNow) != 1 {
+		t.Errorf("%d checks requested, want 1", len(service.checkNow))
+	}
+
+	resp = service.HandleControl(control.Request{Command: control.CommandPause, Seconds: 3600, Reason: "release build"})
+	if !resp.OK {
+		t.Fatalf("pause = %+v", resp)
+	}
+	reason, paused := service.pauseReason()
+	if !paused || !strings.Contains(reason, "release build") {
+		t.Errorf("pauseReason() = %q, %v; want paused for the release build", reason, paused)
+	}
+	if st := service.status(time.Now()); st.PausedUntil == nil || len(st.Inhibitors) != 1 {
+		t.Errorf("status after pause = %+v", st)
+	}
+
+	resp = service.HandleControl(control.Request{Command: control.CommandResume})
+	if !resp.OK || resp.Message != "Idle actions resumed" {
+		t.Errorf("resume = %+v", resp)
+	}
+	if _, paused := service.pauseReason(); paused {
+		t.Error("idle checks still paused after resume")
+	}
+
+	// The test logger has no adjustable level
+	resp = service.HandleControl(control.Request{Command: control.CommandSetLogLevel, Level: "debug"})
+	if resp.OK {
+		t.Errorf("setLogLevel = %+v, want an error", resp)
+	}
+}
+
+// TestHandleSessionChange tests that session changes re-evaluate idle state and reach the notifier manager
+func TestHandleSessionChange(t *testing.T) {
+	tests := []struct {
+		eventType uint32
+		wantCheck bool
+	}{
+		{windows.WTS_SESSION_LOGON, true},
+		{windows.WTS_SESSION_LOGOFF, true},
+		{windows.WTS_REMOTE_CONNECT, true},
+		{windows.WTS_CONSOLE_DISCONNECT, true},
+		{windows.WTS_SESSION_LOCK, false},
+		{windows.WTS_SESSION_UNLOCK, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(sessionChangeName(tt.eventType), func(t *testing.T) {
+			nm := &NotifierManager{logger: &mockLogger{}, notifiers: make(map[int]*NotifierProcess), sessionChanges: make(chan sessionChange, 1)}
+			service := &AutoHibernateService{logger: &mockLogger{}, checkNow: make(chan struct{}, 1), notifierManager: nm}
+
+			notification := windows.WTSSESSION_NOTIFICATION{Size: uint32(unsafe.Sizeof(windows.WTSSESSION_NOTIFICATION{})), SessionID: 3}
+			service.handleSessionChange(tt.eventType, uintptr(unsafe.Pointer(&notification)))
+
+			if got := len(service.checkNow) == 1; got != tt.wantCheck {
+				t.Errorf("check requested = %v, want %v", got, tt.wantCheck)
+			}
+			select {
+			case change := <-nm.sessionChanges:
+				if change.eventType != tt.eventType || change.sessionID != 3 {
+					t.Errorf("queued change = %+v", change)
+				}
+			default:
+				t.Error("session change not queued for the notifier manager")
+			}
+		})
+	}
+}
+
+// TestApplySessionChange tests that disconnects and logoffs update the notifiers
+func TestApplySessionChange(t *testing.T) {
+	nm := &NotifierManager{
+		logger: &mockLogger{},
+		notifiers: map[int]*NotifierProcess{
+			2: {SessionID: 2, IsConnected: true},
+			3: {SessionID: 3, IsConnected: true},
+		},
+		sessionChanges: make(chan sessionChange, 1),
+	}
+
+	nm.applySessionChange(sessionChange{eventType: windows.WTS_REMOTE_DISCONNECT, sessionID: 2})
+	if nm.notifiers[2].IsConnected {
+		t.Error("session 2 still connected after a disconnect")
+	}
+
+	nm.applySessionChange(sessionChange{eventType: windows.WTS_SESSION_LOGOFF, sessionID: 3})
+	if _, exists := nm.notifiers[3]; exists {
+		t.Error("notifier for session 3 still tracked after logoff")
+	}
+
+	// A full queue drops the change instead of blocking the service control handler
+	nm.SessionChanged(sessionChange{eventType: windows.WTS_SESSION_LOCK, sessionID: 2})
+	nm.SessionChanged(sessionChange{eventType: windows.WTS_SESSION_UNLOCK, sessionID: 2})
+	if len(nm.sessionChanges) != 1 {
+		t.Errorf("%d changes queued, want 1", len(nm.sessionChanges))
+	}
+}
+
+// TestPauseService tests that a service pause inhibits idle actions until the service continues
+func TestPauseService(t *testing.T) {
+	for _, maxMinutes := range []int{0, 30} {
+		t.Run(fmt.Sprintf("servicePauseMaxMinutes=%d", maxMinutes), func(t *testing.T) {
+			service := &AutoHibernateService{
+				config:      &config.Config{NoUsersIdleMinutes: 30, ServicePauseMaxMinutes: maxMinutes},
+				idleMonitor: monitor.NewIdleMonitor(30, 0, 0, 0, 0),
+				logger:      &mockLogger{},
+				checkNow:    make(chan struct{}, 1),
+			}
+			now := time.Now()
+
+			timeout := service.pauseService(now)
+			if (timeout != nil) != (maxMinutes > 0) {
+				t.Errorf("pause timeout set = %v, want %v", timeout != nil, maxMinutes > 0)
+			}
+			reason, paused := service.pauseReason()
+			if !paused || !strings.Contains(reason, "the service is paused") {
+				t.Errorf("pauseReason() = %q, %v; want the service pause", reason, paused)
+			}
+			if _, until, _ := service.servicePause(); maxMinutes > 0 && !until.Equal(now.Add(time.Duration(maxMinutes)*time.Minute)) {
+				t.Errorf("pause ends at %v, want %d minutes after %v", until, maxMinutes, now)
+			}
+			if len(service.checkNow) != 1 {
+				t.Error("pause did not request an idle check to cancel the warning")
+			}
+			<-service.checkNow
+
+			service.continueService(now.Add(time.Minute), "test")
+			if _, paused := service.pauseReason(); paused {
+				t.Error("idle actions still paused after continue")
+			}
+			if state := service.idleMonitor.GetState(); state.RearmedAt == nil || !state.RearmedAt.Equal(now.Add(time.Minute)) {
+				t.Errorf("idle timers not restarted on continue: RearmedAt = %v", state.RearmedAt)
+			}
+			if len(service.checkNow) != 1 {
+				t.Error("continue did not request an idle check")
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+//go:build windows
+
+package service
+
+import (
+	"fmt"
+	"unsafe"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"golang.org/x/sys/windows"
+)
+
+// sessionChange is a session change reported by the service control manager
+type sessionChange struct {
+	eventType uint32 // WTS_* event, e.g. WTS_SESSION_LOGON
+	sessionID int
+}
+
+// sessionChangeName returns a readable name for a WTS session change event
+func sessionChangeName(eventType uint32) string {
+	switch eventType {
+	case windows.WTS_CONSOLE_CONNECT:
+		return "console connect"
+	case windows.WTS_CONSOLE_DISCONNECT:
+		return "console disconnect"
+	case windows.WTS_REMOTE_CONNECT:
+		return "remote connect"
+	case windows.WTS_REMOTE_DISCONNECT:
+		return "remote disconnect"
+	case windows.WTS_SESSION_LOGON:
+		return "logon"
+	case windows.WTS_SESSION_LOGOFF:
+		return "logoff"
+	case windows.WTS_SESSION_LOCK:
+		return "lock"
+	case windows.WTS_SESSION_UNLOCK:
+		return "unlock"
+	default:
+		return fmt.Sprintf("event %d", eventType)
+	}
+}
+
+// changesIdleState reports whether a session change can change the idle conditions:
+// a user arriving, leaving, connecting or disconnecting. Lock and unlock do not, since
+// idle time comes from user input.
+func changesIdleState(eventType uint32) bool {
+	switch eventType {
+	case windows.WTS_CONSOLE_CONNECT, windows.WTS_CONSOLE_DISCONNECT,
+		windows.WTS_REMOTE_CONNECT, windows.WTS_REMOTE_DISCONNECT,
+		windows.WTS_SESSION_LOGON, windows.WTS_SESSION_LOGOFF:
+		return true
+	default:
+		return false
+	}
+}
+
+// handleSessionChange handles SERVICE_CONTROL_SESSIONCHANGE. It runs on the service control
+// handler, so notifiers are started and stopped in the background and the idle check runs
+// on the monitor loop.
+func (s *AutoHibernateService) handleSessionChange(eventType uint32, eventData uintptr) {
+	if eventData == 0 {
+		return
+	}
+	// eventData points to a WTSSESSION_NOTIFICATION owned by the service control manager,
+	// valid for the duration of the call
+	notification := *(**windows.WTSSESSION_NOTIFICATION)(unsafe.Pointer(&eventData))
+	change := sessionChange{eventType: eventType, sessionID: int(notification.SessionID)}
+
+	if changesIdleState(eventType) {
+		s.logger.Infof(logger.EventSessionSummary, "Session %d: %s", change.sessionID, sessionChangeName(eventType))
+		s.requestCheck()
+	} else {
+		s.logger.Debugf(logger.EventSessionSummary, "Session %d: %s", change.sessionID, sessionChangeName(eventType))
+	}
+
+	if s.notifierManager != nil {
+		s.notifierManager.SessionChanged(change)
+	}
+}
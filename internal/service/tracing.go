@@ -0,0 +1,71 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+)
+
+// tracingShutdownTimeout bounds the delivery of the remaining spans when the service stops
+const tracingShutdownTimeout = 5 * time.Second
+
+// startTracing starts exporting spans to the OTLP collector if tracing is enabled
+func (s *AutoHibernateService) startTracing() {
+	if !s.config.Tracing.Enabled {
+		return
+	}
+
+	resource := []tracing.Attribute{
+		tracing.String("service.name", appinfo.ServiceName),
+		tracing.String("service.version", version.Version),
+		tracing.String("cloud.provider", "azure"),
+	}
+	if s.vm != nil {
+		resource = append(resource,
+			tracing.String("host.name", s.vm.VMName),
+			tracing.String("cloud.region", s.vm.Location),
+			tracing.String("cloud.account.id", s.vm.SubscriptionId))
+	}
+
+	s.tracer = tracing.NewExporter(s.config.Tracing.Endpoint, resource, func(err error) {
+		// Warn once to avoid flooding the event log while the collector is down
+		if s.tracingFailing.CompareAndSwap(false, true) {
+			s.logger.Warningf(logger.EventTracingError, "Failed to export spans, further failures are logged at debug level: %v", err)
+		} else {
+			s.logger.Debugf(logger.EventTracingError, "Failed to export spans: %v", err)
+		}
+	})
+	tracing.SetExporter(s.tracer)
+	s.logger.Infof(logger.EventServiceStart, "Tracing enabled, exporting spans to %s%s", s.config.Tracing.Endpoint, tracing.TracesPath)
+}
+
+// stopTracing stops tracing and delivers the spans still queued
+func (s *AutoHibernateService) stopTracing() {
+	if s.tracer == nil {
+		return
+	}
+	tracing.SetExporter(nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), tracingShutdownTimeout)
+	defer cancel()
+	if err := s.tracer.Shutdown(ctx); err != nil {
+		s.logger.Warningf(logger.EventTracingError, "Failed to deliver the remaining spans: %v", err)
+	}
+}
+
+// tracedLogger returns the service logger, appending the trace and span IDs of the
+// operation in ctx to each message so log lines can be matched with their trace
+func (s *AutoHibernateService) tracedLogger(ctx context.Context) logger.Logger {
+	span := tracing.FromContext(ctx)
+	if span == nil {
+		return s.logger
+	}
+	return logger.WithSuffix(s.logger, fmt.Sprintf(" [trace_id=%s span_id=%s]", span.TraceID(), span.SpanID()))
+}
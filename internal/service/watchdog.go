@@ -0,0 +1,87 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+)
+
+// watchdogInterval is how often the watchdog checks the monitor loop heartbeat. The
+// monitor loop beats at the same interval while it waits for the next check.
+const watchdogInterval = 15 * time.Second
+
+// watchdogLoop restarts the monitor loop when it stops making progress, for instance in
+// an IMDS or ARM call that never returns or a notifier that stopped reading its pipe
+func (s *AutoHibernateService) watchdogLoop() {
+	ticker := time.NewTicker(watchdogInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.checkWatchdog(time.Now())
+		case <-s.stopChan:
+			return
+		}
+	}
+}
+
+// checkWatchdog reports the heartbeat age and, if the monitor loop missed its deadline,
+// cancels the operation it is stuck in and starts a replacement loop
+func (s *AutoHibernateService) checkWatchdog(now time.Time) {
+	age, _ := s.watchdog.HeartbeatAge(now)
+	heartbeatAgeGauge.Set(age.Seconds())
+
+	stall, stalled := s.watchdog.Check(now)
+	if !stalled {
+		return
+	}
+
+	where := "between operations"
+	if stall.Operation != "" {
+		where = "in " + stall.Operation
+	}
+	s.logger.Errorf(logger.EventMonitorStalled, "Monitor loop made no progress for %v (%s) - canceling it and restarting the loop (watchdogTimeoutSeconds: %d)",
+		stall.Age.Round(time.Second), where, s.config.WatchdogTimeoutSeconds)
+	monitorRestarts.Inc()
+	s.loopRestarts.Add(1)
+	s.startMonitorLoop()
+}
+
+// startMonitorLoop starts a monitor loop. A loop that was running exits as soon as its
+// current operation returns. Until then it checks its canceled context after each operation
+// and makes no further changes to the loop state, so it never acts alongside its replacement.
+func (s *AutoHibernateService) startMonitorLoop() {
+	go s.monitorLoop(s.loopGeneration.Add(1))
+}
+
+// superseded reports whether the watchdog replaced the monitor loop of this generation
+func (s *AutoHibernateService) superseded(generation uint64) bool {
+	return s.loopGeneration.Load() != generation
+}
+
+// abandoned reports whether the watchdog canceled the operation of ctx because the loop
+// stalled. The loop state then belongs to the replacement loop, so a stalled loop must not
+// change it when its operation finally returns.
+func abandoned(ctx context.Context) bool {
+	return ctx.Err() != nil
+}
+
+// beginOperation starts a monitor loop operation. Its context is canceled if the loop
+// stalls; end must be called when the operation returns.
+func (s *AutoHibernateService) beginOperation(parent context.Context, name string) (ctx context.Context, end func()) {
+	if s.watchdog == nil {
+		return context.WithCancel(parent)
+	}
+	return s.watchdog.Begin(parent, name, time.Now())
+}
+
+// heartbeat records that the monitor loop is making progress
+func (s *AutoHibernateService) heartbeat() {
+	if s.watchdog != nil {
+		s.watchdog.Beat(time.Now())
+	}
+}
@@ -0,0 +1,79 @@
+//go:build windows
+
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/azure"
+	"github.com/smitstech/AzureAutoHibernate/internal/config"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+	"github.com/smitstech/AzureAutoHibernate/internal/logger"
+	"github.com/smitstech/AzureAutoHibernate/internal/version"
+	"github.com/smitstech/AzureAutoHibernate/internal/webhook"
+)
+
+// webhookDrainTimeout bounds how long the service waits for queued webhook deliveries when stopping
+const webhookDrainTimeout = 10 * time.Second
+
+// newWebhookDispatcher starts delivering to the configured webhooks, or returns nil if none
+// are configured. Webhooks whose signing secret cannot be read are skipped.
+func newWebhookDispatcher(cfg *config.Config, log logger.Logger) *webhook.Dispatcher {
+	var sinks []webhook.Sink
+	for _, w := range cfg.Webhooks {
+		secret, err := azure.ReadSecret(w.SecretFile, w.SecretEnv)
+		if err != nil {
+			log.Errorf(logger.EventWebhookError, "Webhook %s disabled: failed to read signing secret: %v", w.Name, err)
+			continue
+		}
+		sinks = append(sinks, webhook.Sink{
+			Name:       w.Name,
+			URL:        w.URL,
+			Format:     w.Format,
+			Events:     w.Events,
+			Secret:     secret,
+			MaxRetries: w.MaxRetries,
+		})
+	}
+	if len(sinks) == 0 {
+		return nil
+	}
+
+	return webhook.NewDispatcher(sinks, webhook.Options{
+		Client: httpclient.Default,
+		OnError: func(sink string, e webhook.Event, err error) {
+			log.Warningf(logger.EventWebhookError, "Failed to deliver %s event to webhook %s: %v", e.Type, sink, err)
+		},
+		OnDelivery: func(sink string, e webhook.Event, attempts int) {
+			log.Debugf(logger.EventWebhookDelivered, "Delivered %s event to webhook %s (attempts: %d)", e.Type, sink, attempts)
+		},
+	})
+}
+
+// publish sends a lifecycle event to the configured webhooks, filling in the VM details
+func (s *AutoHibernateService) publish(e webhook.Event) {
+	if s.webhooks == nil {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	if s.vm != nil {
+		e.VMName = s.vm.VMName
+		e.ResourceGroup = s.vm.ResourceGroup
+		e.SubscriptionID = s.vm.SubscriptionId
+	}
+	e.AgentVersion = version.Version
+	s.webhooks.Publish(e)
+}
+
+// closeWebhooks waits briefly for queued webhook deliveries when the service stops
+func (s *AutoHibernateService) closeWebhooks() {
+	if s.webhooks == nil {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), webhookDrainTimeout)
+	defer cancel()
+	s.webhooks.Close(ctx)
+}
@@ -0,0 +1,256 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TracesPath is the OTLP/HTTP path that spans are posted to, under the collector endpoint
+const TracesPath = "/v1/traces"
+
+// scopeName is the instrumentation scope reported with every span
+const scopeName = "github.com/smitstech/AzureAutoHibernate"
+
+const (
+	queueSize      = 2048             // Ended spans waiting for export; more are dropped
+	batchSize      = 256              // Spans per request
+	exportInterval = 5 * time.Second  // Longest time a span waits for its batch
+	exportTimeout  = 10 * time.Second // Bound of one request to the collector
+)
+
+// OTLP span kind and status codes
+const (
+	spanKindInternal = 1
+	statusCodeError  = 2
+)
+
+// Exporter posts ended spans in batches to an OTLP/HTTP collector, encoded as JSON. The
+// collector is local, so requests go straight to it rather than through the proxy.
+type Exporter struct {
+	url      string
+	resource []Attribute
+	onError  func(error)
+	client   *http.Client
+
+	queue    chan spanData
+	stop     chan struct{}
+	stopOnce sync.Once
+	done     chan struct{}
+}
+
+// NewExporter starts an exporter that posts to endpoint + TracesPath. resource describes the
+// process (service.name, service.version, ...); onError, if not nil, is called from the
+// export goroutine when a batch cannot be delivered.
+func NewExporter(endpoint string, resource []Attribute, onError func(error)) *Exporter {
+	e := &Exporter{
+		url:      strings.TrimSuffix(endpoint, "/") + TracesPath,
+		resource: resource,
+		onError:  onError,
+		client:   &http.Client{Timeout: exportTimeout, Transport: &http.Transport{}},
+		queue:    make(chan spanData, queueSize),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go e.run()
+	return e
+}
+
+// export queues an ended span, dropping it if the queue is full so a slow collector never
+// holds up the service
+func (e *Exporter) export(span spanData) {
+	select {
+	case e.queue <- span:
+	default:
+	}
+}
+
+// Shutdown delivers the queued spans and stops the exporter. It returns ctx.Err() if ctx
+// ends first.
+func (e *Exporter) Shutdown(ctx context.Context) error {
+	e.stopOnce.Do(func() { close(e.stop) })
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// run batches queued spans and posts them when a batch is full or has waited exportInterval
+func (e *Exporter) run() {
+	defer close(e.done)
+	ticker := time.NewTicker(exportInterval)
+	defer ticker.Stop()
+
+	var batch []spanData
+	flush := func() {
+		if len(batch) > 0 {
+			if err := e.post(batch); err != nil && e.onError != nil {
+				e.onError(err)
+			}
+			batch = nil
+		}
+	}
+
+	for {
+		select {
+		case span := <-e.queue:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-e.stop:
+			for {
+				select {
+				case span := <-e.queue:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+// post sends one batch of spans to the collector
+func (e *Exporter) post(batch []spanData) error {
+	body, err := json.Marshal(encode(batch, e.resource))
+	if err != nil {
+		return fmt.Errorf("failed to encode spans: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create export request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to export %d span(s) to %s: %w", len(batch), e.url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("collector at %s returned status %d exporting %d span(s): %s", e.url, resp.StatusCode, len(batch), strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// ExportTraceServiceRequest in the OTLP/HTTP JSON encoding. 64-bit integers are strings and
+// IDs are hex, as the encoding requires.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpResource struct {
+	Attributes []otlpKeyValue `json:"attributes"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              int            `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+// encode builds the export request of a batch
+func encode(batch []spanData, resource []Attribute) otlpRequest {
+	spans := make([]otlpSpan, 0, len(batch))
+	for _, s := range batch {
+		span := otlpSpan{
+			TraceID:           s.traceID.String(),
+			SpanID:            s.spanID.String(),
+			Name:              s.name,
+			Kind:              spanKindInternal,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+			Attributes:        encodeAttributes(s.attributes),
+		}
+		if s.parentID != (SpanID{}) {
+			span.ParentSpanID = s.parentID.String()
+		}
+		if s.err != "" {
+			span.Status = otlpStatus{Code: statusCodeError, Message: s.err}
+		}
+		spans = append(spans, span)
+	}
+
+	return otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: encodeAttributes(resource)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: scopeName}, Spans: spans}},
+	}}}
+}
+
+// encodeAttributes converts attributes to key-value pairs; values of other types are sent as strings
+func encodeAttributes(attributes []Attribute) []otlpKeyValue {
+	kvs := make([]otlpKeyValue, 0, len(attributes))
+	for _, a := range attributes {
+		var v otlpAnyValue
+		switch value := a.Value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: a.Key, Value: v})
+	}
+	return kvs
+}
@@ -0,0 +1,189 @@
+// Package tracing records spans of the service's operations (idle checks, Azure requests,
+// update checks and notifier commands) and exports them over OTLP/HTTP to a local
+// OpenTelemetry collector. Tracing is off until an exporter is installed with SetExporter;
+// until then Start returns a nil span, whose methods do nothing.
+package tracing
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// TraceID identifies a trace: a span and all the spans started under it
+type TraceID [16]byte
+
+func (id TraceID) String() string { return hex.EncodeToString(id[:]) }
+
+// SpanID identifies a span within its trace
+type SpanID [8]byte
+
+func (id SpanID) String() string { return hex.EncodeToString(id[:]) }
+
+// Attribute is a key with a string, int64, bool or float64 value
+type Attribute struct {
+	Key   string
+	Value any
+}
+
+// String returns a string attribute
+func String(key, value string) Attribute { return Attribute{Key: key, Value: value} }
+
+// Int returns an integer attribute
+func Int(key string, value int) Attribute { return Attribute{Key: key, Value: int64(value)} }
+
+// Bool returns a boolean attribute
+func Bool(key string, value bool) Attribute { return Attribute{Key: key, Value: value} }
+
+// exporter receives ended spans (nil while tracing is off)
+var exporter atomic.Pointer[Exporter]
+
+// SetExporter turns tracing on with an exporter, or off with nil
+func SetExporter(e *Exporter) {
+	exporter.Store(e)
+}
+
+// Span is an operation being timed. A nil span records nothing, so callers never need to
+// check whether tracing is on.
+type Span struct {
+	exporter *Exporter
+	traceID  TraceID
+	spanID   SpanID
+	parentID SpanID // Zero for the root span of a trace
+	name     string
+	start    time.Time
+
+	mu         sync.Mutex // Guards the fields below
+	attributes []Attribute
+	err        string // Error that failed the operation ("" if it succeeded)
+	ended      bool
+}
+
+// spanKey is the context key of the current span
+type spanKey struct{}
+
+// Start starts a span, as a child of the span in ctx if there is one, and returns a
+// context carrying it. It returns ctx and a nil span while tracing is off.
+func Start(ctx context.Context, name string, attributes ...Attribute) (context.Context, *Span) {
+	e := exporter.Load()
+	if e == nil {
+		return ctx, nil
+	}
+
+	span := &Span{
+		exporter:   e,
+		spanID:     newSpanID(),
+		name:       name,
+		start:      time.Now(),
+		attributes: attributes,
+	}
+	if parent := FromContext(ctx); parent != nil {
+		span.traceID = parent.traceID
+		span.parentID = parent.spanID
+	} else {
+		span.traceID = newTraceID()
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+// FromContext returns the span carried by ctx, or nil
+func FromContext(ctx context.Context) *Span {
+	span, _ := ctx.Value(spanKey{}).(*Span)
+	return span
+}
+
+// SetAttributes adds attributes to the span
+func (s *Span) SetAttributes(attributes ...Attribute) {
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.attributes = append(s.attributes, attributes...)
+}
+
+// RecordError marks the operation failed without ending the span
+func (s *Span) RecordError(err error) {
+	if s == nil || err == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err.Error()
+}
+
+// End ends the span and queues it for export. A non-nil err marks the operation failed.
+// Only the first call has an effect.
+func (s *Span) End(err error) {
+	if s == nil {
+		return
+	}
+	end := time.Now()
+
+	s.mu.Lock()
+	if s.ended {
+		s.mu.Unlock()
+		return
+	}
+	s.ended = true
+	if err != nil {
+		s.err = err.Error()
+	}
+	data := spanData{
+		traceID:    s.traceID,
+		spanID:     s.spanID,
+		parentID:   s.parentID,
+		name:       s.name,
+		start:      s.start,
+		end:        end,
+		attributes: append([]Attribute(nil), s.attributes...),
+		err:        s.err,
+	}
+	s.mu.Unlock()
+
+	s.exporter.export(data)
+}
+
+// TraceID returns the trace ID in hex, or "" for a nil span
+func (s *Span) TraceID() string {
+	if s == nil {
+		return ""
+	}
+	return s.traceID.String()
+}
+
+// SpanID returns the span ID in hex, or "" for a nil span
+func (s *Span) SpanID() string {
+	if s == nil {
+		return ""
+	}
+	return s.spanID.String()
+}
+
+// spanData is an ended span, as queued for export
+type spanData struct {
+	traceID    TraceID
+	spanID     SpanID
+	parentID   SpanID
+	name       string
+	start, end time.Time
+	attributes []Attribute
+	err        string
+}
+
+// newTraceID returns a random trace ID
+func newTraceID() TraceID {
+	var id TraceID
+	rand.Read(id[:])
+	return id
+}
+
+// newSpanID returns a random span ID
+func newSpanID() SpanID {
+	var id SpanID
+	rand.Read(id[:])
+	return id
+}
@@ -0,0 +1,180 @@
+package tracing
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+// collector is an in-process OTLP/HTTP collector that keeps the requests it receives
+type collector struct {
+	mu       sync.Mutex
+	requests []otlpRequest
+	status   int // Response status (200 if zero)
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost || r.URL.Path != TracesPath || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusBadRequest)
+		return
+	}
+	var req otlpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = append(c.requests, req)
+	if c.status != 0 {
+		http.Error(w, "collector unavailable", c.status)
+		return
+	}
+	w.Write([]byte("{}"))
+}
+
+// spans returns the spans received, by name
+func (c *collector) spans() map[string]otlpSpan {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	spans := make(map[string]otlpSpan)
+	for _, req := range c.requests {
+		for _, rs := range req.ResourceSpans {
+			for _, ss := range rs.ScopeSpans {
+				for _, span := range ss.Spans {
+					spans[span.Name] = span
+				}
+			}
+		}
+	}
+	return spans
+}
+
+// startCollector installs an exporter that posts to a new in-process collector
+func startCollector(t *testing.T, c *collector, onError func(error)) *Exporter {
+	t.Helper()
+	server := httptest.NewServer(c)
+	t.Cleanup(server.Close)
+
+	exporter := NewExporter(server.URL, []Attribute{String("service.name", "AzureAutoHibernate")}, onError)
+	SetExporter(exporter)
+	t.Cleanup(func() { SetExporter(nil) })
+	return exporter
+}
+
+// shutdown delivers the spans queued by the test
+func shutdown(t *testing.T, e *Exporter) {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := e.Shutdown(ctx); err != nil {
+		t.Fatalf("Shutdown() = %v", err)
+	}
+}
+
+func TestExport(t *testing.T) {
+	c := &collector{}
+	exporter := startCollector(t, c, func(err error) { t.Errorf("export failed: %v", err) })
+
+	ctx, check := Start(context.Background(), "idle check", String("condition", "no_users"))
+	check.SetAttributes(Int("session.count", 0), Bool("should_hibernate", true))
+	_, token := Start(ctx, "imds token")
+	token.SetAttributes(Int("http.response.status_code", 500), Int("retry.count", 2))
+	token.End(errors.New("IMDS returned status 500"))
+	check.End(nil)
+	check.End(errors.New("ignored")) // Only the first End counts
+
+	shutdown(t, exporter)
+
+	spans := c.spans()
+	if len(spans) != 2 {
+		t.Fatalf("collector received %d span(s), want 2", len(spans))
+	}
+	root, child := spans["idle check"], spans["imds token"]
+
+	if root.TraceID != check.TraceID() || root.SpanID != check.SpanID() || root.ParentSpanID != "" {
+		t.Errorf("root span IDs = %s/%s (parent %q), want %s/%s with no parent", root.TraceID, root.SpanID, root.ParentSpanID, check.TraceID(), check.SpanID())
+	}
+	if child.TraceID != root.TraceID || child.ParentSpanID != root.SpanID {
+		t.Errorf("child span trace %s parent %s, want trace %s parent %s", child.TraceID, child.ParentSpanID, root.TraceID, root.SpanID)
+	}
+	if len(root.TraceID) != 32 || len(root.SpanID) != 16 {
+		t.Errorf("IDs %q/%q are not 16 and 8 bytes in hex", root.TraceID, root.SpanID)
+	}
+	if root.Kind != spanKindInternal || root.StartTimeUnixNano == "" || root.EndTimeUnixNano < root.StartTimeUnixNano {
+		t.Errorf("root span kind %d, times %s-%s", root.Kind, root.StartTimeUnixNano, root.EndTimeUnixNano)
+	}
+
+	if root.Status.Code != 0 {
+		t.Errorf("root span status = %+v, want unset", root.Status)
+	}
+	if child.Status.Code != statusCodeError || child.Status.Message != "IMDS returned status 500" {
+		t.Errorf("child span status = %+v, want an error with the message", child.Status)
+	}
+
+	attrs := make(map[string]otlpAnyValue)
+	for _, kv := range root.Attributes {
+		attrs[kv.Key] = kv.Value
+	}
+	if v := attrs["condition"].StringValue; v == nil || *v != "no_users" {
+		t.Errorf("condition = %v, want no_users", v)
+	}
+	if v := attrs["session.count"].IntValue; v == nil || *v != "0" {
+		t.Errorf("session.count = %v, want \"0\"", v)
+	}
+	if v := attrs["should_hibernate"].BoolValue; v == nil || !*v {
+		t.Errorf("should_hibernate = %v, want true", v)
+	}
+
+	resource := c.requests[0].ResourceSpans[0].Resource.Attributes
+	if len(resource) != 1 || resource[0].Key != "service.name" || *resource[0].Value.StringValue != "AzureAutoHibernate" {
+		t.Errorf("resource attributes = %+v", resource)
+	}
+}
+
+func TestExportFailure(t *testing.T) {
+	errs := make(chan error, 1)
+	c := &collector{status: http.StatusServiceUnavailable}
+	exporter := startCollector(t, c, func(err error) { errs <- err })
+
+	_, span := Start(context.Background(), "update check")
+	span.RecordError(errors.New("rate limited"))
+	span.End(nil)
+	shutdown(t, exporter)
+
+	select {
+	case err := <-errs:
+		if err == nil {
+			t.Error("onError called with nil")
+		}
+	default:
+		t.Error("onError was not called when the collector failed")
+	}
+
+	// The span reached the collector, marked failed by RecordError
+	if span := c.spans()["update check"]; span.Status.Code != statusCodeError || span.Status.Message != "rate limited" {
+		t.Errorf("span status = %+v, want the recorded error", span.Status)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	ctx := context.Background()
+	got, span := Start(ctx, "idle check")
+	if span != nil || got != ctx {
+		t.Fatalf("Start() without an exporter = %v, %v; want the same context and a nil span", got, span)
+	}
+
+	// A nil span is safe to use
+	span.SetAttributes(String("condition", "no_users"))
+	span.RecordError(errors.New("failed"))
+	span.End(errors.New("failed"))
+	if span.TraceID() != "" || span.SpanID() != "" || FromContext(got) != nil {
+		t.Error("nil span has IDs")
+	}
+}
@@ -0,0 +1,78 @@
+//go:build windows
+
+package updater
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+
+	"github.com/creativeprojects/go-selfupdate"
+	"github.com/google/go-github/v86/github"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
+	"github.com/smitstech/AzureAutoHibernate/internal/tracing"
+)
+
+// githubSource lists releases of one repository through the shared HTTP client, so update
+// checks honor the configured proxy, root CAs and timeouts. selfupdate.GitHubSource always
+// uses the default transport.
+type githubSource struct {
+	api   *github.Client
+	owner string
+	repo  string
+}
+
+// newGitHubSource creates a release source for owner/repo, authenticated with $GITHUB_TOKEN if set
+func newGitHubSource(owner, repo string) *githubSource {
+	client := github.NewClient(httpclient.Default())
+	if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+		client = client.WithAuthToken(token)
+	}
+	return &githubSource{api: client, owner: owner, repo: repo}
+}
+
+// ListReleases returns all releases of the repository
+func (s *githubSource) ListReleases(ctx context.Context, repository selfupdate.Repository) (releases []selfupdate.SourceRelease, err error) {
+	owner, repo, err := repository.GetSlug()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, span := tracing.Start(ctx, "github releases", tracing.String("repository", owner+"/"+repo))
+	defer func() {
+		span.SetAttributes(tracing.Int("release.count", len(releases)))
+		span.End(err)
+	}()
+
+	rels, res, err := s.api.Repositories.ListReleases(ctx, owner, repo, nil)
+	if res != nil {
+		span.SetAttributes(tracing.Int("http.response.status_code", res.StatusCode))
+	}
+	if err != nil {
+		if res != nil && res.StatusCode == http.StatusNotFound {
+			// No repository or no releases: not an error, there is just nothing to update to
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	releases = make([]selfupdate.SourceRelease, len(rels))
+	for i, rel := range rels {
+		releases[i] = selfupdate.NewGitHubRelease(rel)
+	}
+	return releases, nil
+}
+
+// DownloadReleaseAsset downloads a release asset. The caller must close the returned reader.
+func (s *githubSource) DownloadReleaseAsset(ctx context.Context, rel *selfupdate.Release, assetID int64) (io.ReadCloser, error) {
+	if rel == nil {
+		return nil, selfupdate.ErrInvalidRelease
+	}
+	rc, _, err := s.api.Repositories.DownloadReleaseAsset(ctx, s.owner, s.repo, assetID, httpclient.Download())
+	if err != nil {
+		return nil, fmt.Errorf("failed to download asset %d from %s/%s: %w", assetID, s.owner, s.repo, err)
+	}
+	return rc, nil
+}
@@ -15,6 +15,7 @@ import (
 
 	"github.com/creativeprojects/go-selfupdate"
 	"github.com/smitstech/AzureAutoHibernate/internal/appinfo"
+	"github.com/smitstech/AzureAutoHibernate/internal/httpclient"
 	"github.com/smitstech/AzureAutoHibernate/internal/version"
 )
 
@@ -30,10 +31,7 @@ type UpdateInfo struct {
 
 // CheckForUpdate checks GitHub for a newer version
 func CheckForUpdate(ctx context.Context) (*UpdateInfo, error) {
-	source, err := selfupdate.NewGitHubSource(selfupdate.GitHubConfig{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to create GitHub source: %w", err)
-	}
+	source := newGitHubSource(appinfo.RepoOwner, appinfo.RepoName)
 
 	updater, err := selfupdate.NewUpdater(selfupdate.Config{
 		Source:    source,
@@ -125,7 +123,7 @@ func downloadFile(ctx context.Context, url, destPath string) error {
 		return fmt.Errorf("failed to create request: %w", err)
 	}
 
-	resp, err := http.DefaultClient.Do(req)
+	resp, err := httpclient.Download().Do(req)
 	if err != nil {
 		return fmt.Errorf("failed to download: %w", err)
 	}
@@ -0,0 +1,124 @@
+// Package watchdog detects a loop that stopped making progress, such as a monitor loop
+// stuck in a network call or a blocked pipe write, and cancels the operation it is stuck in.
+package watchdog
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Watchdog tracks the heartbeat of a loop and the operations it runs. The loop beats
+// while it waits and between steps; an operation started with Begin is canceled when the
+// heartbeat is older than the deadline. The zero value never reports a stall.
+type Watchdog struct {
+	mu         sync.Mutex
+	deadline   time.Duration
+	beat       time.Time
+	checked    time.Time    // Last call to Check
+	operations []*operation // Operations in flight, outermost first
+}
+
+// operation is a step of the loop that can be canceled
+type operation struct {
+	name    string
+	started time.Time
+	cancel  context.CancelFunc
+}
+
+// Stall describes a loop that missed its deadline
+type Stall struct {
+	Operation string        // Innermost operation in flight ("" if none)
+	Running   time.Duration // How long that operation has run
+	Age       time.Duration // Time since the last heartbeat
+}
+
+// New returns a watchdog that reports a stall when the heartbeat is older than deadline
+func New(deadline time.Duration, now time.Time) *Watchdog {
+	return &Watchdog{deadline: deadline, beat: now}
+}
+
+// Deadline returns the heartbeat age at which the loop is considered stalled
+func (w *Watchdog) Deadline() time.Duration {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.deadline
+}
+
+// Beat records that the loop is making progress
+func (w *Watchdog) Beat(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.beat = now
+}
+
+// Begin starts an operation and returns its context, which is canceled if the loop stalls.
+// The returned function ends the operation and must be called when it returns.
+func (w *Watchdog) Begin(parent context.Context, name string, now time.Time) (context.Context, func()) {
+	ctx, cancel := context.WithCancel(parent)
+	op := &operation{name: name, started: now, cancel: cancel}
+
+	w.mu.Lock()
+	w.beat = now
+	w.operations = append(w.operations, op)
+	w.mu.Unlock()
+
+	return ctx, func() {
+		cancel()
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		for i, o := range w.operations {
+			if o == op {
+				w.operations = append(w.operations[:i], w.operations[i+1:]...)
+				break
+			}
+		}
+	}
+}
+
+// HeartbeatAge returns the time since the last heartbeat and the innermost operation in flight
+func (w *Watchdog) HeartbeatAge(now time.Time) (age time.Duration, operation string) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if n := len(w.operations); n > 0 {
+		operation = w.operations[n-1].name
+	}
+	if w.beat.IsZero() {
+		return 0, operation
+	}
+	return max(now.Sub(w.beat), 0), operation
+}
+
+// Check reports whether the loop has stalled. On a stall, every operation in flight is
+// canceled and forgotten, and the heartbeat restarts from now for the replacement loop.
+//
+// Check is meant to be called at intervals well below the deadline. A longer gap means
+// the whole process was suspended, as when the VM hibernates, so the heartbeat restarts
+// from now instead of reporting a stall.
+func (w *Watchdog) Check(now time.Time) (Stall, bool) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	suspended := !w.checked.IsZero() && now.Sub(w.checked) > w.deadline
+	w.checked = now
+	if suspended {
+		w.beat = now
+		return Stall{}, false
+	}
+
+	if w.deadline <= 0 || w.beat.IsZero() || now.Sub(w.beat) <= w.deadline {
+		return Stall{}, false
+	}
+
+	stall := Stall{Age: now.Sub(w.beat)}
+	if n := len(w.operations); n > 0 {
+		stall.Operation = w.operations[n-1].name
+		stall.Running = now.Sub(w.operations[n-1].started)
+	}
+	for _, op := range w.operations {
+		op.cancel()
+	}
+	w.operations = nil
+	w.beat = now
+	return stall, true
+}
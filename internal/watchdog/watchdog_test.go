@@ -0,0 +1,97 @@
+package watchdog
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	w := New(time.Minute, start)
+
+	// Beats while waiting keep the loop healthy
+	w.Beat(start.Add(50 * time.Second))
+	if _, stalled := w.Check(start.Add(100 * time.Second)); stalled {
+		t.Fatal("Check() reported a stall within the deadline")
+	}
+
+	outer, endOuter := w.Begin(context.Background(), "idle check", start.Add(100*time.Second))
+	inner, endInner := w.Begin(outer, "remote control refresh", start.Add(110*time.Second))
+	if age, op := w.HeartbeatAge(start.Add(130 * time.Second)); age != 20*time.Second || op != "remote control refresh" {
+		t.Errorf("HeartbeatAge() = %v, %q", age, op)
+	}
+	if _, stalled := w.Check(start.Add(130 * time.Second)); stalled {
+		t.Fatal("Check() reported a stall while an operation was within the deadline")
+	}
+
+	stall, stalled := w.Check(start.Add(171 * time.Second))
+	if !stalled {
+		t.Fatal("Check() did not report a stall past the deadline")
+	}
+	if stall.Operation != "remote control refresh" || stall.Running != 61*time.Second || stall.Age != 61*time.Second {
+		t.Errorf("stall = %+v", stall)
+	}
+	for name, ctx := range map[string]context.Context{"outer": outer, "inner": inner} {
+		if ctx.Err() == nil {
+			t.Errorf("%s operation not canceled", name)
+		}
+	}
+
+	// The heartbeat restarts for the replacement loop, and ending the canceled operations is harmless
+	if _, stalled := w.Check(start.Add(200 * time.Second)); stalled {
+		t.Error("Check() reported the same stall twice")
+	}
+	endInner()
+	endOuter()
+	if _, op := w.HeartbeatAge(start.Add(200 * time.Second)); op != "" {
+		t.Errorf("operation %q still in flight", op)
+	}
+}
+
+func TestEndOperation(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	w := New(time.Minute, start)
+
+	ctx, end := w.Begin(context.Background(), "idle check", start)
+	end()
+	if ctx.Err() == nil {
+		t.Error("context not released when the operation ended")
+	}
+	if _, op := w.HeartbeatAge(start); op != "" {
+		t.Errorf("operation %q still in flight", op)
+	}
+}
+
+func TestCheckAfterSuspend(t *testing.T) {
+	start := time.Date(2026, 3, 2, 14, 0, 0, 0, time.UTC)
+	w := New(time.Minute, start)
+	w.Check(start.Add(10 * time.Second))
+
+	// No check ran for an hour: the VM hibernated with the loop, which is not a stall
+	resumed := start.Add(time.Hour)
+	if _, stalled := w.Check(resumed); stalled {
+		t.Fatal("Check() reported a stall after a suspend")
+	}
+	if age, _ := w.HeartbeatAge(resumed); age != 0 {
+		t.Errorf("HeartbeatAge() = %v after a suspend, want 0", age)
+	}
+
+	// Checks resume at their usual interval and a real stall is still caught
+	for i := 1; i <= 6; i++ {
+		if _, stalled := w.Check(resumed.Add(time.Duration(i) * 10 * time.Second)); stalled {
+			t.Fatalf("Check() reported a stall %ds after the resume", i*10)
+		}
+	}
+	if _, stalled := w.Check(resumed.Add(70 * time.Second)); !stalled {
+		t.Error("Check() did not report a stall after the resume")
+	}
+}
+
+func TestZeroValue(t *testing.T) {
+	var w Watchdog
+	w.Beat(time.Now().Add(-time.Hour))
+	if _, stalled := w.Check(time.Now()); stalled {
+		t.Error("zero value reported a stall")
+	}
+}
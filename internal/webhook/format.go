@@ -0,0 +1,117 @@
+package webhook
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/smitstech/AzureAutoHibernate/internal/action"
+)
+
+// Render builds the request body for an event in the given format
+func Render(format string, e Event) ([]byte, error) {
+	var payload any
+	switch format {
+	case "", FormatGeneric:
+		payload = e
+	case FormatTeams:
+		payload = teamsPayload(e)
+	case FormatSlack:
+		payload = slackPayload(e)
+	default:
+		return nil, fmt.Errorf("unknown webhook format: %s", format)
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode webhook payload: %w", err)
+	}
+	return data, nil
+}
+
+// Title returns a one-line summary of the event
+func Title(e Event) string {
+	switch e.Type {
+	case EventWarning:
+		return fmt.Sprintf("%s will %s in %v", e.VMName, action.Verb(e.Action), (time.Duration(e.TimeRemainingSeconds) * time.Second).Round(time.Second))
+	case EventWarningCanceled:
+		return fmt.Sprintf("%s: hibernation warning canceled", e.VMName)
+	case EventHibernated:
+		return fmt.Sprintf("%s was stopped (%s)", e.VMName, e.Action)
+	case EventHibernationFailed:
+		return fmt.Sprintf("%s failed to %s", e.VMName, action.Verb(e.Action))
+	case EventResumed:
+		return fmt.Sprintf("%s resumed", e.VMName)
+	default:
+		return fmt.Sprintf("%s: %s", e.VMName, e.Type)
+	}
+}
+
+// facts returns the event details shown in chat messages
+func facts(e Event) [][2]string {
+	facts := [][2]string{
+		{"VM", e.VMName},
+		{"Resource group", e.ResourceGroup},
+	}
+	if e.Reason != "" {
+		facts = append(facts, [2]string{"Reason", e.Reason})
+	}
+	if e.Action != "" {
+		facts = append(facts, [2]string{"Action", e.Action})
+	}
+	if e.Error != "" {
+		facts = append(facts, [2]string{"Error", e.Error})
+	}
+	facts = append(facts, [2]string{"Time", e.Time.UTC().Format(time.RFC3339)})
+	return facts
+}
+
+// teamsPayload returns an Adaptive Card message for a Teams workflow ("Post to a channel
+// when a webhook request is received") or incoming webhook
+func teamsPayload(e Event) map[string]any {
+	var factSet []map[string]string
+	for _, f := range facts(e) {
+		factSet = append(factSet, map[string]string{"title": f[0], "value": f[1]})
+	}
+
+	color := "Default"
+	switch e.Type {
+	case EventHibernationFailed:
+		color = "Attention"
+	case EventWarning:
+		color = "Warning"
+	case EventHibernated, EventResumed:
+		color = "Good"
+	}
+
+	return map[string]any{
+		"type": "message",
+		"attachments": []map[string]any{{
+			"contentType": "application/vnd.microsoft.card.adaptive",
+			"content": map[string]any{
+				"$schema": "http://adaptivecards.io/schemas/adaptive-card.json",
+				"type":    "AdaptiveCard",
+				"version": "1.4",
+				"body": []map[string]any{
+					{"type": "TextBlock", "text": Title(e), "weight": "Bolder", "size": "Medium", "wrap": true, "color": color},
+					{"type": "FactSet", "facts": factSet},
+				},
+			},
+		}},
+	}
+}
+
+// slackPayload returns a message for a Slack incoming webhook
+func slackPayload(e Event) map[string]any {
+	text := "*" + Title(e) + "*"
+	for _, f := range facts(e) {
+		text += fmt.Sprintf("\n%s: %s", f[0], f[1])
+	}
+	return map[string]any{
+		"text": Title(e), // Shown in notifications
+		"blocks": []map[string]any{{
+			"type": "section",
+			"text": map[string]string{"type": "mrkdwn", "text": text},
+		}},
+	}
+}
@@ -0,0 +1,326 @@
+// Package webhook posts hibernation lifecycle events to chat and automation
+// webhooks (Microsoft Teams, Slack or a generic JSON schema). Deliveries are
+// asynchronous, retried on transient failures and optionally HMAC-signed.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Event types a sink can subscribe to
+const (
+	EventWarning           = "warning"           // Users were warned of an upcoming idle action
+	EventWarningCanceled   = "warningCanceled"   // The warning was canceled (activity or pause)
+	EventHibernated        = "hibernated"        // The idle action completed
+	EventHibernationFailed = "hibernationFailed" // The idle action (and any fallback) failed
+	EventResumed           = "resumed"           // The VM resumed from hibernation
+)
+
+// EventTypes lists all event types
+var EventTypes = []string{EventWarning, EventWarningCanceled, EventHibernated, EventHibernationFailed, EventResumed}
+
+// Payload formats
+const (
+	FormatGeneric = "generic" // The Event as JSON
+	FormatTeams   = "teams"   // Adaptive Card for a Teams workflow webhook
+	FormatSlack   = "slack"   // Slack incoming webhook message
+)
+
+// Headers set on every delivery; the timestamp and signature only when the sink has a secret
+const (
+	HeaderEvent     = "X-AutoHibernate-Event"     // Event type
+	HeaderDelivery  = "X-AutoHibernate-Delivery"  // Unique delivery ID, the same across retries
+	HeaderTimestamp = "X-AutoHibernate-Timestamp" // Unix time the delivery was signed
+	HeaderSignature = "X-AutoHibernate-Signature" // sha256=hex(HMAC-SHA256(secret, timestamp + "." + body))
+)
+
+const (
+	// defaultMaxRetries is the number of retries after the first attempt
+	defaultMaxRetries = 3
+	// defaultRetryDelay is the delay before the first retry; it doubles on each retry
+	defaultRetryDelay = 2 * time.Second
+	// maxRetryDelay caps the retry delay, including Retry-After
+	maxRetryDelay = 5 * time.Minute
+	// queueSize is the number of events buffered per sink before new events are dropped
+	queueSize = 100
+)
+
+// Event is a hibernation lifecycle event
+type Event struct {
+	Type                 string    `json:"type"`
+	Time                 time.Time `json:"time"`
+	VMName               string    `json:"vmName"`
+	ResourceGroup        string    `json:"resourceGroup"`
+	SubscriptionID       string    `json:"subscriptionId"`
+	Condition            string    `json:"condition,omitempty"`            // noUsers, allDisconnected or inactiveUser
+	Reason               string    `json:"reason,omitempty"`               // Why the action ran or the warning was canceled
+	Action               string    `json:"action,omitempty"`               // Idle action, e.g. hibernate
+	Error                string    `json:"error,omitempty"`                // Failure details (hibernationFailed)
+	TimeRemainingSeconds int       `json:"timeRemainingSeconds,omitempty"` // Seconds until the action (warning)
+	AgentVersion         string    `json:"agentVersion"`
+}
+
+// Sink is a webhook endpoint
+type Sink struct {
+	Name       string   // Used in logs
+	URL        string   // Endpoint receiving the POST
+	Format     string   // generic (default), teams or slack
+	Events     []string // Event types to send (empty: all)
+	Secret     string   // HMAC signing key (empty: unsigned)
+	MaxRetries int      // Retries after the first attempt (0: default of 3, negative: none)
+}
+
+// wants reports whether the sink subscribes to the event type
+func (s *Sink) wants(eventType string) bool {
+	if len(s.Events) == 0 {
+		return true
+	}
+	for _, e := range s.Events {
+		if e == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// Options configures a Dispatcher
+type Options struct {
+	Client     func() *http.Client                      // HTTP client for each delivery (default: http.DefaultClient)
+	RetryDelay time.Duration                            // Delay before the first retry (default: 2s)
+	OnError    func(sink string, e Event, err error)    // Called when a delivery fails after all retries, or is dropped
+	OnDelivery func(sink string, e Event, attempts int) // Called when a delivery succeeds
+}
+
+// Dispatcher delivers events to its sinks in the background. Each sink has its own
+// queue, so a slow or failing endpoint does not delay the others.
+type Dispatcher struct {
+	opts    Options
+	workers []*worker
+	wg      sync.WaitGroup
+	ctx     context.Context // Canceled by Close to abandon requests in flight and retries
+	cancel  context.CancelFunc
+	closeMu sync.RWMutex
+	closed  bool
+}
+
+type worker struct {
+	sink  Sink
+	queue chan delivery
+}
+
+type delivery struct {
+	event Event
+	id    string
+}
+
+// NewDispatcher starts a dispatcher for the given sinks
+func NewDispatcher(sinks []Sink, opts Options) *Dispatcher {
+	if opts.Client == nil {
+		opts.Client = func() *http.Client { return http.DefaultClient }
+	}
+	if opts.RetryDelay <= 0 {
+		opts.RetryDelay = defaultRetryDelay
+	}
+
+	d := &Dispatcher{opts: opts}
+	d.ctx, d.cancel = context.WithCancel(context.Background())
+	for _, sink := range sinks {
+		w := &worker{sink: sink, queue: make(chan delivery, queueSize)}
+		d.workers = append(d.workers, w)
+		d.wg.Add(1)
+		go d.run(w)
+	}
+	return d
+}
+
+// Publish queues the event for every sink subscribed to its type. It never blocks:
+// if a sink's queue is full, the event is dropped for that sink.
+func (d *Dispatcher) Publish(e Event) {
+	d.closeMu.RLock()
+	defer d.closeMu.RUnlock()
+	if d.closed {
+		return
+	}
+
+	id := newDeliveryID()
+	for _, w := range d.workers {
+		if !w.sink.wants(e.Type) {
+			continue
+		}
+		select {
+		case w.queue <- delivery{event: e, id: id}:
+		default:
+			d.reportError(w.sink.Name, e, fmt.Errorf("queue full, event dropped"))
+		}
+	}
+}
+
+// Close stops accepting events and waits for queued deliveries until ctx is done.
+// Requests in flight and retries still pending when ctx is done are abandoned.
+func (d *Dispatcher) Close(ctx context.Context) {
+	d.closeMu.Lock()
+	if d.closed {
+		d.closeMu.Unlock()
+		return
+	}
+	d.closed = true
+	for _, w := range d.workers {
+		close(w.queue)
+	}
+	d.closeMu.Unlock()
+
+	finished := make(chan struct{})
+	go func() {
+		d.wg.Wait()
+		close(finished)
+	}()
+
+	select {
+	case <-finished:
+	case <-ctx.Done():
+		d.cancel()
+		<-finished
+	}
+	d.cancel()
+}
+
+// run delivers the events queued for one sink
+func (d *Dispatcher) run(w *worker) {
+	defer d.wg.Done()
+	for del := range w.queue {
+		d.deliver(w.sink, del)
+	}
+}
+
+// deliver posts one event to a sink, retrying transient failures with exponential backoff
+func (d *Dispatcher) deliver(sink Sink, del delivery) {
+	body, err := Render(sink.Format, del.event)
+	if err != nil {
+		d.reportError(sink.Name, del.event, err)
+		return
+	}
+
+	retries := sink.MaxRetries
+	if retries == 0 {
+		retries = defaultMaxRetries
+	} else if retries < 0 {
+		retries = 0
+	}
+
+	delay := d.opts.RetryDelay
+	for attempt := 1; ; attempt++ {
+		retryAfter, err := d.post(sink, del, body)
+		if err == nil {
+			if d.opts.OnDelivery != nil {
+				d.opts.OnDelivery(sink.Name, del.event, attempt)
+			}
+			return
+		}
+
+		var perm *permanentError
+		if attempt > retries || errors.As(err, &perm) {
+			d.reportError(sink.Name, del.event, fmt.Errorf("after %d attempt(s): %w", attempt, err))
+			return
+		}
+
+		wait := delay
+		if retryAfter > wait {
+			wait = retryAfter
+		}
+		if wait > maxRetryDelay {
+			wait = maxRetryDelay
+		}
+		select {
+		case <-time.After(wait):
+		case <-d.ctx.Done():
+			d.reportError(sink.Name, del.event, fmt.Errorf("abandoned at shutdown after %d attempt(s): %w", attempt, err))
+			return
+		}
+		delay *= 2
+	}
+}
+
+// post sends one delivery attempt. It returns the Retry-After delay requested by the sink, if any.
+func (d *Dispatcher) post(sink Sink, del delivery, body []byte) (time.Duration, error) {
+	req, err := http.NewRequestWithContext(d.ctx, "POST", sink.URL, bytes.NewReader(body))
+	if err != nil {
+		return 0, &permanentError{fmt.Errorf("failed to create request: %w", err)}
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderEvent, del.event.Type)
+	req.Header.Set(HeaderDelivery, del.id)
+	if sink.Secret != "" {
+		timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+		req.Header.Set(HeaderTimestamp, timestamp)
+		req.Header.Set(HeaderSignature, Sign(sink.Secret, timestamp, body))
+	}
+
+	resp, err := d.opts.Client().Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("failed to post to webhook: %w", err)
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return 0, nil
+	}
+
+	err = fmt.Errorf("webhook returned status %d: %s", resp.StatusCode, bytes.TrimSpace(respBody))
+	// Retry throttling and server errors; other client errors will not succeed on retry
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusRequestTimeout && resp.StatusCode < 500 {
+		return 0, &permanentError{err}
+	}
+	var retryAfter time.Duration
+	if seconds, convErr := strconv.Atoi(resp.Header.Get("Retry-After")); convErr == nil && seconds > 0 {
+		retryAfter = time.Duration(seconds) * time.Second
+	}
+	return retryAfter, err
+}
+
+// reportError passes a failed delivery to the OnError callback
+func (d *Dispatcher) reportError(sink string, e Event, err error) {
+	if d.opts.OnError != nil {
+		d.opts.OnError(sink, e, err)
+	}
+}
+
+// Sign returns the signature header value for a body: "sha256=" followed by the hex
+// HMAC-SHA256 of timestamp + "." + body. Receivers should recompute it with the shared
+// secret, compare in constant time and reject stale timestamps.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// permanentError is a delivery failure that is not retried
+type permanentError struct {
+	err error
+}
+
+func (e *permanentError) Error() string { return e.err.Error() }
+func (e *permanentError) Unwrap() error { return e.err }
+
+// newDeliveryID returns a random delivery ID
+func newDeliveryID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return strconv.FormatInt(time.Now().UnixNano(), 16)
+	}
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,409 @@
+package webhook
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// receiver is a local stand-in for a webhook endpoint
+type receiver struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []*http.Request
+	bodies   [][]byte
+	statuses []int // Status returned for each request in turn; 200 once exhausted
+}
+
+func newReceiver(t *testing.T, statuses ...int) *receiver {
+	t.Helper()
+	r := &receiver{statuses: statuses}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		r.mu.Lock()
+		r.requests = append(r.requests, req)
+		r.bodies = append(r.bodies, body)
+		status := http.StatusOK
+		if len(r.statuses) > 0 {
+			status = r.statuses[0]
+			r.statuses = r.statuses[1:]
+		}
+		r.mu.Unlock()
+		w.WriteHeader(status)
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *receiver) count() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.requests)
+}
+
+// result collects the dispatcher callbacks
+type result struct {
+	mu        sync.Mutex
+	delivered map[string]int // Sink -> attempts
+	errors    map[string]error
+}
+
+func newResult() *result {
+	return &result{delivered: make(map[string]int), errors: make(map[string]error)}
+}
+
+func (r *result) options() Options {
+	return Options{
+		RetryDelay: time.Millisecond,
+		OnDelivery: func(sink string, e Event, attempts int) {
+			r.mu.Lock()
+			r.delivered[sink] = attempts
+			r.mu.Unlock()
+		},
+		OnError: func(sink string, e Event, err error) {
+			r.mu.Lock()
+			r.errors[sink] = err
+			r.mu.Unlock()
+		},
+	}
+}
+
+func testEvent(eventType string) Event {
+	return Event{
+		Type:          eventType,
+		Time:          time.Date(2026, 3, 2, 18, 30, 0, 0, time.UTC),
+		VMName:        "vm-dev-01",
+		ResourceGroup: "rg-desktops",
+		Condition:     "noUsers",
+		Reason:        "No users logged in for over 15 minutes",
+		Action:        "hibernate",
+		AgentVersion:  "1.2.0",
+	}
+}
+
+// TestDispatcherDelivers tests signed delivery and per-sink event subscriptions
+func TestDispatcherDelivers(t *testing.T) {
+	signed := newReceiver(t)
+	failuresOnly := newReceiver(t)
+	res := newResult()
+
+	d := NewDispatcher([]Sink{
+		{Name: "signed", URL: signed.URL, Secret: "s3cret"},
+		{Name: "failures", URL: failuresOnly.URL, Events: []string{EventHibernationFailed}},
+	}, res.options())
+
+	d.Publish(testEvent(EventHibernated))
+	d.Close(context.Background())
+
+	if got := failuresOnly.count(); got != 0 {
+		t.Errorf("unsubscribed sink received %d requests, want 0", got)
+	}
+	if got := signed.count(); got != 1 {
+		t.Fatalf("signed sink received %d requests, want 1", got)
+	}
+
+	req, body := signed.requests[0], signed.bodies[0]
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type = %q", got)
+	}
+	if got := req.Header.Get(HeaderEvent); got != EventHibernated {
+		t.Errorf("%s = %q, want %q", HeaderEvent, got, EventHibernated)
+	}
+	if req.Header.Get(HeaderDelivery) == "" {
+		t.Errorf("%s not set", HeaderDelivery)
+	}
+	timestamp := req.Header.Get(HeaderTimestamp)
+	if want := Sign("s3cret", timestamp, body); req.Header.Get(HeaderSignature) != want {
+		t.Errorf("%s = %q, want %q", HeaderSignature, req.Header.Get(HeaderSignature), want)
+	}
+	if Sign("other", timestamp, body) == req.Header.Get(HeaderSignature) {
+		t.Error("signature does not depend on the secret")
+	}
+
+	var got Event
+	if err := json.Unmarshal(body, &got); err != nil {
+		t.Fatalf("failed to decode generic payload: %v", err)
+	}
+	if got != testEvent(EventHibernated) {
+		t.Errorf("payload = %+v, want %+v", got, testEvent(EventHibernated))
+	}
+	if res.delivered["signed"] != 1 {
+		t.Errorf("delivered attempts = %d, want 1", res.delivered["signed"])
+	}
+}
+
+// TestDispatcherUnsigned tests that sinks without a secret get no signature
+func TestDispatcherUnsigned(t *testing.T) {
+	r := newReceiver(t)
+	d := NewDispatcher([]Sink{{Name: "plain", URL: r.URL}}, Options{})
+	d.Publish(testEvent(EventResumed))
+	d.Close(context.Background())
+
+	if r.count() != 1 {
+		t.Fatalf("received %d requests, want 1", r.count())
+	}
+	if r.requests[0].Header.Get(HeaderSignature) != "" || r.requests[0].Header.Get(HeaderTimestamp) != "" {
+		t.Error("unsigned sink received signature headers")
+	}
+}
+
+// TestDispatcherRetries tests retries of transient failures
+func TestDispatcherRetries(t *testing.T) {
+	tests := []struct {
+		name         string
+		statuses     []int
+		maxRetries   int
+		wantRequests int
+		wantError    bool
+	}{
+		{name: "success", wantRequests: 1},
+		{name: "server errors then success", statuses: []int{500, 503}, wantRequests: 3},
+		{name: "throttled then success", statuses: []int{429}, wantRequests: 2},
+		{name: "retries exhausted", statuses: []int{500, 500, 500, 500}, wantRequests: 4, wantError: true},
+		{name: "custom retries exhausted", statuses: []int{502, 502}, maxRetries: 1, wantRequests: 2, wantError: true},
+		{name: "no retries", statuses: []int{500}, maxRetries: -1, wantRequests: 1, wantError: true},
+		{name: "client error is not retried", statuses: []int{404}, wantRequests: 1, wantError: true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newReceiver(t, tt.statuses...)
+			res := newResult()
+			d := NewDispatcher([]Sink{{Name: "sink", URL: r.URL, MaxRetries: tt.maxRetries}}, res.options())
+			d.Publish(testEvent(EventHibernationFailed))
+			d.Close(context.Background())
+
+			if got := r.count(); got != tt.wantRequests {
+				t.Errorf("requests = %d, want %d", got, tt.wantRequests)
+			}
+			if err := res.errors["sink"]; (err != nil) != tt.wantError {
+				t.Errorf("error = %v, wantError %v", err, tt.wantError)
+			}
+			if !tt.wantError && res.delivered["sink"] != tt.wantRequests {
+				t.Errorf("delivered attempts = %d, want %d", res.delivered["sink"], tt.wantRequests)
+			}
+
+			// Retries keep the delivery ID so receivers can deduplicate
+			ids := make(map[string]bool)
+			for _, req := range r.requests {
+				ids[req.Header.Get(HeaderDelivery)] = true
+			}
+			if len(ids) != 1 {
+				t.Errorf("delivery IDs = %v, want one ID across retries", ids)
+			}
+		})
+	}
+}
+
+// TestDispatcherIsolatesSinks tests that a slow sink does not delay the others
+func TestDispatcherIsolatesSinks(t *testing.T) {
+	release := make(chan struct{})
+	slow := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+	}))
+	defer slow.Close()
+	fast := newReceiver(t)
+
+	d := NewDispatcher([]Sink{
+		{Name: "slow", URL: slow.URL},
+		{Name: "fast", URL: fast.URL},
+	}, Options{})
+
+	start := time.Now()
+	d.Publish(testEvent(EventWarning))
+	if time.Since(start) > time.Second {
+		t.Error("Publish blocked on delivery")
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for fast.count() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fast.count() != 1 {
+		t.Error("fast sink was delayed by the slow sink")
+	}
+
+	close(release)
+	d.Close(context.Background())
+}
+
+// TestDispatcherCloseAbandonsRetries tests that Close gives up on pending retries at its deadline
+func TestDispatcherCloseAbandonsRetries(t *testing.T) {
+	r := newReceiver(t, 503, 503, 503, 503)
+	var abandoned atomic.Bool
+	d := NewDispatcher([]Sink{{Name: "down", URL: r.URL}}, Options{
+		RetryDelay: time.Hour,
+		OnError: func(sink string, e Event, err error) {
+			abandoned.Store(strings.Contains(err.Error(), "abandoned"))
+		},
+	})
+	d.Publish(testEvent(EventHibernated))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Close(ctx)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close took %v", elapsed)
+	}
+	if !abandoned.Load() {
+		t.Error("pending retry was not reported as abandoned")
+	}
+
+	// Events published after Close are ignored
+	d.Publish(testEvent(EventResumed))
+	if got := r.count(); got != 1 {
+		t.Errorf("requests = %d, want 1", got)
+	}
+}
+
+// TestDispatcherCloseCancelsRequest tests that Close does not wait for a sink that never responds
+func TestDispatcherCloseCancelsRequest(t *testing.T) {
+	received := make(chan struct{})
+	release := make(chan struct{})
+	hung := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		close(received)
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	defer hung.Close()
+	defer close(release)
+
+	var failed atomic.Bool
+	d := NewDispatcher([]Sink{{Name: "hung", URL: hung.URL}}, Options{
+		OnError: func(sink string, e Event, err error) { failed.Store(true) },
+	})
+	d.Publish(testEvent(EventWarning))
+	<-received
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	d.Close(ctx)
+
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Close took %v with a request in flight", elapsed)
+	}
+	if !failed.Load() {
+		t.Error("canceled delivery was not reported")
+	}
+}
+
+// TestRender tests the Teams, Slack and generic payloads
+func TestRender(t *testing.T) {
+	e := testEvent(EventHibernationFailed)
+	e.Error = "hibernation is not enabled"
+
+	t.Run("generic", func(t *testing.T) {
+		data, err := Render(FormatGeneric, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got map[string]any
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		for _, key := range []string{"type", "time", "vmName", "resourceGroup", "reason", "action", "error", "agentVersion"} {
+			if _, ok := got[key]; !ok {
+				t.Errorf("generic payload missing %q: %s", key, data)
+			}
+		}
+		if _, ok := got["timeRemainingSeconds"]; ok {
+			t.Errorf("generic payload has empty timeRemainingSeconds: %s", data)
+		}
+	})
+
+	t.Run("teams", func(t *testing.T) {
+		data, err := Render(FormatTeams, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Type        string `json:"type"`
+			Attachments []struct {
+				ContentType string `json:"contentType"`
+				Content     struct {
+					Type string           `json:"type"`
+					Body []map[string]any `json:"body"`
+				} `json:"content"`
+			} `json:"attachments"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Type != "message" || len(got.Attachments) != 1 {
+			t.Fatalf("teams payload = %s", data)
+		}
+		card := got.Attachments[0]
+		if card.ContentType != "application/vnd.microsoft.card.adaptive" || card.Content.Type != "AdaptiveCard" {
+			t.Errorf("teams attachment = %s", data)
+		}
+		if len(card.Content.Body) == 0 || card.Content.Body[0]["text"] != "vm-dev-01 failed to hibernate" {
+			t.Errorf("teams title = %v", card.Content.Body)
+		}
+		if !strings.Contains(string(data), "hibernation is not enabled") {
+			t.Errorf("teams payload missing error: %s", data)
+		}
+	})
+
+	t.Run("slack", func(t *testing.T) {
+		data, err := Render(FormatSlack, e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got struct {
+			Text   string `json:"text"`
+			Blocks []struct {
+				Text struct {
+					Text string `json:"text"`
+				} `json:"text"`
+			} `json:"blocks"`
+		}
+		if err := json.Unmarshal(data, &got); err != nil {
+			t.Fatal(err)
+		}
+		if got.Text != "vm-dev-01 failed to hibernate" {
+			t.Errorf("slack text = %q", got.Text)
+		}
+		if len(got.Blocks) != 1 || !strings.Contains(got.Blocks[0].Text.Text, "Error: hibernation is not enabled") {
+			t.Errorf("slack blocks = %s", data)
+		}
+	})
+
+	t.Run("unknown format", func(t *testing.T) {
+		if _, err := Render("discord", e); err == nil {
+			t.Error("Expected error for unknown format")
+		}
+	})
+}
+
+// TestTitle tests the event summaries
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		event Event
+		want  string
+	}{
+		{Event{Type: EventWarning, VMName: "vm1", Action: "hibernate", TimeRemainingSeconds: 300}, "vm1 will hibernate in 5m0s"},
+		{Event{Type: EventWarning, VMName: "vm1", Action: "deallocate", TimeRemainingSeconds: 90}, "vm1 will be deallocated in 1m30s"},
+		{Event{Type: EventWarningCanceled, VMName: "vm1"}, "vm1: hibernation warning canceled"},
+		{Event{Type: EventHibernated, VMName: "vm1", Action: "hibernate"}, "vm1 was stopped (hibernate)"},
+		{Event{Type: EventHibernationFailed, VMName: "vm1", Action: "run-script"}, "vm1 failed to run the action script"},
+		{Event{Type: EventResumed, VMName: "vm1"}, "vm1 resumed"},
+	}
+
+	for _, tt := range tests {
+		if got := Title(tt.event); got != tt.want {
+			t.Errorf("Title(%s) = %q, want %q", tt.event.Type, got, tt.want)
+		}
+	}
+}